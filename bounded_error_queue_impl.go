@@ -61,8 +61,7 @@ func NewBoundedErrorQueue(userCapacity uint32) ErrorQueue {
 }
 
 // Enqueue adds an error to the error queue.  If the queue is
-// at capacity should return ErrAtCapacity.  All other errors
-// will be ignored
+// at capacity returns ErrAtCapacity
 func (errorq *BoundedErrorQueue) Enqueue(info ErrorInformation) error {
 	if info == nil {
 		return nil
@@ -0,0 +1,98 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"time"
+)
+
+// realClock is the Clock used unless SetClock is called,
+// it simply uses the time package
+type realClock struct{}
+
+func (rc realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (rc realClock) AfterFunc(duration time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(duration, f)
+}
+
+func (rc realClock) Sleep(duration time.Duration) {
+	time.Sleep(duration)
+}
+
+// SetClock sets the clock used for all timeouts, idle decay and
+// scheduling.  A nil clock goes back to the real clock
+func (goth *StandardThreadUtilities) SetClock(clock Clock) {
+	goth.clockMux.Lock()
+	defer goth.clockMux.Unlock()
+
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	goth.clock = clock
+}
+
+// getClock returns the clock to use for all time operations
+func (goth *StandardThreadUtilities) getClock() Clock {
+	goth.clockMux.Lock()
+	defer goth.clockMux.Unlock()
+
+	return goth.clock
+}
+
+// now returns the current time according to the clock
+func now() time.Time {
+	return globalGoethe.getClock().Now()
+}
+
+// since returns the time elapsed since t according to the clock
+func since(t time.Time) time.Duration {
+	return now().Sub(t)
+}
+
+// afterFunc calls f in its own goroutine once the duration
+// has passed according to the clock
+func afterFunc(duration time.Duration, f func()) ClockTimer {
+	return globalGoethe.getClock().AfterFunc(duration, f)
+}
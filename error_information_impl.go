@@ -40,16 +40,39 @@
 
 package goethe
 
+import (
+	"time"
+)
+
 type errorInformation struct {
-	tid int64
-	err error
+	tid         int64
+	name        string
+	err         error
+	enqueueTime time.Time
+	userCall    interface{}
+	args        []interface{}
 }
 
 func newErrorinformation(id int64, err error) ErrorInformation {
-	return &errorInformation{
-		tid: id,
-		err: err,
+	return newEnqueuedErrorinformation(id, err, nil)
+}
+
+// newEnqueuedErrorinformation is for errors from the function of the
+// descriptor, which was put on a function queue.  The descriptor may be nil
+func newEnqueuedErrorinformation(id int64, err error, descriptor *FunctionDescriptor) ErrorInformation {
+	retVal := &errorInformation{
+		tid:  id,
+		name: globalGoethe.getThreadName(id),
+		err:  err,
+	}
+
+	if descriptor != nil {
+		retVal.enqueueTime = descriptor.EnqueueTime
+		retVal.userCall = descriptor.UserCall
+		retVal.args = descriptor.Args
 	}
+
+	return retVal
 }
 
 func (ei *errorInformation) GetThreadID() int64 {
@@ -59,3 +82,22 @@ func (ei *errorInformation) GetThreadID() int64 {
 func (ei *errorInformation) GetError() error {
 	return ei.err
 }
+
+func (ei *errorInformation) GetThreadName() string {
+	return ei.name
+}
+
+func (ei *errorInformation) GetEnqueueTime() time.Time {
+	return ei.enqueueTime
+}
+
+func (ei *errorInformation) GetFunction() (interface{}, []interface{}) {
+	if ei.userCall == nil {
+		return nil, nil
+	}
+
+	args := make([]interface{}, len(ei.args))
+	copy(args, ei.args)
+
+	return ei.userCall, args
+}
@@ -67,6 +67,9 @@ func main() {
 		fmt.Println(err.Error())
 	}
 
+	fmt.Println("Log with goethe thread ids...")
+	logWithThreadIDs()
+
 	RunClockForOneHour()
 
 	time.Sleep(10 * time.Minute)
@@ -41,6 +41,8 @@
 package goethe
 
 import (
+	"fmt"
+	"math"
 	"sync"
 	"time"
 )
@@ -55,15 +57,40 @@ type FunctionQueueImpl struct {
 	cond    *sync.Cond
 	changer func(queue FunctionQueue)
 
-	capacity uint32
-	queue    []*FunctionDescriptor
+	capacity         uint32
+	queue            functionStore
+	affinityFallback bool
+
+	// see SetHighWatermark
+	watermark      int
+	onWatermark    func(size int)
+	aboveWatermark bool
+
+	// shared with other queues, see SetWorkBudget
+	budget WorkBudget
+
+	// functions dequeued but not yet finished, for barriers
+	running        int
+	barrierRunning bool
 }
 
 // NewBoundedFunctionQueue creates a new function queue with the given capacity
 func NewBoundedFunctionQueue(userCapacity uint32) FunctionQueue {
+	return newFunctionQueue(userCapacity, newSliceStore())
+}
+
+// NewRingBufferFunctionQueue creates a new function queue with the given
+// capacity whose storage is allocated once up front and then reused.
+// This reduces allocations for pools that run very many small functions,
+// at the cost of always holding storage for the full capacity
+func NewRingBufferFunctionQueue(userCapacity uint32) FunctionQueue {
+	return newFunctionQueue(userCapacity, newRingStore(userCapacity))
+}
+
+func newFunctionQueue(userCapacity uint32, store functionStore) *FunctionQueueImpl {
 	retVal := &FunctionQueueImpl{
 		capacity: userCapacity,
-		queue:    make([]*FunctionDescriptor, 0),
+		queue:    store,
 	}
 
 	retVal.cond = sync.NewCond(&retVal.mux)
@@ -74,48 +101,175 @@ func NewBoundedFunctionQueue(userCapacity uint32) FunctionQueue {
 // Enqueue queues a function to be run in the pool.  Returns
 // ErrAtCapacity if the queue is currently at capacity
 func (fq *FunctionQueueImpl) Enqueue(userCall interface{}, args ...interface{}) error {
-	if userCall == nil {
-		return nil
+	return fq.enqueue(&FunctionDescriptor{
+		UserCall: userCall,
+	}, args)
+}
+
+// EnqueueToThread queues a function that will only be dequeued by
+// the goethe thread with the given id.  Returns ErrAtCapacity if the
+// queue is currently at capacity.  If the thread is not running and
+// affinity fallback is off returns ErrNoSuchThread
+func (fq *FunctionQueueImpl) EnqueueToThread(threadID int64, userCall interface{}, args ...interface{}) error {
+	if threadID <= 0 {
+		return ErrNoSuchThread
+	}
+
+	return fq.enqueue(&FunctionDescriptor{
+		UserCall: userCall,
+		ThreadID: threadID,
+	}, args)
+}
+
+// EnqueueWithCallback queues a function to be run in the pool.  After
+// the function returns onDone is called on the same thread with what
+// the function returned.  Returns ErrAtCapacity if the queue is
+// currently at capacity
+func (fq *FunctionQueueImpl) EnqueueWithCallback(onDone func(results []interface{}, err error),
+	userCall interface{}, args ...interface{}) error {
+	return fq.enqueue(&FunctionDescriptor{
+		UserCall: userCall,
+		OnDone:   onDone,
+	}, args)
+}
+
+// EnqueueWithDeadline queues a function to be run in the pool which
+// is not run if it is dequeued after the deadline.  Returns
+// ErrAtCapacity if the queue is currently at capacity
+func (fq *FunctionQueueImpl) EnqueueWithDeadline(deadline time.Time, userCall interface{}, args ...interface{}) error {
+	return fq.enqueue(&FunctionDescriptor{
+		UserCall: userCall,
+		Deadline: deadline,
+	}, args)
+}
+
+// EnqueueWithMetadata queues a function to be run in the pool with
+// a copy of the given metadata.  Returns ErrAtCapacity if the queue
+// is currently at capacity
+func (fq *FunctionQueueImpl) EnqueueWithMetadata(metadata map[string]string, userCall interface{}, args ...interface{}) error {
+	var copied map[string]string
+	if metadata != nil {
+		copied = make(map[string]string, len(metadata))
+		for key, value := range metadata {
+			copied[key] = value
+		}
+	}
+
+	return fq.enqueue(&FunctionDescriptor{
+		UserCall: userCall,
+		Metadata: copied,
+	}, args)
+}
+
+// EnqueueBarrier queues a function that is only returned by Dequeue
+// once every function enqueued before it has been dequeued and
+// finished.  No function enqueued after it is returned until the
+// barrier function itself has finished.  Returns ErrAtCapacity if
+// the queue is currently at capacity
+func (fq *FunctionQueueImpl) EnqueueBarrier(userCall interface{}, args ...interface{}) error {
+	return fq.enqueue(&FunctionDescriptor{
+		UserCall: userCall,
+		barrier:  true,
+	}, args)
+}
+
+// EnqueueIf queues a function to be run in the pool only if the
+// predicate returns true for the functions already on the queue
+func (fq *FunctionQueueImpl) EnqueueIf(predicate func(pending []FunctionDescriptor) bool,
+	userCall interface{}, args ...interface{}) (bool, error) {
+	return fq.enqueueIf(&FunctionDescriptor{
+		UserCall: userCall,
+	}, args, predicate)
+}
+
+// enqueue finishes filling in the descriptor and adds it to the queue
+func (fq *FunctionQueueImpl) enqueue(descriptor *FunctionDescriptor, args []interface{}) error {
+	_, err := fq.enqueueIf(descriptor, args, nil)
+	return err
+}
+
+// enqueueIf adds the function to the queue if the predicate, which may
+// be nil, returns true.  Returns true if the function was added
+func (fq *FunctionQueueImpl) enqueueIf(descriptor *FunctionDescriptor, args []interface{},
+	predicate func(pending []FunctionDescriptor) bool) (bool, error) {
+	if descriptor.UserCall == nil {
+		return false, nil
 	}
 
 	fq.mux.Lock()
 	defer fq.mux.Unlock()
 
-	if uint32(len(fq.queue)) >= fq.capacity {
-		return ErrAtCapacity
+	if predicate != nil {
+		pending := make([]FunctionDescriptor, fq.queue.size())
+		for index := range pending {
+			pending[index] = *fq.queue.at(index)
+		}
+
+		if !predicate(pending) {
+			return false, nil
+		}
 	}
 
-	descriptor := &FunctionDescriptor{
-		UserCall: userCall,
-		Args:     make([]interface{}, len(args)),
+	if uint32(fq.queue.size()) >= fq.capacity {
+		return false, ErrAtCapacity
 	}
 
+	threadID := descriptor.ThreadID
+	if threadID != 0 && !fq.affinityFallback && !globalGoethe.isThreadAlive(threadID) {
+		return false, ErrNoSuchThread
+	}
+
+	if fq.budget != nil {
+		if !fq.budget.TryAcquire() {
+			return false, ErrWorkBudgetExhausted
+		}
+
+		descriptor.budget = fq.budget
+	}
+
+	descriptor.Args = make([]interface{}, len(args))
+	descriptor.EnqueueTime = now()
+
 	for index, arg := range args {
 		descriptor.Args[index] = arg
 	}
 
-	fq.queue = append(fq.queue, descriptor)
+	fq.queue.add(descriptor)
+	fq.checkWatermark()
 
 	fq.cond.Broadcast()
 	if fq.changer != nil {
 		go fq.changer(fq)
 	}
 
-	return nil
+	return true, nil
 }
 
 // Dequeue returns a function to be run, waiting the given
 // duration.  If there is no message within the given
-// duration return the error returned will be ErrEmptyQueue
+// duration return the error returned will be ErrEmptyQueue.
+// Functions enqueued with EnqueueToThread are only returned
+// to their own thread.  If their thread has exited and affinity
+// fallback is off the function is removed and returned along
+// with ErrNoSuchThread
 func (fq *FunctionQueueImpl) Dequeue(duration time.Duration) (*FunctionDescriptor, error) {
+	descriptor, _, err := fq.DequeueWithDepth(duration)
+	return descriptor, err
+}
+
+// DequeueWithDepth is Dequeue that also returns the number of
+// functions left on the queue after this one was removed
+func (fq *FunctionQueueImpl) DequeueWithDepth(duration time.Duration) (*FunctionDescriptor, int, error) {
 	fq.mux.Lock()
 	defer fq.mux.Unlock()
 
-	currentTime := time.Now()
-	elapsedDuration := time.Since(currentTime)
+	tid := int64(-2)
+
+	currentTime := now()
+	elapsedDuration := since(currentTime)
 
-	for (duration > 0) && (elapsedDuration < duration) && (len(fq.queue) <= 0) {
-		timer := time.AfterFunc(duration-elapsedDuration, func() {
+	for (duration > 0) && (elapsedDuration < duration) && (fq.nextIndex(&tid) < 0) {
+		timer := afterFunc(duration-elapsedDuration, func() {
 			fq.cond.Broadcast()
 		})
 
@@ -123,21 +277,232 @@ func (fq *FunctionQueueImpl) Dequeue(duration time.Duration) (*FunctionDescripto
 
 		timer.Stop()
 
-		elapsedDuration = time.Since(currentTime)
+		elapsedDuration = since(currentTime)
 	}
 
-	if len(fq.queue) <= 0 {
-		return nil, ErrEmptyQueue
+	index := fq.nextIndex(&tid)
+	if index < 0 {
+		return nil, fq.queue.size(), ErrEmptyQueue
 	}
 
-	retVal := fq.queue[0]
-	fq.queue = fq.queue[1:]
+	retVal := fq.queue.removeAt(index)
+	fq.checkWatermark()
+
+	fq.running++
+	if retVal.barrier {
+		fq.barrierRunning = true
+	}
+	retVal.finisher = fq.finished
 
 	if fq.changer != nil {
 		go fq.changer(fq)
 	}
 
-	return retVal, nil
+	if retVal.ThreadID != 0 && retVal.ThreadID != tid && !fq.affinityFallback {
+		// The thread this was meant for has exited
+		return retVal, fq.queue.size(), ErrNoSuchThread
+	}
+
+	claimWriteLock(retVal)
+
+	return retVal, fq.queue.size(), nil
+}
+
+// TryDequeue returns the next function to be run without waiting,
+// or false if there is none
+func (fq *FunctionQueueImpl) TryDequeue() (*FunctionDescriptor, bool) {
+	descriptor, err := fq.Dequeue(0)
+	if err == ErrEmptyQueue {
+		return nil, false
+	}
+
+	return descriptor, true
+}
+
+// nextIndex returns the index of the first function that can be given
+// to the calling thread or -1 if there is none.  The thread id is only
+// looked up the first time a function with a thread affinity is found.
+// Must have mutex held
+func (fq *FunctionQueueImpl) nextIndex(tid *int64) int {
+	if fq.barrierRunning {
+		return -1
+	}
+
+	for index := 0; index < fq.queue.size(); index++ {
+		descriptor := fq.queue.at(index)
+		if descriptor.barrier {
+			// Nothing behind a barrier can go before it
+			if index == 0 && fq.running == 0 {
+				return index
+			}
+
+			return -1
+		}
+
+		if descriptor.ThreadID == 0 {
+			return index
+		}
+
+		if *tid == -2 {
+			*tid = globalGoethe.GetThreadID()
+		}
+
+		if descriptor.ThreadID == *tid || !globalGoethe.isThreadAlive(descriptor.ThreadID) {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// ForEach calls the callback with a copy of each function descriptor
+// on the queue, stopping early if the callback returns false.  The callback
+// must not call any method of this queue
+func (fq *FunctionQueueImpl) ForEach(callback func(FunctionDescriptor) bool) {
+	fq.mux.Lock()
+	defer fq.mux.Unlock()
+
+	for index := 0; index < fq.queue.size(); index++ {
+		if !callback(*fq.queue.at(index)) {
+			return
+		}
+	}
+}
+
+// finished is called when a function dequeued from this queue has finished
+func (fq *FunctionQueueImpl) finished(descriptor *FunctionDescriptor) {
+	fq.mux.Lock()
+	defer fq.mux.Unlock()
+
+	fq.running--
+	releaseBudget(descriptor)
+	releaseWriteLock(descriptor)
+	if descriptor.barrier {
+		fq.barrierRunning = false
+	}
+
+	// A barrier may now be able to go
+	fq.cond.Broadcast()
+	if fq.changer != nil {
+		go fq.changer(fq)
+	}
+}
+
+// Clear removes every function from the queue, telling their
+// callbacks they were cleared.  Returns the number removed
+func (fq *FunctionQueueImpl) Clear() int {
+	cleared := fq.removeAll()
+
+	for _, descriptor := range cleared {
+		releaseBudget(descriptor)
+		releaseWriteLock(descriptor)
+
+		if descriptor.OnDone != nil {
+			callOnDone(descriptor, nil, ErrCleared)
+		}
+	}
+
+	return len(cleared)
+}
+
+// removeAll takes every function off the queue without dequeueing
+// them, so they are not counted as running.  They keep their share of
+// any work budget, since they are either put on another queue or cleared
+func (fq *FunctionQueueImpl) removeAll() []*FunctionDescriptor {
+	fq.mux.Lock()
+	defer fq.mux.Unlock()
+
+	retVal := make([]*FunctionDescriptor, 0, fq.queue.size())
+	for fq.queue.size() > 0 {
+		retVal = append(retVal, fq.queue.removeAt(0))
+	}
+	fq.checkWatermark()
+
+	if len(retVal) > 0 && fq.changer != nil {
+		go fq.changer(fq)
+	}
+
+	return retVal
+}
+
+// addAll puts the functions at the back of the queue as they are,
+// or none of them if they do not all fit
+func (fq *FunctionQueueImpl) addAll(descriptors []*FunctionDescriptor) error {
+	fq.mux.Lock()
+	defer fq.mux.Unlock()
+
+	if uint32(fq.queue.size()+len(descriptors)) > fq.capacity {
+		return ErrAtCapacity
+	}
+
+	for _, descriptor := range descriptors {
+		fq.queue.add(descriptor)
+	}
+	fq.checkWatermark()
+
+	if len(descriptors) > 0 {
+		fq.cond.Broadcast()
+		if fq.changer != nil {
+			go fq.changer(fq)
+		}
+	}
+
+	return nil
+}
+
+// takeAllFunctions removes every function it can from the queue.  Other
+// implementations of FunctionQueue may keep functions meant for other threads
+func takeAllFunctions(queue FunctionQueue) []*FunctionDescriptor {
+	if impl, ok := queue.(*FunctionQueueImpl); ok {
+		return impl.removeAll()
+	}
+
+	retVal := make([]*FunctionDescriptor, 0)
+	for {
+		descriptor, err := queue.Dequeue(0)
+		if descriptor == nil || err == ErrEmptyQueue {
+			return retVal
+		}
+
+		retVal = append(retVal, descriptor)
+	}
+}
+
+// putAllFunctions adds the functions to the queue in order, or none
+// of them if they do not all fit
+func putAllFunctions(queue FunctionQueue, descriptors []*FunctionDescriptor) error {
+	if impl, ok := queue.(*FunctionQueueImpl); ok {
+		return impl.addAll(descriptors)
+	}
+
+	if uint32(queue.GetSize()+len(descriptors)) > queue.GetCapacity() {
+		return ErrAtCapacity
+	}
+
+	for _, descriptor := range descriptors {
+		var err error
+
+		switch {
+		case descriptor.barrier:
+			err = queue.EnqueueBarrier(descriptor.UserCall, descriptor.Args...)
+		case descriptor.ThreadID != 0:
+			err = queue.EnqueueToThread(descriptor.ThreadID, descriptor.UserCall, descriptor.Args...)
+		case descriptor.OnDone != nil:
+			err = queue.EnqueueWithCallback(descriptor.OnDone, descriptor.UserCall, descriptor.Args...)
+		case !descriptor.Deadline.IsZero():
+			err = queue.EnqueueWithDeadline(descriptor.Deadline, descriptor.UserCall, descriptor.Args...)
+		case descriptor.Metadata != nil:
+			err = queue.EnqueueWithMetadata(descriptor.Metadata, descriptor.UserCall, descriptor.Args...)
+		default:
+			err = queue.Enqueue(descriptor.UserCall, descriptor.Args...)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // GetCapacity gets the capacity of this queue
@@ -150,7 +515,7 @@ func (fq *FunctionQueueImpl) GetSize() int {
 	fq.mux.Lock()
 	defer fq.mux.Unlock()
 
-	return len(fq.queue)
+	return fq.queue.size()
 }
 
 // IsEmpty Returns true if this queue is currently empty
@@ -167,3 +532,64 @@ func (fq *FunctionQueueImpl) SetStateChangeCallback(ch func(FunctionQueue)) {
 
 	fq.changer = ch
 }
+
+// SetAffinityFallback sets what happens to functions enqueued with
+// EnqueueToThread whose thread has exited.  If true they can be
+// run on any thread, otherwise they are errors.  The default is false
+func (fq *FunctionQueueImpl) SetAffinityFallback(fallback bool) {
+	fq.mux.Lock()
+	defer fq.mux.Unlock()
+
+	fq.affinityFallback = fallback
+}
+
+// SetHighWatermark sets a function to be called when the size of
+// the queue rises to the given fraction of its capacity
+func (fq *FunctionQueueImpl) SetHighWatermark(fraction float64, onReached func(size int)) error {
+	if fraction <= 0 || fraction > 1 {
+		return fmt.Errorf("high watermark fraction must be greater than zero and at most one, not %v", fraction)
+	}
+
+	fq.mux.Lock()
+	defer fq.mux.Unlock()
+
+	fq.watermark = int(math.Ceil(fraction * float64(fq.capacity)))
+	if fq.watermark < 1 {
+		fq.watermark = 1
+	}
+
+	fq.onWatermark = onReached
+	fq.aboveWatermark = fq.queue.size() >= fq.watermark
+
+	return nil
+}
+
+// checkWatermark calls the high watermark callback if the size of the
+// queue has just risen to the watermark.  Must have mutex held
+func (fq *FunctionQueueImpl) checkWatermark() {
+	if fq.onWatermark == nil {
+		return
+	}
+
+	size := fq.queue.size()
+	if size < fq.watermark {
+		fq.aboveWatermark = false
+		return
+	}
+
+	if fq.aboveWatermark {
+		return
+	}
+
+	fq.aboveWatermark = true
+	go fq.onWatermark(size)
+}
+
+// SetWorkBudget makes functions enqueued from now on take a share
+// of the given budget until they have run
+func (fq *FunctionQueueImpl) SetWorkBudget(budget WorkBudget) {
+	fq.mux.Lock()
+	defer fq.mux.Unlock()
+
+	fq.budget = budget
+}
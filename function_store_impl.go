@@ -0,0 +1,138 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+// functionStore holds the functions of a FunctionQueueImpl in order.
+// None of the methods are synchronized, the queue holds its own lock
+type functionStore interface {
+	size() int
+	at(index int) *FunctionDescriptor
+	add(*FunctionDescriptor)
+	removeAt(index int) *FunctionDescriptor
+}
+
+// sliceStore is the original store, a slice that grows as needed
+type sliceStore struct {
+	queue []*FunctionDescriptor
+}
+
+func newSliceStore() functionStore {
+	return &sliceStore{
+		queue: make([]*FunctionDescriptor, 0),
+	}
+}
+
+func (ss *sliceStore) size() int {
+	return len(ss.queue)
+}
+
+func (ss *sliceStore) at(index int) *FunctionDescriptor {
+	return ss.queue[index]
+}
+
+func (ss *sliceStore) add(descriptor *FunctionDescriptor) {
+	ss.queue = append(ss.queue, descriptor)
+}
+
+func (ss *sliceStore) removeAt(index int) *FunctionDescriptor {
+	retVal := ss.queue[index]
+	if index == 0 {
+		ss.queue = ss.queue[1:]
+	} else {
+		ss.queue = append(ss.queue[:index], ss.queue[index+1:]...)
+	}
+
+	return retVal
+}
+
+// ringStore is a fixed size circular buffer allocated up front
+// so that adding and removing functions never grows the storage
+type ringStore struct {
+	buffer []*FunctionDescriptor
+	head   int
+	count  int
+}
+
+func newRingStore(capacity uint32) functionStore {
+	return &ringStore{
+		buffer: make([]*FunctionDescriptor, capacity),
+	}
+}
+
+func (rs *ringStore) size() int {
+	return rs.count
+}
+
+func (rs *ringStore) slot(index int) int {
+	return (rs.head + index) % len(rs.buffer)
+}
+
+func (rs *ringStore) at(index int) *FunctionDescriptor {
+	return rs.buffer[rs.slot(index)]
+}
+
+// add must only be called when there is room in the buffer
+func (rs *ringStore) add(descriptor *FunctionDescriptor) {
+	rs.buffer[rs.slot(rs.count)] = descriptor
+	rs.count++
+}
+
+func (rs *ringStore) removeAt(index int) *FunctionDescriptor {
+	retVal := rs.buffer[rs.slot(index)]
+
+	if index == 0 {
+		rs.buffer[rs.head] = nil
+		rs.head = rs.slot(1)
+		rs.count--
+
+		return retVal
+	}
+
+	// Only functions with thread affinity are removed from the middle
+	for lcv := index; lcv < rs.count-1; lcv++ {
+		rs.buffer[rs.slot(lcv)] = rs.buffer[rs.slot(lcv+1)]
+	}
+
+	rs.count--
+	rs.buffer[rs.slot(rs.count)] = nil
+
+	return retVal
+}
@@ -0,0 +1,157 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type futureImpl struct {
+	mux  sync.Mutex
+	cond *sync.Cond
+
+	complete bool
+	closed   bool
+	results  []interface{}
+	err      error
+}
+
+func newFuture() *futureImpl {
+	retVal := &futureImpl{}
+
+	retVal.cond = sync.NewCond(&retVal.mux)
+
+	return retVal
+}
+
+// run calls the method and records its results in this future.  The
+// error is returned so that it also goes to the error queue of the pool
+func (future *futureImpl) run(method interface{}, args []reflect.Value) error {
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+
+		// The future completes either way, the panic still goes on to
+		// whatever handles panics of the thread
+		future.setResults(nil, fmt.Errorf("%v: %w", value, ErrTaskPanicked))
+
+		panic(value)
+	}()
+
+	results, err := callMethod(method, args)
+
+	future.setResults(results, err)
+
+	return err
+}
+
+func (future *futureImpl) setResults(results []interface{}, err error) {
+	future.mux.Lock()
+	defer future.mux.Unlock()
+
+	future.complete = true
+	if !future.closed {
+		future.results = results
+		future.err = err
+	}
+
+	future.cond.Broadcast()
+}
+
+// IsComplete returns true if the function has finished running
+func (future *futureImpl) IsComplete() bool {
+	future.mux.Lock()
+	defer future.mux.Unlock()
+
+	return future.complete
+}
+
+// Get waits up to the given duration for the function to finish.
+// It returns the values returned by the function along with the
+// first non-nil error returned by the function.  If the function
+// is still running after the duration ErrFutureTimeout is returned
+func (future *futureImpl) Get(duration time.Duration) ([]interface{}, error) {
+	future.mux.Lock()
+	defer future.mux.Unlock()
+
+	currentTime := now()
+	elapsedDuration := since(currentTime)
+
+	for (duration > 0) && (elapsedDuration < duration) && !future.complete && !future.closed {
+		timer := afterFunc(duration-elapsedDuration, func() {
+			future.cond.Broadcast()
+		})
+
+		future.cond.Wait()
+
+		timer.Stop()
+
+		elapsedDuration = since(currentTime)
+	}
+
+	if future.closed {
+		return nil, ErrFutureClosed
+	}
+
+	if !future.complete {
+		return nil, ErrFutureTimeout
+	}
+
+	return future.results, future.err
+}
+
+// Close lets go of the values returned by the function
+func (future *futureImpl) Close() {
+	future.mux.Lock()
+	defer future.mux.Unlock()
+
+	future.closed = true
+	future.results = nil
+	future.err = nil
+
+	// Anyone waiting in Get would otherwise wait for nothing
+	future.cond.Broadcast()
+}
@@ -46,7 +46,8 @@
 package goethe
 
 import (
-	"errors"
+	"context"
+	"log/slog"
 	"sync"
 	"time"
 )
@@ -63,6 +64,88 @@ type Timer interface {
 	GetErrorQueue() ErrorQueue
 }
 
+// ScheduledHandle is a Timer returned by ScheduleAtFixedRate or
+// ScheduleWithFixedDelay, as listed by ThreadUtilities.ListScheduled
+type ScheduledHandle interface {
+	Timer
+
+	// GetNextRunTime returns when the method is next run, or when the run
+	// in progress started if a fixed delay method is running right now
+	GetNextRunTime() time.Time
+
+	// IsFixedRate returns true for a method scheduled with ScheduleAtFixedRate
+	// and false for one scheduled with ScheduleWithFixedDelay.  Both kinds
+	// repeat until cancelled
+	IsFixedRate() bool
+}
+
+// VersionedCache holds a value computed from data protected by a Lock,
+// computing it again only after the lock has been written.  See
+// ThreadUtilities.NewVersionedCache
+type VersionedCache interface {
+	// Get returns the cached value if the write version of the lock (see
+	// Lock.GetWriteVersion) is the one it was computed at.  Otherwise it
+	// takes the read lock, computes the value again and caches it.  A
+	// write that is still in progress is not seen until its WriteUnlock.
+	// Returns ErrNotGoetheThread or the error of ReadLock if the value
+	// had to be computed and the read lock could not be taken
+	Get() (interface{}, error)
+}
+
+// WorkBudget limits the number of functions outstanding, which is enqueued
+// and not yet finished, across every FunctionQueue it is given to with
+// FunctionQueue.SetWorkBudget.  Giving one budget to the queues of several
+// pools caps the total work of all of them.  See ThreadUtilities.NewWorkBudget
+type WorkBudget interface {
+	// TryAcquire takes one unit of the budget if one is left and returns
+	// true, or returns false right away if the budget is used up
+	TryAcquire() bool
+
+	// Release gives back a unit taken with TryAcquire
+	Release()
+
+	// GetOutstanding returns the number of units currently taken
+	GetOutstanding() int
+
+	// GetMax returns the most units that can be taken at once
+	GetMax() int
+}
+
+// Debouncer coalesces many triggers into one run of a function,
+// made once things have been quiet for a while
+type Debouncer interface {
+	// Trigger starts the delay over.  The function runs on a
+	// new goethe thread once the delay has passed with no other
+	// call to Trigger
+	Trigger()
+
+	// Stop cancels the pending run, if any.  A later Trigger
+	// starts the delay again
+	Stop()
+}
+
+// TaskGraph runs functions that depend on each other, such as the steps
+// of a build.  Each function runs on its own goethe thread once every
+// function it depends on has succeeded, so functions that do not depend
+// on each other run at the same time
+type TaskGraph interface {
+	// AddTask adds a function taking no arguments that is run after the
+	// tasks with the given ids.  The function fails if it returns a non-nil
+	// error.  Tasks it depends on may be added later.  Returns ErrTaskCycle
+	// if the task would end up depending on itself
+	AddTask(id string, userCall interface{}, dependsOn ...string) error
+
+	// Run runs the tasks and waits up to the given duration for them all to
+	// finish.  A zero duration waits for as long as they take.  It returns
+	// the error of every task that failed, along with an error wrapping
+	// ErrDependencyFailed for every task that was not run because a task it
+	// depends on failed.  Returns ErrTaskGraphTimeout if the tasks did not
+	// finish in time, in which case those still running keep going and the
+	// failures returned are only the ones so far.  A graph can only be run
+	// once, and returns an error if a task depends on a task never added
+	Run(timeout time.Duration) (map[string]error, error)
+}
+
 // ThreadLocal is returned from GetThreadLocal, a different
 // one for each goethe thread
 type ThreadLocal interface {
@@ -76,6 +159,43 @@ type ThreadLocal interface {
 	Get() (interface{}, error)
 }
 
+// Clock is the source of time for goethe.  All timeouts, idle decay
+// and scheduled jobs use it, so tests can replace it with SetClock
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// AfterFunc calls f in its own goroutine after the duration has passed
+	AfterFunc(d time.Duration, f func()) ClockTimer
+
+	// Sleep pauses the calling goroutine for the duration
+	Sleep(d time.Duration)
+}
+
+// ClockTimer is returned by Clock.AfterFunc.  *time.Timer is a ClockTimer
+type ClockTimer interface {
+	// Stop prevents the function from being called.  Returns false
+	// if the function has already been called or the timer was stopped
+	Stop() bool
+}
+
+// ThreadContext is a snapshot of the thread locals and name of a
+// goethe thread, taken with CaptureContext and restored on another
+// goethe thread with RunWithContext
+type ThreadContext interface {
+	// GetThreadID returns the id of the thread the context was captured
+	// from, or -1 if it was not captured on a goethe thread
+	GetThreadID() int64
+
+	// GetThreadName returns the name of the thread the context was captured from
+	GetThreadName() string
+
+	// GetLocal returns the value the named thread local had when the
+	// context was captured.  The second value is false if the thread
+	// local had not been created on the captured thread
+	GetLocal(name string) (interface{}, bool)
+}
+
 // ThreadUtilities a service which runs your routines in threads
 // that can have things such as threadIds and thread
 // local storage
@@ -88,14 +208,227 @@ type ThreadUtilities interface {
 	// an error is returned.  The thread id is also returned
 	Go(interface{}, ...interface{}) (int64, error)
 
+	// GoWithCopiedArgs is like Go but every slice or map argument is copied
+	// before the thread starts, so the caller may change its own slice or map
+	// afterwards without racing with the new thread.  The copy is shallow: the
+	// elements of a slice and the keys and values of a map are not themselves
+	// copied, and arguments of any other kind, including pointers, are passed
+	// as they are
+	GoWithCopiedArgs(userCall interface{}, args ...interface{}) (int64, error)
+
+	// GoOnce is like Go but only starts the function if no thread started
+	// by GoOnce with the same tag is still running.  The tag is free again
+	// once the function returns.  This keeps triggers of a periodic job from
+	// overlapping.  Returns true if the function was started and false if it
+	// was skipped.  An error is returned if the arguments do not match
+	GoOnce(tag string, userCall interface{}, args ...interface{}) (bool, error)
+
+	// GoQuorum runs every task in its own goethe thread and returns the
+	// results of the first k tasks to succeed, in the order they finished,
+	// as soon as there are k of them.  The tasks still running keep running
+	// but their results are ignored.  If so many tasks fail that k can not
+	// succeed, or the timeout passes first, an error matching
+	// ErrQuorumNotReached that says how many succeeded and failed is
+	// returned along with the results that did succeed
+	GoQuorum(k int, tasks []func() (interface{}, error), timeout time.Duration) ([]interface{}, error)
+
+	// JoinThread waits up to the timeout for the function of the goethe
+	// thread with the given id to return.  Returns true right away if it
+	// already has, and false if it was still running after the timeout.
+	// Returns ErrNoSuchThread if no thread was ever given the id
+	JoinThread(threadID int64, timeout time.Duration) (bool, error)
+
+	// WithLockPriority calls f with the given priority used by ReadLock and
+	// WriteLock on this thread, as if ReadLockWithPriority and
+	// WriteLockWithPriority had been called, so that a fair lock lets this
+	// code path in ahead of lower priority waiters.  The previous priority,
+	// zero outside of WithLockPriority, is put back when f returns, so calls
+	// may be nested.  The WithPriority forms still use the priority they are
+	// given, and locks that are not fair ignore priorities.  Returns
+	// ErrNotGoetheThread without calling f if called from a non-goethe thread
+	WithLockPriority(priority int, f func()) error
+
+	// SetThreadName sets the name of the current goethe thread.  The name
+	// is included in the ErrorInformation of errors from this thread.
+	// Returns ErrNotGoetheThread if called from a non-goethe thread
+	SetThreadName(name string) error
+
+	// GetThreadName returns the name of the current goethe thread, or
+	// the empty string if it has no name or is not a goethe thread
+	GetThreadName() string
+
+	// NewSlogHandler returns a slog.Handler that adds the id of the current
+	// goethe thread, and its name if it has one, to every record before
+	// passing it to next, under the keys SlogThreadIDKey and
+	// SlogThreadNameKey.  Records logged from other goroutines are passed
+	// to next unchanged.  The attributes are added like any others on the
+	// record, so they go in the group the logger was in
+	NewSlogHandler(next slog.Handler) slog.Handler
+
+	// CaptureContext takes a snapshot of the values of the thread locals
+	// and the name of the current goethe thread.  The values themselves
+	// are not copied.  If called from a non-goethe thread the context is empty
+	CaptureContext() ThreadContext
+
+	// RunWithContext runs f on the current goethe thread with the thread
+	// locals and thread name from the given context.  When f returns the
+	// thread locals and name the thread had before are restored.  This is
+	// for passing context through things like shared queues where the
+	// thread that does the work is not the thread that had the context.
+	// Returns ErrNotGoetheThread (without calling f) if called from a
+	// non-goethe thread
+	RunWithContext(ctx ThreadContext, f func()) error
+
 	// GetthreadID Gets the current threadID.  Returns -1
 	// if this is not a goethe thread.  Thread ids start at 10
-	// as thread ids 0 through 9 are reserved for future use
+	// as thread ids 0 through 9 are reserved for future use.
+	// Thread ids are never reused, since locks and thread locals
+	// are kept by thread id.  Anything else that hands out thread
+	// ids must also never reuse them
 	GetThreadID() int64
 
+	// GetRuntimeGoroutineID returns the id the Go runtime gave the goroutine
+	// of the running goethe thread with the given id, so goethe threads can
+	// be matched with the goroutines in pprof profiles and execution traces.
+	// This is for diagnostics only.  The runtime does not offer goroutine ids,
+	// so it is read from the stack trace of the thread when it starts, which
+	// is best-effort.  Returns false if the thread is not running or its
+	// goroutine id could not be read
+	GetRuntimeGoroutineID(threadID int64) (uint64, bool)
+
+	// OnThreadExit registers a function to be called when the calling
+	// goethe thread exits, including when it exits because of a panic.
+	// Functions are called last registered first, on the exiting thread
+	// before its thread locals are destroyed, so they can clean up
+	// resources kept in thread locals.  A panic in one of them is
+	// ignored so that the rest are still called.  Returns
+	// ErrNotGoetheThread if not called from a goethe thread
+	OnThreadExit(hook func()) error
+
+	// SetPanicHandler sets the function told when a goethe thread panics,
+	// which is called on that thread after its exit hooks.  Panics are
+	// handled by the first of these that is set: the panic handler of the
+	// pool, for functions run by a pool, then this panic handler, and
+	// otherwise the thread panics again with the same value, which as with
+	// any goroutine ends the program.  A thread started by Go ends once its
+	// panic is handled, while a pool thread goes on to its next function.
+	// A handler that panics itself is not recovered.  A nil handler removes it
+	SetPanicHandler(handler func(info PanicInformation))
+
+	// SuspendThread asks the goethe thread with the given id to park the
+	// next time it calls Checkpoint, until ResumeThread is called.  This is
+	// for pausing long running work cooperatively without stopping it.  A
+	// pool thread parked at a checkpoint is not counted against the maximum
+	// number of threads of its pool, so the pool may start other threads
+	// while it is parked.  Closing a pool resumes its parked threads.
+	// Returns ErrNoSuchThread if the thread is not running
+	SuspendThread(threadID int64) error
+
+	// ResumeThread lets the goethe thread with the given id continue if it
+	// is parked at a checkpoint, or stops it from parking at its next one
+	// if it has been suspended.  Returns ErrNoSuchThread if the thread is
+	// not running
+	ResumeThread(threadID int64) error
+
+	// Checkpoint is called by long running code at points where it is safe
+	// to pause.  If the calling thread has been suspended with SuspendThread
+	// it parks until it is resumed, otherwise it returns right away.  Returns
+	// ErrPoolClosed if the calling thread is a pool thread whose pool has been
+	// closed, which the code may take as a sign to stop early, and
+	// ErrNotGoetheThread if not called from a goethe thread
+	Checkpoint() error
+
+	// InterruptThread makes the goethe thread with the given id give up
+	// waiting for a goethe lock, so it can be cancelled.  The ReadLock,
+	// WriteLock or TryUpgradeReadToWriteLock it is waiting in returns
+	// ErrInterrupted without the lock.  Only a wait in progress is
+	// interrupted.  If the thread is not waiting for a lock nothing happens
+	// and false is returned, so a later wait is not affected.  Waits for
+	// the locks goethe uses itself, such as those of thread locals, are
+	// never interrupted
+	InterruptThread(threadID int64) bool
+
+	// GetTaskMetadata returns the Metadata of the function the calling
+	// pool thread is running, so that the function and the interceptors
+	// of the pool (see Pool.SetTaskInterceptor) can tell what it is for.
+	// Returns nil if the calling thread is not running a pool function
+	// or the function has no metadata
+	GetTaskMetadata() map[string]string
+
+	// SetDebugMode turns on or off extra checking of the internal
+	// state of goethe.  In debug mode goethe panics if a thread id
+	// is assigned while a thread with that id is still running, and
+	// remembers where locks were taken for DumpLocks
+	SetDebugMode(debug bool)
+
+	// ResetForTesting is for tests only.  It forgets every pool, thread
+	// local, thread name and GoOnce tag, and puts the debug mode and clock
+	// back to their defaults, so that one test does not see what another
+	// test left behind.  Thread ids are not reset since they are never
+	// reused.  It must only be called when no goethe threads are running,
+	// and returns ErrThreadsRunning otherwise.  The timer thread that runs
+	// scheduled jobs does not count, and the jobs it already has keep
+	// running until cancelled
+	ResetForTesting() error
+
+	// SetClock replaces the clock used for all timeouts, idle decay and
+	// scheduled jobs.  The default clock uses the time package.  A fake
+	// clock lets tests move time forward without sleeping.  Passing nil
+	// goes back to the default clock.  Operations already waiting keep
+	// waiting on the clock they started with
+	SetClock(clock Clock)
+
 	// NewGoetheLock Creates a new goethe lock
 	NewGoetheLock() Lock
 
+	// NewGoetheLockWithOptions creates a new goethe lock
+	// that behaves as described by the options
+	NewGoetheLockWithOptions(options LockOptions) Lock
+
+	// NewGoetheLockWithTimeouts creates a new goethe lock whose ReadLock and
+	// WriteLock give up with ErrLockTimeout after waiting the given durations.
+	// A zero duration waits forever.  It is the same as NewGoetheLockWithOptions
+	// with only ReadTimeout and WriteTimeout set
+	NewGoetheLockWithTimeouts(readTimeout, writeTimeout time.Duration) Lock
+
+	// LockAll takes the write lock of every given lock in the order they
+	// were created, so that threads locking the same locks in any order
+	// can not deadlock each other.  A lock given more than once is only
+	// taken once.  The returned function unlocks them all.  If a lock
+	// can not be taken the ones already taken are unlocked and the error
+	// is returned.  The locks must have been created by goethe
+	LockAll(locks ...Lock) (unlock func(), err error)
+
+	// LockBoth is LockAll for exactly two locks, without the allocation
+	// and sorting LockAll needs.  If a and b are the same lock it is
+	// only taken once
+	LockBoth(a, b Lock) (unlock func(), err error)
+
+	// DumpLocks returns a report of every lock created with NewGoetheLock
+	// (or NewGoetheLockWithOptions) that is currently held, listing the
+	// threads holding it, their counts and the threads waiting for it.
+	// In debug mode (see SetDebugMode) it also has the stack of each holder
+	// at the time it took the lock.  Each lock is described consistently,
+	// but different locks may be looked at a moment apart
+	DumpLocks() string
+
+	// SetLockOrderHandler turns on lock order checking, or turns it off if
+	// the handler is nil.  While it is on goethe remembers, for every two
+	// locks created with NewGoetheLock that a thread held at once, which
+	// one the thread took first and where.  When a thread takes two locks
+	// in the opposite order of a thread before it the handler is told, on
+	// a goethe thread of its own, with both stacks.  Threads that do this
+	// can deadlock each other even if they have not yet.  Each pair of
+	// locks is reported once.  It is off by default since it slows down
+	// every lock and unlock.  Turning it off forgets the orders seen so far
+	SetLockOrderHandler(handler func(violation LockOrderViolation))
+
+	// CheckNoLocksHeld returns an error naming every goethe thread
+	// that still holds a read or write lock on a lock created with
+	// NewGoetheLock.  Returns nil if no such locks are held.  Useful
+	// in test teardown to find leaked locks
+	CheckNoLocksHeld() error
+
 	// NewPool creates a new thread pool with the given parameters.  The name is the
 	// name of this pool and may not be empty.  It is an error to try to create more than
 	// one open pool with the same name at the same time.
@@ -115,6 +448,40 @@ type ThreadUtilities interface {
 	NewPool(name string, minThreads int32, maxThreads int32, idleDecayDuration time.Duration,
 		functionQueue FunctionQueue, errorQueue ErrorQueue) (Pool, error)
 
+	// NewMultiQueuePool creates a new thread pool whose threads service all
+	// of the given function queues.  The selector decides which queue a thread
+	// takes its next function from.  There must be at least one function queue
+	// and none may be nil.  All other parameters are as in NewPool
+	NewMultiQueuePool(name string, minThreads int32, maxThreads int32, idleDecayDuration time.Duration,
+		selector QueueSelector, functionQueues []FunctionQueue, errorQueue ErrorQueue) (Pool, error)
+
+	// NewPoolWithContext is NewPool for a pool that closes itself when the
+	// context is done, such as a pool used for one request.  The policy
+	// decides what happens to the functions still on the function queue
+	// at that time, see ContextCancelPolicy.  Functions that are running
+	// are left to finish, as with Pool.Close, and Pool.Done can be used to
+	// wait for them.  Closing the pool before the context is done stops it
+	// from watching the context
+	NewPoolWithContext(ctx context.Context, policy ContextCancelPolicy, name string, minThreads int32,
+		maxThreads int32, idleDecayDuration time.Duration, functionQueue FunctionQueue,
+		errorQueue ErrorQueue) (Pool, error)
+
+	// NewThreadPoolFromConfig creates a new pool as described by the config,
+	// such as one from Pool.GetConfig that was saved and read back.  The
+	// config is checked as NewMultiQueuePool and the setters of Pool check
+	// their arguments, and a config with queues or a selector not made by
+	// goethe is refused.  As with NewPool, if a pool with the name already
+	// exists it is returned along with ErrPoolAlreadyExists
+	NewThreadPoolFromConfig(config PoolConfig) (Pool, error)
+
+	// ShutdownAll closes every open pool.  A started pool is not closed
+	// until its function queues are empty, and is only closed after every
+	// pool that depends on it (see Pool.DependsOn) has been closed and its
+	// threads have exited, so work submitted from one pool to another is
+	// not lost.  Returns ErrCloseTimeout if some pools still had running
+	// threads after the timeout, but all pools are closed
+	ShutdownAll(timeout time.Duration) error
+
 	// GetPool returns a non-closed pool with the given name.  If not found second
 	// value returned will be false
 	GetPool(string) (Pool, bool)
@@ -134,6 +501,29 @@ type ThreadUtilities interface {
 	// methods will be used
 	GetThreadLocal(string) (ThreadLocal, error)
 
+	// NewTaskGraph returns an empty TaskGraph
+	NewTaskGraph() TaskGraph
+
+	// NewRWMutexAdapter returns an RWMutexAdapter, which has the methods of
+	// sync.RWMutex but is backed by a new goethe Lock.  See RWMutexAdapter
+	// for how it behaves differently from sync.RWMutex
+	NewRWMutexAdapter() *RWMutexAdapter
+
+	// NewVersionedCache returns a VersionedCache of the value compute returns
+	// from data protected by the lock.  The value is computed under the read
+	// lock and only when the lock has been written since it was last computed,
+	// so the value is always one that compute returned for data between writes
+	NewVersionedCache(lock Lock, compute func() interface{}) VersionedCache
+
+	// NewWorkBudget returns a WorkBudget that allows at most maxTotal
+	// functions to be outstanding at once on the queues given it
+	NewWorkBudget(maxTotal int) WorkBudget
+
+	// NewDebouncer returns a Debouncer that runs userCall on a goethe thread
+	// once delay has passed without another call to Trigger.  Each run
+	// happens only after a Trigger, so a flurry of triggers gives one run
+	NewDebouncer(delay time.Duration, userCall func()) Debouncer
+
 	// ScheduleAtFixedRate schedules the given method with the given args at
 	// a fixed rate.  The duration of the method does not affect when the
 	// next method will be run.  The first run will happen only after initialDelay
@@ -150,6 +540,16 @@ type ThreadUtilities interface {
 	// It is the responsibility of the caller to drain the error queue
 	ScheduleWithFixedDelay(initialDelay time.Duration, delay time.Duration,
 		errorQueue ErrorQueue, method interface{}, args ...interface{}) (Timer, error)
+
+	// ListScheduled returns every method scheduled with ScheduleAtFixedRate
+	// or ScheduleWithFixedDelay that has not been cancelled, the one to run
+	// next first
+	ListScheduled() []ScheduledHandle
+
+	// CancelAllScheduled cancels every scheduled method, for example when
+	// shutting down, and returns how many were cancelled.  Cancelling a
+	// timer more than once has no further effect
+	CancelAllScheduled() int
 }
 
 // Pool is used to manage a thread pool.  Every thread pool has one
@@ -170,6 +570,23 @@ type Pool interface {
 	// GetMaxThreads the maximum number of threads for this pool
 	GetMaxThreads() int32
 
+	// Resize changes the minimum and maximum number of threads of this pool
+	// together, so the minimum is never above the maximum in between.
+	// Threads are started right away to reach the new minimum.  Threads
+	// over the new maximum leave once they finish the function they are
+	// running, and threads over the new minimum leave once they have been
+	// idle for the idle decay duration.  Returns an error if min is greater
+	// than max and ErrPoolClosed if the pool has been closed
+	Resize(min, max int32) error
+
+	// RetireThread asks the thread of this pool with the given id to leave
+	// the pool once it has finished the function it is running, for example
+	// to recycle a thread suspected to be in a bad state.  If the pool would
+	// then have fewer than its minimum number of threads a replacement is
+	// started right away.  Returns ErrNoSuchThread if the thread is not in
+	// this pool and ErrPoolClosed if the pool has been closed
+	RetireThread(threadID int64) error
+
 	// GetIdleDecayDuration returns the IdleDecayDuration of this
 	// thread pool (the duration a thread must be idle before being
 	// removed from the pool)
@@ -179,9 +596,40 @@ type Pool interface {
 	// in this pool
 	GetCurrentThreadCount() int32
 
-	// GetFunctionQueue Returns the function queue associated with this pool
+	// AwaitThreadCount waits up to the timeout for the current number of
+	// threads in this pool to be the target, returning right away if it
+	// already is.  This is for checking that a pool has warmed up or shrunk
+	// without polling GetCurrentThreadCount.  Returns an error matching
+	// ErrThreadCountTimeout that gives the actual count if the pool did not
+	// have the target number of threads before the timeout
+	AwaitThreadCount(target int32, timeout time.Duration) error
+
+	// GetIdleThreadCount returns the number of threads in this pool waiting
+	// for a function and GetBusyThreadCount the number running one.  Together
+	// they add up to GetCurrentThreadCount at the moment they are called.
+	// They are cheaper than GetStats for a health check polled often
+	GetIdleThreadCount() int32
+	GetBusyThreadCount() int32
+
+	// GetFunctionQueue Returns the function queue associated with this pool.
+	// If this pool has more than one function queue returns the first one
 	GetFunctionQueue() FunctionQueue
 
+	// SetFunctionQueue replaces the function queue of this pool (the first one
+	// if it has more than one) while the pool keeps running.  The functions on
+	// the old queue are moved to the back of the new queue in order, and pool
+	// threads take functions from the new queue from then on.  Functions
+	// given to methods of the pool such as Submit are never lost, but functions
+	// enqueued directly on the old queue afterwards are not run, so callers
+	// should enqueue on the queue returned by GetFunctionQueue.  If the functions
+	// do not all fit on the new queue it returns ErrAtCapacity and nothing
+	// changes.  Returns ErrPoolClosed if the pool has been closed
+	SetFunctionQueue(newQueue FunctionQueue) error
+
+	// GetFunctionQueues returns all of the function queues associated
+	// with this pool
+	GetFunctionQueues() []FunctionQueue
+
 	// GetErrorQueue returns the error queue associated with this pool
 	GetErrorQueue() ErrorQueue
 
@@ -189,11 +637,257 @@ type Pool interface {
 	// this pool from Goethe's map of pools
 	IsClosed() bool
 
+	// GetStats returns a snapshot of the statistics of this pool
+	GetStats() PoolStats
+
+	// ResetStats returns a snapshot of the statistics of this pool and sets
+	// the counters, SaturationEvents, TaskFailures, ThreadsRecycledForTasks
+	// and ThreadsRecycledForAge, to zero in one step
+	// so no count is lost between the two.  The other statistics describe
+	// the pool as it is now and are not changed
+	ResetStats() PoolStats
+
+	// SetCircuitBreaker protects the pool from functions that keep failing.
+	// Once threshold functions have returned an error within the window
+	// the breaker opens and the pool stops adding threads until the number
+	// of failures within the window drops below threshold again.  When the
+	// breaker opens ErrCircuitBreakerOpen is put on the error queue.  A
+	// threshold of zero turns the circuit breaker off, which is the default
+	SetCircuitBreaker(threshold int, window time.Duration) error
+
+	// SetHealthThresholds sets when the pool is considered unhealthy, see
+	// GetHealth and SubmitOrFail.  Thresholds left at zero are not checked,
+	// which is the default.  The functions the error rate is taken from are
+	// only recorded while an error rate threshold is set
+	SetHealthThresholds(thresholds PoolHealthThresholds) error
+
+	// GetHealth returns how the pool is doing compared with the thresholds
+	// given to SetHealthThresholds
+	GetHealth() PoolHealth
+
+	// SubmitOrFail is like Submit but if the pool is not healthy according
+	// to GetHealth the function is not enqueued and ErrPoolUnhealthy is
+	// returned, wrapped with the reasons.  This lets callers shed load
+	// rather than add to the work of a pool that is struggling
+	SubmitOrFail(userCall interface{}, args ...interface{}) (Future, error)
+
+	// Resubmit submits the function that returned the error again, with
+	// the same arguments, as Submit does.  This is for retrying functions
+	// that failed for a passing reason, such as a timeout, from the error
+	// queue of the pool.  Only the function and arguments are kept, so a
+	// function given to SubmitKeyed or similar is now submitted without its
+	// key.  Returns ErrNotResubmittable if the error did not come from a
+	// function given to a pool and ErrPoolClosed if this pool is closed
+	Resubmit(info ErrorInformation) (Future, error)
+
+	// GetRunningTasks returns what each thread of the pool that is running
+	// a function is running, in order of thread id, all taken at the same
+	// moment.  Threads waiting for a function are left out.  Functions
+	// given to SubmitKeyed, SubmitOrdered, SubmitWithCategory and
+	// SubmitWeighted are run by a function of the pool, which is the
+	// name reported for them
+	GetRunningTasks() []RunningTask
+
+	// SetMonitorInterval sets how often the pool checks whether it needs
+	// more threads when nothing else prompts it to.  The pool already
+	// checks whenever its queues change, so this is only the fallback,
+	// and a shorter interval reacts sooner to missed changes at the cost
+	// of more checks.  The default is one minute.  The interval must be
+	// positive.  Returns ErrPoolClosed if the pool has been closed
+	SetMonitorInterval(interval time.Duration) error
+
+	// SetThreadRecycling limits how long each thread of the pool is used,
+	// which bounds the harm done by functions that leak a little each time
+	// they run.  A thread leaves the pool once it has run maxTasksPerThread
+	// functions or is older than maxThreadLifetime, between functions, and
+	// a new thread is started if the pool would otherwise have fewer than its
+	// minimum number of threads.  Zero turns either limit off, which is the
+	// default.  The threads recycled for each reason are counted in PoolStats
+	SetThreadRecycling(maxTasksPerThread int64, maxThreadLifetime time.Duration) error
+
+	// SetDroppedErrorHandler sets a function to be given every error the
+	// error queue of the pool returns an error for instead of taking, such
+	// as ErrAtCapacity from a full BoundedErrorQueue.  These errors are
+	// counted in PoolStats.ErrorsDropped whether or not there is a handler.
+	// The handler is called on the pool thread that had the error, so it
+	// should not take long.  A nil handler removes it
+	SetDroppedErrorHandler(handler func(info ErrorInformation))
+
+	// SetSizeChangeListener sets a function to be told every time a thread
+	// joins or leaves the pool, with the number of threads before and after
+	// and the reason, one of the SizeChange values.  It is called in the
+	// order the changes happened, in a goroutine of its own and never with
+	// the pool locked, so it may use the pool.  Changes made before the
+	// listener is set are not reported.  A nil listener removes it
+	SetSizeChangeListener(listener func(oldCount, newCount int32, reason string))
+
+	// SetTaskInterceptor adds an interceptor that is run around every
+	// function run by the threads of this pool.  The interceptor is given
+	// a function that runs the pool function and returns the function
+	// the thread calls instead, so it can do things such as start a span
+	// before calling next and end it afterwards, or recover panics.
+	// Interceptors compose in the order they are added, the first one
+	// being outermost.  If the function does not finish, because an
+	// interceptor never calls next or recovers a panic of the function,
+	// the callback of the function is given ErrTaskNotRun
+	SetTaskInterceptor(interceptor func(next func()) func()) error
+
+	// SetPanicHandler sets the function told when a function run by a
+	// thread of this pool panics.  It is called on the thread that
+	// panicked, which then goes on to run other functions.  The function
+	// is counted as failed, its error, which wraps ErrTaskPanicked, is put
+	// on the error queue and given to its callback.  Without a handler for
+	// the pool the panic goes to the handler given to
+	// ThreadUtilities.SetPanicHandler, and without that the thread panics
+	// again.  A nil handler removes it
+	SetPanicHandler(handler func(info PanicInformation))
+
+	// Submit enqueues the function and arguments onto the function queue
+	// of this pool and returns a Future that can be used to get the
+	// results of the function.  Returns ErrPoolClosed if this pool has
+	// been closed and ErrAtCapacity if the function queue is full, or
+	// ErrWorkBudgetExhausted if its work budget is used up
+	Submit(userCall interface{}, args ...interface{}) (Future, error)
+
+	// SubmitBlocking is like Submit but if the function queue is full it
+	// waits until there is room rather than returning ErrAtCapacity, which
+	// slows down producers that get ahead of the pool.  If the context is
+	// done while waiting it returns the error of the context
+	SubmitBlocking(ctx context.Context, userCall interface{}, args ...interface{}) (Future, error)
+
+	// SubmitStream enqueues a function that hands back values while it
+	// runs by calling emit.  The values are read from the first channel,
+	// which is closed when the function finishes.  The error the function
+	// returns is then sent on the second channel, which is closed after
+	// it; if the function returns nil it is closed with nothing sent.
+	// The value channel is not buffered, so emit waits until the value is
+	// read and the caller must read it until it is closed.  If the
+	// function can not be enqueued, or is cleared from the queue, both
+	// channels are closed and the error, such as ErrPoolClosed or
+	// ErrAtCapacity, is sent on the second one.  Values emitted after the
+	// function has returned are dropped
+	SubmitStream(task func(emit func(interface{})) error) (<-chan interface{}, <-chan error)
+
+	// SubmitKeyed is like Submit but no two functions submitted with the
+	// same key will run at the same time anywhere in the pool, even if both
+	// were submitted before either started.  What happens to a function
+	// whose key is already running is decided by SetDuplicateKeyPolicy
+	SubmitKeyed(key string, userCall interface{}, args ...interface{}) (Future, error)
+
+	// SetDuplicateKeyPolicy sets what happens to a function submitted with
+	// SubmitKeyed when a function with the same key is running.  The
+	// default is WaitForDuplicateKey
+	SetDuplicateKeyPolicy(DuplicateKeyPolicy)
+
+	// GetRunningKeys returns the keys of the functions submitted with
+	// SubmitKeyed that are currently running, in sorted order
+	GetRunningKeys() []string
+
+	// SubmitOrdered is like Submit but functions submitted with the same
+	// key run one at a time in the order they were submitted, while
+	// functions of different keys run at the same time on as many threads
+	// as the pool has.  Unlike SubmitKeyed the order is fixed when the
+	// function is submitted, which suits processing the events of each
+	// entity in order.  The functions of a key run one after the other on
+	// the thread that ran the first of them, unless the pool rebalances,
+	// see SetOrderedRebalancing
+	SubmitOrdered(key string, userCall interface{}, args ...interface{}) (Future, error)
+
+	// GetActiveKeyCount returns the number of keys given to SubmitOrdered
+	// that have functions running or waiting to run
+	GetActiveKeyCount() int
+
+	// SetOrderedRebalancing turns rebalancing of the keys given to
+	// SubmitOrdered on or off.  Without it a thread that runs a function
+	// of a key goes on to run every function of the key waiting behind
+	// it, so a few busy keys can keep every thread and the functions of
+	// other keys wait on the queue.  With it the thread puts the next
+	// function of the key at the back of the function queue instead, for
+	// whichever thread is free, and the functions of a key still run one
+	// at a time in order.  If the queue is full the thread runs the next
+	// function itself.  Off by default
+	SetOrderedRebalancing(rebalance bool)
+
+	// GetOrderedBacklogs returns, for every key given to SubmitOrdered that
+	// has a function running or on the queue, how many more functions of
+	// the key are waiting behind it
+	GetOrderedBacklogs() map[string]int
+
+	// SubmitWithCategory is like Submit but no more functions of the
+	// category will run at the same time than the limit given to
+	// SetCategoryLimit.  Functions of a category at its limit wait, in
+	// order, while functions of other categories keep running.  This keeps
+	// one kind of slow function from using every thread of the pool
+	SubmitWithCategory(category string, userCall interface{}, args ...interface{}) (Future, error)
+
+	// SetCategoryLimit sets the maximum number of functions of the category
+	// that may run at once.  Zero, the default, means no limit
+	SetCategoryLimit(category string, maxConcurrent int) error
+
+	// GetCategoryCounts returns the number of functions of each category
+	// that are currently running.  Categories with none running are left out
+	GetCategoryCounts() map[string]int
+
+	// SubmitWeighted is like Submit but the function has a weight, such
+	// as an estimate of the memory it uses.  The pool will not start a
+	// function if that would make the total weight of the running weighted
+	// functions more than the limit given to SetMaxWeight.  Such functions
+	// wait, in order, without holding a thread.  A function heavier than
+	// the limit runs when no other weighted function is running
+	SubmitWeighted(weight int64, userCall interface{}, args ...interface{}) (Future, error)
+
+	// SetMaxWeight sets the most total weight of weighted functions
+	// that may run at once.  Zero, the default, means no limit
+	SetMaxWeight(maxWeight int64) error
+
+	// GetInFlightWeight returns the total weight of the weighted
+	// functions that are currently running
+	GetInFlightWeight() int64
+
 	// Close closes this pool.  All work remaining will be completed, but
 	// no new work will be accepted.  The system will stop reading from
 	// the FunctionQueue, so any remaining jobs can be found on the function
 	// queue
 	Close()
+
+	// CloseWait closes this pool and then waits up to the given duration
+	// for the threads of this pool to finish the functions they are running
+	// and exit.  The error queue of this pool is then drained and its
+	// contents returned, so errors from the last functions run are not
+	// lost.  If threads were still running when the duration expired
+	// the drained errors are returned along with ErrCloseTimeout
+	CloseWait(time.Duration) ([]ErrorInformation, error)
+
+	// CloseWaitWithProgress is CloseWait that calls progress every interval
+	// while it waits, with the number of functions left on the function
+	// queues and the number of functions still running, so that a long
+	// shutdown can be followed.  Progress is called a final time when the
+	// wait is over, which is with zero functions running unless the
+	// duration expired first.  The functions left on the queues are not
+	// run by this pool, see Close.  Progress is called on the calling
+	// goroutine and the interval must be positive
+	CloseWaitWithProgress(duration time.Duration, interval time.Duration,
+		progress func(queued int, running int)) ([]ErrorInformation, error)
+
+	// Done returns a channel that is closed once this pool has been
+	// closed and all of its threads have finished their functions and
+	// exited, which is when CloseWait would return without timing out.
+	// Before Close is called the channel is open
+	Done() <-chan struct{}
+
+	// GetConfig describes the threads, queues and settings of this pool in
+	// a form that can be saved, for example as JSON, and given to
+	// ThreadUtilities.NewThreadPoolFromConfig to make a pool just like it.
+	// Handlers, listeners, interceptors and dependencies are code or other
+	// pools, so they are not part of it
+	GetConfig() PoolConfig
+
+	// DependsOn declares that the functions of this pool use the other
+	// pool, for example by submitting to it.  ThreadUtilities.ShutdownAll
+	// will then shut this pool down before the other.  Returns
+	// ErrDependencyCycle if the other pool already depends on this one
+	// and ErrPoolClosed if either pool is closed
+	DependsOn(other Pool) error
 }
 
 // Lock is a reader/writer lock that is a counting lock
@@ -201,7 +895,10 @@ type Pool interface {
 // one writer.  You CAN get a reader lock while inside a write
 // lock.  No readers will be allowed in while a write-lock
 // is waiting to get in.  If you just use the WriteLock calls
-// this behaves like a counting mutex
+// this behaves like a counting mutex.  Changes made while holding
+// the write lock are seen by the next thread to take the lock, and
+// since goethe locks are built on sync.Mutex the race detector of
+// go test -race knows this too
 type Lock interface {
 	// Locker the methods in Locker are equivalent to WriteLock and
 	// WriteUnlock
@@ -210,7 +907,11 @@ type Lock interface {
 	// ReadLock Locks for read.  Multiple readers on multiple threads
 	// are allowed in simultaneously.  Is counting, but all locks must
 	// be paired with ReadUnlock.  You may get a ReadLock while holding
-	// a WriteLock.  May only be called from inside a Goethe thread
+	// a WriteLock.  Once a writer is waiting, threads that do not already
+	// hold the read lock wait until the writer is done, so a steady stream
+	// of readers can not starve it.  Threads that already hold the read
+	// lock can still take it again.  May only be called from inside a
+	// Goethe thread
 	ReadLock() error
 
 	// ReadUnlock unlocks the read lock.  Will only truly leave
@@ -219,14 +920,238 @@ type Lock interface {
 
 	// WriteLock Locks for write.  Only one writer is allowed
 	// into the critical section.  Once a WriteLock is requested
-	// no more readers will be allowed into the critical section.
+	// no new readers will be allowed into the critical section.
 	// An ReadLockHeld error will be returned immediately if an attempt
-	// is made to acquire a WriteLock when a ReadLock is held
+	// is made to acquire a WriteLock when a ReadLock is held, leaving the
+	// read lock held as many times as it was
 	WriteLock() error
 
 	// WriteUnlock unlocks write lock.  Will only truly leave
 	// critical section as reader when count is zero
 	WriteUnlock() error
+
+	// TransferWriteLock gives the write lock held by the calling thread,
+	// including its count, to the goethe thread with the given id.  This
+	// is for continuation patterns where a critical section is finished on
+	// another thread.  Returns ErrWriteLockNotHeld if the caller does not
+	// hold the write lock, ErrReadLockHeld if the caller also holds a read
+	// lock and ErrNoSuchThread if the other thread is not running.
+	// After a successful transfer the caller no longer owns the lock and
+	// must not unlock it (beware of deferred unlocks) while the receiving
+	// thread must call WriteUnlock once for every count transferred.  If the
+	// receiving thread is waiting in WriteLock it will get the lock with its
+	// count increased by one
+	TransferWriteLock(toThreadID int64) error
+
+	// GetWriteOwner returns the id of the goethe thread currently holding
+	// the write lock.  The second value is false if no thread holds it.
+	// By the time it returns the owner may have changed, so this is for
+	// diagnostics such as logging who is holding up a writer
+	GetWriteOwner() (int64, bool)
+
+	// WaitUntilUnlocked waits up to the given duration until no thread
+	// holds the write lock.  It does not acquire the lock, so by the time
+	// it returns another writer may already have the lock.  Returns
+	// ErrLockTimeout if the write lock was still held after the duration.
+	// May be called from any thread.  If called by the thread holding
+	// the write lock it will time out
+	WaitUntilUnlocked(time.Duration) error
+
+	// TryUpgradeReadToWriteLock attempts to acquire the write lock while
+	// the caller holds a read lock, waiting up to the given duration for
+	// all other readers to leave.  On success it returns true and the caller
+	// holds the write lock in addition to its read lock, so it must call
+	// WriteUnlock and later ReadUnlock.  On timeout it returns false and the
+	// caller still holds its read lock unchanged.  If another thread is already
+	// trying to upgrade it returns false immediately, since neither upgrade
+	// could succeed while both threads hold read locks.  Returns
+	// ErrReadLockNotHeld if the caller does not hold a read lock
+	TryUpgradeReadToWriteLock(time.Duration) (bool, error)
+
+	// ReadThenMaybeWrite takes the read lock and runs read.  If read returns
+	// true the lock is upgraded to the write lock and write is run.  If the
+	// lock can not be upgraded right away because other threads are reading,
+	// the read lock is released, the write lock is taken and read is run
+	// again, since another thread may have done the write in between.  The
+	// locks are released on every path, including panics.  Returns any error
+	// from taking the locks, in which case read or write may not have run
+	ReadThenMaybeWrite(read func() bool, write func()) error
+
+	// ReadLockYieldPoint is for long readers to call every so often.  If a
+	// writer is waiting it gives up all of the read lock counts of the caller,
+	// waits for the waiting writers to finish and then takes the same counts
+	// back, returning true.  Otherwise it returns false right away.  Anything
+	// read under the lock before a yield may have been changed by a writer,
+	// so after it returns true such state must be read again.  Returns false
+	// without yielding if the caller does not hold the read lock, or also
+	// holds the write lock
+	ReadLockYieldPoint() bool
+
+	// WriteThenRead takes the write lock and runs mutate, then swaps the
+	// write lock for a read lock without letting another writer in between
+	// and runs use.  Other readers can go ahead while use runs, so the lock
+	// is only held exclusively for as long as mutate takes.  The locks are
+	// released on every path, including panics.  Returns any error from
+	// taking the locks, in which case mutate or use may not have run
+	WriteThenRead(mutate func(), use func()) error
+
+	// WriteLocker returns a sync.Locker whose Lock and Unlock call
+	// WriteLock and WriteUnlock.  ReadLocker returns one whose Lock and
+	// Unlock call ReadLock and ReadUnlock.  These are for libraries that
+	// take a sync.Locker, such as sync.NewCond.  Since sync.Locker can
+	// not return errors the adapters panic on any error, for example
+	// when used from a non-goethe thread.  Unlike sync.Mutex these
+	// lockers are reentrant, which matters to code that expects a second
+	// Lock from the same thread to block.  In particular sync.Cond.Wait
+	// only releases one count of the lock
+	WriteLocker() sync.Locker
+	ReadLocker() sync.Locker
+
+	// ReadLockWithPriority and WriteLockWithPriority are ReadLock and
+	// WriteLock with a priority hint.  In a fair lock (see LockOptions.Fair)
+	// waiting threads with a higher priority are granted the lock before
+	// those with a lower priority, and waiting threads with the same priority
+	// are granted it in the order they asked.  Go can not raise the scheduling
+	// priority of the thread holding the lock, so this only decides who goes
+	// next once the lock is free.  Other locks ignore the priority.  ReadLock
+	// and WriteLock use priority zero
+	ReadLockWithPriority(priority int) error
+	WriteLockWithPriority(priority int) error
+
+	// GetStats returns statistics about this lock
+	GetStats() LockStats
+
+	// GetWriteVersion returns a counter that goes up by one each time
+	// a thread gives up the write lock, when its last WriteUnlock is
+	// called.  It does not take the lock, so a cache can store the
+	// version it read along with the data and later tell cheaply whether
+	// the data may have been written since.  The version never goes down.
+	// Compare versions for equality rather than order, which stays
+	// correct even in the unlikely case of the counter wrapping around
+	GetWriteVersion() uint64
+
+	// ResetStats returns statistics about this lock and sets the counters
+	// to zero in one step, so no count is lost between the two.  Calling
+	// it at the end of each reporting interval gives the counts for that
+	// interval
+	ResetStats() LockStats
+
+	// GetLockState returns who holds and who is waiting for this lock,
+	// all taken at the same moment.  This is for diagnostics, since the
+	// lock may have changed hands by the time the caller looks at it
+	GetLockState() LockState
+}
+
+// LockState is a snapshot of the holders and waiters of a lock,
+// see Lock.GetLockState
+type LockState struct {
+	// WriteOwner is the id of the thread holding the write lock and
+	// WriteCount how many times it holds it.  Both are zero if no
+	// thread holds the write lock
+	WriteOwner int64
+	WriteCount int32
+
+	// ReadHolders is how many times each thread holding
+	// the read lock holds it, by thread id
+	ReadHolders map[int64]int32
+
+	// Waiters are the threads waiting for the lock.  For a fair lock
+	// they are in the order they will get the lock, otherwise they are
+	// in the order they started waiting
+	Waiters []LockWaiter
+}
+
+// LockWaiter is a thread waiting for a lock, see LockState
+type LockWaiter struct {
+	// ThreadID is the id of the waiting thread
+	ThreadID int64
+
+	// Mode is what the thread is waiting for, one of
+	// "read", "write" or "upgrade" for TryUpgradeReadToWriteLock
+	Mode string
+}
+
+// LockStats is a snapshot of statistics about a lock
+type LockStats struct {
+	// PriorityReorders is the number of times a fair lock was granted
+	// to a thread while a thread that asked earlier was left waiting
+	// because of its lower priority
+	PriorityReorders uint64
+
+	// Contended is the number of times a thread had to wait for the
+	// lock, TotalWait is how long those threads waited altogether and
+	// MaxWait is the longest wait.  Waits that timed out or were
+	// interrupted are counted as well
+	Contended uint64
+	TotalWait time.Duration
+	MaxWait   time.Duration
+
+	// WriteHoldTimes and ReadHoldTimes are how long threads held the
+	// write lock and the read lock, if LockOptions.RecordHoldTimes is
+	// set.  A hold is from when a thread takes the lock until it gives
+	// it up entirely, however many times it took it in between
+	WriteHoldTimes HoldTimeStats
+	ReadHoldTimes  HoldTimeStats
+}
+
+// HoldTimeStats describes how long a lock was held, see LockStats
+type HoldTimeStats struct {
+	// Count is the number of holds that have ended
+	Count uint64
+
+	// P50 and P99 are the median and 99th percentile hold
+	// times of the most recent 1024 holds
+	P50 time.Duration
+	P99 time.Duration
+
+	// Max is the longest hold
+	Max time.Duration
+}
+
+// LockOptions are given to NewGoetheLockWithOptions to change how
+// the lock behaves.  The zero value gives the same lock as NewGoetheLock
+type LockOptions struct {
+	// NonReentrant if true makes a WriteLock (or an upgrade) by a thread
+	// that already holds the write lock return ErrAlreadyHeld rather
+	// than increment the count.  Useful to find accidental recursion
+	NonReentrant bool
+
+	// MaxRecursionDepth if greater than zero is the most times a single
+	// thread may hold the read lock, or the write lock, at once.  Going
+	// past it returns ErrRecursionLimitExceeded.  Zero, the default, is
+	// no limit.  Useful to stop recursive code that might not end
+	MaxRecursionDepth int32
+
+	// Fair if true grants the lock to waiting threads in order of the
+	// priority they gave (see Lock.WriteLockWithPriority) and then in the
+	// order they asked, rather than letting them race for it.  Readers
+	// that are next in line are let in together
+	Fair bool
+
+	// ReadTimeout and WriteTimeout if greater than zero are the longest
+	// ReadLock and WriteLock (and their WithPriority forms) wait for the
+	// lock before returning ErrLockTimeout.  Zero, the default, waits
+	// forever.  Since Lock and the lockers from WriteLocker and ReadLocker
+	// can not return errors they panic on a timeout
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Name if not empty is shown with the id of the lock in DumpLocks
+	// and in the LockOrderViolation reports of SetLockOrderHandler
+	Name string
+
+	// RecordHoldTimes if true times how long threads hold the lock, for
+	// finding the occasional slow critical section.  The times are in the
+	// ReadHoldTimes and WriteHoldTimes of Lock.GetStats.  Off by default
+	// since it adds a little work to every lock and unlock
+	RecordHoldTimes bool
+
+	// SpinCount is how many times a thread that finds the lock taken lets
+	// other goroutines run with runtime.Gosched and looks again before it
+	// blocks.  For a lock held only for moments this is cheaper than
+	// blocking and being woken.  Zero, the default, or a negative count
+	// blocks right away.  Fair locks do not spin
+	SpinCount int
 }
 
 // FunctionDescriptor describes a function to be called with
@@ -234,21 +1159,182 @@ type Lock interface {
 type FunctionDescriptor struct {
 	UserCall interface{}
 	Args     []interface{}
+
+	// ThreadID if non-zero is the id of the goethe thread
+	// this function must be run on
+	ThreadID int64
+
+	// EnqueueTime is when this function was put on the queue
+	EnqueueTime time.Time
+
+	// OnDone if not nil is called by the pool thread after the
+	// function returns, with the values it returned and the first
+	// non-nil error among them
+	OnDone func(results []interface{}, err error)
+
+	// Deadline if not zero is the time by which a pool thread must
+	// have dequeued this function.  A function dequeued after its
+	// deadline is not run, instead ErrDeadlineExceeded is put on
+	// the error queue of the pool and given to OnDone
+	Deadline time.Time
+
+	// Metadata if not nil describes this function, for example with the
+	// tenant or request it is for.  It can be read while the function is
+	// on the queue with ForEach and while it runs in a pool with
+	// ThreadUtilities.GetTaskMetadata
+	Metadata map[string]string
+
+	barrier  bool
+	finisher func(*FunctionDescriptor)
+	finished bool
+	budget   WorkBudget
+	handoff  *lockHandoff
+}
+
+// Finished tells the queue this function came from that the function
+// has finished running.  Pools call this for every function they dequeue.
+// Code that dequeues functions itself only needs to call it when using
+// FunctionQueue.EnqueueBarrier, since a barrier waits for every function
+// before it to be finished, FunctionQueue.SetWorkBudget, since the
+// function keeps its share of the budget until it is finished, or
+// FunctionQueue.EnqueueWithWriteLock, since the function holds the write
+// lock until it is finished.  Calling it more than once has no effect
+func (descriptor *FunctionDescriptor) Finished() {
+	if descriptor.finished || descriptor.finisher == nil {
+		return
+	}
+
+	descriptor.finished = true
+	descriptor.finisher(descriptor)
 }
 
 // FunctionQueue a queue of functions to be enqueued and dequeued
 // The system can use any FunctionQueue it is given or you can use
 // the ones returned by Goethe.NewBoundedFunctionQueue
+//
+// The queues made by goethe are first in first out.  Each enqueue takes
+// the lock of the queue, which is the moment it is ordered against every
+// other enqueue, and the function is put at the back of the queue and
+// given its EnqueueTime while the lock is held.  So functions are dequeued
+// in the order of their EnqueueTime even with many producers, and the
+// functions of any one producer are dequeued in the order it enqueued
+// them.  Only functions enqueued to a thread, which other threads pass
+// over, and barriers, which hold back the functions behind them, change
+// this.  In a pool with more than one thread functions dequeued in order
+// may of course still finish in any order
 type FunctionQueue interface {
 	// Enqueue queues a function to be run in the pool.  Returns
 	// ErrAtCapacity if the queue is currently at capacity
 	Enqueue(userCall interface{}, args ...interface{}) error
 
+	// EnqueueToThread queues a function that will only be dequeued by
+	// the goethe thread with the given id.  Returns ErrAtCapacity if the
+	// queue is currently at capacity.  If the thread is not running and
+	// affinity fallback is off returns ErrNoSuchThread
+	EnqueueToThread(threadID int64, userCall interface{}, args ...interface{}) error
+
+	// EnqueueWithCallback queues a function to be run in the pool.  After
+	// the function returns onDone is called on the same pool thread with
+	// the values the function returned and the first non-nil error among
+	// them.  A panic in onDone does not affect the pool thread.  Returns
+	// ErrAtCapacity if the queue is currently at capacity
+	EnqueueWithCallback(onDone func(results []interface{}, err error), userCall interface{}, args ...interface{}) error
+
+	// EnqueueWithDeadline queues a function to be run in the pool that is
+	// skipped if a pool thread has not dequeued it by the given deadline,
+	// so that work nobody is waiting for anymore is not run when the pool
+	// is overloaded.  Returns ErrAtCapacity if the queue is currently at
+	// capacity
+	EnqueueWithDeadline(deadline time.Time, userCall interface{}, args ...interface{}) error
+
+	// EnqueueWithMetadata queues a function to be run in the pool with the
+	// given metadata, which is copied into the Metadata of its descriptor.
+	// Returns ErrAtCapacity if the queue is currently at capacity
+	EnqueueWithMetadata(metadata map[string]string, userCall interface{}, args ...interface{}) error
+
+	// EnqueueIf queues a function to be run in the pool only if the predicate
+	// returns true, checking and enqueueing in one step so that no other
+	// enqueue or dequeue can happen in between.  The predicate is given a
+	// copy of each function descriptor on the queue, from the front of the
+	// queue to the back, and can look at their number, Metadata or UserCall,
+	// for example to only enqueue a flush if none is already waiting.  The
+	// predicate runs with the queue locked, so it must not call any method
+	// of this queue and should be quick.  Returns true if the function was
+	// enqueued.  Returns false and ErrAtCapacity if the predicate returned
+	// true but the queue is at capacity
+	EnqueueIf(predicate func(pending []FunctionDescriptor) bool, userCall interface{}, args ...interface{}) (bool, error)
+
+	// EnqueueWithWriteLock queues a function that runs holding the write
+	// lock the calling thread holds now, so that a critical section can be
+	// started on one thread and finished on the pool thread without anyone
+	// else getting the lock in between.  The lock must have been made by
+	// goethe and be held for write once, with no read lock, by the calling
+	// goethe thread.  From then on the calling thread can no longer use
+	// the lock, it gets ErrLockHandedOff if it tries, and other threads
+	// wait for the lock as usual.  The goethe thread that dequeues the
+	// function gets the write lock, as with Lock.TransferWriteLock.  When
+	// the function is finished (see FunctionDescriptor.Finished) the write
+	// lock is unlocked once for it, unless the function unlocked or
+	// transferred it itself.  If the function is cleared, shed or is
+	// dequeued by a thread that is not a goethe thread the lock is held
+	// on its behalf until then.  Returns ErrWriteLockNotHeld if the
+	// calling thread does not hold the write lock and ErrAtCapacity if
+	// the queue is at capacity, in which case the caller keeps the lock
+	EnqueueWithWriteLock(lock Lock, userCall interface{}, args ...interface{}) error
+
+	// EnqueueBarrier queues a function that is only returned by Dequeue once
+	// every function enqueued before it has been dequeued and finished (see
+	// FunctionDescriptor.Finished), and no function enqueued after it is
+	// returned until the barrier function itself has finished.  This is for
+	// checkpoints between batches of work.  A barrier only orders the functions
+	// of its own queue, so in a pool with several queues functions from other
+	// queues keep running while the barrier waits.  Functions before the
+	// barrier that were enqueued to a thread must be run by that thread
+	// before the barrier can run.  Returns ErrAtCapacity if the queue is
+	// currently at capacity
+	EnqueueBarrier(userCall interface{}, args ...interface{}) error
+
 	// Dequeue returns a function to be run, waiting the given
 	// duration.  If there is no message within the given
-	// duration return the error returned will be ErrEmptyQueue
+	// duration return the error returned will be ErrEmptyQueue.
+	// Functions enqueued with EnqueueToThread are only returned
+	// to their own thread.  If their thread has exited and affinity
+	// fallback is off the function is removed and returned along
+	// with ErrNoSuchThread
 	Dequeue(time.Duration) (*FunctionDescriptor, error)
 
+	// TryDequeue returns the next function to be run without waiting,
+	// or false if there is none right now.  It is Dequeue with a zero
+	// duration for worker loops of their own that do other work while
+	// no functions are queued.  A function enqueued to a thread that has
+	// exited is returned as Dequeue would, and so can be recognized by
+	// its ThreadID not being the calling thread
+	TryDequeue() (*FunctionDescriptor, bool)
+
+	// DequeueWithDepth is Dequeue that also returns the number of functions
+	// left on the queue once this one has been removed, taken at the same
+	// time so that a worker can tell whether it is behind without calling
+	// GetSize.  If the error is ErrEmptyQueue the depth is the size of the
+	// queue, which is not zero when the functions left are for other threads
+	DequeueWithDepth(time.Duration) (*FunctionDescriptor, int, error)
+
+	// SetAffinityFallback sets what happens to functions enqueued with
+	// EnqueueToThread whose thread has exited.  If true they can be
+	// run on any thread, otherwise they are errors.  The default is false
+	SetAffinityFallback(bool)
+
+	// ForEach calls the callback with a copy of each function descriptor
+	// on the queue, from the front of the queue to the back, stopping early
+	// if the callback returns false.  The queue is locked while iterating,
+	// so the callback must not call any method of this queue
+	ForEach(func(FunctionDescriptor) bool)
+
+	// Clear removes every function from the queue and returns how many were
+	// removed.  The callbacks of functions enqueued with EnqueueWithCallback
+	// are called with ErrCleared, which also completes the Futures of functions
+	// given to Pool.Submit.  Functions already dequeued are not affected
+	Clear() int
+
 	// GetCapacity gets the capacity of this queue
 	GetCapacity() uint32
 
@@ -262,6 +1348,310 @@ type FunctionQueue interface {
 	// called whenever an enqueue or dequeue changes
 	// the size of queue
 	SetStateChangeCallback(func(FunctionQueue))
+
+	// SetHighWatermark sets a function to be called when the size of the
+	// queue rises to the given fraction of its capacity, as a warning
+	// before the queue is full and enqueues fail with ErrAtCapacity.  It
+	// is called once, with the size of the queue, each time the size goes
+	// from below the mark to at or above it, not for every enqueue while
+	// above it.  If the queue is already at the mark it is called the next
+	// time the mark is reached.  The fraction must be greater than zero
+	// and at most one.  A nil onReached removes the watermark
+	SetHighWatermark(fraction float64, onReached func(size int)) error
+
+	// SetWorkBudget makes every function enqueued from now on take a share
+	// of the budget, which it gives back once it has finished running in a
+	// pool (see FunctionDescriptor.Finished) or is removed by Clear.  While
+	// the budget is used up enqueues fail with ErrWorkBudgetExhausted.
+	// Functions already on the queue keep whatever budget they had, and
+	// keep their share if they are moved to another queue, for example
+	// by Pool.SetFunctionQueue.  A nil budget stops using one
+	SetWorkBudget(budget WorkBudget)
+}
+
+// DuplicateKeyPolicy is what a pool does with a function submitted with
+// SubmitKeyed when a function with the same key is already running
+type DuplicateKeyPolicy int
+
+const (
+	// WaitForDuplicateKey runs the function after the running functions
+	// with the same key have finished, in the order they were dequeued
+	WaitForDuplicateKey DuplicateKeyPolicy = iota
+
+	// DropDuplicateKey does not run the function.  Its Future
+	// returns ErrDuplicateKey
+	DropDuplicateKey
+)
+
+// ContextCancelPolicy is what a pool made with NewPoolWithContext
+// does with the functions waiting on its queue when its context is done
+type ContextCancelPolicy int
+
+const (
+	// CancelQueued closes the pool and clears its function queues, so the
+	// functions waiting on them are not run.  Their callbacks and Futures
+	// are given ErrCleared
+	CancelQueued ContextCancelPolicy = iota
+
+	// DrainQueued lets a started pool run every function on its function
+	// queues, including any enqueued while it drains, before it is closed.
+	// A pool that was never started is closed right away and its functions
+	// are left on the queues
+	DrainQueued
+)
+
+// The reasons given to the listener set with Pool.SetSizeChangeListener
+const (
+	// SizeChangeStart a thread was started by Pool.Start
+	SizeChangeStart = "start"
+
+	// SizeChangeResize a thread was started to reach the new minimum or
+	// left to get down to the new maximum after Pool.Resize
+	SizeChangeResize = "resize"
+
+	// SizeChangeGrow a thread was started to run queued functions
+	SizeChangeGrow = "grow"
+
+	// SizeChangeReplace a thread was started in place of a retired or
+	// recycled thread to keep the pool at its minimum
+	SizeChangeReplace = "replace"
+
+	// SizeChangeIdle a thread left after being idle for the idle decay duration
+	SizeChangeIdle = "idle"
+
+	// SizeChangeRetire a thread left after Pool.RetireThread
+	SizeChangeRetire = "retire"
+
+	// SizeChangeRecycle a thread left because of Pool.SetThreadRecycling
+	SizeChangeRecycle = "recycle"
+
+	// SizeChangeClose a thread left because the pool was closed
+	SizeChangeClose = "close"
+
+	// SizeChangeError a thread left because of an error getting a function
+	SizeChangeError = "error"
+)
+
+// Kinds of queue and selector in a PoolConfig
+const (
+	// QueueTypeBounded is a queue from NewBoundedFunctionQueue,
+	// or for an error queue from NewBoundedErrorQueue
+	QueueTypeBounded = "bounded"
+
+	// QueueTypeRingBuffer is a queue from NewRingBufferFunctionQueue
+	QueueTypeRingBuffer = "ring_buffer"
+
+	// SelectorStrictPriority is a selector from NewStrictPrioritySelector
+	SelectorStrictPriority = "strict_priority"
+
+	// SelectorWeightedRoundRobin is a selector from NewWeightedRoundRobinSelector
+	SelectorWeightedRoundRobin = "weighted_round_robin"
+
+	// QueueTypeCustom and SelectorCustom are queues and selectors not
+	// made by goethe, which a pool can not be made from
+	QueueTypeCustom = "custom"
+	SelectorCustom  = "custom"
+)
+
+// QueueConfig describes a queue of a pool, see PoolConfig
+type QueueConfig struct {
+	// Type is one of the QueueType constants
+	Type     string
+	Capacity uint32
+}
+
+// PoolConfig describes a pool so that it can be made again,
+// see Pool.GetConfig and ThreadUtilities.NewThreadPoolFromConfig
+type PoolConfig struct {
+	Name       string
+	MinThreads int32
+	MaxThreads int32
+	IdleDecay  time.Duration
+
+	// FunctionQueues are the function queues of the pool in order,
+	// taken by its threads as the Selector decides.  QueueWeights are
+	// the weights of a SelectorWeightedRoundRobin selector
+	FunctionQueues []QueueConfig
+	Selector       string
+	QueueWeights   []int
+
+	// ErrorQueue is the error queue of the pool.  Its Type is
+	// empty if the pool has no error queue
+	ErrorQueue QueueConfig
+
+	// The settings of the pool, see the Pool method setting each one
+	MonitorInterval    time.Duration
+	BreakerThreshold   int
+	BreakerWindow      time.Duration
+	MaxTasksPerThread  int64
+	MaxThreadLifetime  time.Duration
+	DuplicateKeyPolicy DuplicateKeyPolicy
+	CategoryLimits     map[string]int
+	MaxWeight          int64
+	HealthThresholds   PoolHealthThresholds
+	OrderedRebalancing bool
+}
+
+// PoolStats is a snapshot of statistics about a pool
+type PoolStats struct {
+	// CurrentThreads is the number of threads in the pool
+	CurrentThreads int32
+
+	// QueueSize is the number of functions waiting on the function queues of the pool
+	QueueSize int
+
+	// SaturationEvents is the number of times the pool wanted to add
+	// threads but was already at its maximum.  If this keeps rising
+	// the maximum number of threads may be too low
+	SaturationEvents int64
+
+	// TaskFailures is the number of functions run by the pool that returned an error
+	TaskFailures int64
+
+	// CircuitBreakerOpen is true if too many functions have failed recently
+	// and the pool will not add threads.  See Pool.SetCircuitBreaker
+	CircuitBreakerOpen bool
+
+	// ThreadsRecycledForTasks is the number of threads that left the pool
+	// because they had run as many functions as allowed, and
+	// ThreadsRecycledForAge is the number that left because they were too
+	// old.  See Pool.SetThreadRecycling
+	ThreadsRecycledForTasks int64
+	ThreadsRecycledForAge   int64
+
+	// ErrorsDropped is the number of errors the error queue of the pool
+	// would not take, for example because it was full.  See
+	// Pool.SetDroppedErrorHandler
+	ErrorsDropped int64
+}
+
+// PoolHealthThresholds are the limits past which a pool is
+// unhealthy, see Pool.SetHealthThresholds.  A zero limit is not checked
+type PoolHealthThresholds struct {
+	// MaxErrorRate is the largest fraction, from zero to one, of the
+	// functions that finished within the ErrorRateWindow that may have
+	// returned an error.  The error rate is only checked once at least
+	// MinTasks functions have finished within the window
+	MaxErrorRate    float64
+	ErrorRateWindow time.Duration
+	MinTasks        int
+
+	// MaxQueueLatency is the longest a function may have been waiting
+	// on the function queues of the pool
+	MaxQueueLatency time.Duration
+
+	// MaxSaturation is the fraction, from zero to one, of the maximum
+	// number of threads running functions at which the pool is unhealthy
+	MaxSaturation float64
+}
+
+// PoolHealth describes how a pool is doing, see Pool.GetHealth
+type PoolHealth struct {
+	// Healthy is true if no threshold was breached
+	Healthy bool
+
+	// Reasons describes each threshold that was breached
+	Reasons []string
+
+	// ErrorRate is the fraction of the RecentTasks, the functions that
+	// finished within the error rate window, that returned an error.
+	// Both are zero if there is no error rate threshold
+	ErrorRate   float64
+	RecentTasks int
+
+	// QueueLatency is how long the function that has been
+	// waiting the longest on the function queues has waited
+	QueueLatency time.Duration
+
+	// Saturation is the fraction of the maximum
+	// number of threads that are running functions
+	Saturation float64
+}
+
+// LockOrderViolation describes two locks taken in both orders,
+// see ThreadUtilities.SetLockOrderHandler
+type LockOrderViolation struct {
+	// FirstLock and SecondLock describe the two locks in the order
+	// the thread with ThreadID took them, as in DumpLocks
+	FirstLock  string
+	SecondLock string
+
+	// ThreadID took SecondLock while holding FirstLock, at Stack
+	ThreadID int64
+	Stack    string
+
+	// EarlierThreadID took FirstLock while holding SecondLock,
+	// at EarlierStack.  It may be the same thread as ThreadID
+	EarlierThreadID int64
+	EarlierStack    string
+}
+
+// PanicInformation describes a panic of a goethe thread,
+// see ThreadUtilities.SetPanicHandler
+type PanicInformation struct {
+	// ThreadID and ThreadName are the id and name of the thread that panicked
+	ThreadID   int64
+	ThreadName string
+
+	// PoolName is the name of the pool whose function panicked,
+	// or the empty string if the thread is not a pool thread
+	PoolName string
+
+	// Value is the value given to panic
+	Value interface{}
+
+	// Stack is the stack trace of the thread where it panicked
+	Stack string
+}
+
+// RunningTask describes a function a pool thread is running,
+// see Pool.GetRunningTasks
+type RunningTask struct {
+	// ThreadID is the id of the goethe thread running the function
+	ThreadID int64
+
+	// FunctionName is the name of the function as known to the runtime,
+	// such as main.worker, or main.main.func1 for a closure
+	FunctionName string
+
+	// StartTime is when the thread started running the function
+	StartTime time.Time
+
+	// EnqueueTime is when the function was put on its function queue
+	EnqueueTime time.Time
+
+	// Metadata is the metadata the function was enqueued with,
+	// see FunctionQueue.EnqueueWithMetadata
+	Metadata map[string]string
+}
+
+// Future is the result of a function submitted to a pool
+type Future interface {
+	// IsComplete returns true if the function has finished running
+	IsComplete() bool
+
+	// Get waits up to the given duration for the function to finish.
+	// It returns the values returned by the function along with the
+	// first non-nil error returned by the function.  If the function
+	// is still running after the duration ErrFutureTimeout is returned
+	Get(time.Duration) ([]interface{}, error)
+
+	// Close lets go of the values returned by the function so they can
+	// be garbage collected.  The pool keeps no reference to a Future once
+	// its function has finished, so this is only needed when the Future
+	// itself is kept after its results are no longer wanted.  If the
+	// function is still running its results are let go of when it
+	// finishes.  Get returns ErrFutureClosed once the Future is closed
+	Close()
+}
+
+// QueueSelector chooses the order in which the function queues of a
+// pool with more than one function queue are checked for work.  Goethe
+// provides NewStrictPrioritySelector and NewWeightedRoundRobinSelector
+type QueueSelector interface {
+	// Order returns the indexes of the function queues in the order
+	// they should be checked for the next function to run
+	Order(numQueues int) []int
 }
 
 // ErrorInformation represents data about an error that occurred
@@ -271,6 +1661,21 @@ type ErrorInformation interface {
 
 	// GetError returns the error that occurred
 	GetError() error
+
+	// GetThreadName returns the name of the thread on which the error
+	// occurred, or the empty string if that thread had no name
+	GetThreadName() string
+
+	// GetEnqueueTime returns when the function that returned the error was
+	// put on its function queue, or the zero time if the error did not come
+	// from a function queue
+	GetEnqueueTime() time.Time
+
+	// GetFunction returns the function that returned the error and a copy
+	// of the arguments it was given, so that it can be run again with
+	// Pool.Resubmit.  Returns nil if the error did not come from a function
+	// given to a pool
+	GetFunction() (userCall interface{}, args []interface{})
 }
 
 // ErrorQueue is used to retrieve errors thrown by the functions
@@ -279,8 +1684,9 @@ type ErrorInformation interface {
 // Goethe.NewErrorQueue
 type ErrorQueue interface {
 	// Enqueue adds an error to the error queue.  If the queue is
-	// at capacity should return ErrAtCapacity.  All other errors
-	// will be ignored
+	// at capacity should return ErrAtCapacity.  A pool counts an
+	// error that could not be enqueued, for any reason, as dropped
+	// and gives it to its dropped error handler
 	Enqueue(ErrorInformation) error
 
 	// Dequeue removes ErrorInformation from the pools
@@ -295,34 +1701,150 @@ type ErrorQueue interface {
 	IsEmpty() bool
 }
 
+// Error is implemented by every error variable of this package, such as
+// ErrReadLockHeld.  Those values can still be compared with ==, and
+// errors.Is also matches them against the category they belong to, such
+// as ErrCategoryLock, so callers can handle a whole kind of error at once
+type Error interface {
+	error
+
+	// Code returns a short name for the error that never changes,
+	// such as "read_lock_held", for logs and metrics
+	Code() string
+
+	// Category returns the category of the error, such as ErrCategoryLock
+	Category() error
+}
+
 var (
+	// ErrCategoryLock is matched by errors.Is for every error about locks
+	ErrCategoryLock = newCategory("lock")
+
+	// ErrCategoryThread is matched by errors.Is for every error about goethe threads
+	ErrCategoryThread = newCategory("thread")
+
+	// ErrCategoryQueue is matched by errors.Is for every error about function queues
+	ErrCategoryQueue = newCategory("queue")
+
+	// ErrCategoryPool is matched by errors.Is for every error about pools
+	ErrCategoryPool = newCategory("pool")
+
+	// ErrCategoryTask is matched by errors.Is for every error about task graphs
+	ErrCategoryTask = newCategory("task")
+
 	// ErrReadLockHeld returned if a WriteLock call is made while holding a ReadLock
-	ErrReadLockHeld = errors.New("attempted to acquire a WriteLock while ReadLock was held")
+	ErrReadLockHeld = newError(ErrCategoryLock, "read_lock_held", "attempted to acquire a WriteLock while ReadLock was held")
 
 	// ErrNotGoetheThread returned if any lock is attempted while not in a goethe thread
-	ErrNotGoetheThread = errors.New("function called from non-goethe thread")
+	ErrNotGoetheThread = newError(ErrCategoryThread, "not_goethe_thread", "function called from non-goethe thread")
 
 	// ErrWriteLockNotHeld returned if a call to WriteUnlock is made while not holding the WriteLock
-	ErrWriteLockNotHeld = errors.New("write lock is not held by this thread")
+	ErrWriteLockNotHeld = newError(ErrCategoryLock, "write_lock_not_held", "write lock is not held by this thread")
+
+	// ErrAlreadyHeld returned if a non-reentrant lock is acquired by the thread already holding it
+	ErrAlreadyHeld = newError(ErrCategoryLock, "already_held", "lock is already held by this thread and is not reentrant")
+
+	// ErrRecursionLimitExceeded returned if a thread takes a lock more times than LockOptions.MaxRecursionDepth
+	ErrRecursionLimitExceeded = newError(ErrCategoryLock, "recursion_limit_exceeded", "lock taken too many times by the same thread")
+
+	// ErrReadLockNotHeld returned if an upgrade is attempted while not holding the ReadLock
+	ErrReadLockNotHeld = newError(ErrCategoryLock, "read_lock_not_held", "read lock is not held by this thread")
 
 	// ErrAtCapacity returned by FunctionQueue.Enqueue if the queue is currently at capacity
-	ErrAtCapacity = errors.New("queue is at capacity")
+	ErrAtCapacity = newError(ErrCategoryQueue, "at_capacity", "queue is at capacity")
 
 	// ErrEmptyQueue returned by FunctionQueue.Dequeue if no function was available inside
 	// of the given duration
-	ErrEmptyQueue = errors.New("queue is empty")
+	ErrEmptyQueue = newError(ErrCategoryQueue, "empty_queue", "queue is empty")
 
 	// ErrPoolAlreadyExists a pool already exist and was returned
-	ErrPoolAlreadyExists = errors.New("pool with this name already exists, new pool not created")
+	ErrPoolAlreadyExists = newError(ErrCategoryPool, "pool_already_exists", "pool with this name already exists, new pool not created")
 
 	// ErrPoolClosed implies the pool has been closed
-	ErrPoolClosed = errors.New("pool has been closed")
+	ErrPoolClosed = newError(ErrCategoryPool, "pool_closed", "pool has been closed")
 
 	// ErrNotCalledOnCorrectThread This method was called on a ThreadLocal from a thread other than its own
-	ErrNotCalledOnCorrectThread = errors.New("called from an illegal thread")
+	ErrNotCalledOnCorrectThread = newError(ErrCategoryThread, "not_called_on_correct_thread", "called from an illegal thread")
+
+	// ErrFutureTimeout returned by Future.Get if the function did not finish in the given duration
+	ErrFutureTimeout = newError(ErrCategoryPool, "future_timeout", "timed out waiting for future")
+
+	// ErrLockTimeout returned if a lock could not be acquired in the given duration
+	ErrLockTimeout = newError(ErrCategoryLock, "lock_timeout", "timed out waiting for lock")
+
+	// ErrCloseTimeout returned by Pool.CloseWait if threads were still running after the given duration
+	ErrCloseTimeout = newError(ErrCategoryPool, "close_timeout", "timed out waiting for pool threads to exit")
+
+	// ErrCircuitBreakerOpen put on the error queue of a pool when its circuit breaker opens
+	ErrCircuitBreakerOpen = newError(ErrCategoryPool, "circuit_breaker_open", "pool circuit breaker opened, too many functions failed")
+
+	// ErrDuplicateKey returned by the Future of a keyed function dropped because its key was running
+	ErrDuplicateKey = newError(ErrCategoryPool, "duplicate_key", "a function with the same key was already running")
+
+	// ErrDependencyCycle returned by Pool.DependsOn if the dependency would make a cycle
+	ErrDependencyCycle = newError(ErrCategoryPool, "dependency_cycle", "pool dependency would create a cycle")
+
+	// ErrDeadlineExceeded put on the error queue of a pool for a function dequeued after its deadline
+	ErrDeadlineExceeded = newError(ErrCategoryQueue, "deadline_exceeded", "function was dequeued after its deadline and was not run")
+
+	// ErrThreadsRunning returned by ResetForTesting if goethe threads are still running
+	ErrThreadsRunning = newError(ErrCategoryThread, "threads_running", "goethe threads are still running")
+
+	// ErrCleared given to the callback of a function removed by FunctionQueue.Clear
+	ErrCleared = newError(ErrCategoryQueue, "cleared", "function was cleared from the queue before it was run")
+
+	// ErrTaskCycle returned by TaskGraph.AddTask if the task would depend on itself
+	ErrTaskCycle = newError(ErrCategoryTask, "task_cycle", "task dependency would create a cycle")
+
+	// ErrDependencyFailed reported by TaskGraph.Run for a task skipped because a task it depends on failed
+	ErrDependencyFailed = newError(ErrCategoryTask, "dependency_failed", "a task this task depends on failed")
+
+	// ErrTaskGraphTimeout returned by TaskGraph.Run if the tasks did not all finish in time
+	ErrTaskGraphTimeout = newError(ErrCategoryTask, "task_graph_timeout", "timed out waiting for task graph")
+
+	// ErrTaskNotRun given to the callback of a pool function that an interceptor did not run
+	ErrTaskNotRun = newError(ErrCategoryPool, "task_not_run", "a task interceptor did not run the function")
+
+	// ErrInterrupted returned by a lock method whose wait was interrupted with InterruptThread
+	ErrInterrupted = newError(ErrCategoryLock, "interrupted", "interrupted while waiting for lock")
+
+	// ErrNoSuchThread returned if a function is enqueued to a goethe thread that is not running
+	ErrNoSuchThread = newError(ErrCategoryThread, "no_such_thread", "goethe thread is not running")
+
+	// ErrQuorumNotReached is returned by GoQuorum if fewer tasks than needed succeeded
+	ErrQuorumNotReached = newError(ErrCategoryThread, "quorum_not_reached", "not enough tasks succeeded to reach the quorum")
+
+	// ErrWorkBudgetExhausted is returned by an enqueue when the work budget of the queue is used up
+	ErrWorkBudgetExhausted = newError(ErrCategoryQueue, "work_budget_exhausted", "work budget of the queue is used up")
+
+	// ErrThreadCountTimeout is returned by Pool.AwaitThreadCount if the pool did not reach the count in time
+	ErrThreadCountTimeout = newError(ErrCategoryPool, "thread_count_timeout", "timed out waiting for the pool thread count")
+
+	// ErrLockHandedOff is returned by a lock method called by a thread that gave its write lock to a queued function
+	ErrLockHandedOff = newError(ErrCategoryLock, "lock_handed_off", "write lock was handed to a queued function")
+
+	// ErrPoolUnhealthy is returned by Pool.SubmitOrFail if the pool breached one of its health thresholds
+	ErrPoolUnhealthy = newError(ErrCategoryPool, "pool_unhealthy", "pool is not healthy")
+
+	// ErrNotResubmittable is returned by Pool.Resubmit if the error information has no function
+	ErrNotResubmittable = newError(ErrCategoryPool, "not_resubmittable", "error did not come from a function given to a pool")
+
+	// ErrTaskPanicked is wrapped by the error of a pool function that panicked, see Pool.SetPanicHandler
+	ErrTaskPanicked = newError(ErrCategoryTask, "task_panicked", "a task panicked")
+
+	// ErrFutureClosed returned by Future.Get once the Future has been closed
+	ErrFutureClosed = newError(ErrCategoryPool, "future_closed", "future was closed")
 )
 
 const (
 	// TimerThreadLocal A thread local with this name will have the Timer when called from a scheuled job
 	TimerThreadLocal = "goethe.Timer"
+
+	// SlogThreadIDKey is the key of the goethe thread id added to records
+	// by the handler from ThreadUtilities.NewSlogHandler
+	SlogThreadIDKey = "thread_id"
+
+	// SlogThreadNameKey is the key of the goethe thread name added to
+	// records by the handler from ThreadUtilities.NewSlogHandler
+	SlogThreadNameKey = "thread_name"
 )
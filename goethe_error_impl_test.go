@@ -0,0 +1,102 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorsMatchSentinelAndCategory(t *testing.T) {
+	wrapped := fmt.Errorf("could not lock: %w", ErrReadLockHeld)
+
+	if !errors.Is(wrapped, ErrReadLockHeld) {
+		t.Error("wrapped error should match its sentinel")
+		return
+	}
+
+	if !errors.Is(wrapped, ErrCategoryLock) {
+		t.Error("wrapped error should match its category")
+		return
+	}
+
+	if errors.Is(wrapped, ErrCategoryPool) || errors.Is(wrapped, ErrAtCapacity) {
+		t.Error("wrapped error should not match other categories or sentinels")
+		return
+	}
+
+	var goetheErr Error
+	if !errors.As(wrapped, &goetheErr) {
+		t.Error("wrapped error should be a goethe Error")
+		return
+	}
+
+	if goetheErr.Code() != "read_lock_held" || goetheErr.Category() != ErrCategoryLock {
+		t.Errorf("unexpected code %s and category %v", goetheErr.Code(), goetheErr.Category())
+		return
+	}
+
+	if ErrReadLockHeld.Error() != "attempted to acquire a WriteLock while ReadLock was held" {
+		t.Errorf("message of the sentinel changed to %s", ErrReadLockHeld.Error())
+	}
+}
+
+func TestEveryErrorHasACategory(t *testing.T) {
+	categories := map[error][]error{
+		ErrCategoryLock:   {ErrReadLockHeld, ErrWriteLockNotHeld, ErrLockTimeout},
+		ErrCategoryThread: {ErrNotGoetheThread, ErrNoSuchThread},
+		ErrCategoryQueue:  {ErrAtCapacity, ErrEmptyQueue, ErrCleared},
+		ErrCategoryPool:   {ErrPoolClosed, ErrFutureTimeout, ErrDuplicateKey, ErrFutureClosed},
+	}
+
+	for category, errs := range categories {
+		for _, err := range errs {
+			if !errors.Is(err, category) {
+				t.Errorf("%v should be in category %v", err, category)
+			}
+
+			if err.(Error).Code() == "" {
+				t.Errorf("%v has no code", err)
+			}
+		}
+	}
+}
@@ -41,23 +41,34 @@
 package goethe
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type poolData struct {
 	poolMux sync.Mutex
 	poolMap map[string]Pool
+
+	// names of the pools each pool depends on
+	dependencies map[string][]string
 }
 
 type timersData struct {
 	timerMux sync.Mutex
 	timer    timerImpl
+	timerTid int64
+
+	// methods scheduled by users, see ListScheduled
+	scheduled map[*timerJob]bool
 }
 
 type threadLocalsData struct {
@@ -65,6 +76,68 @@ type threadLocalsData struct {
 	threadLocals map[string]*threadLocalOperators
 }
 
+type threadsData struct {
+	threadMux sync.Mutex
+	exitCond  *sync.Cond
+	active    map[int64]bool
+	names     map[int64]string
+
+	// see SetDebugMode, only read atomically
+	debug int32
+
+	// threads started with GoOnce by tag, and the other way around
+	tagged map[string]int64
+	tagsOf map[int64]string
+
+	// functions to call when each thread exits, see OnThreadExit
+	exitHooks map[int64][]func()
+
+	// metadata of the pool function each thread is running, see GetTaskMetadata
+	taskMetadata map[int64]map[string]string
+
+	// the runtime goroutine of each thread, see GetRuntimeGoroutineID
+	goroutineIDs map[int64]uint64
+
+	// threads asked to park at their next checkpoint, and what to tell
+	// when a thread parks, see SuspendThread.  suspendCond uses threadMux
+	suspended     map[int64]bool
+	parkListeners map[int64]func(parked bool) error
+	suspendCond   *sync.Cond
+
+	// priority ReadLock and WriteLock use on each thread, see WithLockPriority
+	lockPriorities map[int64]int
+
+	// told of panics no pool handled, see SetPanicHandler
+	panicHandler func(info PanicInformation)
+}
+
+type locksData struct {
+	lockMux    sync.Mutex
+	lastLockID uint64
+
+	// locks currently held, sharded by lock id so that taking
+	// and freeing locks does not contend on lockMux
+	held [heldShards]heldShard
+
+	// the lock each thread is waiting for, see InterruptThread
+	waitingOn map[int64]*goetheLock
+
+	// see SetLockOrderHandler, checking is only read atomically
+	checking     int32
+	orderHandler func(violation LockOrderViolation)
+	heldBy       map[int64]map[*goetheLock]bool
+	orders       map[lockPair]lockOrder
+	reported     map[lockPair]bool
+}
+
+// heldShards is how many pieces locksData.held is split into
+const heldShards = 64
+
+type heldShard struct {
+	mux   sync.Mutex
+	locks map[*goetheLock]bool
+}
+
 // StandardThreadUtilities provides methods for using the goethe threading
 // system, including timers, pools, recursive locks,
 // and thread pools.  It implements the ThreadUtilities interface
@@ -73,9 +146,14 @@ type StandardThreadUtilities struct {
 	tidMux  sync.Mutex
 	lastTid int64
 
-	pools  *poolData
-	timers *timersData
-	locals *threadLocalsData
+	clockMux sync.Mutex
+	clock    Clock
+
+	pools   *poolData
+	timers  *timersData
+	locals  *threadLocalsData
+	threads *threadsData
+	locks   *locksData
 }
 
 type threadLocalOperators struct {
@@ -92,24 +170,54 @@ var (
 
 const (
 	timerTid = 9
+
+	// drainPollInterval is how often ShutdownAll checks
+	// whether a pool has emptied its queues
+	drainPollInterval = 10 * time.Millisecond
 )
 
 func newGoethe() *StandardThreadUtilities {
 	pools := &poolData{
-		poolMap: make(map[string]Pool),
+		poolMap:      make(map[string]Pool),
+		dependencies: make(map[string][]string),
 	}
 
-	timers := &timersData{}
+	timers := &timersData{
+		scheduled: make(map[*timerJob]bool),
+	}
 
 	locals := &threadLocalsData{
 		threadLocals: make(map[string]*threadLocalOperators),
 	}
 
+	threads := &threadsData{
+		active:         make(map[int64]bool),
+		names:          make(map[int64]string),
+		tagged:         make(map[string]int64),
+		tagsOf:         make(map[int64]string),
+		exitHooks:      make(map[int64][]func()),
+		taskMetadata:   make(map[int64]map[string]string),
+		goroutineIDs:   make(map[int64]uint64),
+		suspended:      make(map[int64]bool),
+		parkListeners:  make(map[int64]func(parked bool) error),
+		lockPriorities: make(map[int64]int),
+	}
+	threads.exitCond = sync.NewCond(&threads.threadMux)
+	threads.suspendCond = sync.NewCond(&threads.threadMux)
+
+	locks := &locksData{
+		waitingOn: make(map[int64]*goetheLock),
+	}
+	locks.resetHeld()
+
 	retVal := &StandardThreadUtilities{
 		lastTid: 9,
+		clock:   realClock{},
 		pools:   pools,
 		timers:  timers,
 		locals:  locals,
+		threads: threads,
+		locks:   locks,
 	}
 
 	return retVal
@@ -125,6 +233,8 @@ func GG() ThreadUtilities {
 	return globalGoethe
 }
 
+// getAndIncrementTid returns the next thread id.  Thread ids are never
+// reused, since locks and thread locals are keyed by thread id
 func (goth *StandardThreadUtilities) getAndIncrementTid() int64 {
 	goth.tidMux.Lock()
 	defer goth.tidMux.Unlock()
@@ -152,11 +262,421 @@ func (goth *StandardThreadUtilities) Go(userCall interface{}, args ...interface{
 		return -1, err
 	}
 
+	goth.threadStarted(tid)
+
 	go invokeStart(tid, userCall, arguments)
 
 	return tid, nil
 }
 
+// GoWithCopiedArgs is like Go but gives the thread its own
+// copy of any slice or map argument
+func (goth *StandardThreadUtilities) GoWithCopiedArgs(userCall interface{}, args ...interface{}) (int64, error) {
+	arguments, err := getValues(userCall, args)
+	if err != nil {
+		return -1, err
+	}
+
+	for index, argument := range arguments {
+		arguments[index] = shallowCopy(argument)
+	}
+
+	tid := goth.getAndIncrementTid()
+
+	goth.threadStarted(tid)
+
+	go invokeStart(tid, userCall, arguments)
+
+	return tid, nil
+}
+
+// GoOnce is like Go but does nothing if a thread started by GoOnce
+// with the same tag is still running.  Returns true if a thread was started
+func (goth *StandardThreadUtilities) GoOnce(tag string, userCall interface{}, args ...interface{}) (bool, error) {
+	argArray := make([]interface{}, len(args))
+	for index, arg := range args {
+		argArray[index] = arg
+	}
+
+	arguments, err := getValues(userCall, argArray)
+	if err != nil {
+		return false, err
+	}
+
+	goth.threads.threadMux.Lock()
+
+	_, running := goth.threads.tagged[tag]
+	if running {
+		goth.threads.threadMux.Unlock()
+		return false, nil
+	}
+
+	tid := goth.getAndIncrementTid()
+
+	goth.threads.tagged[tag] = tid
+	goth.threads.tagsOf[tid] = tag
+
+	goth.threads.threadMux.Unlock()
+
+	goth.threadStarted(tid)
+
+	go invokeStart(tid, userCall, arguments)
+
+	return true, nil
+}
+
+// goClosure runs a function with no arguments in a new goethe
+// thread.  Since there are no arguments it can not fail
+func (goth *StandardThreadUtilities) goClosure(userCall func()) int64 {
+	tid := goth.getAndIncrementTid()
+
+	goth.threadStarted(tid)
+
+	go invokeStart(tid, userCall, []reflect.Value{})
+
+	return tid
+}
+
+func (goth *StandardThreadUtilities) threadStarted(tid int64) {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	if goth.isDebugMode() && goth.threads.active[tid] {
+		panic(fmt.Sprintf("goethe thread id %d assigned while still in use", tid))
+	}
+
+	goth.threads.active[tid] = true
+}
+
+// isDebugMode returns true if SetDebugMode(true) was called
+func (goth *StandardThreadUtilities) isDebugMode() bool {
+	return atomic.LoadInt32(&goth.threads.debug) != 0
+}
+
+// describeThread returns the thread id along with its name if it has one
+func (goth *StandardThreadUtilities) describeThread(tid int64) string {
+	name := goth.getThreadName(tid)
+	if name == "" {
+		return fmt.Sprintf("thread %d", tid)
+	}
+
+	return fmt.Sprintf("thread %d (%s)", tid, name)
+}
+
+// ResetForTesting puts goethe back to how it started, except for
+// thread ids and the timer thread.  Only for use between tests
+func (goth *StandardThreadUtilities) ResetForTesting() error {
+	goth.timers.timerMux.Lock()
+	timerTid := goth.timers.timerTid
+	timerStarted := goth.timers.timer != nil
+	goth.timers.timerMux.Unlock()
+
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	for tid := range goth.threads.active {
+		if !timerStarted || tid != timerTid {
+			return ErrThreadsRunning
+		}
+	}
+
+	goth.threads.names = make(map[int64]string)
+	goth.threads.tagged = make(map[string]int64)
+	goth.threads.tagsOf = make(map[int64]string)
+	goth.threads.exitHooks = make(map[int64][]func())
+	goth.threads.taskMetadata = make(map[int64]map[string]string)
+	goth.threads.panicHandler = nil
+	goth.SetDebugMode(false)
+
+	goth.pools.poolMux.Lock()
+	goth.pools.poolMap = make(map[string]Pool)
+	goth.pools.dependencies = make(map[string][]string)
+	goth.pools.poolMux.Unlock()
+
+	goth.locals.localsMux.Lock()
+	timerLocal, hasTimerLocal := goth.locals.threadLocals[TimerThreadLocal]
+	goth.locals.threadLocals = make(map[string]*threadLocalOperators)
+	if timerStarted && hasTimerLocal {
+		// The timer thread still needs it to run its jobs
+		goth.locals.threadLocals[TimerThreadLocal] = timerLocal
+	}
+	goth.locals.localsMux.Unlock()
+
+	goth.locks.resetHeld()
+
+	goth.locks.lockMux.Lock()
+	goth.locks.waitingOn = make(map[int64]*goetheLock)
+	goth.locks.lockMux.Unlock()
+
+	goth.SetLockOrderHandler(nil)
+
+	goth.clockMux.Lock()
+	goth.clock = realClock{}
+	goth.clockMux.Unlock()
+
+	return nil
+}
+
+// OnThreadExit registers a function to be called when the calling
+// goethe thread exits
+func (goth *StandardThreadUtilities) OnThreadExit(hook func()) error {
+	tid := goth.GetThreadID()
+	if tid < 0 {
+		return ErrNotGoetheThread
+	}
+
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	goth.threads.exitHooks[tid] = append(goth.threads.exitHooks[tid], hook)
+
+	return nil
+}
+
+// SuspendThread asks the thread to park at its next checkpoint
+func (goth *StandardThreadUtilities) SuspendThread(threadID int64) error {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	if !goth.threads.active[threadID] {
+		return ErrNoSuchThread
+	}
+
+	goth.threads.suspended[threadID] = true
+
+	return nil
+}
+
+// ResumeThread lets a suspended thread continue
+func (goth *StandardThreadUtilities) ResumeThread(threadID int64) error {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	if !goth.threads.active[threadID] {
+		return ErrNoSuchThread
+	}
+
+	delete(goth.threads.suspended, threadID)
+	goth.threads.suspendCond.Broadcast()
+
+	return nil
+}
+
+// Checkpoint parks the calling thread while it is suspended
+func (goth *StandardThreadUtilities) Checkpoint() error {
+	tid := goth.GetThreadID()
+	if tid < 0 {
+		return ErrNotGoetheThread
+	}
+
+	goth.threads.threadMux.Lock()
+	suspended := goth.threads.suspended[tid]
+	listener := goth.threads.parkListeners[tid]
+	goth.threads.threadMux.Unlock()
+
+	if !suspended {
+		return nil
+	}
+
+	if listener != nil {
+		err := listener(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	goth.threads.threadMux.Lock()
+	for goth.threads.suspended[tid] {
+		goth.threads.suspendCond.Wait()
+	}
+	goth.threads.threadMux.Unlock()
+
+	if listener != nil {
+		return listener(false)
+	}
+
+	return nil
+}
+
+// setParkListener sets the function called when the thread
+// parks at a checkpoint and when it continues
+func (goth *StandardThreadUtilities) setParkListener(tid int64, listener func(parked bool) error) {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	goth.threads.parkListeners[tid] = listener
+}
+
+// GetTaskMetadata returns the metadata of the pool function
+// the calling thread is running
+func (goth *StandardThreadUtilities) GetTaskMetadata() map[string]string {
+	tid := goth.GetThreadID()
+	if tid < 0 {
+		return nil
+	}
+
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	return goth.threads.taskMetadata[tid]
+}
+
+// setTaskMetadata records the metadata of the pool function the
+// thread is running, or that it is not running one if nil
+func (goth *StandardThreadUtilities) setTaskMetadata(tid int64, metadata map[string]string) {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	if metadata == nil {
+		delete(goth.threads.taskMetadata, tid)
+		return
+	}
+
+	goth.threads.taskMetadata[tid] = metadata
+}
+
+// WithLockPriority calls f with ReadLock and WriteLock using the given
+// priority on this thread, putting the previous priority back afterwards
+func (goth *StandardThreadUtilities) WithLockPriority(priority int, f func()) error {
+	tid := goth.GetThreadID()
+	if tid < 0 {
+		return ErrNotGoetheThread
+	}
+
+	goth.threads.threadMux.Lock()
+	previous, hadPrevious := goth.threads.lockPriorities[tid]
+	goth.threads.lockPriorities[tid] = priority
+	goth.threads.threadMux.Unlock()
+
+	defer func() {
+		goth.threads.threadMux.Lock()
+		defer goth.threads.threadMux.Unlock()
+
+		if hadPrevious {
+			goth.threads.lockPriorities[tid] = previous
+		} else {
+			delete(goth.threads.lockPriorities, tid)
+		}
+	}()
+
+	f()
+
+	return nil
+}
+
+// getLockPriority returns the priority ReadLock and WriteLock
+// use on the thread, which is zero outside of WithLockPriority
+func (goth *StandardThreadUtilities) getLockPriority(tid int64) int {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	return goth.threads.lockPriorities[tid]
+}
+
+// runExitHooks calls the exit hooks of the thread, last registered
+// first.  Hooks may register more hooks, which are also called
+func (goth *StandardThreadUtilities) runExitHooks(tid int64) {
+	for {
+		goth.threads.threadMux.Lock()
+		hooks := goth.threads.exitHooks[tid]
+		if len(hooks) == 0 {
+			delete(goth.threads.exitHooks, tid)
+			goth.threads.threadMux.Unlock()
+			return
+		}
+
+		hook := hooks[len(hooks)-1]
+		goth.threads.exitHooks[tid] = hooks[:len(hooks)-1]
+		goth.threads.threadMux.Unlock()
+
+		callExitHook(hook)
+	}
+}
+
+// callExitHook calls the hook, ignoring any panic so that
+// the other hooks still run
+func callExitHook(hook func()) {
+	defer func() {
+		recover()
+	}()
+
+	hook()
+}
+
+// SetDebugMode turns on or off extra checking of the internal state
+// of goethe, such as thread ids being assigned more than once
+func (goth *StandardThreadUtilities) SetDebugMode(debug bool) {
+	var value int32
+	if debug {
+		value = 1
+	}
+
+	atomic.StoreInt32(&goth.threads.debug, value)
+}
+
+func (goth *StandardThreadUtilities) threadExited(tid int64) {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	delete(goth.threads.active, tid)
+	delete(goth.threads.names, tid)
+	delete(goth.threads.goroutineIDs, tid)
+	delete(goth.threads.suspended, tid)
+	delete(goth.threads.parkListeners, tid)
+
+	tag, found := goth.threads.tagsOf[tid]
+	if found {
+		delete(goth.threads.tagged, tag)
+		delete(goth.threads.tagsOf, tid)
+	}
+
+	goth.threads.exitCond.Broadcast()
+}
+
+// JoinThread waits up to the timeout for the goethe thread with
+// the given id to finish.  Returns true if it has finished
+func (goth *StandardThreadUtilities) JoinThread(threadID int64, timeout time.Duration) (bool, error) {
+	goth.tidMux.Lock()
+	lastTid := goth.lastTid
+	goth.tidMux.Unlock()
+
+	if threadID <= timerTid || threadID > lastTid {
+		return false, ErrNoSuchThread
+	}
+
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	deadline := now().Add(timeout)
+	for goth.threads.active[threadID] {
+		remaining := deadline.Sub(now())
+		if remaining <= 0 {
+			return false, nil
+		}
+
+		timer := afterFunc(remaining, func() {
+			goth.threads.threadMux.Lock()
+			defer goth.threads.threadMux.Unlock()
+
+			goth.threads.exitCond.Broadcast()
+		})
+
+		goth.threads.exitCond.Wait()
+
+		timer.Stop()
+	}
+
+	return true, nil
+}
+
+// isThreadAlive returns true if the goethe thread with the given id is running
+func (goth *StandardThreadUtilities) isThreadAlive(tid int64) bool {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	return goth.threads.active[tid]
+}
+
 // GetThreadID Gets the current threadID.  Returns -1
 // if this is not a goethe thread.  Thread ids start at 10
 // as thread ids 0 through 9 are reserved for future use
@@ -189,9 +709,313 @@ func (goth *StandardThreadUtilities) GetThreadID() int64 {
 	return int64(result)
 }
 
+// GetRuntimeGoroutineID returns the runtime goroutine id of
+// the running goethe thread, read from its stack when it started
+func (goth *StandardThreadUtilities) GetRuntimeGoroutineID(threadID int64) (uint64, bool) {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	retVal, found := goth.threads.goroutineIDs[threadID]
+
+	return retVal, found
+}
+
+// recordGoroutineID remembers the runtime goroutine id of the calling
+// goroutine for the thread.  The first line of a stack trace looks like
+// "goroutine 18 [running]:"
+func (goth *StandardThreadUtilities) recordGoroutineID(tid int64) {
+	var buffer [64]byte
+	stack := buffer[:runtime.Stack(buffer[:], false)]
+
+	var goroutineID uint64
+	_, err := fmt.Sscanf(string(stack), "goroutine %d ", &goroutineID)
+	if err != nil {
+		return
+	}
+
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	goth.threads.goroutineIDs[tid] = goroutineID
+}
+
+// SetThreadName sets the name of the current goethe thread.  The name
+// is included in the ErrorInformation of errors from this thread.
+// Returns ErrNotGoetheThread if called from a non-goethe thread
+func (goth *StandardThreadUtilities) SetThreadName(name string) error {
+	tid := goth.GetThreadID()
+	if tid < 0 {
+		return ErrNotGoetheThread
+	}
+
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	goth.threads.names[tid] = name
+
+	return nil
+}
+
+// GetThreadName returns the name of the current goethe thread, or
+// the empty string if it has no name or is not a goethe thread
+func (goth *StandardThreadUtilities) GetThreadName() string {
+	tid := goth.GetThreadID()
+	if tid < 0 {
+		return ""
+	}
+
+	return goth.getThreadName(tid)
+}
+
+func (goth *StandardThreadUtilities) getThreadName(tid int64) string {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	return goth.threads.names[tid]
+}
+
 // NewGoetheLock Creates a new goethe lock
 func (goth *StandardThreadUtilities) NewGoetheLock() Lock {
-	return newReaderWriterLock(goth)
+	return newReaderWriterLock(goth, false, LockOptions{})
+}
+
+// NewGoetheLockWithOptions creates a new goethe lock
+// that behaves as described by the options
+func (goth *StandardThreadUtilities) NewGoetheLockWithOptions(options LockOptions) Lock {
+	return newReaderWriterLock(goth, false, options)
+}
+
+// NewGoetheLockWithTimeouts creates a new goethe lock whose ReadLock
+// and WriteLock give up after the given durations.  Zero waits forever
+func (goth *StandardThreadUtilities) NewGoetheLockWithTimeouts(readTimeout, writeTimeout time.Duration) Lock {
+	return newReaderWriterLock(goth, false, LockOptions{
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+	})
+}
+
+// newInternalLock creates a lock used by goethe itself, which
+// is not reported by CheckNoLocksHeld
+func (goth *StandardThreadUtilities) newInternalLock() Lock {
+	return newReaderWriterLock(goth, true, LockOptions{})
+}
+
+// LockAll takes the write locks of all the locks in the order they were created
+func (goth *StandardThreadUtilities) LockAll(locks ...Lock) (func(), error) {
+	ordered := make([]*goetheLock, 0, len(locks))
+	for _, lock := range locks {
+		gLock, err := asGoetheLock(lock)
+		if err != nil {
+			return nil, err
+		}
+
+		ordered = append(ordered, gLock)
+	}
+
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].id < ordered[j].id
+	})
+
+	taken := make([]*goetheLock, 0, len(ordered))
+	unlock := func() {
+		for lcv := len(taken) - 1; lcv >= 0; lcv-- {
+			taken[lcv].WriteUnlock()
+		}
+	}
+
+	for index, lock := range ordered {
+		if index > 0 && ordered[index-1] == lock {
+			continue
+		}
+
+		err := lock.WriteLock()
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+
+		taken = append(taken, lock)
+	}
+
+	return unlock, nil
+}
+
+// LockBoth takes the write locks of both locks in the order they were created
+func (goth *StandardThreadUtilities) LockBoth(a, b Lock) (func(), error) {
+	first, err := asGoetheLock(a)
+	if err != nil {
+		return nil, err
+	}
+	second, err := asGoetheLock(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if first == second {
+		err = first.WriteLock()
+		if err != nil {
+			return nil, err
+		}
+
+		return func() {
+			first.WriteUnlock()
+		}, nil
+	}
+
+	if second.id < first.id {
+		first, second = second, first
+	}
+
+	err = first.WriteLock()
+	if err != nil {
+		return nil, err
+	}
+
+	err = second.WriteLock()
+	if err != nil {
+		first.WriteUnlock()
+		return nil, err
+	}
+
+	return func() {
+		second.WriteUnlock()
+		first.WriteUnlock()
+	}, nil
+}
+
+// asGoetheLock returns the lock as a goethe lock, which has
+// the id that LockAll and LockBoth order locks by
+func asGoetheLock(lock Lock) (*goetheLock, error) {
+	gLock, ok := lock.(*goetheLock)
+	if !ok {
+		return nil, fmt.Errorf("lock %v was not created by goethe", lock)
+	}
+
+	return gLock, nil
+}
+
+func (goth *StandardThreadUtilities) nextLockID() uint64 {
+	goth.locks.lockMux.Lock()
+	defer goth.locks.lockMux.Unlock()
+
+	goth.locks.lastLockID++
+	return goth.locks.lastLockID
+}
+
+// lockHeldChanged is called by a lock when it goes from
+// being free to being held or from being held to being free
+func (goth *StandardThreadUtilities) lockHeldChanged(lock *goetheLock, held bool) {
+	shard := &goth.locks.held[lock.id%heldShards]
+
+	shard.mux.Lock()
+	defer shard.mux.Unlock()
+
+	if held {
+		shard.locks[lock] = true
+	} else {
+		delete(shard.locks, lock)
+	}
+}
+
+// resetHeld forgets every held lock
+func (locks *locksData) resetHeld() {
+	for lcv := range locks.held {
+		shard := &locks.held[lcv]
+
+		shard.mux.Lock()
+		shard.locks = make(map[*goetheLock]bool)
+		shard.mux.Unlock()
+	}
+}
+
+// heldLocks returns every lock currently held, in order of creation
+func (locks *locksData) heldLocks() []*goetheLock {
+	retVal := make([]*goetheLock, 0)
+	for lcv := range locks.held {
+		shard := &locks.held[lcv]
+
+		shard.mux.Lock()
+		for lock := range shard.locks {
+			retVal = append(retVal, lock)
+		}
+		shard.mux.Unlock()
+	}
+
+	sort.Slice(retVal, func(i, j int) bool {
+		return retVal[i].id < retVal[j].id
+	})
+
+	return retVal
+}
+
+// lockWaitChanged is called by a lock when a thread starts waiting
+// for it, or with nil when the thread stops waiting
+func (goth *StandardThreadUtilities) lockWaitChanged(tid int64, lock *goetheLock) {
+	goth.locks.lockMux.Lock()
+	defer goth.locks.lockMux.Unlock()
+
+	if lock == nil {
+		delete(goth.locks.waitingOn, tid)
+	} else {
+		goth.locks.waitingOn[tid] = lock
+	}
+}
+
+// InterruptThread makes the thread give up waiting for a goethe lock
+func (goth *StandardThreadUtilities) InterruptThread(threadID int64) bool {
+	goth.locks.lockMux.Lock()
+	lock := goth.locks.waitingOn[threadID]
+	goth.locks.lockMux.Unlock()
+
+	if lock == nil {
+		return false
+	}
+
+	// The lock takes goMux, which may not be taken while holding lockMux
+	return lock.interrupt(threadID)
+}
+
+// DumpLocks returns a description of every held lock created
+// with NewGoetheLock, for debugging
+func (goth *StandardThreadUtilities) DumpLocks() string {
+	heldLocks := make([]*goetheLock, 0)
+	for _, lock := range goth.locks.heldLocks() {
+		if !lock.internal {
+			heldLocks = append(heldLocks, lock)
+		}
+	}
+
+	var retVal strings.Builder
+	fmt.Fprintf(&retVal, "%d goethe locks held\n", len(heldLocks))
+
+	for _, lock := range heldLocks {
+		retVal.WriteString(lock.dump())
+	}
+
+	return retVal.String()
+}
+
+// CheckNoLocksHeld returns an error naming every goethe thread
+// that still holds a read or write lock on a lock created with
+// NewGoetheLock.  Returns nil if no such locks are held.  Useful
+// in test teardown to find leaked locks
+func (goth *StandardThreadUtilities) CheckNoLocksHeld() error {
+	holders := make([]string, 0)
+	for _, lock := range goth.locks.heldLocks() {
+		if lock.internal {
+			continue
+		}
+
+		holders = append(holders, lock.describeHolders()...)
+	}
+
+	if len(holders) == 0 {
+		return nil
+	}
+
+	sort.Strings(holders)
+
+	return fmt.Errorf("goethe locks are still held: %s", strings.Join(holders, ", "))
 }
 
 // NewPool creates a new thread pool with the given parameters.  The name is the
@@ -220,8 +1044,34 @@ func (goth *StandardThreadUtilities) NewPool(name string, minThreads int32, maxT
 		return foundPool, ErrPoolAlreadyExists
 	}
 
-	retVal, err := newThreadPool(goth, name, minThreads, maxThreads, idleDecayDuration, functionQueue,
-		errorQueue)
+	retVal, err := newThreadPool(goth, name, minThreads, maxThreads, idleDecayDuration,
+		NewStrictPrioritySelector(), []FunctionQueue{functionQueue}, errorQueue)
+	if err != nil {
+		return nil, err
+	}
+
+	goth.pools.poolMap[name] = retVal
+
+	return retVal, nil
+}
+
+// NewMultiQueuePool creates a new thread pool whose threads service all
+// of the given function queues.  The selector decides which queue a thread
+// takes its next function from.  There must be at least one function queue
+// and none may be nil.  All other parameters are as in NewPool
+func (goth *StandardThreadUtilities) NewMultiQueuePool(name string, minThreads int32, maxThreads int32,
+	idleDecayDuration time.Duration, selector QueueSelector, functionQueues []FunctionQueue,
+	errorQueue ErrorQueue) (Pool, error) {
+	goth.pools.poolMux.Lock()
+	defer goth.pools.poolMux.Unlock()
+
+	foundPool, found := goth.pools.poolMap[name]
+	if found {
+		return foundPool, ErrPoolAlreadyExists
+	}
+
+	retVal, err := newThreadPool(goth, name, minThreads, maxThreads, idleDecayDuration, selector,
+		functionQueues, errorQueue)
 	if err != nil {
 		return nil, err
 	}
@@ -231,11 +1081,57 @@ func (goth *StandardThreadUtilities) NewPool(name string, minThreads int32, maxT
 	return retVal, nil
 }
 
+// NewPoolWithContext is NewPool for a pool that closes
+// itself according to the policy when the context is done
+func (goth *StandardThreadUtilities) NewPoolWithContext(ctx context.Context, policy ContextCancelPolicy, name string,
+	minThreads int32, maxThreads int32, idleDecayDuration time.Duration, functionQueue FunctionQueue,
+	errorQueue ErrorQueue) (Pool, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("context of pool %s may not be nil", name)
+	}
+	if policy != CancelQueued && policy != DrainQueued {
+		return nil, fmt.Errorf("unknown context cancel policy %d", policy)
+	}
+
+	retVal, err := goth.NewPool(name, minThreads, maxThreads, idleDecayDuration, functionQueue, errorQueue)
+	if err != nil {
+		return retVal, err
+	}
+
+	go closeWhenDone(ctx, policy, retVal)
+
+	return retVal, nil
+}
+
+// closeWhenDone closes the pool according to the policy when
+// the context is done, unless the pool is done first
+func closeWhenDone(ctx context.Context, policy ContextCancelPolicy, pool Pool) {
+	select {
+	case <-ctx.Done():
+	case <-pool.Done():
+		return
+	}
+
+	if policy == DrainQueued {
+		waitForEmptyQueue(pool, time.Time{})
+
+		pool.Close()
+
+		return
+	}
+
+	pool.Close()
+
+	for _, queue := range pool.GetFunctionQueues() {
+		queue.Clear()
+	}
+}
+
 // GetPool returns a non-closed pool with the given name.  If not found second
 // value returned will be false
 func (goth *StandardThreadUtilities) GetPool(name string) (Pool, bool) {
 	goth.pools.poolMux.Lock()
-	goth.pools.poolMux.Unlock()
+	defer goth.pools.poolMux.Unlock()
 
 	retVal, found := goth.pools.poolMap[name]
 
@@ -250,7 +1146,7 @@ func (goth *StandardThreadUtilities) GetPool(name string) (Pool, bool) {
 func (goth *StandardThreadUtilities) EstablishThreadLocal(name string, initializer func(ThreadLocal) error,
 	destroyer func(ThreadLocal) error) error {
 	goth.locals.localsMux.Lock()
-	goth.locals.localsMux.Unlock()
+	defer goth.locals.localsMux.Unlock()
 
 	_, found := goth.locals.threadLocals[name]
 	if found {
@@ -260,7 +1156,7 @@ func (goth *StandardThreadUtilities) EstablishThreadLocal(name string, initializ
 	operation := &threadLocalOperators{
 		initializer: initializer,
 		destroyer:   destroyer,
-		lock:        goth.NewGoetheLock(),
+		lock:        goth.newInternalLock(),
 		actuals:     make(map[int64]ThreadLocal),
 	}
 
@@ -281,17 +1177,7 @@ func (goth *StandardThreadUtilities) GetThreadLocal(name string) (ThreadLocal, e
 		return nil, ErrNotGoetheThread
 	}
 
-	operators, found := goth.getOperatorsByName(name)
-	if !found {
-		operators = &threadLocalOperators{
-			lock:    goth.NewGoetheLock(),
-			actuals: make(map[int64]ThreadLocal),
-		}
-
-		goth.locals.localsMux.Lock()
-		goth.locals.threadLocals[name] = operators
-		goth.locals.localsMux.Unlock()
-	}
+	operators := goth.getOrCreateOperators(name)
 
 	operators.lock.WriteLock()
 	defer operators.lock.WriteUnlock()
@@ -326,7 +1212,7 @@ func (goth *StandardThreadUtilities) startTimer() {
 		func() {
 		}, values, false)
 
-	goth.Go(goth.timers.timer.run)
+	goth.timers.timerTid, _ = goth.Go(goth.timers.timer.run)
 
 	goth.EstablishThreadLocal(TimerThreadLocal, nil, nil)
 }
@@ -355,7 +1241,7 @@ func (goth *StandardThreadUtilities) ScheduleAtFixedRate(initialDelay time.Durat
 		return nil, err
 	}
 
-	return goth.timers.timer.addJob(initialDelay, period, errorQueue, method, arguments, true)
+	return goth.addScheduled(goth.timers.timer.addJob(initialDelay, period, errorQueue, method, arguments, true))
 }
 
 // ScheduleWithFixedDelay schedules the given method with the given args
@@ -381,18 +1267,113 @@ func (goth *StandardThreadUtilities) ScheduleWithFixedDelay(initialDelay time.Du
 		return nil, err
 	}
 
-	return goth.timers.timer.addJob(initialDelay, delay, errorQueue, method, arguments, false)
+	return goth.addScheduled(goth.timers.timer.addJob(initialDelay, delay, errorQueue, method, arguments, false))
+}
+
+// addScheduled remembers a method scheduled by a user for ListScheduled
+func (goth *StandardThreadUtilities) addScheduled(timer Timer, err error) (Timer, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	goth.timers.timerMux.Lock()
+	defer goth.timers.timerMux.Unlock()
+
+	if job, ok := timer.(*timerJob); ok {
+		goth.timers.scheduled[job] = true
+	}
+
+	return timer, nil
+}
+
+// ListScheduled returns the scheduled methods that have not been cancelled
+func (goth *StandardThreadUtilities) ListScheduled() []ScheduledHandle {
+	goth.timers.timerMux.Lock()
+	defer goth.timers.timerMux.Unlock()
+
+	retVal := make([]ScheduledHandle, 0, len(goth.timers.scheduled))
+	for job := range goth.timers.scheduled {
+		if !job.IsRunning() {
+			delete(goth.timers.scheduled, job)
+			continue
+		}
+
+		retVal = append(retVal, job)
+	}
+
+	sort.Slice(retVal, func(i, j int) bool {
+		return retVal[i].GetNextRunTime().Before(retVal[j].GetNextRunTime())
+	})
+
+	return retVal
+}
+
+// CancelAllScheduled cancels every scheduled method
+func (goth *StandardThreadUtilities) CancelAllScheduled() int {
+	goth.timers.timerMux.Lock()
+	defer goth.timers.timerMux.Unlock()
+
+	retVal := 0
+	for job := range goth.timers.scheduled {
+		if job.IsRunning() {
+			job.Cancel()
+			retVal++
+		}
+
+		delete(goth.timers.scheduled, job)
+	}
+
+	return retVal
 }
 
 func (goth *StandardThreadUtilities) getOperatorsByName(name string) (*threadLocalOperators, bool) {
 	goth.locals.localsMux.Lock()
-	goth.locals.localsMux.Unlock()
+	defer goth.locals.localsMux.Unlock()
 
 	retVal, found := goth.locals.threadLocals[name]
 
 	return retVal, found
 }
 
+// getOrCreateOperators returns the operators for the named thread local,
+// creating ones with no initializer or destroyer if it was never established
+func (goth *StandardThreadUtilities) getOrCreateOperators(name string) *threadLocalOperators {
+	operators, found := goth.getOperatorsByName(name)
+	if found {
+		return operators
+	}
+
+	created := &threadLocalOperators{
+		lock:    goth.newInternalLock(),
+		actuals: make(map[int64]ThreadLocal),
+	}
+
+	goth.locals.localsMux.Lock()
+	defer goth.locals.localsMux.Unlock()
+
+	// Another thread may have created them while the lock was free
+	if operators, found = goth.locals.threadLocals[name]; found {
+		return operators
+	}
+
+	goth.locals.threadLocals[name] = created
+
+	return created
+}
+
+// getAllOperators returns a copy of all of the thread local operators by name
+func (goth *StandardThreadUtilities) getAllOperators() map[string]*threadLocalOperators {
+	goth.locals.localsMux.Lock()
+	defer goth.locals.localsMux.Unlock()
+
+	retVal := make(map[string]*threadLocalOperators)
+	for name, operators := range goth.locals.threadLocals {
+		retVal[name] = operators
+	}
+
+	return retVal
+}
+
 func removeThreadLocal(operators *threadLocalOperators, tid int64) {
 	operators.lock.WriteLock()
 	defer operators.lock.WriteUnlock()
@@ -410,19 +1391,158 @@ func removeThreadLocal(operators *threadLocalOperators, tid int64) {
 }
 
 func (goth *StandardThreadUtilities) removeAllActuals(tid int64) {
-	goth.locals.localsMux.Lock()
-	goth.locals.localsMux.Unlock()
-
-	for _, operators := range goth.locals.threadLocals {
+	// Destroyers may use thread locals, so they are not called with localsMux held
+	for _, operators := range goth.getAllOperators() {
 		removeThreadLocal(operators, tid)
 	}
 }
 
 func (goth *StandardThreadUtilities) removePool(name string) {
 	goth.pools.poolMux.Lock()
-	goth.pools.poolMux.Unlock()
+	defer goth.pools.poolMux.Unlock()
 
 	delete(goth.pools.poolMap, name)
+	delete(goth.pools.dependencies, name)
+}
+
+// addPoolDependency records that the pool named from uses the pool named to.
+// Returns ErrDependencyCycle if to already depends on from
+func (goth *StandardThreadUtilities) addPoolDependency(from, to string) error {
+	goth.pools.poolMux.Lock()
+	defer goth.pools.poolMux.Unlock()
+
+	if goth.dependsOn(to, from, make(map[string]bool)) {
+		return ErrDependencyCycle
+	}
+
+	for _, existing := range goth.pools.dependencies[from] {
+		if existing == to {
+			return nil
+		}
+	}
+
+	goth.pools.dependencies[from] = append(goth.pools.dependencies[from], to)
+
+	return nil
+}
+
+// dependsOn returns true if the pool named from is or depends on the
+// pool named to, directly or not.  Must have poolMux held
+func (goth *StandardThreadUtilities) dependsOn(from, to string, visited map[string]bool) bool {
+	if from == to {
+		return true
+	}
+
+	if visited[from] {
+		return false
+	}
+	visited[from] = true
+
+	for _, dependency := range goth.pools.dependencies[from] {
+		if goth.dependsOn(dependency, to, visited) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ShutdownAll closes every open pool, waiting for each to empty its queues
+// and finish before closing the pools it depends on
+func (goth *StandardThreadUtilities) ShutdownAll(timeout time.Duration) error {
+	deadline := now().Add(timeout)
+
+	goth.pools.poolMux.Lock()
+
+	remaining := make(map[string]Pool)
+	for name, pool := range goth.pools.poolMap {
+		remaining[name] = pool
+	}
+
+	dependencies := make(map[string][]string)
+	for name, names := range goth.pools.dependencies {
+		dependencies[name] = names
+	}
+
+	goth.pools.poolMux.Unlock()
+
+	var retVal error
+	for len(remaining) > 0 {
+		// Pools that no remaining pool depends on.  Since there
+		// are no cycles there is always at least one
+		needed := make(map[string]bool)
+		for name := range remaining {
+			for _, dependency := range dependencies[name] {
+				needed[dependency] = true
+			}
+		}
+
+		ready := make([]string, 0)
+		for name := range remaining {
+			if !needed[name] {
+				ready = append(ready, name)
+			}
+		}
+
+		sort.Strings(ready)
+
+		for _, name := range ready {
+			pool := remaining[name]
+			delete(remaining, name)
+
+			waitForEmptyQueue(pool, deadline)
+
+			pool.Close()
+
+			if !waitForDone(pool, deadline) {
+				retVal = ErrCloseTimeout
+			}
+		}
+	}
+
+	return retVal
+}
+
+// waitForEmptyQueue waits until a started pool has taken every function
+// from its queues or the deadline, if it is not zero, has passed.  Gives
+// up if the pool is closed, since then its queues are no longer emptied
+func waitForEmptyQueue(pool Pool, deadline time.Time) {
+	if !pool.IsStarted() {
+		return
+	}
+
+	for pool.GetStats().QueueSize > 0 && (deadline.IsZero() || now().Before(deadline)) && !pool.IsClosed() {
+		globalGoethe.getClock().Sleep(drainPollInterval)
+	}
+}
+
+// waitForDone waits until the pool is done or the deadline has passed.
+// Returns false if the pool was not done by the deadline
+func waitForDone(pool Pool, deadline time.Time) bool {
+	select {
+	case <-pool.Done():
+		return true
+	default:
+		break
+	}
+
+	wait := deadline.Sub(now())
+	if wait <= 0 {
+		return false
+	}
+
+	timedOut := make(chan struct{})
+	timer := afterFunc(wait, func() {
+		close(timedOut)
+	})
+	defer timer.Stop()
+
+	select {
+	case <-pool.Done():
+		return true
+	case <-timedOut:
+		return false
+	}
 }
 
 // convertToNibbles returns the nibbles of the string
@@ -442,7 +1562,12 @@ func invokeStart(tid int64, userCall interface{}, args []reflect.Value) error {
 }
 
 func invokeEnd(tid int64, userCall interface{}, args []reflect.Value) error {
+	defer globalGoethe.threadExited(tid)
 	defer globalGoethe.removeAllActuals(tid)
+	defer recoverThread(tid)
+	defer globalGoethe.runExitHooks(tid)
+
+	globalGoethe.recordGoroutineID(tid)
 
 	invoke(userCall, args, nil)
 
@@ -42,7 +42,11 @@ package goethe
 
 import (
 	"errors"
+	"fmt"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGoetheFactory(t *testing.T) {
@@ -139,6 +143,43 @@ func TestGoWithArgsFunctionReturnsError(t *testing.T) {
 	goethe.Go(returnError, err)
 }
 
+func TestTypedGo(t *testing.T) {
+	goethe := GetGoethe()
+
+	ret := make(chan int)
+	tids := make(chan int64, 3)
+
+	tid1 := Go1(func(a int) {
+		tids <- goethe.GetThreadID()
+		ret <- a
+	}, 1)
+
+	tid2 := Go2(func(a int, s string) {
+		tids <- goethe.GetThreadID()
+		ret <- a + len(s)
+	}, 1, "ab")
+
+	tid3 := Go3(func(a, b int, out chan int) {
+		tids <- goethe.GetThreadID()
+		out <- a + b
+	}, 1, 2, ret)
+
+	sum := <-ret + <-ret + <-ret
+	if sum != 1+3+3 {
+		t.Errorf("did not get expected sum, got %d", sum)
+		return
+	}
+
+	seen := map[int64]bool{}
+	for lcv := 0; lcv < 3; lcv++ {
+		seen[<-tids] = true
+	}
+
+	if !seen[tid1] || !seen[tid2] || !seen[tid3] {
+		t.Errorf("returned thread ids %d, %d, %d did not match %v", tid1, tid2, tid3, seen)
+	}
+}
+
 func addMe(a, b, c int, ret chan int) {
 	ret <- a + b + c
 }
@@ -146,3 +187,455 @@ func addMe(a, b, c int, ret chan int) {
 func returnError(echo error) error {
 	return echo
 }
+
+func TestThreadIDsNeverReused(t *testing.T) {
+	goethe := GetGoethe()
+
+	goethe.SetDebugMode(true)
+	defer goethe.SetDebugMode(false)
+
+	ids := make(chan int64, 500)
+	for lcv := 0; lcv < 500; lcv++ {
+		goethe.Go(func() {
+			ids <- goethe.GetThreadID()
+		})
+	}
+
+	seen := make(map[int64]bool)
+	for lcv := 0; lcv < 500; lcv++ {
+		tid := <-ids
+		if seen[tid] {
+			t.Errorf("thread id %d was given out twice", tid)
+			return
+		}
+
+		seen[tid] = true
+	}
+}
+
+func TestDebugModeFindsReusedThreadID(t *testing.T) {
+	goethe := GetGoethe()
+
+	goethe.SetDebugMode(true)
+	defer goethe.SetDebugMode(false)
+
+	tid := globalGoethe.getAndIncrementTid()
+
+	globalGoethe.threadStarted(tid)
+	defer globalGoethe.threadExited(tid)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("debug mode should have panicked when a running thread id was reused")
+		}
+	}()
+
+	globalGoethe.threadStarted(tid)
+}
+
+func TestJoinThread(t *testing.T) {
+	goethe := GetGoethe()
+
+	proceed := make(chan bool)
+	tid, err := goethe.Go(func() {
+		<-proceed
+	})
+	if err != nil {
+		t.Errorf("error running thread %v", err)
+		return
+	}
+
+	finished, err := goethe.JoinThread(tid, 100*time.Millisecond)
+	if err != nil || finished {
+		t.Errorf("thread should still be running, got %v %v", finished, err)
+		return
+	}
+
+	close(proceed)
+
+	finished, err = goethe.JoinThread(tid, 20*time.Second)
+	if err != nil || !finished {
+		t.Errorf("thread should have finished, got %v %v", finished, err)
+		return
+	}
+
+	finished, err = goethe.JoinThread(tid, 0)
+	if err != nil || !finished {
+		t.Errorf("finished thread should join right away, got %v %v", finished, err)
+		return
+	}
+
+	_, err = goethe.JoinThread(tid+1000000, 0)
+	if err != ErrNoSuchThread {
+		t.Errorf("expected ErrNoSuchThread for an id never given out, got %v", err)
+	}
+}
+
+func TestGoOnce(t *testing.T) {
+	goethe := GetGoethe()
+
+	proceed := make(chan bool)
+	ran := make(chan int, 2)
+
+	started, err := goethe.GoOnce("onceJob", func(which int) {
+		<-proceed
+		ran <- which
+	}, 1)
+	if err != nil || !started {
+		t.Errorf("first GoOnce should have started, got %v %v", started, err)
+		return
+	}
+
+	started, err = goethe.GoOnce("onceJob", func(which int) {
+		ran <- which
+	}, 2)
+	if err != nil || started {
+		t.Errorf("second GoOnce should have been skipped, got %v %v", started, err)
+		return
+	}
+
+	close(proceed)
+
+	if which := <-ran; which != 1 {
+		t.Errorf("expected first function to run, got %d", which)
+		return
+	}
+
+	// The tag is cleared once the first thread has completely exited
+	started = false
+	for lcv := 0; lcv < 200 && !started; lcv++ {
+		started, err = goethe.GoOnce("onceJob", func(which int) {
+			ran <- which
+		}, 3)
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+			return
+		}
+
+		if !started {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if !started {
+		t.Error("tag was never cleared after the first function returned")
+		return
+	}
+
+	if which := <-ran; which != 3 {
+		t.Errorf("expected third function to run, got %d", which)
+	}
+}
+
+func TestResetForTesting(t *testing.T) {
+	goethe := GetGoethe()
+
+	proceed := make(chan bool)
+	started := make(chan bool)
+	tid, _ := goethe.Go(func() {
+		goethe.SetThreadName("resetMe")
+		started <- true
+
+		<-proceed
+	})
+
+	<-started
+
+	goethe.SetDebugMode(true)
+	goethe.EstablishThreadLocal("resetLocal", nil, nil)
+
+	if err := goethe.ResetForTesting(); err != ErrThreadsRunning {
+		t.Errorf("expected ErrThreadsRunning while a thread runs, got %v", err)
+		return
+	}
+
+	close(proceed)
+
+	var err error
+	for lcv := 0; lcv < 200; lcv++ {
+		if err = goethe.ResetForTesting(); err != ErrThreadsRunning {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err != nil {
+		t.Errorf("reset failed after the thread exited %v", err)
+		return
+	}
+
+	if globalGoethe.isDebugMode() {
+		t.Error("debug mode should have been turned off")
+		return
+	}
+
+	if name := globalGoethe.getThreadName(tid); name != "" {
+		t.Errorf("thread name should have been forgotten, got %s", name)
+		return
+	}
+
+	if err := goethe.EstablishThreadLocal("resetLocal", nil, nil); err != nil {
+		t.Errorf("thread local should have been forgotten, got %v", err)
+		return
+	}
+
+	next, _ := goethe.Go(func() {})
+	if next <= tid {
+		t.Errorf("thread id %d was reused after reset, last was %d", next, tid)
+	}
+}
+
+func TestGoWithCopiedArgs(t *testing.T) {
+	goethe := GetGoethe()
+
+	numbers := []int{1, 2, 3}
+	names := map[string]int{"one": 1}
+
+	start := make(chan bool)
+	seen := make(chan int)
+
+	goethe.GoWithCopiedArgs(func(n []int, m map[string]int) {
+		<-start
+
+		seen <- n[0] + len(n) + m["one"] + len(m)
+	}, numbers, names)
+
+	// Without the copy these would race with the thread, which go test -race reports
+	numbers[0] = 100
+	numbers = append(numbers, 4)
+	names["one"] = 100
+	names["two"] = 2
+
+	close(start)
+
+	if got := <-seen; got != 1+3+1+1 {
+		t.Errorf("thread saw changes made after it started, got %d", got)
+	}
+
+	if _, err := goethe.GoWithCopiedArgs(func(n []int) {}, "not a slice"); err == nil {
+		t.Error("expected an error for an argument of the wrong type")
+	}
+}
+
+func TestGetRuntimeGoroutineID(t *testing.T) {
+	goethe := GetGoethe()
+
+	proceed := make(chan bool)
+	stacks := make(chan string)
+
+	tid, err := goethe.Go(func() {
+		buffer := make([]byte, 64)
+		stacks <- string(buffer[:runtime.Stack(buffer, false)])
+
+		<-proceed
+	})
+	if err != nil {
+		t.Errorf("error running thread %v", err)
+		return
+	}
+
+	stack := <-stacks
+
+	goroutineID, found := goethe.GetRuntimeGoroutineID(tid)
+	if !found {
+		t.Error("expected a goroutine id for a running thread")
+		return
+	}
+
+	if expected := fmt.Sprintf("goroutine %d ", goroutineID); !strings.HasPrefix(stack, expected) {
+		t.Errorf("expected the stack of the thread to start with %q, got %q", expected, stack)
+		return
+	}
+
+	close(proceed)
+	goethe.JoinThread(tid, 20*time.Second)
+
+	if _, found := goethe.GetRuntimeGoroutineID(tid); found {
+		t.Error("thread has exited and should have no goroutine id")
+	}
+}
+
+func TestGoQuorum(t *testing.T) {
+	goethe := GetGoethe()
+
+	release := make(chan bool)
+	defer close(release)
+
+	succeed := func(value int) func() (interface{}, error) {
+		return func() (interface{}, error) {
+			return value, nil
+		}
+	}
+	fail := func() (interface{}, error) {
+		return nil, errors.New("backend down")
+	}
+	stuck := func() (interface{}, error) {
+		<-release
+		return 0, nil
+	}
+
+	results, err := goethe.GoQuorum(2, []func() (interface{}, error){
+		fail, succeed(1), stuck, succeed(2),
+	}, 10*time.Second)
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+		return
+	}
+	if len(results) != 2 || results[0].(int)+results[1].(int) != 3 {
+		t.Errorf("expected the two successful results, got %v", results)
+		return
+	}
+
+	// Two failures leave too few tasks for three to succeed, whether
+	// or not the successful one finished before them
+	results, err = goethe.GoQuorum(3, []func() (interface{}, error){
+		fail, succeed(1), fail, stuck,
+	}, 10*time.Second)
+	if !errors.Is(err, ErrQuorumNotReached) {
+		t.Errorf("expected ErrQuorumNotReached once too many failed, got %v", err)
+		return
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("%d of 4 tasks succeeded and 2 failed, 3 needed", len(results))) {
+		t.Errorf("error does not report the counts: %v", err)
+		return
+	}
+	if len(results) > 1 || (len(results) == 1 && results[0] != 1) {
+		t.Errorf("expected at most the one successful result, got %v", results)
+		return
+	}
+
+	results, err = goethe.GoQuorum(1, []func() (interface{}, error){
+		stuck, stuck,
+	}, 50*time.Millisecond)
+	if !errors.Is(err, ErrQuorumNotReached) || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("expected ErrQuorumNotReached after the timeout, got %v", err)
+		return
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no results, got %v", results)
+	}
+
+	if _, err = goethe.GoQuorum(3, []func() (interface{}, error){fail, fail}, time.Second); err == nil {
+		t.Error("expected an error for a quorum larger than the number of tasks")
+	}
+}
+
+func TestTypedLockReturn(t *testing.T) {
+	goethe := GetGoethe()
+
+	lock := goethe.NewGoetheLock()
+	counter := 0
+
+	reply := make(chan error)
+	Go1(func(reply chan error) {
+		count, err := WriteLockReturn(lock, func() (int, error) {
+			if owner, held := lock.GetWriteOwner(); !held || owner != goethe.GetThreadID() {
+				return 0, errors.New("write lock not held in the function")
+			}
+
+			counter++
+			return counter, nil
+		})
+		if err != nil || count != 1 {
+			reply <- fmt.Errorf("expected 1 from WriteLockReturn, got %d and %v", count, err)
+			return
+		}
+
+		name, err := ReadLockReturn(lock, func() (string, error) {
+			return fmt.Sprintf("count-%d", counter), nil
+		})
+		if err != nil || name != "count-1" {
+			reply <- fmt.Errorf("expected count-1 from ReadLockReturn, got %s and %v", name, err)
+			return
+		}
+
+		lock.ReadLock()
+		called := false
+		count, err = WriteLockReturn(lock, func() (int, error) {
+			called = true
+			return 5, nil
+		})
+		lock.ReadUnlock()
+		if err != ErrReadLockHeld || called || count != 0 {
+			reply <- fmt.Errorf("expected ErrReadLockHeld and the zero value, got %d, %v and called %v", count, err, called)
+			return
+		}
+
+		if _, held := lock.GetWriteOwner(); held {
+			reply <- errors.New("write lock still held after the typed calls")
+			return
+		}
+
+		reply <- nil
+	}, reply)
+
+	if err := <-reply; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	goethe := GetGoethe()
+
+	// Without a handler the thread panics again
+	repanicked := func() (value interface{}) {
+		defer func() {
+			value = recover()
+		}()
+
+		func() {
+			defer recoverThread(1)
+
+			panic("no handler")
+		}()
+
+		return nil
+	}()
+	if repanicked != "no handler" {
+		t.Errorf("expected the panic to be raised again, got %v", repanicked)
+		return
+	}
+
+	panics := make(chan PanicInformation, 1)
+	goethe.SetPanicHandler(func(info PanicInformation) {
+		panics <- info
+	})
+	defer goethe.SetPanicHandler(nil)
+
+	exited := make(chan bool, 1)
+	tid, err := goethe.Go(func() {
+		goethe.SetThreadName("panicker")
+		goethe.OnThreadExit(func() {
+			exited <- true
+		})
+
+		panic("thread panic")
+	})
+	if err != nil {
+		t.Errorf("error running thread %v", err)
+		return
+	}
+
+	info := <-panics
+	if info.ThreadID != tid || info.ThreadName != "panicker" || info.Value != "thread panic" || info.PoolName != "" {
+		t.Errorf("unexpected panic information %+v", info)
+		return
+	}
+
+	if !strings.Contains(info.Stack, "TestPanicHandler") {
+		t.Errorf("expected the stack of the panic, got %s", info.Stack)
+		return
+	}
+
+	if joined, _ := goethe.JoinThread(tid, 20*time.Second); !joined {
+		t.Error("thread did not exit after its panic was handled")
+		return
+	}
+
+	select {
+	case <-exited:
+	default:
+		t.Error("exit hooks did not run after the panic")
+	}
+}
@@ -41,11 +41,26 @@
 package goethe
 
 import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type goetheLock struct {
-	parent *StandardThreadUtilities
+	// see GetWriteVersion, read without goMux so only used through
+	// the atomic package.  First so it is 64-bit aligned everywhere
+	writeVersion uint64
+
+	parent   *StandardThreadUtilities
+	id       uint64
+	internal bool
+	held     bool
+	options  LockOptions
 
 	goMux sync.Mutex
 	cond  *sync.Cond
@@ -55,17 +70,75 @@ type goetheLock struct {
 	holdingWriter  int64
 	writerCount    int32
 	writersWaiting int64
+	upgrader       int64
+
+	// for DumpLocks, what each waiting thread is waiting for, in
+	// which order they started waiting, and in debug mode where
+	// each holder took the lock
+	waiting     map[int64]string
+	waitTickets map[int64]uint64
+	lastWait    uint64
+	stacks      map[int64]string
+
+	// when each waiting thread first had to wait, and how
+	// often and how long threads have had to wait altogether
+	waitStarts map[int64]time.Time
+	contended  uint64
+	totalWait  time.Duration
+	maxWait    time.Duration
+
+	// threads told to stop waiting by InterruptThread
+	interrupted map[int64]bool
+
+	// for fair locks, the waiting threads in the order they will get the lock
+	queue            []*lockWaiter
+	lastTicket       uint64
+	priorityReorders uint64
+
+	// when the current holds started and how long holds lasted,
+	// only kept if LockOptions.RecordHoldTimes is set
+	writeHoldStart time.Time
+	readHoldStarts map[int64]time.Time
+	writeHolds     *holdTimes
+	readHolds      *holdTimes
+
+	// the write lock given to a queued function that
+	// has not been dequeued, see EnqueueWithWriteLock
+	handoff *lockHandoff
 }
 
-func newReaderWriterLock(pparent *StandardThreadUtilities) Lock {
+// lockWaiter is a thread waiting for a fair lock
+type lockWaiter struct {
+	tid      int64
+	priority int
+	ticket   uint64
+	write    bool
+}
+
+func newReaderWriterLock(pparent *StandardThreadUtilities, internal bool, options LockOptions) Lock {
 	retVal := &goetheLock{
 		parent:        pparent,
+		id:            pparent.nextLockID(),
+		internal:      internal,
+		options:       options,
 		holdingWriter: -2,
+		upgrader:      -2,
 		readerCounts:  make(map[int64]int32),
+		waiting:       make(map[int64]string),
+		waitTickets:   make(map[int64]uint64),
+		stacks:        make(map[int64]string),
+		waitStarts:    make(map[int64]time.Time),
+		interrupted:   make(map[int64]bool),
 	}
 
 	retVal.cond = sync.NewCond(&retVal.goMux)
 
+	if options.RecordHoldTimes {
+		retVal.readHoldStarts = make(map[int64]time.Time)
+		retVal.writeHolds = &holdTimes{}
+		retVal.readHolds = &holdTimes{}
+	}
+
 	return retVal
 }
 
@@ -83,6 +156,37 @@ func (lock *goetheLock) Unlock() {
 	}
 }
 
+// readLocker is a sync.Locker for the read side of a goethe lock
+type readLocker struct {
+	lock *goetheLock
+}
+
+func (rl readLocker) Lock() {
+	err := rl.lock.ReadLock()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (rl readLocker) Unlock() {
+	err := rl.lock.ReadUnlock()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// WriteLocker returns a sync.Locker that takes the write lock
+func (lock *goetheLock) WriteLocker() sync.Locker {
+	return lock
+}
+
+// ReadLocker returns a sync.Locker that takes the read lock
+func (lock *goetheLock) ReadLocker() sync.Locker {
+	return readLocker{
+		lock: lock,
+	}
+}
+
 // ReadLock Locks for read.  Multiple readers on multiple threads
 // are allowed in simultaneously.  Is counting, but all locks must
 // be paired with ReadUnlock.  You may get a ReadLock while holding
@@ -93,25 +197,88 @@ func (lock *goetheLock) ReadLock() error {
 		return ErrNotGoetheThread
 	}
 
+	return lock.readLock(tid, lock.threadPriority(tid))
+}
+
+// threadPriority returns the WithLockPriority priority of the
+// thread.  Only fair locks use it, so others skip the lookup
+func (lock *goetheLock) threadPriority(tid int64) int {
+	if !lock.options.Fair {
+		return 0
+	}
+
+	return lock.parent.getLockPriority(tid)
+}
+
+// ReadLockWithPriority is ReadLock where a fair lock lets in higher
+// priority waiters first
+func (lock *goetheLock) ReadLockWithPriority(priority int) error {
+	tid := lock.parent.GetThreadID()
+	if tid < 0 {
+		return ErrNotGoetheThread
+	}
+
+	return lock.readLock(tid, priority)
+}
+
+func (lock *goetheLock) readLock(tid int64, priority int) error {
 	lock.goMux.Lock()
 	defer lock.goMux.Unlock()
 
+	if lock.handedOff(tid) {
+		return ErrLockHandedOff
+	}
+
+	if lock.atDepthLimit(lock.getMyReadCount(tid)) {
+		return ErrRecursionLimitExceeded
+	}
+
 	if lock.holdingWriter == tid {
 		// We can go ahead and increment our count and leave
 		lock.incrementReadLock(tid)
 		return nil
 	}
 
-	for lock.holdingWriter >= 0 || lock.writersWaiting > 0 {
-		lock.cond.Wait()
+	if lock.getMyReadCount(tid) > 0 {
+		// Any waiting writer is waiting for us to leave, so waiting
+		// behind it would wait on ourselves
+		lock.incrementReadLock(tid)
+		return nil
+	}
+
+	deadline := deadlineAfter(lock.options.ReadTimeout)
+
+	var err error
+
+	lock.startWaiting(tid, "read")
+	if lock.options.Fair {
+		err = lock.waitFair(tid, priority, false, deadline)
+	} else {
+		spins := 0
+		for err == nil && (lock.holdingWriter >= 0 || lock.writersWaiting > 0) {
+			err = lock.spinOrWait(tid, deadline, &spins)
+		}
+	}
+	lock.stopWaiting(tid)
+
+	if err != nil {
+		return err
 	}
 
 	// At this point holdingWriter < 0 and there are no writersWaiting
 	lock.incrementReadLock(tid)
+	lock.updateHeld()
+	lock.recordStack(tid)
 
 	return nil
 }
 
+// atDepthLimit returns true if a thread already holding the lock
+// count times may not take it again
+func (lock *goetheLock) atDepthLimit(count int32) bool {
+	return lock.options.MaxRecursionDepth > 0 && count >= lock.options.MaxRecursionDepth
+}
+
 func (lock *goetheLock) incrementReadLock(tid int64) {
 	currentValue, found := lock.readerCounts[tid]
 	if found {
@@ -119,6 +286,7 @@ func (lock *goetheLock) incrementReadLock(tid int64) {
 		lock.readerCounts[tid] = currentValue
 	} else {
 		lock.readerCounts[tid] = 1
+		lock.holdStarted(tid, false)
 	}
 }
 
@@ -142,6 +310,9 @@ func (lock *goetheLock) ReadUnlock() error {
 	count--
 	if count <= 0 {
 		delete(lock.readerCounts, tid)
+		lock.holdEnded(tid, false)
+		lock.forgetStack(tid)
+		lock.updateHeld()
 
 		if lock.writersWaiting > 0 {
 			lock.cond.Broadcast()
@@ -186,32 +357,348 @@ func (lock *goetheLock) WriteLock() error {
 		return ErrNotGoetheThread
 	}
 
+	return lock.writeLock(tid, lock.threadPriority(tid))
+}
+
+// WriteLockWithPriority is WriteLock where a fair lock lets in higher
+// priority waiters first
+func (lock *goetheLock) WriteLockWithPriority(priority int) error {
+	tid := lock.parent.GetThreadID()
+	if tid < 0 {
+		return ErrNotGoetheThread
+	}
+
+	return lock.writeLock(tid, priority)
+}
+
+func (lock *goetheLock) writeLock(tid int64, priority int) error {
 	lock.goMux.Lock()
 	defer lock.goMux.Unlock()
 
+	if lock.handedOff(tid) {
+		return ErrLockHandedOff
+	}
+
 	if lock.getMyReadCount(tid) != 0 {
 		return ErrReadLockHeld
 	}
 
 	if lock.holdingWriter == tid {
+		if lock.options.NonReentrant {
+			return ErrAlreadyHeld
+		}
+
+		if lock.atDepthLimit(lock.writerCount) {
+			return ErrRecursionLimitExceeded
+		}
+
 		// counting
 		lock.writerCount++
 		return nil
 	}
 
+	deadline := deadlineAfter(lock.options.WriteTimeout)
+
 	lock.writersWaiting++
-	for lock.holdingWriter >= 0 || lock.getAllOtherReadCount(tid) > 0 {
-		lock.cond.Wait()
+	lock.startWaiting(tid, "write")
+	if lock.options.Fair {
+		if err := lock.waitFair(tid, priority, true, deadline); err != nil {
+			return lock.writeGaveUp(tid, err)
+		}
+	} else {
+		spins := 0
+		for (lock.holdingWriter >= 0 && lock.holdingWriter != tid) || lock.getAllOtherReadCount(tid) > 0 {
+			if err := lock.spinOrWait(tid, deadline, &spins); err != nil {
+				return lock.writeGaveUp(tid, err)
+			}
+		}
+	}
+	lock.stopWaiting(tid)
+
+	if lock.holdingWriter == tid {
+		// The lock was transferred to me while I was waiting
+		lock.writerCount++
+		lock.writersWaiting--
+		return nil
 	}
 
 	// I just got this lock for myself
 	lock.holdingWriter = tid
+	lock.holdStarted(tid, true)
 
 	lock.writerCount = 1
 	lock.writersWaiting--
+	lock.updateHeld()
+	lock.recordStack(tid)
+	return nil
+}
+
+// writeGaveUp gives up waiting for the write lock because of the
+// error, which it returns.  Must have mutex held
+func (lock *goetheLock) writeGaveUp(tid int64, err error) error {
+	lock.writersWaiting--
+	lock.stopWaiting(tid)
+
+	// Readers held back by this writer may now proceed
+	lock.cond.Broadcast()
+
+	return err
+}
+
+// deadlineAfter returns when a wait of the given timeout ends,
+// or the zero time if the timeout is zero
+func deadlineAfter(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	return now().Add(timeout)
+}
+
+// waitUntil waits on the condition, giving up at the deadline unless
+// it is the zero time.  Returns ErrLockTimeout if the deadline has passed
+// and ErrInterrupted if InterruptThread was called for the thread while
+// it waited.  Must have mutex held
+func (lock *goetheLock) waitUntil(tid int64, deadline time.Time) error {
+	lock.contendedWait(tid)
+
+	if deadline.IsZero() {
+		lock.cond.Wait()
+	} else if !lock.timedWait(deadline) {
+		return ErrLockTimeout
+	}
+
+	if lock.interrupted[tid] {
+		delete(lock.interrupted, tid)
+		return ErrInterrupted
+	}
+
+	return nil
+}
+
+// spinOrWait is waitUntil that first lets go of the mutex and yields the
+// processor the number of times given by LockOptions.SpinCount, counted in
+// spins, so that a lock held only briefly can be had without blocking.
+// Must have mutex held
+func (lock *goetheLock) spinOrWait(tid int64, deadline time.Time, spins *int) error {
+	if *spins >= lock.options.SpinCount {
+		return lock.waitUntil(tid, deadline)
+	}
+	*spins++
+
+	lock.contendedWait(tid)
+
+	lock.goMux.Unlock()
+	runtime.Gosched()
+	lock.goMux.Lock()
+
+	// Any broadcast while spinning was missed, so look for what it was about
+	if lock.interrupted[tid] {
+		delete(lock.interrupted, tid)
+		return ErrInterrupted
+	}
+
+	if !deadline.IsZero() && !now().Before(deadline) {
+		return ErrLockTimeout
+	}
+
+	return nil
+}
+
+// startWaiting records that the thread is waiting for the lock, for
+// DumpLocks and InterruptThread.  Must have mutex held
+func (lock *goetheLock) startWaiting(tid int64, what string) {
+	lock.waiting[tid] = what
+	lock.lastWait++
+	lock.waitTickets[tid] = lock.lastWait
+
+	// Goethe itself does not expect its own locks to be interrupted
+	if !lock.internal {
+		lock.parent.lockWaitChanged(tid, lock)
+	}
+}
+
+// contendedWait records when the thread first had to spin or block
+// while waiting for the lock.  Must have mutex held
+func (lock *goetheLock) contendedWait(tid int64) {
+	if _, found := lock.waitStarts[tid]; !found {
+		lock.waitStarts[tid] = now()
+	}
+}
+
+// stopWaiting records that the thread is no longer waiting for the
+// lock, forgetting any interrupt it did not see.  Must have mutex held
+func (lock *goetheLock) stopWaiting(tid int64) {
+	if started, found := lock.waitStarts[tid]; found {
+		waited := since(started)
+
+		lock.contended++
+		lock.totalWait += waited
+		if waited > lock.maxWait {
+			lock.maxWait = waited
+		}
+
+		delete(lock.waitStarts, tid)
+	}
+
+	delete(lock.waiting, tid)
+	delete(lock.waitTickets, tid)
+	delete(lock.interrupted, tid)
+	if !lock.internal {
+		lock.parent.lockWaitChanged(tid, nil)
+	}
+}
+
+// interrupt wakes the thread if it is waiting for this lock so that
+// it gives up with ErrInterrupted.  Returns false if it is not waiting
+func (lock *goetheLock) interrupt(tid int64) bool {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	if _, found := lock.waiting[tid]; !found {
+		return false
+	}
+
+	lock.interrupted[tid] = true
+	lock.cond.Broadcast()
+
+	return true
+}
+
+// waitFair puts the thread in line for a fair lock and waits until
+// it may have the lock.  Returns the error of waitUntil if the thread
+// gave up first, in which case it is no longer in line.  Must have
+// mutex held
+func (lock *goetheLock) waitFair(tid int64, priority int, write bool, deadline time.Time) error {
+	lock.lastTicket++
+	waiter := &lockWaiter{
+		tid:      tid,
+		priority: priority,
+		ticket:   lock.lastTicket,
+		write:    write,
+	}
+
+	// Higher priorities first, behind everyone of the same priority
+	index := sort.Search(len(lock.queue), func(i int) bool {
+		return lock.queue[i].priority < priority
+	})
+	lock.queue = append(lock.queue, nil)
+	copy(lock.queue[index+1:], lock.queue[index:])
+	lock.queue[index] = waiter
+
+	for !lock.mayGrant(waiter) {
+		if err := lock.waitUntil(tid, deadline); err != nil {
+			lock.removeWaiter(waiter)
+
+			// Those behind may now be next in line
+			lock.cond.Broadcast()
+
+			return err
+		}
+	}
+
+	lock.removeWaiter(waiter)
+
+	for _, other := range lock.queue {
+		if other.ticket < waiter.ticket {
+			lock.priorityReorders++
+			break
+		}
+	}
+
 	return nil
 }
 
+// removeWaiter takes the waiter out of the line.  Must have mutex held
+func (lock *goetheLock) removeWaiter(waiter *lockWaiter) {
+	for index, other := range lock.queue {
+		if other == waiter {
+			lock.queue = append(lock.queue[:index], lock.queue[index+1:]...)
+			return
+		}
+	}
+}
+
+// mayGrant returns true if the waiter of a fair lock may have
+// the lock now.  Must have mutex held
+func (lock *goetheLock) mayGrant(waiter *lockWaiter) bool {
+	if waiter.write {
+		if lock.holdingWriter == waiter.tid {
+			// Transferred to us while waiting
+			return true
+		}
+
+		if lock.holdingWriter >= 0 || lock.getAllOtherReadCount(waiter.tid) > 0 {
+			return false
+		}
+
+		return lock.queue[0] == waiter
+	}
+
+	if lock.holdingWriter >= 0 || lock.upgrader >= 0 {
+		return false
+	}
+
+	// Readers go in together, up to the first writer in line
+	for _, other := range lock.queue {
+		if other == waiter {
+			return true
+		}
+
+		if other.write {
+			return false
+		}
+	}
+
+	return false
+}
+
+// GetStats returns statistics about this lock
+func (lock *goetheLock) GetStats() LockStats {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	return LockStats{
+		PriorityReorders: lock.priorityReorders,
+		Contended:        lock.contended,
+		TotalWait:        lock.totalWait,
+		MaxWait:          lock.maxWait,
+		WriteHoldTimes:   lock.writeHolds.stats(),
+		ReadHoldTimes:    lock.readHolds.stats(),
+	}
+}
+
+// GetWriteVersion returns the number of times the write lock was given up
+func (lock *goetheLock) GetWriteVersion() uint64 {
+	return atomic.LoadUint64(&lock.writeVersion)
+}
+
+// ResetStats returns statistics about this lock and zeroes them
+func (lock *goetheLock) ResetStats() LockStats {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	retVal := LockStats{
+		PriorityReorders: lock.priorityReorders,
+		Contended:        lock.contended,
+		TotalWait:        lock.totalWait,
+		MaxWait:          lock.maxWait,
+		WriteHoldTimes:   lock.writeHolds.stats(),
+		ReadHoldTimes:    lock.readHolds.stats(),
+	}
+
+	lock.priorityReorders = 0
+	lock.contended = 0
+	lock.totalWait = 0
+	lock.maxWait = 0
+	if lock.options.RecordHoldTimes {
+		lock.writeHolds = &holdTimes{}
+		lock.readHolds = &holdTimes{}
+	}
+
+	return retVal
+}
+
 // WriteUnlock unlocks write lock.  Will only truly leave
 // critical section as reader when count is zero
 func (lock *goetheLock) WriteUnlock() error {
@@ -223,17 +710,482 @@ func (lock *goetheLock) WriteUnlock() error {
 	lock.goMux.Lock()
 	defer lock.goMux.Unlock()
 
+	if lock.handedOff(tid) {
+		return ErrLockHandedOff
+	}
+
 	if tid != lock.holdingWriter {
 		return ErrWriteLockNotHeld
 	}
 
+	lock.releaseWriter(tid)
+
+	return nil
+}
+
+// releaseWriter unlocks the write lock held by the
+// thread once.  Must have mutex held
+func (lock *goetheLock) releaseWriter(tid int64) {
 	lock.writerCount--
 	if lock.writerCount <= 0 {
 		lock.writerCount = 0
 		lock.holdingWriter = -2
+		lock.holdEnded(tid, true)
+		atomic.AddUint64(&lock.writeVersion, 1)
+		lock.forgetStack(tid)
+		lock.updateHeld()
+
+		lock.cond.Broadcast()
+	}
+}
+
+// TransferWriteLock gives the write lock held by the calling thread,
+// including its count, to the goethe thread with the given id
+func (lock *goetheLock) TransferWriteLock(toThreadID int64) error {
+	tid := lock.parent.GetThreadID()
+	if tid < 0 {
+		return ErrNotGoetheThread
+	}
+
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	if lock.handedOff(tid) {
+		return ErrLockHandedOff
+	}
+
+	if tid != lock.holdingWriter {
+		return ErrWriteLockNotHeld
+	}
+
+	if lock.getMyReadCount(tid) != 0 {
+		return ErrReadLockHeld
+	}
+
+	if toThreadID == tid {
+		return nil
+	}
+
+	if !lock.parent.isThreadAlive(toThreadID) {
+		return ErrNoSuchThread
+	}
+
+	// The other thread holds it from now on
+	lock.holdEnded(tid, true)
+	lock.holdingWriter = toThreadID
+	lock.holdStarted(toThreadID, true)
+	lock.forgetStack(tid)
+
+	// The other thread did not choose when to take it, so
+	// it says nothing about the order of its locks
+	lock.parent.lockTaken(lock, toThreadID, false)
+
+	// In case the other thread is waiting for this lock
+	lock.cond.Broadcast()
+
+	return nil
+}
+
+// GetWriteOwner returns the id of the goethe thread
+// holding the write lock, or false if nobody does
+func (lock *goetheLock) GetWriteOwner() (int64, bool) {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	if lock.holdingWriter < 0 {
+		return 0, false
+	}
+
+	return lock.holdingWriter, true
+}
+
+// WaitUntilUnlocked waits up to the given duration until no thread
+// holds the write lock without acquiring the lock
+func (lock *goetheLock) WaitUntilUnlocked(duration time.Duration) error {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	deadline := now().Add(duration)
+	for lock.holdingWriter >= 0 {
+		if !lock.timedWait(deadline) {
+			return ErrLockTimeout
+		}
+	}
+
+	return nil
+}
+
+// TryUpgradeReadToWriteLock attempts to get the write lock while holding
+// a read lock, giving up if the other readers do not leave in time
+func (lock *goetheLock) TryUpgradeReadToWriteLock(duration time.Duration) (bool, error) {
+	tid := lock.parent.GetThreadID()
+	if tid < 0 {
+		return false, ErrNotGoetheThread
+	}
+
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	if lock.getMyReadCount(tid) == 0 {
+		return false, ErrReadLockNotHeld
+	}
+
+	if lock.holdingWriter == tid {
+		if lock.options.NonReentrant {
+			return false, ErrAlreadyHeld
+		}
+
+		if lock.atDepthLimit(lock.writerCount) {
+			return false, ErrRecursionLimitExceeded
+		}
+
+		// counting
+		lock.writerCount++
+		return true, nil
+	}
+
+	if lock.upgrader >= 0 {
+		// Two upgraders would wait on each other forever
+		return false, nil
+	}
+
+	lock.upgrader = tid
+	lock.writersWaiting++
+	lock.startWaiting(tid, "upgrade")
+	defer func() {
+		lock.upgrader = -2
+		lock.writersWaiting--
+		lock.stopWaiting(tid)
 
+		// Readers held back by this upgrader may now proceed
 		lock.cond.Broadcast()
+	}()
+
+	deadline := now().Add(duration)
+	for lock.holdingWriter >= 0 || lock.getAllOtherReadCount(tid) > 0 {
+		err := lock.waitUntil(tid, deadline)
+		if err == ErrLockTimeout {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	lock.holdingWriter = tid
+	lock.holdStarted(tid, true)
+	lock.writerCount = 1
+	lock.recordStack(tid)
+
+	return true, nil
+}
+
+// ReadThenMaybeWrite runs read under the read lock and, if it returns
+// true, runs write under the write lock
+func (lock *goetheLock) ReadThenMaybeWrite(read func() bool, write func()) error {
+	done, err := lock.readThenUpgradedWrite(read, write)
+	if err != nil || done {
+		return err
+	}
+
+	// Another thread was also reading, so things may change between
+	// giving up the read lock and getting the write lock
+	err = lock.WriteLock()
+	if err != nil {
+		return err
+	}
+	defer lock.WriteUnlock()
+
+	if read() {
+		write()
+	}
+
+	return nil
+}
+
+// readThenUpgradedWrite runs read under the read lock and, if it returns
+// true and the lock can be upgraded without waiting, runs write.  Returns
+// false if write is needed but the lock could not be upgraded
+func (lock *goetheLock) readThenUpgradedWrite(read func() bool, write func()) (bool, error) {
+	err := lock.ReadLock()
+	if err != nil {
+		return false, err
+	}
+	defer lock.ReadUnlock()
+
+	if !read() {
+		return true, nil
+	}
+
+	upgraded, err := lock.TryUpgradeReadToWriteLock(0)
+	if err != nil || !upgraded {
+		return false, err
+	}
+	defer lock.WriteUnlock()
+
+	write()
+
+	return true, nil
+}
+
+// ReadLockYieldPoint lets waiting writers go ahead of a reader
+// that holds the read lock, returning true if it did
+func (lock *goetheLock) ReadLockYieldPoint() bool {
+	tid := lock.parent.GetThreadID()
+	if tid < 0 {
+		return false
+	}
+
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	count := lock.getMyReadCount(tid)
+	if count == 0 || lock.writersWaiting == 0 || lock.holdingWriter == tid || lock.upgrader == tid {
+		return false
+	}
+
+	delete(lock.readerCounts, tid)
+	lock.holdEnded(tid, false)
+	lock.forgetStack(tid)
+	lock.updateHeld()
+	lock.cond.Broadcast()
+
+	lock.startWaiting(tid, "read")
+	if lock.options.Fair {
+		for lock.waitFair(tid, 0, false, time.Time{}) != nil {
+			// Interrupted, but the thread must get back the read lock it had
+		}
+	} else {
+		for lock.holdingWriter >= 0 || lock.writersWaiting > 0 {
+			lock.contendedWait(tid)
+			lock.cond.Wait()
+		}
+	}
+	lock.stopWaiting(tid)
+
+	lock.readerCounts[tid] = count
+	lock.holdStarted(tid, false)
+	lock.updateHeld()
+	lock.recordStack(tid)
+
+	return true
+}
+
+// WriteThenRead runs mutate under the write lock and then
+// use under the read lock, downgrading in between
+func (lock *goetheLock) WriteThenRead(mutate func(), use func()) error {
+	err := lock.mutateThenDowngrade(mutate)
+	if err != nil {
+		return err
 	}
+	defer lock.ReadUnlock()
+
+	use()
 
 	return nil
 }
+
+// mutateThenDowngrade runs mutate under the write lock and leaves the
+// caller holding a read lock instead.  A writer may take a read lock,
+// so no other writer can get in before the write lock is released
+func (lock *goetheLock) mutateThenDowngrade(mutate func()) error {
+	err := lock.WriteLock()
+	if err != nil {
+		return err
+	}
+	defer lock.WriteUnlock()
+
+	mutate()
+
+	return lock.ReadLock()
+}
+
+// timedWait waits on the condition until it is signalled or the
+// deadline has passed.  Returns false if the deadline has passed.
+// Must have mutex held
+func (lock *goetheLock) timedWait(deadline time.Time) bool {
+	remaining := deadline.Sub(now())
+	if remaining <= 0 {
+		return false
+	}
+
+	timer := afterFunc(remaining, func() {
+		lock.goMux.Lock()
+		defer lock.goMux.Unlock()
+
+		lock.cond.Broadcast()
+	})
+
+	lock.cond.Wait()
+
+	timer.Stop()
+
+	return true
+}
+
+// updateHeld tells the parent when this lock goes from being
+// free to being held or back.  Must have mutex held
+func (lock *goetheLock) updateHeld() {
+	held := lock.holdingWriter >= 0 || len(lock.readerCounts) > 0
+	if held == lock.held {
+		return
+	}
+
+	lock.held = held
+	lock.parent.lockHeldChanged(lock, held)
+}
+
+// recordStack remembers where the thread took this lock when in
+// debug mode, and checks the order the thread took its locks in when
+// lock order checking is on.  Must have mutex held
+func (lock *goetheLock) recordStack(tid int64) {
+	if lock.parent.isDebugMode() {
+		lock.stacks[tid] = string(debug.Stack())
+	}
+
+	lock.parent.lockTaken(lock, tid, true)
+}
+
+// forgetStack is called when the thread no longer holds this lock
+// at all.  Must have mutex held
+func (lock *goetheLock) forgetStack(tid int64) {
+	if lock.holdingWriter != tid && lock.readerCounts[tid] == 0 {
+		delete(lock.stacks, tid)
+		lock.parent.lockReleased(lock, tid)
+	}
+}
+
+// describe returns the id of the lock along with its name if it has one
+func (lock *goetheLock) describe() string {
+	if lock.options.Name == "" {
+		return fmt.Sprintf("lock %d", lock.id)
+	}
+
+	return fmt.Sprintf("lock %d (%s)", lock.id, lock.options.Name)
+}
+
+// GetLockState returns the holders and waiters of this lock
+func (lock *goetheLock) GetLockState() LockState {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	return lock.getState()
+}
+
+// getState returns the holders and waiters of this lock.
+// Must have mutex held
+func (lock *goetheLock) getState() LockState {
+	retVal := LockState{
+		ReadHolders: make(map[int64]int32, len(lock.readerCounts)),
+		Waiters:     make([]LockWaiter, 0, len(lock.waiting)),
+	}
+
+	if lock.holdingWriter >= 0 {
+		retVal.WriteOwner = lock.holdingWriter
+		retVal.WriteCount = lock.writerCount
+	}
+
+	for tid, count := range lock.readerCounts {
+		retVal.ReadHolders[tid] = count
+	}
+
+	// Threads in line for a fair lock in the order they
+	// will get it, then the rest in the order they came
+	inLine := make(map[int64]bool, len(lock.queue))
+	for _, waiter := range lock.queue {
+		inLine[waiter.tid] = true
+		retVal.Waiters = append(retVal.Waiters, LockWaiter{
+			ThreadID: waiter.tid,
+			Mode:     lock.waiting[waiter.tid],
+		})
+	}
+
+	others := make([]int64, 0, len(lock.waiting))
+	for tid := range lock.waiting {
+		if !inLine[tid] {
+			others = append(others, tid)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return lock.waitTickets[others[i]] < lock.waitTickets[others[j]]
+	})
+
+	for _, tid := range others {
+		retVal.Waiters = append(retVal.Waiters, LockWaiter{
+			ThreadID: tid,
+			Mode:     lock.waiting[tid],
+		})
+	}
+
+	return retVal
+}
+
+// dump describes the holders and waiters of this lock for DumpLocks
+func (lock *goetheLock) dump() string {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	var retVal strings.Builder
+
+	fmt.Fprintf(&retVal, "%s:\n", lock.describe())
+
+	state := lock.getState()
+
+	if state.WriteCount > 0 {
+		fmt.Fprintf(&retVal, "  write held by %s (count %d)\n",
+			lock.parent.describeThread(state.WriteOwner), state.WriteCount)
+	}
+
+	for _, tid := range sortedThreadIDs(state.ReadHolders) {
+		fmt.Fprintf(&retVal, "  read held by %s (count %d)\n",
+			lock.parent.describeThread(tid), state.ReadHolders[tid])
+	}
+
+	for _, waiter := range state.Waiters {
+		fmt.Fprintf(&retVal, "  %s waiting for %s\n", lock.parent.describeThread(waiter.ThreadID), waiter.Mode)
+	}
+
+	holders := make(map[int64]int32)
+	for tid := range lock.stacks {
+		holders[tid] = 0
+	}
+	for _, tid := range sortedThreadIDs(holders) {
+		fmt.Fprintf(&retVal, "  %s took the lock at:\n%s\n", lock.parent.describeThread(tid), lock.stacks[tid])
+	}
+
+	return retVal.String()
+}
+
+// sortedThreadIDs returns the thread ids of the map in order
+func sortedThreadIDs(byThread map[int64]int32) []int64 {
+	retVal := make([]int64, 0, len(byThread))
+	for tid := range byThread {
+		retVal = append(retVal, tid)
+	}
+
+	sort.Slice(retVal, func(i, j int) bool {
+		return retVal[i] < retVal[j]
+	})
+
+	return retVal
+}
+
+// describeHolders returns a description of every thread holding this lock
+func (lock *goetheLock) describeHolders() []string {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	retVal := make([]string, 0)
+
+	if lock.holdingWriter >= 0 {
+		retVal = append(retVal, fmt.Sprintf("thread %d holds write lock %d (count %d)",
+			lock.holdingWriter, lock.id, lock.writerCount))
+	}
+
+	for tid, count := range lock.readerCounts {
+		retVal = append(retVal, fmt.Sprintf("thread %d holds read lock %d (count %d)",
+			tid, lock.id, count))
+	}
+
+	return retVal
+}
@@ -0,0 +1,215 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import "fmt"
+
+// lockHandoff is a write lock given to a queued function with
+// FunctionQueue.EnqueueWithWriteLock
+type lockHandoff struct {
+	lock *goetheLock
+
+	// owner is the thread holding the lock for the function, first
+	// the thread that enqueued it and then the one that dequeued it
+	owner int64
+
+	// claimed is true once the thread that dequeued the function has it
+	claimed bool
+}
+
+// EnqueueWithWriteLock queues a function that runs holding the write
+// lock held by the calling thread, which can no longer use it
+func (fq *FunctionQueueImpl) EnqueueWithWriteLock(lock Lock, userCall interface{}, args ...interface{}) error {
+	goLock, ok := lock.(*goetheLock)
+	if !ok {
+		return fmt.Errorf("only a lock made by goethe can be handed to a function, not a %T", lock)
+	}
+
+	tid := goLock.parent.GetThreadID()
+	if tid < 0 {
+		return ErrNotGoetheThread
+	}
+
+	descriptor := &FunctionDescriptor{
+		UserCall: userCall,
+		handoff: &lockHandoff{
+			lock:  goLock,
+			owner: tid,
+		},
+	}
+
+	if err := goLock.startHandoff(descriptor.handoff); err != nil {
+		return err
+	}
+
+	added, err := fq.enqueueIf(descriptor, args, nil)
+	if !added {
+		// The caller keeps the lock
+		goLock.cancelHandoff(descriptor.handoff)
+	}
+
+	return err
+}
+
+// claimWriteLock gives the write lock handed to the function to the
+// goethe thread that dequeued it
+func claimWriteLock(descriptor *FunctionDescriptor) {
+	if descriptor.handoff == nil {
+		return
+	}
+
+	lock := descriptor.handoff.lock
+
+	tid := lock.parent.GetThreadID()
+	if tid < 0 {
+		return
+	}
+
+	lock.claimHandoff(descriptor.handoff, tid)
+}
+
+// releaseWriteLock unlocks the write lock handed to the function once
+// it is finished, if the function still has it
+func releaseWriteLock(descriptor *FunctionDescriptor) {
+	if descriptor.handoff == nil {
+		return
+	}
+
+	descriptor.handoff.lock.endHandoff(descriptor.handoff)
+	descriptor.handoff = nil
+}
+
+// handedOff returns true if the thread gave its write lock to a
+// queued function that has not gotten it yet.  Must have mutex held
+func (lock *goetheLock) handedOff(tid int64) bool {
+	return lock.handoff != nil && lock.holdingWriter == tid
+}
+
+// startHandoff checks that the calling thread can give its write lock
+// to a queued function and marks the lock as given
+func (lock *goetheLock) startHandoff(handoff *lockHandoff) error {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	tid := handoff.owner
+
+	if lock.handedOff(tid) {
+		return ErrLockHandedOff
+	}
+
+	if lock.holdingWriter != tid {
+		return ErrWriteLockNotHeld
+	}
+
+	if lock.getMyReadCount(tid) != 0 {
+		return ErrReadLockHeld
+	}
+
+	if lock.writerCount != 1 {
+		return fmt.Errorf("the write lock is held %d times, only a lock held once can be handed to a function",
+			lock.writerCount)
+	}
+
+	lock.handoff = handoff
+
+	return nil
+}
+
+// cancelHandoff gives the write lock back to the thread
+// that tried to give it to a function
+func (lock *goetheLock) cancelHandoff(handoff *lockHandoff) {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	if lock.handoff == handoff {
+		lock.handoff = nil
+	}
+}
+
+// claimHandoff makes the thread that dequeued the function the
+// holder of the write lock handed to it
+func (lock *goetheLock) claimHandoff(handoff *lockHandoff, tid int64) {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	if lock.handoff != handoff {
+		return
+	}
+
+	lock.handoff = nil
+	handoff.claimed = true
+
+	if handoff.owner == tid {
+		return
+	}
+
+	lock.forgetStack(handoff.owner)
+	lock.holdEnded(handoff.owner, true)
+
+	lock.holdingWriter = tid
+	handoff.owner = tid
+
+	lock.holdStarted(tid, true)
+	lock.recordStack(tid)
+
+	lock.cond.Broadcast()
+}
+
+// endHandoff unlocks the write lock once for the finished
+// function if the thread holding it for the function still does
+func (lock *goetheLock) endHandoff(handoff *lockHandoff) {
+	lock.goMux.Lock()
+	defer lock.goMux.Unlock()
+
+	if !handoff.claimed {
+		if lock.handoff != handoff {
+			return
+		}
+
+		lock.handoff = nil
+	}
+
+	if lock.holdingWriter != handoff.owner {
+		return
+	}
+
+	lock.releaseWriter(handoff.owner)
+}
@@ -0,0 +1,139 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// holdSamples is how many of the most recent holds
+// the percentiles of HoldTimeStats are taken from
+const holdSamples = 1024
+
+// holdTimes keeps how long a lock was held, for one of
+// read or write, see LockOptions.RecordHoldTimes
+type holdTimes struct {
+	count  uint64
+	max    time.Duration
+	recent [holdSamples]time.Duration
+}
+
+func (holds *holdTimes) add(held time.Duration) {
+	holds.recent[holds.count%holdSamples] = held
+	holds.count++
+
+	if held > holds.max {
+		holds.max = held
+	}
+}
+
+func (holds *holdTimes) stats() HoldTimeStats {
+	if holds == nil || holds.count == 0 {
+		return HoldTimeStats{}
+	}
+
+	samples := holds.count
+	if samples > holdSamples {
+		samples = holdSamples
+	}
+
+	sorted := make([]time.Duration, samples)
+	copy(sorted, holds.recent[:samples])
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	return HoldTimeStats{
+		Count: holds.count,
+		P50:   percentile(sorted, 0.50),
+		P99:   percentile(sorted, 0.99),
+		Max:   holds.max,
+	}
+}
+
+// percentile returns the smallest of the sorted durations
+// that at least the given fraction of them are no more than
+func percentile(sorted []time.Duration, fraction float64) time.Duration {
+	index := int(math.Ceil(fraction*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+
+	return sorted[index]
+}
+
+// holdStarted notes that the thread now holds the lock for read or
+// write.  Only called when the thread did not hold it that way
+// already.  Must have mutex held
+func (lock *goetheLock) holdStarted(tid int64, write bool) {
+	if !lock.options.RecordHoldTimes {
+		return
+	}
+
+	if write {
+		lock.writeHoldStart = now()
+	} else {
+		lock.readHoldStarts[tid] = now()
+	}
+}
+
+// holdEnded records how long the thread held the lock for read or
+// write, now that it has given it up.  Must have mutex held
+func (lock *goetheLock) holdEnded(tid int64, write bool) {
+	if !lock.options.RecordHoldTimes {
+		return
+	}
+
+	if write {
+		lock.writeHolds.add(since(lock.writeHoldStart))
+		return
+	}
+
+	started, found := lock.readHoldStarts[tid]
+	if !found {
+		return
+	}
+
+	delete(lock.readHoldStarts, tid)
+	lock.readHolds.add(since(started))
+}
@@ -0,0 +1,212 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// lockPair is two locks by id, the first taken before the second
+type lockPair struct {
+	first  uint64
+	second uint64
+}
+
+// lockOrder is the first thread seen taking the locks of a pair
+// in that order, and where it took the second one
+type lockOrder struct {
+	tid        int64
+	firstName  string
+	secondName string
+	stack      string
+}
+
+// SetLockOrderHandler turns lock order checking on, or off if the handler is nil
+func (goth *StandardThreadUtilities) SetLockOrderHandler(handler func(violation LockOrderViolation)) {
+	goth.locks.lockMux.Lock()
+	defer goth.locks.lockMux.Unlock()
+
+	goth.locks.orderHandler = handler
+
+	if handler == nil {
+		atomic.StoreInt32(&goth.locks.checking, 0)
+
+		goth.locks.heldBy = nil
+		goth.locks.orders = nil
+		goth.locks.reported = nil
+
+		return
+	}
+
+	if goth.locks.heldBy == nil {
+		goth.locks.heldBy = make(map[int64]map[*goetheLock]bool)
+		goth.locks.orders = make(map[lockPair]lockOrder)
+		goth.locks.reported = make(map[lockPair]bool)
+	}
+
+	atomic.StoreInt32(&goth.locks.checking, 1)
+}
+
+// lockTaken records that the thread now holds the lock.  If check is true
+// the thread chose to take it, so it is taken after every other lock the
+// thread holds, which is checked against the orders seen before.  Called
+// with the mutex of the lock held
+func (goth *StandardThreadUtilities) lockTaken(lock *goetheLock, tid int64, check bool) {
+	if lock.internal || atomic.LoadInt32(&goth.locks.checking) == 0 {
+		return
+	}
+
+	violations, handler := goth.recordTaken(lock, tid, check)
+
+	// The handler runs on a goethe thread of its own so
+	// it may take goethe locks, even these ones
+	for _, violation := range violations {
+		violation := violation
+
+		goth.goClosure(func() {
+			handler(violation)
+		})
+	}
+}
+
+// recordTaken is lockTaken with lockMux held, returning what must
+// be reported and the handler to report it to
+func (goth *StandardThreadUtilities) recordTaken(lock *goetheLock, tid int64,
+	check bool) ([]LockOrderViolation, func(LockOrderViolation)) {
+	goth.locks.lockMux.Lock()
+	defer goth.locks.lockMux.Unlock()
+
+	if goth.locks.heldBy == nil {
+		// Turned off in the meantime
+		return nil, nil
+	}
+
+	held, found := goth.locks.heldBy[tid]
+	if !found {
+		held = make(map[*goetheLock]bool)
+		goth.locks.heldBy[tid] = held
+	}
+
+	if held[lock] {
+		// An upgrade or a read lock taken along with the write lock
+		return nil, nil
+	}
+
+	var violations []LockOrderViolation
+
+	if check {
+		var stack string
+
+		for earlier := range held {
+			pair := lockPair{first: earlier.id, second: lock.id}
+			if _, seen := goth.locks.orders[pair]; seen {
+				continue
+			}
+
+			if stack == "" {
+				stack = string(debug.Stack())
+			}
+
+			goth.locks.orders[pair] = lockOrder{
+				tid:        tid,
+				firstName:  earlier.describe(),
+				secondName: lock.describe(),
+				stack:      stack,
+			}
+
+			violation, found := goth.checkOrder(pair)
+			if found {
+				violations = append(violations, violation)
+			}
+		}
+	}
+
+	held[lock] = true
+
+	return violations, goth.locks.orderHandler
+}
+
+// checkOrder returns a violation if the locks of the pair were also
+// taken the other way around and that was not reported before.  Must
+// have lockMux held
+func (goth *StandardThreadUtilities) checkOrder(pair lockPair) (LockOrderViolation, bool) {
+	reversed := lockPair{first: pair.second, second: pair.first}
+
+	other, found := goth.locks.orders[reversed]
+	if !found || goth.locks.reported[pair] || goth.locks.reported[reversed] {
+		return LockOrderViolation{}, false
+	}
+
+	goth.locks.reported[pair] = true
+
+	this := goth.locks.orders[pair]
+
+	return LockOrderViolation{
+		FirstLock:       this.firstName,
+		SecondLock:      this.secondName,
+		ThreadID:        this.tid,
+		Stack:           this.stack,
+		EarlierThreadID: other.tid,
+		EarlierStack:    other.stack,
+	}, true
+}
+
+// lockReleased records that the thread no longer holds the
+// lock.  Called with the mutex of the lock held
+func (goth *StandardThreadUtilities) lockReleased(lock *goetheLock, tid int64) {
+	if lock.internal || atomic.LoadInt32(&goth.locks.checking) == 0 {
+		return
+	}
+
+	goth.locks.lockMux.Lock()
+	defer goth.locks.lockMux.Unlock()
+
+	held := goth.locks.heldBy[tid]
+	if held == nil {
+		return
+	}
+
+	delete(held, lock)
+	if len(held) == 0 {
+		delete(goth.locks.heldBy, tid)
+	}
+}
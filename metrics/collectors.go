@@ -0,0 +1,167 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package metrics
+
+import (
+	"github.com/jwells131313/goethe"
+)
+
+type poolCollector struct {
+	pool goethe.Pool
+}
+
+// NewPoolCollector returns a Collector of the thread counts, queue depth,
+// queue latency and counters of the pool, labeled with the name of the pool
+func NewPoolCollector(pool goethe.Pool) Collector {
+	return &poolCollector{
+		pool: pool,
+	}
+}
+
+func (collector *poolCollector) Collect() []Metric {
+	pool := collector.pool
+
+	labels := map[string]string{
+		"pool": pool.GetName(),
+	}
+
+	stats := pool.GetStats()
+	health := pool.GetHealth()
+
+	circuitBreakerOpen := 0.0
+	if stats.CircuitBreakerOpen {
+		circuitBreakerOpen = 1.0
+	}
+
+	return []Metric{
+		{"goethe_pool_threads", "Number of threads in the pool",
+			Gauge, labels, float64(stats.CurrentThreads)},
+		{"goethe_pool_idle_threads", "Number of threads in the pool waiting for a function",
+			Gauge, labels, float64(pool.GetIdleThreadCount())},
+		{"goethe_pool_busy_threads", "Number of threads in the pool running a function",
+			Gauge, labels, float64(pool.GetBusyThreadCount())},
+		{"goethe_pool_max_threads", "Maximum number of threads in the pool",
+			Gauge, labels, float64(pool.GetMaxThreads())},
+		{"goethe_pool_queue_depth", "Number of functions waiting on the function queues of the pool",
+			Gauge, labels, float64(stats.QueueSize)},
+		{"goethe_pool_queue_latency_seconds", "How long the oldest function on the function queues has waited",
+			Gauge, labels, health.QueueLatency.Seconds()},
+		{"goethe_pool_saturation", "Fraction of the maximum number of threads running functions",
+			Gauge, labels, health.Saturation},
+		{"goethe_pool_circuit_breaker_open", "1 if the circuit breaker of the pool is open",
+			Gauge, labels, circuitBreakerOpen},
+		{"goethe_pool_saturation_events_total", "Times the pool wanted more threads than its maximum",
+			Counter, labels, float64(stats.SaturationEvents)},
+		{"goethe_pool_task_failures_total", "Functions run by the pool that returned an error",
+			Counter, labels, float64(stats.TaskFailures)},
+		{"goethe_pool_threads_recycled_for_tasks_total", "Threads that left the pool after running their most functions",
+			Counter, labels, float64(stats.ThreadsRecycledForTasks)},
+		{"goethe_pool_threads_recycled_for_age_total", "Threads that left the pool for being too old",
+			Counter, labels, float64(stats.ThreadsRecycledForAge)},
+		{"goethe_pool_errors_dropped_total", "Errors the error queue of the pool would not take",
+			Counter, labels, float64(stats.ErrorsDropped)},
+	}
+}
+
+type lockCollector struct {
+	name string
+	lock goethe.Lock
+}
+
+// NewLockCollector returns a Collector of the contention and hold
+// times of the lock, labeled with the given name.  Hold times are
+// only recorded for locks with LockOptions.RecordHoldTimes set
+func NewLockCollector(name string, lock goethe.Lock) Collector {
+	return &lockCollector{
+		name: name,
+		lock: lock,
+	}
+}
+
+func (collector *lockCollector) Collect() []Metric {
+	stats := collector.lock.GetStats()
+
+	labels := map[string]string{"lock": collector.name}
+
+	retVal := []Metric{
+		{"goethe_lock_priority_reorders_total",
+			"Times a fair lock was granted ahead of a lower priority thread that asked earlier",
+			Counter, labels, float64(stats.PriorityReorders)},
+		{"goethe_lock_contended_total", "Times a thread had to wait for the lock",
+			Counter, labels, float64(stats.Contended)},
+		{"goethe_lock_wait_seconds_total", "Time threads spent waiting for the lock",
+			Counter, labels, stats.TotalWait.Seconds()},
+		{"goethe_lock_wait_max_seconds", "Longest wait for the lock",
+			Gauge, labels, stats.MaxWait.Seconds()},
+	}
+
+	retVal = append(retVal, holdTimeMetrics(collector.name, "write", stats.WriteHoldTimes)...)
+	retVal = append(retVal, holdTimeMetrics(collector.name, "read", stats.ReadHoldTimes)...)
+
+	return retVal
+}
+
+// holdTimeMetrics returns the metrics of the hold times of one mode of a lock
+func holdTimeMetrics(name string, mode string, holds goethe.HoldTimeStats) []Metric {
+	labels := map[string]string{
+		"lock": name,
+		"mode": mode,
+	}
+
+	quantile := func(value string) map[string]string {
+		return map[string]string{
+			"lock":     name,
+			"mode":     mode,
+			"quantile": value,
+		}
+	}
+
+	return []Metric{
+		{"goethe_lock_holds_total", "Holds of the lock that have ended",
+			Counter, labels, float64(holds.Count)},
+		{"goethe_lock_hold_seconds", "Hold times of the most recent holds of the lock",
+			Gauge, quantile("0.5"), holds.P50.Seconds()},
+		{"goethe_lock_hold_seconds", "Hold times of the most recent holds of the lock",
+			Gauge, quantile("0.99"), holds.P99.Seconds()},
+		{"goethe_lock_hold_max_seconds", "Longest hold of the lock",
+			Gauge, labels, holds.Max.Seconds()},
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+// Package metrics exports the statistics of goethe pools and locks in the
+// Prometheus text format, so they can be scraped without the goethe package
+// depending on a Prometheus client library.  A Registry is an http.Handler
+// that can be served as the /metrics endpoint directly, and each Collector
+// is small enough to wrap in a prometheus.Collector where that is wanted
+package metrics
+
+import (
+	"fmt"
+	"github.com/jwells131313/goethe"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricType is how a Prometheus scraper treats a metric
+type MetricType string
+
+const (
+	// Gauge is a value that can go up and down
+	Gauge MetricType = "gauge"
+
+	// Counter is a value that only goes up, until its counters are reset
+	Counter MetricType = "counter"
+)
+
+// Metric is one sample of a pool or a lock
+type Metric struct {
+	// Name is the Prometheus name of the metric, such as goethe_pool_threads
+	Name string
+
+	// Help describes the metric
+	Help string
+
+	// Type is whether the metric is a gauge or a counter
+	Type MetricType
+
+	// Labels tell apart the samples of the metric, such as the pool they came from
+	Labels map[string]string
+
+	// Value is the value of the sample
+	Value float64
+}
+
+// Collector gathers the current metrics of something
+type Collector interface {
+	// Collect returns a sample of each metric as it is now
+	Collect() []Metric
+}
+
+// Registry holds the collectors whose metrics are exported
+type Registry struct {
+	mux        sync.Mutex
+	collectors map[string]Collector
+}
+
+// NewRegistry returns a Registry with no collectors
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]Collector),
+	}
+}
+
+// Register adds a collector under the given name.  Returns an
+// error if a collector is already registered with that name
+func (registry *Registry) Register(name string, collector Collector) error {
+	if collector == nil {
+		return fmt.Errorf("collector %s may not be nil", name)
+	}
+
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+
+	if _, found := registry.collectors[name]; found {
+		return fmt.Errorf("there is already a collector registered as %s", name)
+	}
+
+	registry.collectors[name] = collector
+
+	return nil
+}
+
+// RegisterPool adds a collector of the statistics of the pool,
+// registered under the name of the pool
+func (registry *Registry) RegisterPool(pool goethe.Pool) error {
+	if pool == nil {
+		return fmt.Errorf("pool may not be nil")
+	}
+
+	return registry.Register("pool:"+pool.GetName(), NewPoolCollector(pool))
+}
+
+// RegisterLock adds a collector of the statistics of the lock, registered
+// under the given name, which is also the lock label of its metrics
+func (registry *Registry) RegisterLock(name string, lock goethe.Lock) error {
+	if lock == nil {
+		return fmt.Errorf("lock %s may not be nil", name)
+	}
+
+	return registry.Register("lock:"+name, NewLockCollector(name, lock))
+}
+
+// UnregisterPool removes the collector of the pool with the given name
+func (registry *Registry) UnregisterPool(name string) {
+	registry.Unregister("pool:" + name)
+}
+
+// UnregisterLock removes the collector of the lock with the given name
+func (registry *Registry) UnregisterLock(name string) {
+	registry.Unregister("lock:" + name)
+}
+
+// Unregister removes the collector with the given name, if there is one
+func (registry *Registry) Unregister(name string) {
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+
+	delete(registry.collectors, name)
+}
+
+// Collect returns the metrics of every collector, sorted by name
+// so that the samples of each metric are together
+func (registry *Registry) Collect() []Metric {
+	registry.mux.Lock()
+	names := make([]string, 0, len(registry.collectors))
+	for name := range registry.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	collectors := make([]Collector, len(names))
+	for index, name := range names {
+		collectors[index] = registry.collectors[name]
+	}
+	registry.mux.Unlock()
+
+	retVal := make([]Metric, 0)
+	for _, collector := range collectors {
+		retVal = append(retVal, collector.Collect()...)
+	}
+
+	sort.SliceStable(retVal, func(i, j int) bool {
+		return retVal[i].Name < retVal[j].Name
+	})
+
+	return retVal
+}
+
+// WriteText writes the metrics of every collector in the
+// Prometheus text exposition format
+func (registry *Registry) WriteText(writer io.Writer) error {
+	var builder strings.Builder
+
+	lastName := ""
+	for _, metric := range registry.Collect() {
+		if metric.Name != lastName {
+			fmt.Fprintf(&builder, "# HELP %s %s\n", metric.Name, escapeHelp(metric.Help))
+			fmt.Fprintf(&builder, "# TYPE %s %s\n", metric.Name, metric.Type)
+			lastName = metric.Name
+		}
+
+		builder.WriteString(metric.Name)
+		writeLabels(&builder, metric.Labels)
+		builder.WriteByte(' ')
+		builder.WriteString(strconv.FormatFloat(metric.Value, 'g', -1, 64))
+		builder.WriteByte('\n')
+	}
+
+	_, err := io.WriteString(writer, builder.String())
+
+	return err
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (registry *Registry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	registry.WriteText(writer)
+}
+
+// writeLabels writes the labels in braces, sorted by name
+func writeLabels(builder *strings.Builder, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	builder.WriteByte('{')
+	for index, name := range names {
+		if index > 0 {
+			builder.WriteByte(',')
+		}
+
+		fmt.Fprintf(builder, "%s=\"%s\"", name, escapeLabel(labels[name]))
+	}
+	builder.WriteByte('}')
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,130 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// SetPanicHandler sets the function told of a panic in any goethe
+// thread that was not handled by the panic handler of a pool
+func (goth *StandardThreadUtilities) SetPanicHandler(handler func(info PanicInformation)) {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	goth.threads.panicHandler = handler
+}
+
+func (goth *StandardThreadUtilities) getPanicHandler() func(info PanicInformation) {
+	goth.threads.threadMux.Lock()
+	defer goth.threads.threadMux.Unlock()
+
+	return goth.threads.panicHandler
+}
+
+// newPanicInformation describes a panic of the calling thread, so
+// must be called from the function that recovered the panic
+func newPanicInformation(tid int64, value interface{}, poolName string) PanicInformation {
+	return PanicInformation{
+		ThreadID:   tid,
+		ThreadName: globalGoethe.getThreadName(tid),
+		PoolName:   poolName,
+		Value:      value,
+		Stack:      string(debug.Stack()),
+	}
+}
+
+// recoverThread is deferred by every goethe thread.  A panic is given
+// to the global panic handler, or if there is none the thread panics
+// again with the same value
+func recoverThread(tid int64) {
+	value := recover()
+	if value == nil {
+		return
+	}
+
+	handler := globalGoethe.getPanicHandler()
+	if handler == nil {
+		panic(value)
+	}
+
+	handler(newPanicInformation(tid, value, ""))
+}
+
+func (threadPool *threadPool) SetPanicHandler(handler func(info PanicInformation)) {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	threadPool.panicHandler = handler
+}
+
+// runTask runs a function of the pool on the thread.  A panic of the
+// function is given to the panic handler of the pool, or if there is
+// none to the global panic handler, and returned as an error wrapping
+// ErrTaskPanicked.  With neither handler the thread panics again
+func (threadPool *threadPool) runTask(tid int64, task func()) (err error) {
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+
+		threadPool.mux.Lock()
+		handler := threadPool.panicHandler
+		threadPool.mux.Unlock()
+
+		if handler == nil {
+			handler = globalGoethe.getPanicHandler()
+		}
+		if handler == nil {
+			panic(value)
+		}
+
+		handler(newPanicInformation(tid, value, threadPool.name))
+
+		err = fmt.Errorf("%v: %w", value, ErrTaskPanicked)
+	}()
+
+	task()
+
+	return nil
+}
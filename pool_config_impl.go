@@ -0,0 +1,231 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import "fmt"
+
+func (threadPool *threadPool) GetConfig() PoolConfig {
+	threadPool.swapMux.RLock()
+	defer threadPool.swapMux.RUnlock()
+
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	retVal := PoolConfig{
+		Name:               threadPool.name,
+		MinThreads:         threadPool.minThreads,
+		MaxThreads:         threadPool.maxThreads,
+		IdleDecay:          threadPool.idleDecay,
+		FunctionQueues:     make([]QueueConfig, len(threadPool.queues)),
+		Selector:           SelectorCustom,
+		MonitorInterval:    threadPool.monitorInterval,
+		BreakerThreshold:   threadPool.breakerThreshold,
+		BreakerWindow:      threadPool.breakerWindow,
+		MaxTasksPerThread:  threadPool.maxTasksPerThread,
+		MaxThreadLifetime:  threadPool.maxThreadLifetime,
+		DuplicateKeyPolicy: threadPool.keyPolicy,
+		CategoryLimits:     make(map[string]int),
+		MaxWeight:          threadPool.maxWeight,
+		HealthThresholds:   threadPool.healthThresholds,
+		OrderedRebalancing: threadPool.rebalance,
+	}
+
+	for index, queue := range threadPool.queues {
+		retVal.FunctionQueues[index] = describeFunctionQueue(queue)
+	}
+
+	switch selector := threadPool.selector.(type) {
+	case *strictPrioritySelector:
+		retVal.Selector = SelectorStrictPriority
+	case *weightedRoundRobinSelector:
+		retVal.Selector = SelectorWeightedRoundRobin
+		retVal.QueueWeights = selector.getWeights()
+	}
+
+	switch errorQueue := threadPool.errorQueue.(type) {
+	case nil:
+	case *BoundedErrorQueue:
+		retVal.ErrorQueue = QueueConfig{
+			Type:     QueueTypeBounded,
+			Capacity: errorQueue.capacity,
+		}
+	default:
+		retVal.ErrorQueue = QueueConfig{
+			Type: QueueTypeCustom,
+		}
+	}
+
+	for category, limit := range threadPool.categoryLimits {
+		retVal.CategoryLimits[category] = limit
+	}
+
+	return retVal
+}
+
+// describeFunctionQueue returns the kind and capacity of the queue
+func describeFunctionQueue(queue FunctionQueue) QueueConfig {
+	impl, ok := queue.(*FunctionQueueImpl)
+	if !ok {
+		return QueueConfig{
+			Type: QueueTypeCustom,
+		}
+	}
+
+	retVal := QueueConfig{
+		Type:     QueueTypeBounded,
+		Capacity: impl.GetCapacity(),
+	}
+
+	if _, ring := impl.queue.(*ringStore); ring {
+		retVal.Type = QueueTypeRingBuffer
+	}
+
+	return retVal
+}
+
+// getWeights returns a copy of the weights the selector was made with
+func (selector *weightedRoundRobinSelector) getWeights() []int {
+	selector.mux.Lock()
+	defer selector.mux.Unlock()
+
+	retVal := make([]int, len(selector.weights))
+	copy(retVal, selector.weights)
+
+	return retVal
+}
+
+// NewThreadPoolFromConfig creates a new pool as described by the config
+func (goth *StandardThreadUtilities) NewThreadPoolFromConfig(config PoolConfig) (Pool, error) {
+	functionQueues := make([]FunctionQueue, len(config.FunctionQueues))
+	for index, queueConfig := range config.FunctionQueues {
+		switch queueConfig.Type {
+		case QueueTypeBounded:
+			functionQueues[index] = NewBoundedFunctionQueue(queueConfig.Capacity)
+		case QueueTypeRingBuffer:
+			functionQueues[index] = NewRingBufferFunctionQueue(queueConfig.Capacity)
+		default:
+			return nil, fmt.Errorf("function queue at index %d of pool %s can not be made from type %q",
+				index, config.Name, queueConfig.Type)
+		}
+	}
+
+	var selector QueueSelector
+	switch config.Selector {
+	case SelectorStrictPriority, "":
+		selector = NewStrictPrioritySelector()
+	case SelectorWeightedRoundRobin:
+		var err error
+
+		selector, err = NewWeightedRoundRobinSelector(config.QueueWeights...)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("queue selector of pool %s can not be made from %q", config.Name, config.Selector)
+	}
+
+	var errorQueue ErrorQueue
+	switch config.ErrorQueue.Type {
+	case "":
+	case QueueTypeBounded:
+		errorQueue = NewBoundedErrorQueue(config.ErrorQueue.Capacity)
+	default:
+		return nil, fmt.Errorf("error queue of pool %s can not be made from type %q",
+			config.Name, config.ErrorQueue.Type)
+	}
+
+	retVal, err := goth.NewMultiQueuePool(config.Name, config.MinThreads, config.MaxThreads, config.IdleDecay,
+		selector, functionQueues, errorQueue)
+	if err != nil {
+		return retVal, err
+	}
+
+	err = applyConfig(retVal, config)
+	if err != nil {
+		// Not half made, so that the config can be fixed and given again
+		retVal.Close()
+
+		return nil, err
+	}
+
+	return retVal, nil
+}
+
+// applyConfig gives the settings of the config to the new pool
+func applyConfig(pool Pool, config PoolConfig) error {
+	if config.MonitorInterval != 0 {
+		err := pool.SetMonitorInterval(config.MonitorInterval)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := pool.SetCircuitBreaker(config.BreakerThreshold, config.BreakerWindow)
+	if err != nil {
+		return err
+	}
+
+	err = pool.SetThreadRecycling(config.MaxTasksPerThread, config.MaxThreadLifetime)
+	if err != nil {
+		return err
+	}
+
+	if config.DuplicateKeyPolicy != WaitForDuplicateKey && config.DuplicateKeyPolicy != DropDuplicateKey {
+		return fmt.Errorf("unknown duplicate key policy %d", config.DuplicateKeyPolicy)
+	}
+	pool.SetDuplicateKeyPolicy(config.DuplicateKeyPolicy)
+
+	for category, limit := range config.CategoryLimits {
+		err = pool.SetCategoryLimit(category, limit)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = pool.SetMaxWeight(config.MaxWeight)
+	if err != nil {
+		return err
+	}
+
+	pool.SetOrderedRebalancing(config.OrderedRebalancing)
+
+	return pool.SetHealthThresholds(config.HealthThresholds)
+}
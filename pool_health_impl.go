@@ -0,0 +1,200 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// taskOutcome is when a function of the pool finished and whether it
+// returned an error, kept for the error rate of GetHealth
+type taskOutcome struct {
+	finished time.Time
+	failed   bool
+}
+
+func (threadPool *threadPool) SetHealthThresholds(thresholds PoolHealthThresholds) error {
+	if thresholds.MaxErrorRate < 0 || thresholds.MaxErrorRate > 1 {
+		return fmt.Errorf("maximum error rate must be between zero and one, it is %v", thresholds.MaxErrorRate)
+	}
+	if thresholds.MaxErrorRate > 0 && thresholds.ErrorRateWindow <= 0 {
+		return fmt.Errorf("error rate window must be positive, it is %v", thresholds.ErrorRateWindow)
+	}
+	if thresholds.MinTasks < 0 {
+		return fmt.Errorf("minimum tasks less than zero %d", thresholds.MinTasks)
+	}
+	if thresholds.MaxQueueLatency < 0 {
+		return fmt.Errorf("maximum queue latency less than zero %v", thresholds.MaxQueueLatency)
+	}
+	if thresholds.MaxSaturation < 0 || thresholds.MaxSaturation > 1 {
+		return fmt.Errorf("maximum saturation must be between zero and one, it is %v", thresholds.MaxSaturation)
+	}
+
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	threadPool.healthThresholds = thresholds
+	threadPool.taskOutcomes = nil
+
+	return nil
+}
+
+func (threadPool *threadPool) GetHealth() PoolHealth {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	return threadPool.assessHealth()
+}
+
+func (threadPool *threadPool) SubmitOrFail(userCall interface{}, args ...interface{}) (Future, error) {
+	threadPool.mux.Lock()
+	health := threadPool.assessHealth()
+	threadPool.mux.Unlock()
+
+	if !health.Healthy {
+		return nil, fmt.Errorf("pool %s %s: %w", threadPool.name,
+			strings.Join(health.Reasons, ", "), ErrPoolUnhealthy)
+	}
+
+	return threadPool.Submit(userCall, args...)
+}
+
+// assessHealth compares the pool as it is now with the
+// health thresholds.  Must have mutex held
+func (threadPool *threadPool) assessHealth() PoolHealth {
+	thresholds := threadPool.healthThresholds
+
+	retVal := PoolHealth{
+		Healthy:      true,
+		QueueLatency: threadPool.oldestWait(),
+	}
+
+	if threadPool.maxThreads > 0 {
+		retVal.Saturation = float64(len(threadPool.runningTasks)) / float64(threadPool.maxThreads)
+	}
+
+	if thresholds.MaxErrorRate > 0 {
+		threadPool.pruneOutcomes()
+
+		failed := 0
+		for _, outcome := range threadPool.taskOutcomes {
+			if outcome.failed {
+				failed++
+			}
+		}
+
+		retVal.RecentTasks = len(threadPool.taskOutcomes)
+		if retVal.RecentTasks > 0 {
+			retVal.ErrorRate = float64(failed) / float64(retVal.RecentTasks)
+		}
+
+		if retVal.RecentTasks >= thresholds.MinTasks && retVal.ErrorRate > thresholds.MaxErrorRate {
+			retVal.Reasons = append(retVal.Reasons, fmt.Sprintf("error rate %.2f is over %.2f",
+				retVal.ErrorRate, thresholds.MaxErrorRate))
+		}
+	}
+
+	if thresholds.MaxQueueLatency > 0 && retVal.QueueLatency > thresholds.MaxQueueLatency {
+		retVal.Reasons = append(retVal.Reasons, fmt.Sprintf("queue latency %v is over %v",
+			retVal.QueueLatency, thresholds.MaxQueueLatency))
+	}
+
+	if thresholds.MaxSaturation > 0 && retVal.Saturation >= thresholds.MaxSaturation {
+		retVal.Reasons = append(retVal.Reasons, fmt.Sprintf("saturation %.2f is at least %.2f",
+			retVal.Saturation, thresholds.MaxSaturation))
+	}
+
+	retVal.Healthy = len(retVal.Reasons) == 0
+
+	return retVal
+}
+
+// oldestWait returns how long the function that has been waiting
+// the longest on the queues of the pool has waited.  Must have mutex held
+func (threadPool *threadPool) oldestWait() time.Duration {
+	var oldest time.Time
+	for _, queue := range threadPool.queues {
+		queue.ForEach(func(descriptor FunctionDescriptor) bool {
+			if oldest.IsZero() || descriptor.EnqueueTime.Before(oldest) {
+				oldest = descriptor.EnqueueTime
+			}
+
+			return true
+		})
+	}
+
+	if oldest.IsZero() {
+		return 0
+	}
+
+	return since(oldest)
+}
+
+// recordOutcome remembers that a function finished for the error rate
+// if the pool has an error rate threshold
+func (threadPool *threadPool) recordOutcome(failed bool) {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	if threadPool.healthThresholds.MaxErrorRate <= 0 {
+		return
+	}
+
+	threadPool.taskOutcomes = append(threadPool.taskOutcomes, taskOutcome{
+		finished: now(),
+		failed:   failed,
+	})
+	threadPool.pruneOutcomes()
+}
+
+// pruneOutcomes forgets functions that finished before
+// the error rate window.  Must have mutex held
+func (threadPool *threadPool) pruneOutcomes() {
+	windowStart := now().Add(-threadPool.healthThresholds.ErrorRateWindow)
+
+	keep := 0
+	for keep < len(threadPool.taskOutcomes) && threadPool.taskOutcomes[keep].finished.Before(windowStart) {
+		keep++
+	}
+	threadPool.taskOutcomes = threadPool.taskOutcomes[keep:]
+}
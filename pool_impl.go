@@ -41,8 +41,11 @@
 package goethe
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"runtime"
+	"sort"
 	"sync"
 	"time"
 )
@@ -54,15 +57,96 @@ type threadPool struct {
 	minThreads, maxThreads int32
 	idleDecay              time.Duration
 	functionalQueue        FunctionQueue
+	queues                 []FunctionQueue
+	selector               QueueSelector
 	errorQueue             ErrorQueue
 	parent                 *StandardThreadUtilities
 
-	currentThreads int32
-	threadState    map[int64]int
-	closeChannel   chan bool
-	decayChannel   chan bool
-	changeChannel  chan int
-	decayTimer     Timer
+	currentThreads   int32
+	saturationEvents int64
+	taskFailures     int64
+	threadState      map[int64]int
+	retiring         map[int64]bool
+	closeChannel     chan bool
+	doneChannel      chan struct{}
+	doneClosed       bool
+	decayChannel     chan bool
+	changeChannel    chan int
+	decayTimer       Timer
+	monitorInterval  time.Duration
+
+	// the function each thread is running, see GetRunningTasks
+	runningTasks map[int64]RunningTask
+
+	// errors the error queue would not take, see SetDroppedErrorHandler
+	errorsDropped       int64
+	droppedErrorHandler func(info ErrorInformation)
+
+	// when threads are recycled and how many were, see SetThreadRecycling
+	maxTasksPerThread int64
+	maxThreadLifetime time.Duration
+	recycledForTasks  int64
+	recycledForAge    int64
+
+	// told of every change to currentThreads, see SetSizeChangeListener.
+	// Changes wait in sizeChanges until delivered in order under notifyMux
+	sizeListener func(oldCount, newCount int32, reason string)
+	sizeChanges  []sizeChange
+	notifyMux    sync.Mutex
+
+	// run around each function, see SetTaskInterceptor.  Never
+	// changed in place so threads can use it outside of mux
+	interceptors []func(next func()) func()
+
+	// told of panics of the functions of the pool, see SetPanicHandler
+	panicHandler func(info PanicInformation)
+
+	// recent failure times for the circuit breaker
+	breakerThreshold int
+	breakerWindow    time.Duration
+	breakerOpen      bool
+	failureTimes     []time.Time
+
+	// when SubmitOrFail refuses functions and the recent functions
+	// the error rate is taken from, see SetHealthThresholds
+	healthThresholds PoolHealthThresholds
+	taskOutcomes     []taskOutcome
+
+	// running and waiting functions of each key and category,
+	// see SubmitKeyed and SubmitWithCategory
+	keyPolicy      DuplicateKeyPolicy
+	keyGroups      map[string]*taskGroup
+	categoryLimits map[string]int
+	categoryGroups map[string]*taskGroup
+
+	// functions of each key running and waiting in submission
+	// order, see SubmitOrdered and SetOrderedRebalancing
+	orderedGroups map[string]*taskGroup
+	rebalance     bool
+
+	// weighted functions running and waiting, see SubmitWeighted
+	maxWeight      int64
+	inFlightWeight int64
+	weightWaiting  []*groupedTask
+
+	// exitCond is signalled whenever a thread joins or leaves the pool
+	exitCond *sync.Cond
+
+	// queueCond and queueGeneration are used to wait on multiple queues
+	queueCond       *sync.Cond
+	queueGeneration uint64
+
+	// held for read while the pool enqueues a function so that
+	// SetFunctionQueue does not leave it behind on the old queue
+	swapMux sync.RWMutex
+}
+
+// sizeChange is a change to the number of threads
+// waiting to be given to the size change listener
+type sizeChange struct {
+	oldCount int32
+	newCount int32
+	reason   string
 }
 
 // states for each thread in the pool
@@ -72,6 +156,20 @@ const (
 
 	// RUNNING current running user code
 	RUNNING = 1
+
+	// PARKED running user code that is suspended at a checkpoint,
+	// see ThreadUtilities.Checkpoint
+	PARKED = 2
+)
+
+const (
+	// closePollInterval is the longest a waiting thread goes without
+	// checking whether its pool has been closed
+	closePollInterval = 100 * time.Millisecond
+
+	// defaultMonitorInterval is how often the monitor checks the
+	// pool when nothing wakes it up, see SetMonitorInterval
+	defaultMonitorInterval = 1 * time.Minute
 )
 
 var (
@@ -79,7 +177,7 @@ var (
 )
 
 func newThreadPool(par *StandardThreadUtilities, name string, min, max int32, idle time.Duration,
-	fq FunctionQueue, eq ErrorQueue) (Pool, error) {
+	selector QueueSelector, fqs []FunctionQueue, eq ErrorQueue) (Pool, error) {
 	if min < 0 {
 		return nil, fmt.Errorf("minimum thread count less than zero %d", min)
 	}
@@ -89,25 +187,49 @@ func newThreadPool(par *StandardThreadUtilities, name string, min, max int32, id
 	if min > max {
 		return nil, fmt.Errorf("minimum (%d) is greater than maximum (%d)", min, max)
 	}
-	if fq == nil {
+	if len(fqs) == 0 {
 		return nil, fmt.Errorf("pool must have a functional queue")
 	}
+	for _, fq := range fqs {
+		if fq == nil {
+			return nil, fmt.Errorf("pool must have a functional queue")
+		}
+	}
+	if selector == nil {
+		return nil, fmt.Errorf("pool must have a queue selector")
+	}
+
+	queues := make([]FunctionQueue, len(fqs))
+	copy(queues, fqs)
 
 	retVal := &threadPool{
 		name:            name,
 		minThreads:      min,
 		maxThreads:      max,
 		idleDecay:       idle,
-		functionalQueue: fq,
+		functionalQueue: queues[0],
+		queues:          queues,
+		selector:        selector,
 		errorQueue:      eq,
 		threadState:     make(map[int64]int),
+		retiring:        make(map[int64]bool),
+		runningTasks:    make(map[int64]RunningTask),
+		keyGroups:       make(map[string]*taskGroup),
+		categoryLimits:  make(map[string]int),
+		categoryGroups:  make(map[string]*taskGroup),
+		orderedGroups:   make(map[string]*taskGroup),
 		parent:          par,
 		closeChannel:    make(chan bool),
+		doneChannel:     make(chan struct{}),
 		decayChannel:    make(chan bool),
 		changeChannel:   make(chan int),
+		monitorInterval: defaultMonitorInterval,
 	}
 
-	timer, err := par.ScheduleWithFixedDelay(0, 1*time.Minute,
+	retVal.queueCond = sync.NewCond(&retVal.mux)
+	retVal.exitCond = sync.NewCond(&retVal.mux)
+
+	timer, err := par.ScheduleWithFixedDelay(0, defaultMonitorInterval,
 		retVal.errorQueue, retVal.ringBell)
 	if err != nil {
 		return nil, err
@@ -119,11 +241,10 @@ func newThreadPool(par *StandardThreadUtilities, name string, min, max int32, id
 }
 
 func (threadPool *threadPool) ringBell() {
-	defer func() {
-		recover()
-	}()
-
-	threadPool.decayChannel <- true
+	select {
+	case threadPool.decayChannel <- true:
+	case <-threadPool.closeChannel:
+	}
 }
 
 func (threadPool *threadPool) IsStarted() bool {
@@ -145,16 +266,15 @@ func (threadPool *threadPool) Start() error {
 		return nil
 	}
 
-	goether := GetGoethe()
-
 	var lcv int32
 	for lcv = 0; lcv < threadPool.minThreads; lcv++ {
-		goether.Go(threadRunner, threadPool)
-		threadPool.currentThreads++
+		threadPool.startThread(SizeChangeStart)
 	}
 
-	goether.Go(threadPool.monitor)
-	threadPool.functionalQueue.SetStateChangeCallback(threadPool.functionalQueueChanged)
+	GetGoethe().Go(threadPool.monitor)
+	for _, queue := range threadPool.queues {
+		queue.SetStateChangeCallback(threadPool.functionalQueueChanged)
+	}
 
 	threadPool.started = true
 
@@ -162,18 +282,86 @@ func (threadPool *threadPool) Start() error {
 }
 
 func (threadPool *threadPool) functionalQueueChanged(fq FunctionQueue) {
-	defer func() {
-		recover()
-	}()
-
-	if threadPool.IsClosed() {
+	threadPool.mux.Lock()
+	closed := threadPool.closed
+	started := threadPool.started
+	threadPool.queueGeneration++
+	threadPool.queueCond.Broadcast()
+	queueSize := threadPool.getQueueSize()
+	threadPool.mux.Unlock()
+
+	if closed {
 		return
 	}
-	if !threadPool.IsStarted() {
+	if !started {
 		return
 	}
 
-	threadPool.changeChannel <- fq.GetSize()
+	// The monitor stops receiving once the pool closes
+	select {
+	case threadPool.changeChannel <- queueSize:
+	case <-threadPool.closeChannel:
+	}
+}
+
+// getQueueSize returns the number of functions on all queues.
+// Must have mutex held
+func (threadPool *threadPool) getQueueSize() int {
+	retVal := 0
+	for _, queue := range threadPool.queues {
+		retVal += queue.GetSize()
+	}
+
+	return retVal
+}
+
+// dequeue takes the next function from the queues in the order chosen
+// by the selector, waiting up to the given duration for one to arrive
+func (threadPool *threadPool) dequeue(duration time.Duration) (*FunctionDescriptor, error) {
+	queues := threadPool.GetFunctionQueues()
+	if len(queues) == 1 {
+		return queues[0].Dequeue(duration)
+	}
+
+	start := now()
+	for {
+		threadPool.mux.Lock()
+		generation := threadPool.queueGeneration
+		threadPool.mux.Unlock()
+
+		for _, index := range threadPool.selector.Order(len(queues)) {
+			descriptor, err := queues[index].Dequeue(0)
+			if err != ErrEmptyQueue {
+				return descriptor, err
+			}
+		}
+
+		elapsed := since(start)
+		if elapsed >= duration {
+			return nil, ErrEmptyQueue
+		}
+
+		threadPool.mux.Lock()
+		if threadPool.closed {
+			threadPool.mux.Unlock()
+			return nil, ErrEmptyQueue
+		}
+
+		if generation == threadPool.queueGeneration {
+			// Nothing has changed since we looked, wait for a change
+			timer := afterFunc(duration-elapsed, func() {
+				threadPool.mux.Lock()
+				defer threadPool.mux.Unlock()
+
+				threadPool.queueCond.Broadcast()
+			})
+
+			threadPool.queueCond.Wait()
+
+			timer.Stop()
+		}
+		threadPool.mux.Unlock()
+	}
 }
 
 func (threadPool *threadPool) GetName() string {
@@ -181,13 +369,76 @@ func (threadPool *threadPool) GetName() string {
 }
 
 func (threadPool *threadPool) GetMinThreads() int32 {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
 	return threadPool.minThreads
 }
 
 func (threadPool *threadPool) GetMaxThreads() int32 {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
 	return threadPool.maxThreads
 }
 
+func (threadPool *threadPool) Resize(min, max int32) error {
+	if min < 0 {
+		return fmt.Errorf("minimum thread count less than zero %d", min)
+	}
+	if max < 1 {
+		return fmt.Errorf("maximum thread count less than one %d", max)
+	}
+	if min > max {
+		return fmt.Errorf("minimum (%d) is greater than maximum (%d)", min, max)
+	}
+
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	if threadPool.closed {
+		return ErrPoolClosed
+	}
+
+	threadPool.minThreads = min
+	threadPool.maxThreads = max
+
+	if !threadPool.started {
+		return nil
+	}
+
+	for threadPool.currentThreads < threadPool.minThreads {
+		threadPool.startThread(SizeChangeResize)
+	}
+
+	// Threads over the new maximum leave once they are between functions
+	threadPool.queueGeneration++
+	threadPool.queueCond.Broadcast()
+
+	return nil
+}
+
+func (threadPool *threadPool) RetireThread(threadID int64) error {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	if threadPool.closed {
+		return ErrPoolClosed
+	}
+
+	if _, found := threadPool.threadState[threadID]; !found || threadPool.retiring[threadID] {
+		return ErrNoSuchThread
+	}
+
+	threadPool.retiring[threadID] = true
+
+	if threadPool.activeThreads() < threadPool.minThreads {
+		threadPool.startThread(SizeChangeReplace)
+	}
+
+	return nil
+}
+
 func (threadPool *threadPool) GetIdleDecayDuration() time.Duration {
 	return threadPool.idleDecay
 }
@@ -199,10 +450,142 @@ func (threadPool *threadPool) GetCurrentThreadCount() int32 {
 	return threadPool.currentThreads
 }
 
+func (threadPool *threadPool) AwaitThreadCount(target int32, timeout time.Duration) error {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	deadline := now().Add(timeout)
+	for threadPool.currentThreads != target {
+		remaining := deadline.Sub(now())
+		if remaining <= 0 {
+			return fmt.Errorf("pool %s has %d threads rather than %d: %w",
+				threadPool.name, threadPool.currentThreads, target, ErrThreadCountTimeout)
+		}
+
+		timer := afterFunc(remaining, func() {
+			threadPool.mux.Lock()
+			defer threadPool.mux.Unlock()
+
+			threadPool.exitCond.Broadcast()
+		})
+
+		threadPool.exitCond.Wait()
+
+		timer.Stop()
+	}
+
+	return nil
+}
+
+func (threadPool *threadPool) GetIdleThreadCount() int32 {
+	return threadPool.countThreads(WAITING)
+}
+
+func (threadPool *threadPool) GetBusyThreadCount() int32 {
+	return threadPool.countThreads(RUNNING)
+}
+
+// countThreads returns the number of threads in the given state
+func (threadPool *threadPool) countThreads(state int) int32 {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	var retVal int32
+	for _, threadState := range threadPool.threadState {
+		if threadState == state {
+			retVal++
+		}
+	}
+
+	return retVal
+}
+
 func (threadPool *threadPool) GetFunctionQueue() FunctionQueue {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
 	return threadPool.functionalQueue
 }
 
+func (threadPool *threadPool) GetFunctionQueues() []FunctionQueue {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	retVal := make([]FunctionQueue, len(threadPool.queues))
+	copy(retVal, threadPool.queues)
+
+	return retVal
+}
+
+func (threadPool *threadPool) SetFunctionQueue(newQueue FunctionQueue) error {
+	if newQueue == nil {
+		return fmt.Errorf("pool must have a functional queue")
+	}
+
+	threadPool.swapMux.Lock()
+	defer threadPool.swapMux.Unlock()
+
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	if threadPool.closed {
+		return ErrPoolClosed
+	}
+
+	oldQueue := threadPool.queues[0]
+	if oldQueue == newQueue {
+		return nil
+	}
+
+	oldQueue.SetStateChangeCallback(nil)
+
+	moved := takeAllFunctions(oldQueue)
+	err := putAllFunctions(newQueue, moved)
+	if err != nil {
+		// Leave things as they were
+		putAllFunctions(oldQueue, moved)
+		if threadPool.started {
+			oldQueue.SetStateChangeCallback(threadPool.functionalQueueChanged)
+		}
+
+		return err
+	}
+
+	// Threads take a copy of the queues, so never change them in place
+	queues := make([]FunctionQueue, len(threadPool.queues))
+	copy(queues, threadPool.queues)
+	queues[0] = newQueue
+
+	threadPool.queues = queues
+	threadPool.functionalQueue = newQueue
+
+	if threadPool.started {
+		newQueue.SetStateChangeCallback(threadPool.functionalQueueChanged)
+	}
+
+	threadPool.queueGeneration++
+	threadPool.queueCond.Broadcast()
+
+	return nil
+}
+
+// enqueue puts a function on the function queue of the pool
+func (threadPool *threadPool) enqueue(userCall interface{}, args ...interface{}) error {
+	threadPool.swapMux.RLock()
+	defer threadPool.swapMux.RUnlock()
+
+	return threadPool.GetFunctionQueue().Enqueue(userCall, args...)
+}
+
+// enqueueWithCallback puts a function with a callback on the function queue of the pool
+func (threadPool *threadPool) enqueueWithCallback(onDone func(results []interface{}, err error),
+	userCall interface{}, args ...interface{}) error {
+	threadPool.swapMux.RLock()
+	defer threadPool.swapMux.RUnlock()
+
+	return threadPool.GetFunctionQueue().EnqueueWithCallback(onDone, userCall, args...)
+}
+
 func (threadPool *threadPool) GetErrorQueue() ErrorQueue {
 	return threadPool.errorQueue
 }
@@ -224,142 +607,948 @@ func (threadPool *threadPool) Close() {
 
 	threadPool.closed = true
 
-	threadPool.functionalQueue.SetStateChangeCallback(nil)
+	for _, queue := range threadPool.queues {
+		queue.SetStateChangeCallback(nil)
+	}
+	threadPool.queueCond.Broadcast()
+
+	// Let threads suspended at a checkpoint finish
+	for tid, state := range threadPool.threadState {
+		if state == PARKED {
+			threadPool.parent.ResumeThread(tid)
+		}
+	}
 
 	threadPool.parent.removePool(threadPool.name)
 
 	threadPool.decayTimer.Cancel()
 
 	close(threadPool.closeChannel)
-	close(threadPool.decayChannel)
-	close(threadPool.changeChannel)
+
+	threadPool.checkDone()
 }
 
-func (threadPool *threadPool) monitor() {
-	for {
-		if threadPool.IsClosed() {
-			return
-		}
+func (threadPool *threadPool) DependsOn(other Pool) error {
+	if other == nil {
+		return fmt.Errorf("pool %s can not depend on a nil pool", threadPool.name)
+	}
 
-		threadPool.monitorOnce()
+	if threadPool.IsClosed() || other.IsClosed() {
+		return ErrPoolClosed
+	}
 
-		select {
-		case <-threadPool.changeChannel:
-			break
-		case <-threadPool.closeChannel:
-			return
-		case <-threadPool.decayChannel:
-			break
-		}
+	return threadPool.parent.addPoolDependency(threadPool.name, other.GetName())
+}
+
+func (threadPool *threadPool) Done() <-chan struct{} {
+	return threadPool.doneChannel
+}
+
+// checkDone closes the done channel once the pool is closed
+// and all of its threads have exited.  Must have mutex held
+func (threadPool *threadPool) checkDone() {
+	if !threadPool.closed || threadPool.currentThreads > 0 || threadPool.doneClosed {
+		return
 	}
+
+	threadPool.doneClosed = true
+	close(threadPool.doneChannel)
 }
 
-func (threadPool *threadPool) monitorOnce() {
+func (threadPool *threadPool) GetStats() PoolStats {
 	threadPool.mux.Lock()
 	defer threadPool.mux.Unlock()
 
-	if threadPool.currentThreads >= threadPool.maxThreads {
-		// already at limit
-		return
+	return PoolStats{
+		CurrentThreads:          threadPool.currentThreads,
+		QueueSize:               threadPool.getQueueSize(),
+		SaturationEvents:        threadPool.saturationEvents,
+		TaskFailures:            threadPool.taskFailures,
+		CircuitBreakerOpen:      threadPool.isBreakerOpen(),
+		ThreadsRecycledForTasks: threadPool.recycledForTasks,
+		ThreadsRecycledForAge:   threadPool.recycledForAge,
+		ErrorsDropped:           threadPool.errorsDropped,
 	}
+}
 
-	queueSize := threadPool.functionalQueue.GetSize()
-	if queueSize <= 0 {
-		// nothing to do, individual threads will die at their own rate
-		return
-	}
+func (threadPool *threadPool) ResetStats() PoolStats {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
 
-	numWaiting := 0
-	for _, state := range threadPool.threadState {
-		if state == WAITING {
-			numWaiting++
-		}
+	retVal := PoolStats{
+		CurrentThreads:          threadPool.currentThreads,
+		QueueSize:               threadPool.getQueueSize(),
+		SaturationEvents:        threadPool.saturationEvents,
+		TaskFailures:            threadPool.taskFailures,
+		CircuitBreakerOpen:      threadPool.isBreakerOpen(),
+		ThreadsRecycledForTasks: threadPool.recycledForTasks,
+		ThreadsRecycledForAge:   threadPool.recycledForAge,
+		ErrorsDropped:           threadPool.errorsDropped,
 	}
 
-	if numWaiting >= queueSize {
-		// We already have all we need
-		return
+	threadPool.saturationEvents = 0
+	threadPool.taskFailures = 0
+	threadPool.recycledForTasks = 0
+	threadPool.recycledForAge = 0
+	threadPool.errorsDropped = 0
+
+	return retVal
+}
+
+func (threadPool *threadPool) SetCircuitBreaker(threshold int, window time.Duration) error {
+	if threshold < 0 {
+		return fmt.Errorf("circuit breaker threshold less than zero %d", threshold)
+	}
+	if threshold > 0 && window <= 0 {
+		return fmt.Errorf("circuit breaker window must be positive, it is %v", window)
 	}
 
-	// Figure out the number of threads we need to start
-	needed := queueSize - numWaiting
-	maxToAdd := int(threadPool.maxThreads - threadPool.currentThreads)
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
 
-	numberToAdd := maxToAdd
-	if needed < maxToAdd {
-		numberToAdd = needed
+	threadPool.breakerThreshold = threshold
+	threadPool.breakerWindow = window
+	threadPool.failureTimes = nil
+	threadPool.breakerOpen = false
+
+	return nil
+}
+
+func (threadPool *threadPool) SetMonitorInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("monitor interval must be positive, it is %v", interval)
 	}
 
-	for lcv := 0; lcv < numberToAdd; lcv++ {
-		// We have to grow!
-		goether := GetGoethe()
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
 
-		goether.Go(threadRunner, threadPool)
-		threadPool.currentThreads++
+	if threadPool.closed {
+		return ErrPoolClosed
 	}
-}
 
-func threadRunner(threadPool *threadPool) {
-	goether := GetGoethe()
-	tid := goether.GetThreadID()
+	timer, err := threadPool.parent.ScheduleWithFixedDelay(interval, interval,
+		threadPool.errorQueue, threadPool.ringBell)
+	if err != nil {
+		return err
+	}
 
-	defer deleteMapTid(threadPool, tid)
+	threadPool.decayTimer.Cancel()
+	threadPool.decayTimer = timer
+	threadPool.monitorInterval = interval
 
-	for {
-		if threadPool.IsClosed() {
-			threadPool.mux.Lock()
-			threadPool.currentThreads--
-			threadPool.mux.Unlock()
+	return nil
+}
 
-			return
-		}
+func (threadPool *threadPool) SetThreadRecycling(maxTasksPerThread int64, maxThreadLifetime time.Duration) error {
+	if maxTasksPerThread < 0 {
+		return fmt.Errorf("maximum tasks per thread less than zero %d", maxTasksPerThread)
+	}
+	if maxThreadLifetime < 0 {
+		return fmt.Errorf("maximum thread lifetime less than zero %v", maxThreadLifetime)
+	}
 
-		changeMapState(threadPool, tid, WAITING)
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
 
-		descriptor, err := threadPool.functionalQueue.Dequeue(threadPool.idleDecay)
-		if err != nil {
-			if err == ErrEmptyQueue {
-				threadPool.mux.Lock()
-				if threadPool.currentThreads > threadPool.minThreads {
-					// Reduce size of thread pool, but not below minimum
-					threadPool.currentThreads--
+	threadPool.maxTasksPerThread = maxTasksPerThread
+	threadPool.maxThreadLifetime = maxThreadLifetime
 
-					threadPool.mux.Unlock()
+	return nil
+}
+
+// taskFailed records a function that returned an error.  Returns true
+// if this failure caused the circuit breaker to open
+func (threadPool *threadPool) taskFailed() bool {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	threadPool.taskFailures++
+
+	if threadPool.breakerThreshold <= 0 {
+		return false
+	}
+
+	threadPool.failureTimes = append(threadPool.failureTimes, now())
+
+	wasOpen := threadPool.breakerOpen
+
+	return threadPool.isBreakerOpen() && !wasOpen
+}
+
+// recordFailure counts a function that returned an error on the given
+// thread, telling the error queue if that opened the circuit breaker
+func (threadPool *threadPool) recordFailure(tid int64) {
+	if threadPool.taskFailed() {
+		threadPool.reportError(newErrorinformation(tid, ErrCircuitBreakerOpen))
+	}
+}
+
+// isBreakerOpen removes failures that are outside the window and
+// returns true if the circuit breaker is open.  Must have mutex held
+func (threadPool *threadPool) isBreakerOpen() bool {
+	if threadPool.breakerThreshold <= 0 {
+		return false
+	}
+
+	current := now()
+	windowStart := current.Add(-threadPool.breakerWindow)
+
+	// Failures after now were recorded on a clock that has since been
+	// swapped with SetClock, so they are forgotten as well
+	kept := threadPool.failureTimes[:0]
+	for _, failed := range threadPool.failureTimes {
+		if !failed.Before(windowStart) && !failed.After(current) {
+			kept = append(kept, failed)
+		}
+	}
+	threadPool.failureTimes = kept
+
+	threadPool.breakerOpen = len(threadPool.failureTimes) >= threadPool.breakerThreshold
+
+	return threadPool.breakerOpen
+}
+
+func (threadPool *threadPool) SetTaskInterceptor(interceptor func(next func()) func()) error {
+	if interceptor == nil {
+		return fmt.Errorf("interceptor may not be nil")
+	}
+
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	interceptors := make([]func(next func()) func(), len(threadPool.interceptors), len(threadPool.interceptors)+1)
+	copy(interceptors, threadPool.interceptors)
+
+	threadPool.interceptors = append(interceptors, interceptor)
+
+	return nil
+}
+
+// intercept wraps the call in the interceptors of the pool, the first
+// one added being outermost
+func (threadPool *threadPool) intercept(call func()) func() {
+	threadPool.mux.Lock()
+	interceptors := threadPool.interceptors
+	threadPool.mux.Unlock()
+
+	for lcv := len(interceptors) - 1; lcv >= 0; lcv-- {
+		wrapped := interceptors[lcv](call)
+		if wrapped != nil {
+			call = wrapped
+		}
+	}
+
+	return call
+}
+
+func (threadPool *threadPool) Submit(userCall interface{}, args ...interface{}) (Future, error) {
+	if threadPool.IsClosed() {
+		return nil, ErrPoolClosed
+	}
+
+	_, err := getValues(userCall, args)
+	if err != nil {
+		return nil, err
+	}
+
+	future := newFuture()
+
+	// As a callback the future also hears about functions that are
+	// never run, such as those cleared from the queue
+	err = threadPool.enqueueWithCallback(future.setResults, userCall, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return future, nil
+}
+
+func (threadPool *threadPool) Resubmit(info ErrorInformation) (Future, error) {
+	if threadPool.IsClosed() {
+		return nil, ErrPoolClosed
+	}
+
+	userCall, args := info.GetFunction()
+	if userCall == nil {
+		return nil, ErrNotResubmittable
+	}
+
+	return threadPool.Submit(userCall, args...)
+}
+
+func (threadPool *threadPool) SubmitBlocking(ctx context.Context, userCall interface{}, args ...interface{}) (Future, error) {
+	for {
+		threadPool.mux.Lock()
+		generation := threadPool.queueGeneration
+		threadPool.mux.Unlock()
+
+		future, err := threadPool.Submit(userCall, args...)
+		if err != ErrAtCapacity {
+			return future, err
+		}
+
+		err = threadPool.waitForQueueChange(ctx, generation)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// waitForQueueChange waits until a queue of the pool has changed since
+// the given generation, or the context is done.  Queues only report
+// changes once the pool is started, so it also wakes up every so often
+func (threadPool *threadPool) waitForQueueChange(ctx context.Context, generation uint64) error {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			threadPool.mux.Lock()
+			defer threadPool.mux.Unlock()
+
+			threadPool.queueCond.Broadcast()
+		case <-stop:
+		}
+	}()
+
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	if generation == threadPool.queueGeneration && ctx.Err() == nil && !threadPool.closed {
+		timer := afterFunc(closePollInterval, func() {
+			threadPool.mux.Lock()
+			defer threadPool.mux.Unlock()
+
+			threadPool.queueCond.Broadcast()
+		})
+
+		threadPool.queueCond.Wait()
+
+		timer.Stop()
+	}
+
+	return ctx.Err()
+}
+
+func (threadPool *threadPool) CloseWait(duration time.Duration) ([]ErrorInformation, error) {
+	return threadPool.closeWait(duration, 0, nil)
+}
+
+func (threadPool *threadPool) CloseWaitWithProgress(duration time.Duration, interval time.Duration,
+	progress func(queued int, running int)) ([]ErrorInformation, error) {
+	if progress == nil {
+		return nil, fmt.Errorf("progress may not be nil")
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("progress interval must be positive, it is %v", interval)
+	}
+
+	return threadPool.closeWait(duration, interval, progress)
+}
+
+// closeWait closes the pool and waits for its threads to exit,
+// calling progress every interval if it is not nil
+func (threadPool *threadPool) closeWait(duration time.Duration, interval time.Duration,
+	progress func(queued int, running int)) ([]ErrorInformation, error) {
+	threadPool.Close()
+
+	threadPool.mux.Lock()
+
+	currentTime := now()
+	elapsedDuration := since(currentTime)
+	nextReport := interval
+
+	for (elapsedDuration < duration) && (threadPool.currentThreads > 0) {
+		wait := duration - elapsedDuration
+		if progress != nil && nextReport-elapsedDuration < wait {
+			wait = nextReport - elapsedDuration
+		}
+
+		timer := afterFunc(wait, func() {
+			threadPool.mux.Lock()
+			defer threadPool.mux.Unlock()
+
+			threadPool.exitCond.Broadcast()
+		})
+
+		threadPool.exitCond.Wait()
+
+		timer.Stop()
+
+		elapsedDuration = since(currentTime)
+
+		if progress != nil && elapsedDuration >= nextReport && threadPool.currentThreads > 0 {
+			queued, running := threadPool.getQueueSize(), threadPool.runningFunctions()
+
+			threadPool.mux.Unlock()
+			progress(queued, running)
+			threadPool.mux.Lock()
+
+			for nextReport <= elapsedDuration {
+				nextReport += interval
+			}
+		}
+	}
+
+	remaining := threadPool.currentThreads
+	queued, running := threadPool.getQueueSize(), threadPool.runningFunctions()
+
+	threadPool.mux.Unlock()
+
+	if progress != nil {
+		progress(queued, running)
+	}
+
+	var err error
+	if remaining > 0 {
+		err = ErrCloseTimeout
+	}
+
+	return threadPool.drainErrors(), err
+}
+
+// runningFunctions returns the number of threads running a
+// function, including those parked.  Must have mutex held
+func (threadPool *threadPool) runningFunctions() int {
+	retVal := 0
+	for _, state := range threadPool.threadState {
+		if state != WAITING {
+			retVal++
+		}
+	}
+
+	return retVal
+}
+
+// drainErrors removes everything from the error queue of this pool
+func (threadPool *threadPool) drainErrors() []ErrorInformation {
+	retVal := make([]ErrorInformation, 0)
+	if threadPool.errorQueue == nil {
+		return retVal
+	}
+
+	for {
+		info, found := threadPool.errorQueue.Dequeue()
+		if !found {
+			return retVal
+		}
+
+		retVal = append(retVal, info)
+	}
+}
+
+func (threadPool *threadPool) monitor() {
+	for {
+		if threadPool.IsClosed() {
+			return
+		}
+
+		threadPool.monitorOnce()
+
+		select {
+		case <-threadPool.changeChannel:
+			break
+		case <-threadPool.closeChannel:
+			return
+		case <-threadPool.decayChannel:
+			break
+		}
+	}
+}
+
+func (threadPool *threadPool) monitorOnce() {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	queueSize := threadPool.getQueueSize()
+	if queueSize <= 0 {
+		// nothing to do, individual threads will die at their own rate
+		return
+	}
+
+	numWaiting := 0
+	for _, state := range threadPool.threadState {
+		if state == WAITING {
+			numWaiting++
+		}
+	}
+
+	if numWaiting >= queueSize {
+		// We already have all we need
+		return
+	}
+
+	active := threadPool.activeThreads()
+	if active >= threadPool.maxThreads {
+		// already at limit
+		threadPool.saturationEvents++
+		return
+	}
+
+	if threadPool.isBreakerOpen() {
+		// Too many failures, do not make things worse
+		return
+	}
+
+	// Figure out the number of threads we need to start
+	needed := queueSize - numWaiting
+	maxToAdd := int(threadPool.maxThreads - active)
+
+	numberToAdd := maxToAdd
+	if needed < maxToAdd {
+		numberToAdd = needed
+	}
+
+	for lcv := 0; lcv < numberToAdd; lcv++ {
+		// We have to grow!
+		threadPool.startThread(SizeChangeGrow)
+	}
+}
+
+// startThread adds a thread to the pool.  The new thread is counted as
+// waiting right away so that the monitor does not start another thread
+// for the same function before this one gets to the queue.  Must have
+// mutex held
+func (threadPool *threadPool) startThread(reason string) {
+	tid, _ := threadPool.parent.Go(threadRunner, threadPool)
+
+	threadPool.threadState[tid] = WAITING
+	threadPool.currentThreads++
+	threadPool.exitCond.Broadcast()
+
+	threadPool.sizeChanged(threadPool.currentThreads-1, reason)
+}
+
+func threadRunner(threadPool *threadPool) {
+	goether := GetGoethe()
+	tid := goether.GetThreadID()
+
+	threadPool.parent.setParkListener(tid, func(parked bool) error {
+		return threadPool.threadParked(tid, parked)
+	})
+
+	started := now()
+	var tasksRun int64
+
+	idleSince := now()
+	for {
+		if threadPool.IsClosed() {
+			threadPool.threadExiting(tid, SizeChangeClose)
+
+			return
+		}
+
+		if threadPool.leaveIfNotNeeded(tid) || threadPool.leaveIfWornOut(tid, tasksRun, started) {
+			return
+		}
+
+		changeMapState(threadPool, tid, WAITING)
+
+		// Wake up every so often to see if the pool has been closed
+		wait := threadPool.idleDecay - since(idleSince)
+		if wait > closePollInterval {
+			wait = closePollInterval
+		}
+
+		descriptor, err := threadPool.dequeue(wait)
+		if err != nil {
+			if err == ErrEmptyQueue {
+				if since(idleSince) < threadPool.idleDecay {
+					continue
+				}
+
+				threadPool.mux.Lock()
+				if threadPool.activeThreads() > threadPool.minThreads {
+					// Reduce size of thread pool, but not below minimum
+					threadPool.mux.Unlock()
+
+					threadPool.threadExiting(tid, SizeChangeIdle)
 					return
 				}
 				threadPool.mux.Unlock()
+
+				idleSince = now()
+			} else if err == ErrNoSuchThread {
+				// Function was meant for a thread that has exited
+				descriptor.Finished()
+
+				threadPool.reportError(newErrorinformation(tid, err))
 			} else {
 				// Todo: log this error or something?
-				threadPool.mux.Lock()
-				threadPool.currentThreads--
-				threadPool.mux.Unlock()
+				threadPool.threadExiting(tid, SizeChangeError)
 
 				return
 			}
+		} else if !descriptor.Deadline.IsZero() && now().After(descriptor.Deadline) {
+			// Nobody is waiting for this anymore, shed it
+			descriptor.Finished()
+
+			threadPool.reportError(newEnqueuedErrorinformation(tid, ErrDeadlineExceeded, descriptor))
+
+			if descriptor.OnDone != nil {
+				callOnDone(descriptor, nil, ErrDeadlineExceeded)
+			}
 		} else {
-			changeMapState(threadPool, tid, RUNNING)
+			threadPool.taskStarted(tid, descriptor)
 
-			argsAsVals, err := getValues(descriptor.UserCall, descriptor.Args)
+			pooledArgs, err := getPooledValues(descriptor.UserCall, descriptor.Args)
 			if err != nil {
 				// Todo: log this error or something?
+				descriptor.Finished()
+				threadPool.threadExiting(tid, SizeChangeError)
+
 				return
 			}
 
-			invoke(descriptor.UserCall, argsAsVals, threadPool.errorQueue)
+			if descriptor.Metadata != nil {
+				threadPool.parent.setTaskMetadata(tid, descriptor.Metadata)
+			}
+
+			argsAsVals := *pooledArgs
+
+			ran := false
+			panicErr := threadPool.runTask(tid, threadPool.intercept(func() {
+				if descriptor.OnDone == nil {
+					err = invokeEnqueued(descriptor.UserCall, argsAsVals, threadPool.errorReporter(), descriptor)
+				} else {
+					err = threadPool.invokeWithCallback(tid, descriptor, argsAsVals)
+				}
+				ran = true
+			}))
+
+			if panicErr != nil {
+				err = panicErr
+				threadPool.reportError(newEnqueuedErrorinformation(tid, err, descriptor))
+
+				if descriptor.OnDone != nil {
+					callOnDone(descriptor, nil, err)
+				}
+			} else if !ran && descriptor.OnDone != nil {
+				callOnDone(descriptor, nil, ErrTaskNotRun)
+			}
+			putPooledValues(pooledArgs)
+			if descriptor.Metadata != nil {
+				threadPool.parent.setTaskMetadata(tid, nil)
+			}
+			threadPool.taskDone(tid)
+			descriptor.Finished()
+
+			if err != nil {
+				threadPool.recordFailure(tid)
+			}
+			threadPool.recordOutcome(err != nil)
+
+			tasksRun++
+			idleSince = now()
 		}
 	}
 }
 
-func changeMapState(threadPool *threadPool, tid int64, newState int) {
+// invokeWithCallback runs a function enqueued with EnqueueWithCallback
+// and then its callback, keeping any panic of the callback on this side
+func (threadPool *threadPool) invokeWithCallback(tid int64, descriptor *FunctionDescriptor,
+	args []reflect.Value) error {
+	results, err := callMethod(descriptor.UserCall, args)
+	if err != nil {
+		threadPool.reportError(newEnqueuedErrorinformation(tid, err, descriptor))
+	}
+
+	callOnDone(descriptor, results, err)
+
+	return err
+}
+
+// reportError puts the error on the error queue of the pool, if it has
+// one.  If the error queue will not take it, for example because it is
+// full, the error is counted as dropped and given to the dropped error
+// handler so that it is not lost without a trace
+func (threadPool *threadPool) reportError(info ErrorInformation) {
+	if threadPool.errorQueue == nil {
+		return
+	}
+
+	if threadPool.errorQueue.Enqueue(info) == nil {
+		return
+	}
+
+	threadPool.mux.Lock()
+	threadPool.errorsDropped++
+	handler := threadPool.droppedErrorHandler
+	threadPool.mux.Unlock()
+
+	if handler != nil {
+		callDroppedErrorHandler(handler, info)
+	}
+}
+
+// errorReporter returns reportError, or nil if there is
+// no error queue so that nothing is done for errors
+func (threadPool *threadPool) errorReporter() func(ErrorInformation) {
+	if threadPool.errorQueue == nil {
+		return nil
+	}
+
+	return threadPool.reportError
+}
+
+// callDroppedErrorHandler calls the handler, ignoring any panic
+func callDroppedErrorHandler(handler func(ErrorInformation), info ErrorInformation) {
+	defer func() {
+		recover()
+	}()
+
+	handler(info)
+}
+
+func (threadPool *threadPool) SetDroppedErrorHandler(handler func(info ErrorInformation)) {
 	threadPool.mux.Lock()
 	defer threadPool.mux.Unlock()
 
-	threadPool.threadState[tid] = newState
+	threadPool.droppedErrorHandler = handler
 }
 
-func deleteMapTid(threadPool *threadPool, tid int64) {
+// callOnDone calls the OnDone of the descriptor, ignoring any panic
+func callOnDone(descriptor *FunctionDescriptor, results []interface{}, err error) {
+	defer func() {
+		recover()
+	}()
+
+	descriptor.OnDone(results, err)
+}
+
+// leaveIfNotNeeded returns true if the thread has been retired with
+// RetireThread or the pool has more threads than its maximum, which
+// happens after Resize, in which case the thread has been removed from
+// the pool and must exit.  Checking and removing together means only
+// as many threads leave as need to.  Retiring and parked threads are
+// not counted against the maximum, see activeThreads
+func (threadPool *threadPool) leaveIfNotNeeded(tid int64) bool {
 	threadPool.mux.Lock()
 	defer threadPool.mux.Unlock()
 
+	if !threadPool.retiring[tid] && threadPool.activeThreads() <= threadPool.maxThreads {
+		return false
+	}
+
+	reason := SizeChangeResize
+	if threadPool.retiring[tid] {
+		reason = SizeChangeRetire
+	}
+
+	threadPool.removeThread(tid, reason)
+
+	return true
+}
+
+// leaveIfWornOut returns true if the thread has run as many functions
+// or lived as long as SetThreadRecycling allows, in which case the thread
+// has been removed from the pool and must exit.  A replacement is started
+// if the pool would otherwise be below its minimum
+func (threadPool *threadPool) leaveIfWornOut(tid int64, tasksRun int64, started time.Time) bool {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	switch {
+	case threadPool.maxTasksPerThread > 0 && tasksRun >= threadPool.maxTasksPerThread:
+		threadPool.recycledForTasks++
+	case threadPool.maxThreadLifetime > 0 && since(started) >= threadPool.maxThreadLifetime:
+		threadPool.recycledForAge++
+	default:
+		return false
+	}
+
+	threadPool.removeThread(tid, SizeChangeRecycle)
+
+	if !threadPool.closed && threadPool.activeThreads() < threadPool.minThreads {
+		threadPool.startThread(SizeChangeReplace)
+	}
+
+	return true
+}
+
+// threadExiting removes a thread from the count of threads in this pool
+func (threadPool *threadPool) threadExiting(tid int64, reason string) {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	threadPool.removeThread(tid, reason)
+}
+
+// activeThreads returns the number of threads that can run functions,
+// which leaves out threads that are retiring since they are leaving
+// anyway and threads parked at a checkpoint since they may be parked for
+// a long time.  Must have mutex held
+func (threadPool *threadPool) activeThreads() int32 {
+	var retVal int32
+	for tid, state := range threadPool.threadState {
+		if state != PARKED && !threadPool.retiring[tid] {
+			retVal++
+		}
+	}
+
+	return retVal
+}
+
+// threadParked is called when a thread of this pool parks at or leaves a
+// checkpoint.  A parked thread does not count against the maximum, so the
+// monitor is told it may be able to add a thread.  Returns ErrPoolClosed
+// if the pool is closed, in which case the thread must not park
+func (threadPool *threadPool) threadParked(tid int64, parked bool) error {
+	threadPool.mux.Lock()
+
+	newState := RUNNING
+	if parked {
+		newState = PARKED
+	}
+
+	if _, found := threadPool.threadState[tid]; found {
+		threadPool.threadState[tid] = newState
+	}
+
+	closed := threadPool.closed
+	threadPool.mux.Unlock()
+
+	if closed {
+		return ErrPoolClosed
+	}
+
+	if parked {
+		go threadPool.ringBell()
+	}
+
+	return nil
+}
+
+// removeThread is called when a thread leaves the pool.  Must have mutex held
+func (threadPool *threadPool) removeThread(tid int64, reason string) {
+	// Together so the thread counts always agree with each other
 	delete(threadPool.threadState, tid)
+	delete(threadPool.retiring, tid)
+	delete(threadPool.runningTasks, tid)
+	threadPool.currentThreads--
+	threadPool.exitCond.Broadcast()
+
+	threadPool.sizeChanged(threadPool.currentThreads+1, reason)
+
+	threadPool.checkDone()
+}
+
+func (threadPool *threadPool) SetSizeChangeListener(listener func(oldCount, newCount int32, reason string)) {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	threadPool.sizeListener = listener
+}
+
+// sizeChanged records a change to the number of threads for the size
+// change listener, which is called later outside of the mutex so that
+// it can use the pool.  Must have mutex held
+func (threadPool *threadPool) sizeChanged(oldCount int32, reason string) {
+	if threadPool.sizeListener == nil {
+		return
+	}
+
+	threadPool.sizeChanges = append(threadPool.sizeChanges, sizeChange{
+		oldCount: oldCount,
+		newCount: threadPool.currentThreads,
+		reason:   reason,
+	})
+
+	go threadPool.deliverSizeChanges()
+}
+
+// deliverSizeChanges gives every recorded size change to the listener.
+// Deliveries take turns on notifyMux and each takes every change recorded
+// so far, so the listener sees the changes in the order they happened
+func (threadPool *threadPool) deliverSizeChanges() {
+	threadPool.notifyMux.Lock()
+	defer threadPool.notifyMux.Unlock()
+
+	threadPool.mux.Lock()
+	changes := threadPool.sizeChanges
+	threadPool.sizeChanges = nil
+	listener := threadPool.sizeListener
+	threadPool.mux.Unlock()
+
+	if listener == nil {
+		return
+	}
+
+	for _, change := range changes {
+		callSizeListener(listener, change)
+	}
+}
+
+// callSizeListener calls the listener, ignoring any panic
+func callSizeListener(listener func(oldCount, newCount int32, reason string), change sizeChange) {
+	defer func() {
+		recover()
+	}()
+
+	listener(change.oldCount, change.newCount, change.reason)
+}
+
+func (threadPool *threadPool) GetRunningTasks() []RunningTask {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	retVal := make([]RunningTask, 0, len(threadPool.runningTasks))
+	for _, task := range threadPool.runningTasks {
+		retVal = append(retVal, task)
+	}
+
+	sort.Slice(retVal, func(i, j int) bool {
+		return retVal[i].ThreadID < retVal[j].ThreadID
+	})
+
+	return retVal
+}
+
+// taskStarted marks the thread as running the function
+func (threadPool *threadPool) taskStarted(tid int64, descriptor *FunctionDescriptor) {
+	task := RunningTask{
+		ThreadID:     tid,
+		FunctionName: functionName(descriptor.UserCall),
+		StartTime:    now(),
+		EnqueueTime:  descriptor.EnqueueTime,
+		Metadata:     descriptor.Metadata,
+	}
+
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	threadPool.threadState[tid] = RUNNING
+	threadPool.runningTasks[tid] = task
+}
+
+// taskDone marks the thread as no longer running a function
+func (threadPool *threadPool) taskDone(tid int64) {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	delete(threadPool.runningTasks, tid)
+}
+
+// functionName returns the name the runtime has for the function,
+// such as main.worker or main.main.func1 for a closure
+func functionName(userCall interface{}) string {
+	value := functionValue(userCall)
+	if value.Kind() != reflect.Func {
+		return ""
+	}
+
+	function := runtime.FuncForPC(value.Pointer())
+	if function == nil {
+		return ""
+	}
+
+	return function.Name()
+}
+
+func changeMapState(threadPool *threadPool, tid int64, newState int) {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	threadPool.threadState[tid] = newState
 }
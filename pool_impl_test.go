@@ -0,0 +1,139 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"testing"
+	"time"
+)
+
+// aheadClock is the real clock moved forward by a day
+type aheadClock struct {
+	realClock
+}
+
+func (ac aheadClock) Now() time.Time {
+	return time.Now().Add(24 * time.Hour)
+}
+
+func TestMonitorOnceDoesNotGrowWithBreakerOpen(t *testing.T) {
+	goethe := GetGoethe()
+
+	funcQueue := NewBoundedFunctionQueue(10)
+
+	pool, err := goethe.NewPool("MonitorBreakerPool", 0, 5, 1*time.Minute,
+		funcQueue, NewBoundedErrorQueue(10))
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	threadPool := pool.(*threadPool)
+
+	err = pool.SetCircuitBreaker(3, 1*time.Minute)
+	if err != nil {
+		t.Errorf("could not set circuit breaker %v", err)
+		return
+	}
+
+	for lcv := 0; lcv < 3; lcv++ {
+		threadPool.taskFailed()
+		funcQueue.Enqueue(func() {})
+	}
+
+	threadPool.monitorOnce()
+
+	if count := pool.GetCurrentThreadCount(); count != 0 {
+		t.Errorf("pool should not grow while the breaker is open, it has %d threads", count)
+		return
+	}
+
+	// Once the breaker is gone the same queue makes the pool grow
+	err = pool.SetCircuitBreaker(0, 0)
+	if err != nil {
+		t.Errorf("could not turn off circuit breaker %v", err)
+		return
+	}
+
+	threadPool.monitorOnce()
+
+	if count := pool.GetCurrentThreadCount(); count != 3 {
+		t.Errorf("pool should have grown to 3 threads, it has %d", count)
+	}
+}
+
+func TestBreakerForgetsFailuresFromSwappedClock(t *testing.T) {
+	goethe := GetGoethe()
+
+	pool, err := goethe.NewPool("SwappedClockBreakerPool", 0, 1, 1*time.Minute,
+		NewBoundedFunctionQueue(10), NewBoundedErrorQueue(10))
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	threadPool := pool.(*threadPool)
+
+	err = pool.SetCircuitBreaker(3, 1*time.Minute)
+	if err != nil {
+		t.Errorf("could not set circuit breaker %v", err)
+		return
+	}
+
+	goethe.SetClock(aheadClock{})
+	for lcv := 0; lcv < 3; lcv++ {
+		threadPool.taskFailed()
+	}
+
+	opened := pool.GetStats().CircuitBreakerOpen
+	goethe.SetClock(nil)
+
+	if !opened {
+		t.Error("circuit breaker did not open")
+		return
+	}
+
+	if pool.GetStats().CircuitBreakerOpen {
+		t.Error("failures recorded on a clock ahead of the current one kept the breaker open")
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import "sync/atomic"
+
+// taskStream is the channels of a function given to SubmitStream
+type taskStream struct {
+	values chan interface{}
+	errors chan error
+	done   int32
+}
+
+func (threadPool *threadPool) SubmitStream(task func(emit func(interface{})) error) (<-chan interface{}, <-chan error) {
+	stream := &taskStream{
+		values: make(chan interface{}),
+		errors: make(chan error, 1),
+	}
+
+	if threadPool.IsClosed() {
+		stream.finish(nil, ErrPoolClosed)
+
+		return stream.values, stream.errors
+	}
+
+	// As a callback finish also hears about functions that are never run
+	err := threadPool.enqueueWithCallback(stream.finish, task, stream.emit)
+	if err != nil {
+		stream.finish(nil, err)
+	}
+
+	return stream.values, stream.errors
+}
+
+// emit hands a value of the function to the caller
+func (stream *taskStream) emit(value interface{}) {
+	if atomic.LoadInt32(&stream.done) != 0 {
+		return
+	}
+
+	stream.values <- value
+}
+
+// finish closes the channels once the function is done, with the
+// error of the function last.  A function given to Resubmit uses the
+// same emit, so it may be finished more than once
+func (stream *taskStream) finish(results []interface{}, err error) {
+	if !atomic.CompareAndSwapInt32(&stream.done, 0, 1) {
+		return
+	}
+
+	close(stream.values)
+
+	if err != nil {
+		stream.errors <- err
+	}
+	close(stream.errors)
+}
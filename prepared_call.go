@@ -0,0 +1,143 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// PreparedCall is a function whose reflection is worked out once by
+// NewPreparedCall, such as the types of its parameters and which of its
+// results are errors.  It can be given anywhere goethe takes a function,
+// such as Go, Pool.Submit or FunctionQueue.Enqueue, with the same
+// arguments the function takes.  Each call then does less reflection,
+// which matters for a pool running many small functions of the same kind.
+// A PreparedCall can be used by any number of threads at once
+type PreparedCall struct {
+	function reflect.Value
+	params   []reflect.Type
+
+	// the value given for a nil argument of each parameter
+	zeros []reflect.Value
+
+	// the indexes of the results whose type is error or implements it
+	errorResults []int
+}
+
+// NewPreparedCall works out the reflection of the function once, so that
+// calls of the returned PreparedCall are cheaper.  Returns an error if
+// userCall is not a function
+func NewPreparedCall(userCall interface{}) (*PreparedCall, error) {
+	function := reflect.ValueOf(userCall)
+	if function.Kind() != reflect.Func || function.IsNil() {
+		return nil, fmt.Errorf("a prepared call must be a function, not a %T", userCall)
+	}
+
+	typ := function.Type()
+
+	retVal := &PreparedCall{
+		function: function,
+		params:   make([]reflect.Type, typ.NumIn()),
+		zeros:    make([]reflect.Value, typ.NumIn()),
+	}
+
+	for index := range retVal.params {
+		retVal.params[index] = typ.In(index)
+		retVal.zeros[index] = reflect.Zero(typ.In(index))
+	}
+
+	for index := 0; index < typ.NumOut(); index++ {
+		if typ.Out(index).Implements(errorInterface) {
+			retVal.errorResults = append(retVal.errorResults, index)
+		}
+	}
+
+	return retVal, nil
+}
+
+// appendValues is appendValues for the prepared function
+func (prepared *PreparedCall) appendValues(arguments []reflect.Value, args []interface{}) ([]reflect.Value, error) {
+	if len(prepared.params) != len(args) {
+		return nil, fmt.Errorf("Method has %d parameters, user passed in %d", len(prepared.params), len(args))
+	}
+
+	for index, arg := range args {
+		if arg == nil {
+			arguments = append(arguments, prepared.zeros[index])
+			continue
+		}
+
+		argValue := reflect.ValueOf(arg)
+
+		expected := prepared.params[index]
+		if argValue.Type() != expected && !argValue.Type().AssignableTo(expected) {
+			return nil, fmt.Errorf("Value at index %d of type %s does not match method parameter of type %s",
+				index, argValue.Type().String(), expected.String())
+		}
+
+		arguments = append(arguments, argValue)
+	}
+
+	return arguments, nil
+}
+
+// errors returns the results of the prepared function that are non-nil errors
+func (prepared *PreparedCall) errors(results []reflect.Value) []error {
+	var retVal []error
+	for _, index := range prepared.errorResults {
+		if !results[index].IsNil() {
+			retVal = append(retVal, results[index].Interface().(error))
+		}
+	}
+
+	return retVal
+}
+
+// functionValue returns the function to call for
+// userCall, which may be a PreparedCall
+func functionValue(userCall interface{}) reflect.Value {
+	if prepared, ok := userCall.(*PreparedCall); ok {
+		return prepared.function
+	}
+
+	return reflect.ValueOf(userCall)
+}
@@ -0,0 +1,129 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"fmt"
+	"sync"
+)
+
+type strictPrioritySelector struct {
+}
+
+type weightedRoundRobinSelector struct {
+	mux       sync.Mutex
+	weights   []int
+	numQueues int
+	schedule  []int
+	position  int
+}
+
+// NewStrictPrioritySelector returns a QueueSelector that always checks
+// the function queues in the order they were given to the pool, so a
+// queue is only serviced when all the queues before it are empty
+func NewStrictPrioritySelector() QueueSelector {
+	return &strictPrioritySelector{}
+}
+
+func (selector *strictPrioritySelector) Order(numQueues int) []int {
+	retVal := make([]int, numQueues)
+	for lcv := 0; lcv < numQueues; lcv++ {
+		retVal[lcv] = lcv
+	}
+
+	return retVal
+}
+
+// NewWeightedRoundRobinSelector returns a QueueSelector that services
+// the function queues in proportion to the given weights.  The weight
+// at index n is the weight of the queue at index n in the pool.  Queues
+// with no weight given have a weight of one.  Weights must be positive
+func NewWeightedRoundRobinSelector(weights ...int) (QueueSelector, error) {
+	for index, weight := range weights {
+		if weight < 1 {
+			return nil, fmt.Errorf("weight at index %d must be positive, it is %d", index, weight)
+		}
+	}
+
+	return &weightedRoundRobinSelector{
+		weights: weights,
+	}, nil
+}
+
+func (selector *weightedRoundRobinSelector) Order(numQueues int) []int {
+	selector.mux.Lock()
+	defer selector.mux.Unlock()
+
+	if numQueues <= 0 {
+		return []int{}
+	}
+
+	if selector.numQueues != numQueues {
+		selector.numQueues = numQueues
+		selector.schedule = make([]int, 0)
+		for lcv := 0; lcv < numQueues; lcv++ {
+			weight := 1
+			if lcv < len(selector.weights) {
+				weight = selector.weights[lcv]
+			}
+
+			for count := 0; count < weight; count++ {
+				selector.schedule = append(selector.schedule, lcv)
+			}
+		}
+
+		selector.position = 0
+	}
+
+	first := selector.schedule[selector.position]
+	selector.position = (selector.position + 1) % len(selector.schedule)
+
+	// The chosen queue goes first, if it is empty the rest are in priority order
+	retVal := make([]int, 0, numQueues)
+	retVal = append(retVal, first)
+	for lcv := 0; lcv < numQueues; lcv++ {
+		if lcv != first {
+			retVal = append(retVal, lcv)
+		}
+	}
+
+	return retVal
+}
@@ -0,0 +1,103 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"fmt"
+	"time"
+)
+
+type quorumResult struct {
+	value interface{}
+	err   error
+}
+
+// GoQuorum runs every task in its own goethe thread and returns
+// the results of the first k that succeed
+func (goth *StandardThreadUtilities) GoQuorum(k int, tasks []func() (interface{}, error), timeout time.Duration) ([]interface{}, error) {
+	if k < 1 || k > len(tasks) {
+		return nil, fmt.Errorf("quorum of %d can not be reached with %d tasks", k, len(tasks))
+	}
+
+	// Buffered so that tasks finishing after the quorum is decided
+	// do not block forever
+	results := make(chan quorumResult, len(tasks))
+	for _, task := range tasks {
+		task := task
+
+		goth.goClosure(func() {
+			value, err := task()
+			results <- quorumResult{
+				value: value,
+				err:   err,
+			}
+		})
+	}
+
+	expired := make(chan struct{})
+	timer := afterFunc(timeout, func() {
+		close(expired)
+	})
+	defer timer.Stop()
+
+	succeeded := make([]interface{}, 0, k)
+	failed := 0
+	for len(succeeded) < k {
+		if len(tasks)-failed < k {
+			return succeeded, fmt.Errorf("%d of %d tasks succeeded and %d failed, %d needed: %w",
+				len(succeeded), len(tasks), failed, k, ErrQuorumNotReached)
+		}
+
+		select {
+		case result := <-results:
+			if result.err != nil {
+				failed++
+			} else {
+				succeeded = append(succeeded, result.value)
+			}
+		case <-expired:
+			return succeeded, fmt.Errorf("%d of %d tasks succeeded and %d failed before the timeout, %d needed: %w",
+				len(succeeded), len(tasks), failed, k, ErrQuorumNotReached)
+		}
+	}
+
+	return succeeded, nil
+}
@@ -43,13 +43,68 @@ package goethe
 import (
 	"fmt"
 	"reflect"
+	"sync"
 )
 
+// maxPooledValues is the most arguments a slice of values may have room
+// for and still be kept for reuse, so that one call with very many
+// arguments does not keep a large slice around
+const maxPooledValues = 64
+
+// valueSlices holds the slices of argument values used by pool threads, so
+// that running a function does not need a new slice every time
+var valueSlices = sync.Pool{
+	New: func() interface{} {
+		return new([]reflect.Value)
+	},
+}
+
 // getValues returns the reflection values for the arguments as specified by
 // the method parameters.  Will fail if the wrong number of arguments is passed
 // in or the arguments are not the correct type.  Otherwise will return
 // the value versions of the arguments
 func getValues(method interface{}, args []interface{}) ([]reflect.Value, error) {
+	return appendValues(make([]reflect.Value, 0, len(args)), method, args)
+}
+
+// getPooledValues is getValues with a slice taken from a pool rather than a
+// new one.  The slice must be given back with putPooledValues once the method
+// has been called, and must not be used after that
+func getPooledValues(method interface{}, args []interface{}) (*[]reflect.Value, error) {
+	pooled := valueSlices.Get().(*[]reflect.Value)
+
+	values, err := appendValues((*pooled)[:0], method, args)
+	if err != nil {
+		// The slice may hold some of the arguments, so it is not reused
+		return nil, err
+	}
+
+	*pooled = values
+
+	return pooled, nil
+}
+
+// putPooledValues gives back a slice from getPooledValues.  The values are
+// cleared first so the pool does not keep the arguments alive
+func putPooledValues(pooled *[]reflect.Value) {
+	values := *pooled
+	if cap(values) > maxPooledValues {
+		return
+	}
+
+	clear(values)
+	*pooled = values[:0]
+
+	valueSlices.Put(pooled)
+}
+
+// appendValues is getValues that appends the values to the given
+// slice, so that its backing array can be reused
+func appendValues(arguments []reflect.Value, method interface{}, args []interface{}) ([]reflect.Value, error) {
+	if prepared, ok := method.(*PreparedCall); ok {
+		return prepared.appendValues(arguments, args)
+	}
+
 	typ := reflect.TypeOf(method)
 	kin := typ.Kind()
 	if kin != reflect.Func {
@@ -61,25 +116,21 @@ func getValues(method interface{}, args []interface{}) ([]reflect.Value, error)
 		return nil, fmt.Errorf("Method has %d parameters, user passed in %d", numIn, len(args))
 	}
 
-	expectedTypes := make([]reflect.Type, numIn)
-	for i := 0; i < numIn; i++ {
-		expectedTypes[i] = typ.In(i)
-	}
-
-	arguments := make([]reflect.Value, numIn)
 	for index, arg := range args {
+		expectedType := typ.In(index)
+
 		var argValue reflect.Value
 		if arg == nil {
-			argValue = reflect.New(expectedTypes[index]).Elem()
+			argValue = reflect.New(expectedType).Elem()
 		} else {
 			argValue = reflect.ValueOf(arg)
 		}
 
-		arguments[index] = argValue
+		arguments = append(arguments, argValue)
 
-		if !argValue.Type().AssignableTo(expectedTypes[index]) {
+		if !argValue.Type().AssignableTo(expectedType) {
 			return nil, fmt.Errorf("Value at index %d of type %s does not match method parameter of type %s",
-				index, argValue.Type().String(), expectedTypes[index].String())
+				index, argValue.Type().String(), expectedType.String())
 
 		}
 	}
@@ -87,32 +138,125 @@ func getValues(method interface{}, args []interface{}) ([]reflect.Value, error)
 	return arguments, nil
 }
 
+// shallowCopy returns a copy of a slice or map value, with the
+// same elements.  Any other value is returned as it is
+func shallowCopy(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+
+		retVal := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		reflect.Copy(retVal, value)
+
+		return retVal
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+
+		retVal := reflect.MakeMapWithSize(value.Type(), value.Len())
+		for _, key := range value.MapKeys() {
+			retVal.SetMapIndex(key, value.MapIndex(key))
+		}
+
+		return retVal
+	default:
+		return value
+	}
+}
+
 // invoke will call the method with the arguments, and ship any errors
-// returned by the method to the errorQueue (which may be nil)
-func invoke(method interface{}, args []reflect.Value, errorQueue ErrorQueue) {
-	val := reflect.ValueOf(method)
+// returned by the method to the errorQueue (which may be nil).  The
+// first error returned by the method is also returned
+func invoke(method interface{}, args []reflect.Value, errorQueue ErrorQueue) error {
+	var report func(ErrorInformation)
+	if errorQueue != nil {
+		report = func(info ErrorInformation) {
+			errorQueue.Enqueue(info)
+		}
+	}
+
+	return invokeEnqueued(method, args, report, nil)
+}
+
+// invokeEnqueued is invoke for a method that was put on a function
+// queue with the given descriptor, which is recorded in the error
+// information and may be nil.  Errors are given to report, which may be nil
+func invokeEnqueued(method interface{}, args []reflect.Value, report func(ErrorInformation),
+	descriptor *FunctionDescriptor) error {
+	val := functionValue(method)
 	retVals := val.Call(args)
 
-	if errorQueue != nil {
+	errs := resultErrors(method, retVals)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	if report != nil {
 		tid := GetGoethe().GetThreadID()
 
-		// pick first returned error and return it
-		for _, retVal := range retVals {
-			if !retVal.IsNil() && retVal.CanInterface() {
-				// First returned value that is not nill and is an error
-				it := retVal.Type()
-				isAnError := it.Implements(errorInterface)
+		for _, asErr := range errs {
+			report(newEnqueuedErrorinformation(tid, asErr, descriptor))
+		}
+	}
+
+	return errs[0]
+}
+
+// resultErrors returns the values returned by the method
+// that are errors and not nil, in order
+func resultErrors(method interface{}, retVals []reflect.Value) []error {
+	if prepared, ok := method.(*PreparedCall); ok {
+		return prepared.errors(retVals)
+	}
+
+	var retVal []error
+	for _, value := range retVals {
+		if !isNilValue(value) && value.CanInterface() && value.Type().Implements(errorInterface) {
+			retVal = append(retVal, value.Interface().(error))
+		}
+	}
 
-				if isAnError {
-					iFace := retVal.Interface()
+	return retVal
+}
 
-					asErr := iFace.(error)
+// callMethod calls the method with the arguments and returns all of the values
+// returned by the method along with the first non-nil error returned by the method
+func callMethod(method interface{}, args []reflect.Value) ([]interface{}, error) {
+	val := functionValue(method)
+	retVals := val.Call(args)
 
-					errInfo := newErrorinformation(tid, asErr)
+	results := make([]interface{}, len(retVals))
+	var firstError error
 
-					errorQueue.Enqueue(errInfo)
-				}
-			}
+	for index, retVal := range retVals {
+		if !retVal.CanInterface() {
+			continue
 		}
+
+		iFace := retVal.Interface()
+		results[index] = iFace
+
+		if firstError != nil || isNilValue(retVal) {
+			continue
+		}
+
+		if retVal.Type().Implements(errorInterface) {
+			firstError = iFace.(error)
+		}
+	}
+
+	return results, firstError
+}
+
+// isNilValue returns true if the value is of a kind that can be nil and is nil
+func isNilValue(val reflect.Value) bool {
+	switch val.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return val.IsNil()
+	default:
+		return false
 	}
 }
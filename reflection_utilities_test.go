@@ -159,3 +159,171 @@ func bbB(a, b, c *bBB, d bBB, rChan chan *bBB) {
 	rChan <- &d
 	close(rChan)
 }
+
+func TestPreparedCall(t *testing.T) {
+	prepared, err := NewPreparedCall(func(a string, b *bBB) (int, error) {
+		if b == nil {
+			return 0, fmt.Errorf("no bBB for %s", a)
+		}
+
+		return b.a, nil
+	})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	v, err := getValues(prepared, []interface{}{"a", &bBB{a: 13}})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	results, err := callMethod(prepared, v)
+	if err != nil || results[0] != 13 {
+		t.Errorf("unexpected results %v %v", results, err)
+		return
+	}
+
+	v, err = getValues(prepared, []interface{}{"a", nil})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	var reported []ErrorInformation
+	err = invokeEnqueued(prepared, v, func(info ErrorInformation) {
+		reported = append(reported, info)
+	}, nil)
+	if err == nil || len(reported) != 1 || reported[0].GetError() != err {
+		t.Errorf("expected the error to be returned and reported once, got %v %v", err, reported)
+		return
+	}
+
+	if _, err = getValues(prepared, []interface{}{"a", "b"}); err == nil {
+		t.Error("expected an argument of the wrong type to be refused")
+		return
+	}
+	if _, err = getValues(prepared, []interface{}{"a"}); err == nil {
+		t.Error("expected too few arguments to be refused")
+		return
+	}
+
+	if _, err = NewPreparedCall("not a function"); err == nil {
+		t.Error("expected a prepared call of something other than a function to fail")
+	}
+}
+
+func TestPooledValuesOfDifferentLengths(t *testing.T) {
+	three := func(a, b, c int) {}
+	one := func(s string) {}
+
+	for lcv := 0; lcv < 10; lcv++ {
+		pooled, err := getPooledValues(three, []interface{}{1, 2, 3})
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		values := *pooled
+		if len(values) != 3 || values[2].Int() != 3 {
+			t.Errorf("unexpected values for three arguments %v", values)
+			return
+		}
+
+		putPooledValues(pooled)
+
+		if values[0].IsValid() {
+			t.Error("values given back should be cleared")
+			return
+		}
+
+		pooled, err = getPooledValues(one, []interface{}{"only"})
+		if err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+
+		if len(*pooled) != 1 || (*pooled)[0].String() != "only" {
+			t.Errorf("unexpected values for one argument %v", *pooled)
+			return
+		}
+
+		putPooledValues(pooled)
+	}
+
+	if _, err := getPooledValues(three, []interface{}{1, "two", 3}); err == nil {
+		t.Error("expected an argument of the wrong type to be refused")
+	}
+}
+
+func smallTask(a, b int) (int, error) {
+	return a + b, nil
+}
+
+func manyArgsTask(a, b, c, d, e, f, g, h int) int {
+	return a + b + c + d + e + f + g + h
+}
+
+func BenchmarkInvoke(b *testing.B) {
+	benchmarkInvoke(b, smallTask)
+}
+
+func BenchmarkInvokePrepared(b *testing.B) {
+	prepared, err := NewPreparedCall(smallTask)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkInvoke(b, prepared)
+}
+
+// escapedCall is where the benchmarks put the call, as a pool thread hands
+// it to the interceptors of the pool, which makes the argument values escape
+var escapedCall func()
+
+func BenchmarkInvokeManyArgs(b *testing.B) {
+	benchmarkInvoke(b, manyArgsTask, 1, 2, 3, 4, 5, 6, 7, 8)
+}
+
+func BenchmarkInvokeManyArgsWithoutPooling(b *testing.B) {
+	args := []interface{}{1, 2, 3, 4, 5, 6, 7, 8}
+
+	b.ReportAllocs()
+
+	for lcv := 0; lcv < b.N; lcv++ {
+		v, err := getValues(manyArgsTask, args)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		escapedCall = func() {
+			invokeEnqueued(manyArgsTask, v, nil, nil)
+		}
+		escapedCall()
+	}
+}
+
+// benchmarkInvoke does what a pool thread does with each function
+func benchmarkInvoke(b *testing.B, userCall interface{}, args ...interface{}) {
+	if len(args) == 0 {
+		args = []interface{}{1, 2}
+	}
+
+	b.ReportAllocs()
+
+	for lcv := 0; lcv < b.N; lcv++ {
+		pooled, err := getPooledValues(userCall, args)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		argsAsVals := *pooled
+		escapedCall = func() {
+			invokeEnqueued(userCall, argsAsVals, nil, nil)
+		}
+		escapedCall()
+
+		putPooledValues(pooled)
+	}
+}
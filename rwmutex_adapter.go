@@ -0,0 +1,116 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"sync"
+)
+
+// RWMutexAdapter has the methods of sync.RWMutex but is backed by a goethe
+// Lock, so code written for sync.RWMutex can switch to a goethe lock by
+// changing the type.  It differs from sync.RWMutex in these ways:
+//
+// Its methods must be called from goethe threads.
+//
+// It is reentrant.  A thread holding it can take it again, for read or
+// for write, where a sync.RWMutex would deadlock.  Each Lock or RLock
+// must still be paired with an Unlock or RUnlock.
+//
+// Misuse panics with the goethe error rather than deadlocking or failing
+// fatally.  Lock from a thread holding only the read lock panics with
+// ErrReadLockHeld instead of deadlocking, and Unlock from a thread that
+// does not hold the write lock panics with ErrWriteLockNotHeld.  RUnlock
+// from a thread that does not hold the read lock does nothing.
+//
+// It must be unlocked by the thread that locked it, where a sync.RWMutex
+// may be unlocked by any goroutine.
+//
+// Use NewRWMutexAdapter to create one; the zero value can not be used
+type RWMutexAdapter struct {
+	lock Lock
+}
+
+// NewRWMutexAdapter returns an RWMutexAdapter backed by a new goethe Lock
+func (goth *StandardThreadUtilities) NewRWMutexAdapter() *RWMutexAdapter {
+	return &RWMutexAdapter{
+		lock: goth.NewGoetheLock(),
+	}
+}
+
+// Lock takes the write lock, like sync.RWMutex.Lock
+func (adapter *RWMutexAdapter) Lock() {
+	mustNotFail(adapter.lock.WriteLock())
+}
+
+// Unlock releases the write lock, like sync.RWMutex.Unlock
+func (adapter *RWMutexAdapter) Unlock() {
+	mustNotFail(adapter.lock.WriteUnlock())
+}
+
+// RLock takes the read lock, like sync.RWMutex.RLock
+func (adapter *RWMutexAdapter) RLock() {
+	mustNotFail(adapter.lock.ReadLock())
+}
+
+// RUnlock releases the read lock, like sync.RWMutex.RUnlock
+func (adapter *RWMutexAdapter) RUnlock() {
+	mustNotFail(adapter.lock.ReadUnlock())
+}
+
+// RLocker returns a sync.Locker whose Lock and Unlock call
+// RLock and RUnlock, like sync.RWMutex.RLocker
+func (adapter *RWMutexAdapter) RLocker() sync.Locker {
+	return adapter.lock.ReadLocker()
+}
+
+// GetLock returns the goethe Lock behind the adapter, for its
+// other features such as GetWriteOwner and GetStats
+func (adapter *RWMutexAdapter) GetLock() Lock {
+	return adapter.lock
+}
+
+// mustNotFail panics with the error if there is one, since
+// the methods of sync.RWMutex can not return errors
+func mustNotFail(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -53,6 +53,11 @@ type sleeperNode struct {
 	ringTime time.Time
 	cond     *sync.Cond
 	id       uint64
+
+	// waitingOn is the clock a waiter for this node sleeps on, or nil if
+	// it has none.  A waiter on a clock no longer in use may sleep far
+	// past when this node is due, so the node then needs another
+	waitingOn Clock
 }
 
 type sleeperImpl struct {
@@ -64,7 +69,7 @@ type sleeperImpl struct {
 func newSleeper() sleeper {
 	return &sleeperImpl{
 		heap: newHeap(),
-		lock: GetGoethe().NewGoetheLock(),
+		lock: globalGoethe.newInternalLock(),
 		jobs: make(map[uint64]uint64),
 	}
 }
@@ -85,7 +90,9 @@ func (sleepy *sleeperImpl) sleep(duration time.Duration, cond *sync.Cond, jobNum
 
 	sleepy.jobs[jobNumber] = jobNumber
 
-	ringsAt := time.Now().Add(duration)
+	clock := globalGoethe.getClock()
+
+	ringsAt := clock.Now().Add(duration)
 	newNode := &sleeperNode{
 		ringTime: ringsAt,
 		cond:     cond,
@@ -94,12 +101,12 @@ func (sleepy *sleeperImpl) sleep(duration time.Duration, cond *sync.Cond, jobNum
 
 	startNewThread := true
 
-	nextNode, _, found := sleepy.heap.Peek()
+	nextNode, nextPayload, found := sleepy.heap.Peek()
 	if found {
 		until := nextNode.Sub(ringsAt)
 
-		if until < 0 {
-			// start new thread
+		if until < 0 && nextPayload.(*sleeperNode).waitingOn == clock {
+			// The waiter of the earlier node rings this one too
 			startNewThread = false
 		}
 	}
@@ -107,22 +114,29 @@ func (sleepy *sleeperImpl) sleep(duration time.Duration, cond *sync.Cond, jobNum
 	sleepy.heap.Add(&ringsAt, newNode)
 
 	if startNewThread {
-		GetGoethe().Go(sleepy.waiter, duration)
+		newNode.waitingOn = clock
+		GetGoethe().Go(sleepy.waiter, newNode, clock, duration)
 	}
 }
 
-func (sleepy *sleeperImpl) waiter(duration time.Duration) {
-	time.Sleep(duration)
+func (sleepy *sleeperImpl) waiter(mine *sleeperNode, clock Clock, duration time.Duration) {
+	clock.Sleep(duration)
 
 	sleepy.lock.Lock()
 	defer sleepy.lock.Unlock()
 
+	// If the clock was changed this may wake before its node is due,
+	// in which case the node is left for whichever waiter comes next
+	if mine.waitingOn == clock {
+		mine.waitingOn = nil
+	}
+
 	fireTime, _, found := sleepy.heap.Peek()
 	if !found {
 		return
 	}
 
-	nextFire := time.Until(*fireTime)
+	nextFire := (*fireTime).Sub(now())
 	for nextFire < fudgeFactor {
 		_, node, _ := sleepy.heap.Get()
 
@@ -140,6 +154,22 @@ func (sleepy *sleeperImpl) waiter(duration time.Duration) {
 			return
 		}
 
-		nextFire = time.Until(*fireTime)
+		nextFire = (*fireTime).Sub(now())
+	}
+
+	// A node added behind an earlier one was left to the waiter of
+	// the earlier one, so it needs a waiter of its own now, on the
+	// clock now in use
+	_, node, _ := sleepy.heap.Peek()
+
+	noder, ok := node.(*sleeperNode)
+	if !ok {
+		panic("invalid type as heap payload")
+	}
+
+	current := globalGoethe.getClock()
+	if noder.waitingOn != current {
+		noder.waitingOn = current
+		GetGoethe().Go(sleepy.waiter, noder, current, nextFire)
 	}
 }
@@ -0,0 +1,100 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogHandler adds the goethe thread to records before handing
+// them to the handler it wraps
+type slogHandler struct {
+	goth *StandardThreadUtilities
+	next slog.Handler
+}
+
+// NewSlogHandler returns a slog.Handler that adds the id and name of
+// the current goethe thread to records before passing them to next
+func (goth *StandardThreadUtilities) NewSlogHandler(next slog.Handler) slog.Handler {
+	return &slogHandler{
+		goth: goth,
+		next: next,
+	}
+}
+
+func (handler *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return handler.next.Enabled(ctx, level)
+}
+
+// Handle is called on the goroutine that logged the record, so
+// the thread of the caller is the thread that logged it
+func (handler *slogHandler) Handle(ctx context.Context, record slog.Record) error {
+	tid := handler.goth.GetThreadID()
+	if tid < 0 {
+		return handler.next.Handle(ctx, record)
+	}
+
+	// The record may be shared with other handlers, so it is not changed
+	record = record.Clone()
+	record.AddAttrs(slog.Int64(SlogThreadIDKey, tid))
+
+	name := handler.goth.getThreadName(tid)
+	if name != "" {
+		record.AddAttrs(slog.String(SlogThreadNameKey, name))
+	}
+
+	return handler.next.Handle(ctx, record)
+}
+
+func (handler *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &slogHandler{
+		goth: handler.goth,
+		next: handler.next.WithAttrs(attrs),
+	}
+}
+
+func (handler *slogHandler) WithGroup(name string) slog.Handler {
+	return &slogHandler{
+		goth: handler.goth,
+		next: handler.next.WithGroup(name),
+	}
+}
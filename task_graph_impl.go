@@ -0,0 +1,264 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type graphTask struct {
+	userCall  interface{}
+	dependsOn []string
+}
+
+type taskGraph struct {
+	parent *StandardThreadUtilities
+
+	mux   sync.Mutex
+	tasks map[string]*graphTask
+	ran   bool
+}
+
+// taskResult is sent by each task of a running graph when it is done
+type taskResult struct {
+	id  string
+	err error
+}
+
+// NewTaskGraph returns an empty TaskGraph
+func (goth *StandardThreadUtilities) NewTaskGraph() TaskGraph {
+	return &taskGraph{
+		parent: goth,
+		tasks:  make(map[string]*graphTask),
+	}
+}
+
+func (graph *taskGraph) AddTask(id string, userCall interface{}, dependsOn ...string) error {
+	_, err := getValues(userCall, []interface{}{})
+	if err != nil {
+		return err
+	}
+
+	graph.mux.Lock()
+	defer graph.mux.Unlock()
+
+	if graph.ran {
+		return fmt.Errorf("task %s added to a task graph that has already been run", id)
+	}
+
+	if _, found := graph.tasks[id]; found {
+		return fmt.Errorf("task graph already has a task %s", id)
+	}
+
+	for _, dependency := range dependsOn {
+		if dependency == id || graph.dependsOn(dependency, id, make(map[string]bool)) {
+			return ErrTaskCycle
+		}
+	}
+
+	dependencies := make([]string, len(dependsOn))
+	copy(dependencies, dependsOn)
+
+	graph.tasks[id] = &graphTask{
+		userCall:  userCall,
+		dependsOn: dependencies,
+	}
+
+	return nil
+}
+
+// dependsOn returns true if the task from depends on the task to,
+// directly or not.  Tasks not added yet depend on nothing.  Must
+// have mutex held
+func (graph *taskGraph) dependsOn(from, to string, visited map[string]bool) bool {
+	if visited[from] {
+		return false
+	}
+	visited[from] = true
+
+	task, found := graph.tasks[from]
+	if !found {
+		return false
+	}
+
+	for _, dependency := range task.dependsOn {
+		if dependency == to || graph.dependsOn(dependency, to, visited) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (graph *taskGraph) Run(timeout time.Duration) (map[string]error, error) {
+	graph.mux.Lock()
+	if graph.ran {
+		graph.mux.Unlock()
+		return nil, fmt.Errorf("task graph has already been run")
+	}
+
+	for id, task := range graph.tasks {
+		for _, dependency := range task.dependsOn {
+			if _, found := graph.tasks[dependency]; !found {
+				graph.mux.Unlock()
+				return nil, fmt.Errorf("task %s depends on unknown task %s", id, dependency)
+			}
+		}
+	}
+
+	graph.ran = true
+	graph.mux.Unlock()
+
+	// No more changes to the tasks once ran is set
+	run := newGraphRun(graph)
+
+	return run.wait(timeout)
+}
+
+// graphRun is the state of one Run of a task graph
+type graphRun struct {
+	graph *taskGraph
+
+	// the number of dependencies of each task that have not finished
+	waitingOn  map[string]int
+	dependents map[string][]string
+
+	results  chan taskResult
+	failures map[string]error
+	finished int
+}
+
+func newGraphRun(graph *taskGraph) *graphRun {
+	retVal := &graphRun{
+		graph:      graph,
+		waitingOn:  make(map[string]int),
+		dependents: make(map[string][]string),
+		results:    make(chan taskResult, len(graph.tasks)),
+		failures:   make(map[string]error),
+	}
+
+	for id, task := range graph.tasks {
+		retVal.waitingOn[id] = len(task.dependsOn)
+
+		for _, dependency := range task.dependsOn {
+			retVal.dependents[dependency] = append(retVal.dependents[dependency], id)
+		}
+	}
+
+	for id, count := range retVal.waitingOn {
+		if count == 0 {
+			retVal.start(id)
+		}
+	}
+
+	return retVal
+}
+
+// start runs the task on its own goethe thread
+func (run *graphRun) start(id string) {
+	userCall := run.graph.tasks[id].userCall
+
+	run.graph.parent.goClosure(func() {
+		_, err := callMethod(userCall, nil)
+
+		run.results <- taskResult{
+			id:  id,
+			err: err,
+		}
+	})
+}
+
+// wait collects the results of the tasks, starting each task once
+// all of its dependencies have succeeded
+func (run *graphRun) wait(timeout time.Duration) (map[string]error, error) {
+	timedOut := make(chan struct{})
+	if timeout > 0 {
+		timer := afterFunc(timeout, func() {
+			close(timedOut)
+		})
+		defer timer.Stop()
+	}
+
+	for run.finished < len(run.graph.tasks) {
+		select {
+		case result := <-run.results:
+			run.finished++
+
+			if result.err != nil {
+				run.failures[result.id] = result.err
+				run.skipDependents(result.id)
+				continue
+			}
+
+			for _, dependent := range run.dependents[result.id] {
+				if _, skipped := run.failures[dependent]; skipped {
+					continue
+				}
+
+				run.waitingOn[dependent]--
+				if run.waitingOn[dependent] == 0 {
+					run.start(dependent)
+				}
+			}
+		case <-timedOut:
+			return run.failures, ErrTaskGraphTimeout
+		}
+	}
+
+	return run.failures, nil
+}
+
+// skipDependents marks every task depending on the failed
+// task, directly or not, as finished without running it
+func (run *graphRun) skipDependents(failed string) {
+	for _, dependent := range run.dependents[failed] {
+		if _, skipped := run.failures[dependent]; skipped {
+			continue
+		}
+
+		run.failures[dependent] = fmt.Errorf("%w: %s", ErrDependencyFailed, failed)
+		run.finished++
+
+		run.skipDependents(dependent)
+	}
+}
@@ -0,0 +1,589 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// groupedTask is a function submitted with SubmitKeyed,
+// SubmitWithCategory or SubmitWeighted
+type groupedTask struct {
+	future      *futureImpl
+	userCall    interface{}
+	args        []reflect.Value
+	enqueueTime time.Time
+	weight      int64
+}
+
+// descriptor describes the function for the error information
+// of the pool, so that it can be given to Resubmit
+func (task *groupedTask) descriptor() *FunctionDescriptor {
+	args := make([]interface{}, len(task.args))
+	for index, arg := range task.args {
+		if arg.CanInterface() {
+			args[index] = arg.Interface()
+		}
+	}
+
+	return &FunctionDescriptor{
+		UserCall:    task.userCall,
+		Args:        args,
+		EnqueueTime: task.enqueueTime,
+	}
+}
+
+// taskGroup is the functions of one key or category that are
+// running and those waiting for one of those to finish
+type taskGroup struct {
+	running int
+	waiting []*groupedTask
+}
+
+func (threadPool *threadPool) SubmitKeyed(key string, userCall interface{}, args ...interface{}) (Future, error) {
+	return threadPool.submitGrouped(threadPool.runKeyed, key, userCall, args)
+}
+
+func (threadPool *threadPool) SubmitWithCategory(category string, userCall interface{}, args ...interface{}) (Future, error) {
+	return threadPool.submitGrouped(threadPool.runCategorized, category, userCall, args)
+}
+
+func (threadPool *threadPool) submitGrouped(runner interface{}, group string, userCall interface{},
+	args []interface{}) (Future, error) {
+	task, err := threadPool.newGroupedTask(userCall, args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = threadPool.enqueue(runner, group, task)
+	if err != nil {
+		return nil, err
+	}
+
+	return task.future, nil
+}
+
+func (threadPool *threadPool) newGroupedTask(userCall interface{}, args []interface{}) (*groupedTask, error) {
+	if threadPool.IsClosed() {
+		return nil, ErrPoolClosed
+	}
+
+	arguments, err := getValues(userCall, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &groupedTask{
+		future:      newFuture(),
+		userCall:    userCall,
+		args:        arguments,
+		enqueueTime: now(),
+	}, nil
+}
+
+func (threadPool *threadPool) SubmitOrdered(key string, userCall interface{}, args ...interface{}) (Future, error) {
+	task, err := threadPool.newGroupedTask(userCall, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// The function must join its key or be put on the queue before
+	// the next function of the key is submitted, so the order is kept
+	threadPool.swapMux.RLock()
+	defer threadPool.swapMux.RUnlock()
+
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	if threadPool.closed {
+		return nil, ErrPoolClosed
+	}
+
+	group, found := threadPool.orderedGroups[key]
+	if found {
+		group.waiting = append(group.waiting, task)
+
+		return task.future, nil
+	}
+
+	err = threadPool.functionalQueue.Enqueue(threadPool.runOrdered, key, task)
+	if err != nil {
+		return nil, err
+	}
+
+	threadPool.orderedGroups[key] = &taskGroup{
+		running: 1,
+	}
+
+	return task.future, nil
+}
+
+func (threadPool *threadPool) GetActiveKeyCount() int {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	return len(threadPool.orderedGroups)
+}
+
+func (threadPool *threadPool) SetOrderedRebalancing(rebalance bool) {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	threadPool.rebalance = rebalance
+}
+
+func (threadPool *threadPool) GetOrderedBacklogs() map[string]int {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	retVal := make(map[string]int)
+	for key, group := range threadPool.orderedGroups {
+		retVal[key] = len(group.waiting)
+	}
+
+	return retVal
+}
+
+// runOrdered is what the pool threads run for the function of a key given
+// to SubmitOrdered that is on the queue.  Functions of the key submitted
+// while it runs are run after it, in order, or put on the queue one at a
+// time when rebalancing.  Returns the error of the first function so it
+// goes to the error queue as usual
+func (threadPool *threadPool) runOrdered(key string, task *groupedTask) error {
+	tid := threadPool.parent.GetThreadID()
+
+	released := false
+	defer func() {
+		if !released {
+			threadPool.releaseOrdered(key)
+		}
+	}()
+
+	retVal := threadPool.runGrouped(tid, task)
+
+	for {
+		task = threadPool.nextOrdered(key)
+		if task == nil {
+			released = true
+
+			return retVal
+		}
+
+		threadPool.runWaitingTask(tid, task)
+	}
+}
+
+// releaseOrdered lets go of a key whose thread did not get to the end of
+// its functions
+func (threadPool *threadPool) releaseOrdered(key string) {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	delete(threadPool.orderedGroups, key)
+}
+
+// nextOrdered returns the next function of the key for this thread to run,
+// or nil if there is none or it was put on the queue for any thread
+func (threadPool *threadPool) nextOrdered(key string) *groupedTask {
+	threadPool.swapMux.RLock()
+	defer threadPool.swapMux.RUnlock()
+
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	group := threadPool.orderedGroups[key]
+	if len(group.waiting) == 0 {
+		delete(threadPool.orderedGroups, key)
+
+		return nil
+	}
+
+	task := group.waiting[0]
+	group.waiting[0] = nil
+	group.waiting = group.waiting[1:]
+
+	if threadPool.rebalance && !threadPool.closed {
+		// The key stays running while its next function is on the queue,
+		// so the functions after it keep waiting behind it
+		err := threadPool.functionalQueue.Enqueue(threadPool.runOrdered, key, task)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return task
+}
+
+func (threadPool *threadPool) SetDuplicateKeyPolicy(policy DuplicateKeyPolicy) {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	threadPool.keyPolicy = policy
+}
+
+func (threadPool *threadPool) GetRunningKeys() []string {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	retVal := make([]string, 0, len(threadPool.keyGroups))
+	for key := range threadPool.keyGroups {
+		retVal = append(retVal, key)
+	}
+
+	sort.Strings(retVal)
+
+	return retVal
+}
+
+func (threadPool *threadPool) SetCategoryLimit(category string, maxConcurrent int) error {
+	if maxConcurrent < 0 {
+		return fmt.Errorf("category limit less than zero %d", maxConcurrent)
+	}
+
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	if maxConcurrent == 0 {
+		delete(threadPool.categoryLimits, category)
+	} else {
+		threadPool.categoryLimits[category] = maxConcurrent
+	}
+
+	return nil
+}
+
+func (threadPool *threadPool) GetCategoryCounts() map[string]int {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	retVal := make(map[string]int)
+	for category, group := range threadPool.categoryGroups {
+		retVal[category] = group.running
+	}
+
+	return retVal
+}
+
+// runKeyed is what the pool threads run for keyed functions.  Only
+// one function of a key may run at a time
+func (threadPool *threadPool) runKeyed(key string, task *groupedTask) error {
+	threadPool.mux.Lock()
+	drop := threadPool.keyPolicy == DropDuplicateKey
+	threadPool.mux.Unlock()
+
+	return threadPool.runInGroup(threadPool.keyGroups, key, 1, drop, task)
+}
+
+// runCategorized is what the pool threads run for functions with a category
+func (threadPool *threadPool) runCategorized(category string, task *groupedTask) error {
+	threadPool.mux.Lock()
+	limit := threadPool.categoryLimits[category]
+	threadPool.mux.Unlock()
+
+	return threadPool.runInGroup(threadPool.categoryGroups, category, limit, false, task)
+}
+
+// runInGroup runs the task if fewer than limit functions of the group are
+// running (a limit of zero is no limit), along with any functions of the
+// group that were left waiting while it ran.  Otherwise the task is left for
+// a thread already running the group, or dropped.  Returns the error of the
+// task so it goes to the error queue as usual
+func (threadPool *threadPool) runInGroup(groups map[string]*taskGroup, name string, limit int,
+	drop bool, task *groupedTask) error {
+	threadPool.mux.Lock()
+
+	group, found := groups[name]
+	if !found {
+		group = &taskGroup{}
+		groups[name] = group
+	}
+
+	if limit > 0 && group.running >= limit {
+		if !drop {
+			group.waiting = append(group.waiting, task)
+		}
+		threadPool.mux.Unlock()
+
+		if drop {
+			task.future.setResults(nil, ErrDuplicateKey)
+		}
+
+		return nil
+	}
+
+	group.running++
+
+	threadPool.mux.Unlock()
+
+	tid := threadPool.parent.GetThreadID()
+
+	released := false
+	defer func() {
+		if released {
+			return
+		}
+
+		threadPool.mux.Lock()
+		threadPool.leaveGroup(groups, name, group)
+		threadPool.mux.Unlock()
+	}()
+
+	retVal := threadPool.runGrouped(tid, task)
+
+	for {
+		threadPool.mux.Lock()
+
+		if len(group.waiting) == 0 {
+			threadPool.leaveGroup(groups, name, group)
+			released = true
+			threadPool.mux.Unlock()
+
+			return retVal
+		}
+
+		task = group.waiting[0]
+		group.waiting[0] = nil
+		group.waiting = group.waiting[1:]
+
+		threadPool.mux.Unlock()
+
+		threadPool.runWaitingTask(tid, task)
+	}
+}
+
+// leaveGroup takes one running function out of the group, removing the
+// group once nothing in it is running.  Must have mutex held
+func (threadPool *threadPool) leaveGroup(groups map[string]*taskGroup, name string, group *taskGroup) {
+	group.running--
+	if group.running == 0 {
+		delete(groups, name)
+	}
+}
+
+// runGrouped runs a grouped function with panics handled the way the pool
+// threads handle them, so a panic ends only this function and not the
+// ones waiting behind it in its group
+func (threadPool *threadPool) runGrouped(tid int64, task *groupedTask) error {
+	var retVal error
+	panicErr := threadPool.runTask(tid, func() {
+		retVal = task.future.run(task.userCall, task.args)
+	})
+	if panicErr != nil {
+		return panicErr
+	}
+
+	return retVal
+}
+
+// runWaitingTask runs a function that had to wait for its group,
+// reporting its errors the same way the pool threads do
+func (threadPool *threadPool) runWaitingTask(tid int64, task *groupedTask) {
+	err := threadPool.runGrouped(tid, task)
+	if err == nil {
+		return
+	}
+
+	threadPool.reportError(newEnqueuedErrorinformation(tid, err, task.descriptor()))
+
+	threadPool.recordFailure(tid)
+}
+
+func (threadPool *threadPool) SubmitWeighted(weight int64, userCall interface{}, args ...interface{}) (Future, error) {
+	if weight < 0 {
+		return nil, fmt.Errorf("weight less than zero %d", weight)
+	}
+
+	task, err := threadPool.newGroupedTask(userCall, args)
+	if err != nil {
+		return nil, err
+	}
+
+	task.weight = weight
+
+	err = threadPool.enqueue(threadPool.runWeighted, task)
+	if err != nil {
+		return nil, err
+	}
+
+	return task.future, nil
+}
+
+func (threadPool *threadPool) SetMaxWeight(maxWeight int64) error {
+	if maxWeight < 0 {
+		return fmt.Errorf("maximum weight less than zero %d", maxWeight)
+	}
+
+	threadPool.mux.Lock()
+	threadPool.maxWeight = maxWeight
+	admitted := threadPool.admitWaitingWeighted()
+	threadPool.mux.Unlock()
+
+	threadPool.startAdmittedWeighted(admitted)
+
+	return nil
+}
+
+func (threadPool *threadPool) GetInFlightWeight() int64 {
+	threadPool.mux.Lock()
+	defer threadPool.mux.Unlock()
+
+	return threadPool.inFlightWeight
+}
+
+// runWeighted is what the pool threads run for weighted functions.  If the
+// function does not fit in the weight budget it is left for a thread that
+// frees up enough weight
+func (threadPool *threadPool) runWeighted(task *groupedTask) error {
+	threadPool.mux.Lock()
+
+	// Functions that are already waiting go first
+	if len(threadPool.weightWaiting) > 0 || !threadPool.fitsWeight(task.weight) {
+		threadPool.weightWaiting = append(threadPool.weightWaiting, task)
+		threadPool.mux.Unlock()
+
+		return nil
+	}
+
+	threadPool.inFlightWeight += task.weight
+
+	threadPool.mux.Unlock()
+
+	return threadPool.runAdmittedWeighted(task)
+}
+
+// runAdmittedWeighted runs a weighted function whose weight has already been
+// added to the weight in flight, and then whatever functions fit in the
+// weight it frees.  Returns the error of the first function
+func (threadPool *threadPool) runAdmittedWeighted(task *groupedTask) error {
+	tid := threadPool.parent.GetThreadID()
+
+	released := false
+	defer func() {
+		if released {
+			return
+		}
+
+		threadPool.mux.Lock()
+		threadPool.inFlightWeight -= task.weight
+		admitted := threadPool.admitWaitingWeighted()
+		threadPool.mux.Unlock()
+
+		threadPool.startAdmittedWeighted(admitted)
+	}()
+
+	retVal := threadPool.runGrouped(tid, task)
+
+	for {
+		threadPool.mux.Lock()
+		threadPool.inFlightWeight -= task.weight
+		released = true
+		admitted := threadPool.admitWaitingWeighted()
+		threadPool.mux.Unlock()
+
+		if len(admitted) == 0 {
+			return retVal
+		}
+
+		// This thread runs the first, others may be run by other threads
+		task = admitted[0]
+		released = false
+		threadPool.startAdmittedWeighted(admitted[1:])
+
+		threadPool.runWaitingTask(tid, task)
+	}
+}
+
+// fitsWeight returns true if a function of the given weight can run
+// now.  A function heavier than the whole budget runs when nothing
+// else is running.  Must have mutex held
+func (threadPool *threadPool) fitsWeight(weight int64) bool {
+	return threadPool.maxWeight <= 0 || threadPool.inFlightWeight == 0 ||
+		threadPool.inFlightWeight+weight <= threadPool.maxWeight
+}
+
+// admitWaitingWeighted takes the waiting weighted functions that now fit,
+// in order, and adds their weight to the weight in flight.  Must have
+// mutex held
+func (threadPool *threadPool) admitWaitingWeighted() []*groupedTask {
+	retVal := make([]*groupedTask, 0)
+
+	for len(threadPool.weightWaiting) > 0 && threadPool.fitsWeight(threadPool.weightWaiting[0].weight) {
+		task := threadPool.weightWaiting[0]
+		threadPool.weightWaiting[0] = nil
+		threadPool.weightWaiting = threadPool.weightWaiting[1:]
+
+		threadPool.inFlightWeight += task.weight
+		retVal = append(retVal, task)
+	}
+
+	return retVal
+}
+
+// startAdmittedWeighted puts admitted weighted functions back on the
+// function queue for any thread to run.  Those that do not fit on the
+// queue are given the error and free their weight, which may admit
+// more of the waiting functions
+func (threadPool *threadPool) startAdmittedWeighted(admitted []*groupedTask) {
+	for len(admitted) > 0 {
+		var freed int64
+		for _, task := range admitted {
+			err := threadPool.enqueue(threadPool.runAdmittedWeighted, task)
+			if err == nil {
+				continue
+			}
+
+			task.future.setResults(nil, err)
+			freed += task.weight
+		}
+
+		if freed == 0 {
+			return
+		}
+
+		threadPool.mux.Lock()
+		threadPool.inFlightWeight -= freed
+		admitted = threadPool.admitWaitingWeighted()
+		threadPool.mux.Unlock()
+	}
+}
@@ -40,6 +40,12 @@
 
 package tests
 
+import (
+	"github.com/jwells131313/goethe"
+	"sync"
+	"time"
+)
+
 type dummyErrorInformation struct {
 	tid int64
 	err error
@@ -52,3 +58,134 @@ func (dei *dummyErrorInformation) GetThreadID() int64 {
 func (dei *dummyErrorInformation) GetError() error {
 	return dei.err
 }
+
+func (dei *dummyErrorInformation) GetThreadName() string {
+	return ""
+}
+
+func (dei *dummyErrorInformation) GetEnqueueTime() time.Time {
+	return time.Time{}
+}
+
+func (dei *dummyErrorInformation) GetFunction() (interface{}, []interface{}) {
+	return nil, nil
+}
+
+// fakeClock only moves when advance is called
+type fakeClock struct {
+	mux     sync.Mutex
+	current time.Time
+	timers  []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	fireAt  time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		current: time.Now(),
+	}
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+
+	return fc.current
+}
+
+func (fc *fakeClock) AfterFunc(duration time.Duration, f func()) goethe.ClockTimer {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+
+	retVal := &fakeTimer{
+		clock:  fc,
+		fireAt: fc.current.Add(duration),
+		f:      f,
+	}
+
+	fc.timers = append(fc.timers, retVal)
+
+	return retVal
+}
+
+func (fc *fakeClock) Sleep(duration time.Duration) {
+	done := make(chan bool)
+
+	fc.AfterFunc(duration, func() {
+		close(done)
+	})
+
+	<-done
+}
+
+// advance moves the clock forward, firing every timer that is now due
+func (fc *fakeClock) advance(duration time.Duration) {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+
+	fc.current = fc.current.Add(duration)
+
+	remaining := make([]*fakeTimer, 0)
+	for _, timer := range fc.timers {
+		if timer.stopped {
+			continue
+		}
+
+		if timer.fireAt.After(fc.current) {
+			remaining = append(remaining, timer)
+			continue
+		}
+
+		timer.stopped = true
+		go timer.f()
+	}
+
+	fc.timers = remaining
+}
+
+// advanceUntil moves the clock forward a step at a time, giving the
+// goroutines it wakes a moment to run after each step, until done
+// returns true.  Returns false if done never did
+func (fc *fakeClock) advanceUntil(step time.Duration, done func() bool) bool {
+	for lcv := 0; lcv < 200; lcv++ {
+		if done() {
+			return true
+		}
+
+		fc.advance(step)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return done()
+}
+
+// installFakeClock makes a new fake clock the clock of goethe.  The
+// returned function puts the default clock back and releases anything
+// still waiting on the fake one
+func installFakeClock() (*fakeClock, func()) {
+	ethe := goethe.GetGoethe()
+
+	clock := newFakeClock()
+	ethe.SetClock(clock)
+
+	return clock, func() {
+		ethe.SetClock(nil)
+
+		clock.advance(24 * time.Hour)
+	}
+}
+
+func (ft *fakeTimer) Stop() bool {
+	ft.clock.mux.Lock()
+	defer ft.clock.mux.Unlock()
+
+	wasRunning := !ft.stopped
+	ft.stopped = true
+
+	return wasRunning
+}
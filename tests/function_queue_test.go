@@ -42,9 +42,11 @@ package tests
 
 import (
 	"errors"
+	"fmt"
 	"github.com/jwells131313/goethe"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -168,19 +170,37 @@ func TestFQCapacityWorks(t *testing.T) {
 func TestFQEmptyQueueBlocks(t *testing.T) {
 	funcQueue := goethe.NewBoundedFunctionQueue(10)
 
-	current := time.Now()
+	clock, restore := installFakeClock()
+	defer restore()
 
-	_, err := funcQueue.Dequeue(2 * time.Second)
-	if err == nil {
-		t.Error("Expected an error after waiting two seconds")
+	current := clock.Now()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := funcQueue.Dequeue(2 * time.Second)
+		errs <- err
+	}()
+
+	var err error
+	returned := clock.advanceUntil(500*time.Millisecond, func() bool {
+		select {
+		case err = <-errs:
+			return true
+		default:
+			return false
+		}
+	})
+	if !returned {
+		t.Error("Dequeue did not return when the clock moved past its timeout")
 		return
 	}
+
 	if err != goethe.ErrEmptyQueue {
 		t.Errorf("unexpected exception %v", err)
 		return
 	}
 
-	elapsed := time.Since(current)
+	elapsed := clock.Now().Sub(current)
 	if elapsed < (2 * time.Second) {
 		t.Errorf("should have waited two seconds, only waited %d", elapsed)
 		return
@@ -207,7 +227,10 @@ func TestFQQueueBlocksUntilDataEnqueued(t *testing.T) {
 		return nil
 	}
 
-	current := time.Now()
+	clock, restore := installFakeClock()
+	defer restore()
+
+	current := clock.Now()
 
 	errorOutput := make(chan error)
 
@@ -283,7 +306,7 @@ func TestFQQueueBlocksUntilDataEnqueued(t *testing.T) {
 		return
 	})
 
-	time.Sleep(2 * time.Second)
+	clock.advance(2 * time.Second)
 
 	// nap time is over, send the function
 	err := funcQueue.Enqueue(f)
@@ -304,7 +327,7 @@ func TestFQQueueBlocksUntilDataEnqueued(t *testing.T) {
 		return
 	}
 
-	elapsed := time.Since(current)
+	elapsed := clock.Now().Sub(current)
 
 	if elapsed < (2 * time.Second) {
 		t.Errorf("Should have waited at least two seconds %d", elapsed)
@@ -317,3 +340,530 @@ func TestFQQueueBlocksUntilDataEnqueued(t *testing.T) {
 
 	t.Logf("Actual elapsedTime %d", elapsed)
 }
+
+func TestRingBufferFQWrapsAround(t *testing.T) {
+	funcQueue := goethe.NewRingBufferFunctionQueue(3)
+	f := func(a, b int) {}
+
+	next := 0
+	for round := 0; round < 5; round++ {
+		for lcv := 0; lcv < 3; lcv++ {
+			err := funcQueue.Enqueue(f, next, lcv)
+			if err != nil {
+				t.Errorf("unexpected failure enqueing up to capacity %v", err)
+				return
+			}
+			next++
+		}
+
+		err := funcQueue.Enqueue(f, 0, 0)
+		if err != goethe.ErrAtCapacity {
+			t.Errorf("expected ErrAtCapacity one past capacity, got %v", err)
+			return
+		}
+
+		// Take two off and put one back so the head keeps moving
+		for lcv := 0; lcv < 2; lcv++ {
+			if _, err = funcQueue.Dequeue(0); err != nil {
+				t.Errorf("unexpected dequeue failure %v", err)
+				return
+			}
+		}
+
+		for !funcQueue.IsEmpty() {
+			if _, err = funcQueue.Dequeue(0); err != nil {
+				t.Errorf("unexpected dequeue failure %v", err)
+				return
+			}
+		}
+	}
+
+	for lcv := 0; lcv < 3; lcv++ {
+		funcQueue.Enqueue(f, lcv, 0)
+	}
+
+	for lcv := 0; lcv < 3; lcv++ {
+		descriptor, err := funcQueue.Dequeue(0)
+		if err != nil {
+			t.Errorf("unexpected dequeue failure %v", err)
+			return
+		}
+
+		if descriptor.Args[0] != lcv {
+			t.Errorf("expected functions in order, got %v at %d", descriptor.Args[0], lcv)
+			return
+		}
+	}
+}
+
+func TestRingBufferFQBlocksUntilDataEnqueued(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewRingBufferFunctionQueue(10)
+	f := func(a, b int) {}
+
+	_, err := funcQueue.Dequeue(100 * time.Millisecond)
+	if err != goethe.ErrEmptyQueue {
+		t.Errorf("expected ErrEmptyQueue from empty queue, got %v", err)
+		return
+	}
+
+	results := make(chan error)
+	ethe.Go(func() {
+		_, err := funcQueue.Dequeue(10 * time.Second)
+		results <- err
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	funcQueue.Enqueue(f, 1, 2)
+
+	err = <-results
+	if err != nil {
+		t.Errorf("dequeue should have gotten the function %v", err)
+	}
+}
+
+func TestFQOrderAcrossProducers(t *testing.T) {
+	const producers = 10
+	const perProducer = 200
+
+	queues := map[string]goethe.FunctionQueue{
+		"bounded":     goethe.NewBoundedFunctionQueue(producers * perProducer),
+		"ring buffer": goethe.NewRingBufferFunctionQueue(producers * perProducer),
+	}
+
+	f := func(producer, sequence int) {}
+
+	for kind, funcQueue := range queues {
+		start := make(chan bool)
+
+		var wg sync.WaitGroup
+		wg.Add(producers)
+
+		for producer := 0; producer < producers; producer++ {
+			go func(producer int) {
+				defer wg.Done()
+
+				<-start
+				for sequence := 0; sequence < perProducer; sequence++ {
+					funcQueue.Enqueue(f, producer, sequence)
+				}
+			}(producer)
+		}
+
+		close(start)
+		wg.Wait()
+
+		next := make([]int, producers)
+		var last time.Time
+
+		for lcv := 0; lcv < producers*perProducer; lcv++ {
+			descriptor, err := funcQueue.Dequeue(0)
+			if err != nil {
+				t.Errorf("%s queue lost a function after %d %v", kind, lcv, err)
+				return
+			}
+
+			producer := descriptor.Args[0].(int)
+			sequence := descriptor.Args[1].(int)
+
+			if sequence != next[producer] {
+				t.Errorf("%s queue gave function %d of producer %d when %d was next",
+					kind, sequence, producer, next[producer])
+				return
+			}
+			next[producer]++
+
+			if descriptor.EnqueueTime.Before(last) {
+				t.Errorf("%s queue gave a function enqueued at %v after one enqueued at %v",
+					kind, descriptor.EnqueueTime, last)
+				return
+			}
+			last = descriptor.EnqueueTime
+		}
+	}
+}
+
+func BenchmarkBoundedFQ(b *testing.B) {
+	benchmarkFQ(b, goethe.NewBoundedFunctionQueue(100))
+}
+
+func BenchmarkRingBufferFQ(b *testing.B) {
+	benchmarkFQ(b, goethe.NewRingBufferFunctionQueue(100))
+}
+
+func benchmarkFQ(b *testing.B, funcQueue goethe.FunctionQueue) {
+	f := func() {}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for lcv := 0; lcv < b.N; lcv++ {
+		for inner := 0; inner < 50; inner++ {
+			funcQueue.Enqueue(f)
+		}
+
+		for inner := 0; inner < 50; inner++ {
+			funcQueue.Dequeue(0)
+		}
+	}
+}
+
+func TestFQForEach(t *testing.T) {
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+	f := func(a int) {}
+
+	for lcv := 0; lcv < 5; lcv++ {
+		funcQueue.Enqueue(f, lcv)
+	}
+
+	seen := make([]int, 0)
+	funcQueue.ForEach(func(descriptor goethe.FunctionDescriptor) bool {
+		seen = append(seen, descriptor.Args[0].(int))
+
+		return len(seen) < 3
+	})
+
+	if len(seen) != 3 {
+		t.Errorf("expected iteration to stop after three, saw %v", seen)
+		return
+	}
+
+	for index, value := range seen {
+		if value != index {
+			t.Errorf("expected functions in queue order, saw %v", seen)
+			return
+		}
+	}
+
+	if funcQueue.GetSize() != 5 {
+		t.Errorf("ForEach should not change the queue, size is %d", funcQueue.GetSize())
+	}
+}
+
+func TestFQDequeueWithFakeClock(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	clock := newFakeClock()
+	ethe.SetClock(clock)
+	defer func() {
+		ethe.SetClock(nil)
+
+		// Release anything else that started waiting on the fake clock
+		clock.advance(24 * time.Hour)
+	}()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	results := make(chan error)
+	go func() {
+		_, err := funcQueue.Dequeue(1 * time.Hour)
+		results <- err
+	}()
+
+	for lcv := 0; lcv < 50; lcv++ {
+		clock.advance(1 * time.Hour)
+
+		select {
+		case err := <-results:
+			if err != goethe.ErrEmptyQueue {
+				t.Errorf("expected ErrEmptyQueue after an hour, got %v", err)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+			break
+		}
+	}
+
+	t.Error("dequeue did not time out when the clock moved forward")
+}
+
+func TestFQClear(t *testing.T) {
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+	f := func(a int) {}
+
+	funcQueue.Enqueue(f, 1)
+	funcQueue.Enqueue(f, 2)
+
+	callbackErrors := make(chan error, 1)
+	funcQueue.EnqueueWithCallback(func(results []interface{}, err error) {
+		callbackErrors <- err
+	}, f, 3)
+
+	if cleared := funcQueue.Clear(); cleared != 3 {
+		t.Errorf("expected three functions cleared, got %d", cleared)
+		return
+	}
+
+	if err := <-callbackErrors; err != goethe.ErrCleared {
+		t.Errorf("expected ErrCleared in the callback, got %v", err)
+		return
+	}
+
+	if !funcQueue.IsEmpty() {
+		t.Errorf("queue should be empty after Clear, size is %d", funcQueue.GetSize())
+		return
+	}
+
+	if cleared := funcQueue.Clear(); cleared != 0 {
+		t.Errorf("nothing should be cleared from an empty queue, got %d", cleared)
+		return
+	}
+
+	funcQueue.Enqueue(f, 4)
+
+	descriptor, err := funcQueue.Dequeue(0)
+	if err != nil || descriptor.Args[0] != 4 {
+		t.Errorf("queue should work after Clear, got %v %v", descriptor, err)
+	}
+}
+
+func TestFQTryDequeue(t *testing.T) {
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	if descriptor, found := funcQueue.TryDequeue(); found {
+		t.Errorf("empty queue returned %v", descriptor)
+		return
+	}
+
+	f := func(a int) {}
+	done := make(chan bool)
+
+	go func() {
+		for lcv := 0; lcv < 100; lcv++ {
+			for funcQueue.Enqueue(f, lcv) == goethe.ErrAtCapacity {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		close(done)
+	}()
+
+	seen := 0
+	for seen < 100 {
+		descriptor, found := funcQueue.TryDequeue()
+		if !found {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+
+		if descriptor.Args[0] != seen {
+			t.Errorf("expected function %d, got %v", seen, descriptor.Args[0])
+			return
+		}
+
+		descriptor.Finished()
+		seen++
+	}
+
+	<-done
+
+	if _, found := funcQueue.TryDequeue(); found {
+		t.Error("queue should be empty")
+	}
+}
+
+func TestFQHighWatermark(t *testing.T) {
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	if err := funcQueue.SetHighWatermark(1.5, func(int) {}); err == nil {
+		t.Error("a fraction over one should be rejected")
+		return
+	}
+
+	reached := make(chan int, 10)
+	err := funcQueue.SetHighWatermark(0.5, func(size int) {
+		reached <- size
+	})
+	if err != nil {
+		t.Errorf("could not set watermark %v", err)
+		return
+	}
+
+	f := func() {}
+	for lcv := 0; lcv < 4; lcv++ {
+		funcQueue.Enqueue(f)
+	}
+
+	select {
+	case size := <-reached:
+		t.Errorf("watermark reached early at size %d", size)
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Crossing fires once, staying above does not fire again
+	for lcv := 0; lcv < 3; lcv++ {
+		funcQueue.Enqueue(f)
+	}
+
+	select {
+	case size := <-reached:
+		if size != 5 {
+			t.Errorf("expected watermark at size 5, got %d", size)
+			return
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("watermark never reached")
+		return
+	}
+
+	select {
+	case size := <-reached:
+		t.Errorf("watermark fired again at size %d while above it", size)
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Drop below and cross again
+	for lcv := 0; lcv < 3; lcv++ {
+		descriptor, _ := funcQueue.Dequeue(0)
+		descriptor.Finished()
+	}
+	funcQueue.Enqueue(f)
+
+	select {
+	case size := <-reached:
+		if size != 5 {
+			t.Errorf("expected watermark at size 5 again, got %d", size)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("watermark never reached a second time")
+	}
+}
+
+func TestFQDequeueWithDepth(t *testing.T) {
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	f := func(a int) {}
+	for lcv := 0; lcv < 3; lcv++ {
+		funcQueue.Enqueue(f, lcv)
+	}
+
+	for expected := 2; expected >= 0; expected-- {
+		descriptor, depth, err := funcQueue.DequeueWithDepth(0)
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+			return
+		}
+
+		if depth != expected {
+			t.Errorf("expected %d left after function %v, got %d", expected, descriptor.Args[0], depth)
+			return
+		}
+
+		descriptor.Finished()
+	}
+
+	descriptor, depth, err := funcQueue.DequeueWithDepth(10 * time.Millisecond)
+	if err != goethe.ErrEmptyQueue || descriptor != nil || depth != 0 {
+		t.Errorf("expected ErrEmptyQueue and no depth, got %v, %d and %v", descriptor, depth, err)
+	}
+}
+
+func flushFunction() {}
+
+func TestFQEnqueueIf(t *testing.T) {
+	funcQueue := goethe.NewBoundedFunctionQueue(5)
+
+	flushPointer := reflect.ValueOf(flushFunction).Pointer()
+
+	// Flush once there is some work, unless a flush is already waiting
+	flushNeeded := func(pending []goethe.FunctionDescriptor) bool {
+		for _, descriptor := range pending {
+			if reflect.ValueOf(descriptor.UserCall).Pointer() == flushPointer {
+				return false
+			}
+		}
+
+		return len(pending) >= 2
+	}
+
+	if enqueued, err := funcQueue.EnqueueIf(flushNeeded, flushFunction); enqueued || err != nil {
+		t.Errorf("expected no flush for an empty queue, got %v and %v", enqueued, err)
+		return
+	}
+
+	funcQueue.Enqueue(func() {})
+	funcQueue.Enqueue(func() {})
+
+	// Many at once, but only one flush gets in
+	var wg sync.WaitGroup
+	var flushes int32
+
+	for lcv := 0; lcv < 20; lcv++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			enqueued, err := funcQueue.EnqueueIf(flushNeeded, flushFunction)
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+				return
+			}
+
+			if enqueued {
+				atomic.AddInt32(&flushes, 1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if flushes != 1 || funcQueue.GetSize() != 3 {
+		t.Errorf("expected exactly one flush, got %d and a queue of %d", flushes, funcQueue.GetSize())
+		return
+	}
+
+	funcQueue.Enqueue(func() {})
+	funcQueue.Enqueue(func() {})
+
+	always := func(pending []goethe.FunctionDescriptor) bool {
+		return true
+	}
+
+	if enqueued, err := funcQueue.EnqueueIf(always, func() {}); enqueued || err != goethe.ErrAtCapacity {
+		t.Errorf("expected ErrAtCapacity from a full queue, got %v and %v", enqueued, err)
+	}
+}
+
+func TestFQClearReleasesHandedOffLock(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	queue := goethe.NewBoundedFunctionQueue(1)
+
+	errs := make(chan error)
+	ethe.Go(func() {
+		lock.WriteLock()
+
+		if err := queue.EnqueueWithWriteLock(lock, func() {}); err != nil {
+			errs <- err
+			return
+		}
+
+		// The lock belongs to the queued function now
+		if err := queue.EnqueueWithWriteLock(lock, func() {}); err != goethe.ErrLockHandedOff {
+			errs <- fmt.Errorf("expected ErrLockHandedOff handing the lock over twice, got %v", err)
+			return
+		}
+
+		errs <- nil
+	})
+
+	if err := <-errs; err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, held := lock.GetWriteOwner(); !held {
+		t.Errorf("expected the lock to be held for the queued function")
+		return
+	}
+
+	queue.Clear()
+
+	if owner, held := lock.GetWriteOwner(); held {
+		t.Errorf("expected clearing the queue to unlock the lock, held by %d", owner)
+	}
+}
@@ -0,0 +1,296 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package tests
+
+import (
+	"errors"
+	"github.com/jwells131313/goethe"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFutureGetResults(t *testing.T) {
+	pool := startFuturePool(t, "FutureResultsPool")
+	if pool == nil {
+		return
+	}
+	defer pool.Close()
+
+	future, err := pool.Submit(func(a, b int) (int, error) {
+		return a + b, nil
+	}, 1, 2)
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	results, err := future.Get(10 * time.Second)
+	if err != nil {
+		t.Errorf("unexpected error from future %v", err)
+		return
+	}
+
+	if len(results) != 2 || results[0].(int) != 3 {
+		t.Errorf("unexpected results %v", results)
+		return
+	}
+
+	if !future.IsComplete() {
+		t.Error("future should be complete")
+	}
+}
+
+func TestFutureGetTaskError(t *testing.T) {
+	pool := startFuturePool(t, "FutureErrorPool")
+	if pool == nil {
+		return
+	}
+	defer pool.Close()
+
+	taskError := errors.New("task failed")
+
+	future, err := pool.Submit(func() error {
+		return taskError
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	_, err = future.Get(10 * time.Second)
+	if err != taskError {
+		t.Errorf("expected the error of the task, got %v", err)
+	}
+}
+
+func TestFutureGetTimeout(t *testing.T) {
+	pool := startFuturePool(t, "FutureTimeoutPool")
+	if pool == nil {
+		return
+	}
+	defer pool.Close()
+
+	proceed := make(chan bool)
+
+	future, err := pool.Submit(func() (string, error) {
+		<-proceed
+		return "done", nil
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	_, err = future.Get(100 * time.Millisecond)
+	if err != goethe.ErrFutureTimeout {
+		t.Errorf("expected ErrFutureTimeout, got %v", err)
+		return
+	}
+
+	if future.IsComplete() {
+		t.Error("future should not be complete yet")
+		return
+	}
+
+	proceed <- true
+
+	results, err := future.Get(10 * time.Second)
+	if err != nil {
+		t.Errorf("unexpected error after task finished %v", err)
+		return
+	}
+
+	if results[0].(string) != "done" {
+		t.Errorf("unexpected results %v", results)
+	}
+}
+
+func TestSubmitToClosedPool(t *testing.T) {
+	pool := startFuturePool(t, "FutureClosedPool")
+	if pool == nil {
+		return
+	}
+
+	pool.Close()
+
+	_, err := pool.Submit(func() {})
+	if err != goethe.ErrPoolClosed {
+		t.Errorf("expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestFutureCloseReleasesResults(t *testing.T) {
+	pool := startFuturePool(t, "FutureReleasePool")
+	if pool == nil {
+		return
+	}
+	defer pool.Close()
+
+	type bigResult struct {
+		data []byte
+	}
+
+	var collected int32
+
+	future, err := pool.Submit(func() (*bigResult, error) {
+		result := &bigResult{
+			data: make([]byte, 10*1024*1024),
+		}
+
+		runtime.SetFinalizer(result, func(*bigResult) {
+			atomic.StoreInt32(&collected, 1)
+		})
+
+		return result, nil
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	results, err := future.Get(10 * time.Second)
+	if err != nil || len(results) != 2 {
+		t.Errorf("unexpected results %v %v", results, err)
+		return
+	}
+	results = nil
+
+	runtime.GC()
+	if atomic.LoadInt32(&collected) != 0 {
+		t.Error("result collected while the future still held it")
+		return
+	}
+
+	future.Close()
+
+	for lcv := 0; lcv < 200 && atomic.LoadInt32(&collected) == 0; lcv++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if atomic.LoadInt32(&collected) == 0 {
+		t.Error("result was not collected after the future was closed")
+		return
+	}
+
+	_, err = future.Get(0)
+	if err != goethe.ErrFutureClosed {
+		t.Errorf("expected ErrFutureClosed, got %v", err)
+	}
+
+	runtime.KeepAlive(future)
+}
+
+func TestFutureCloseWhileRunning(t *testing.T) {
+	pool := startFuturePool(t, "FutureCloseRunningPool")
+	if pool == nil {
+		return
+	}
+	defer pool.Close()
+
+	proceed := make(chan bool)
+
+	future, err := pool.Submit(func() (string, error) {
+		<-proceed
+		return "done", nil
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	got := make(chan error)
+	go func() {
+		_, err := future.Get(10 * time.Second)
+		got <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	future.Close()
+
+	select {
+	case err = <-got:
+	case <-time.After(5 * time.Second):
+		t.Error("Get did not return when the future was closed")
+		return
+	}
+
+	if err != goethe.ErrFutureClosed {
+		t.Errorf("expected ErrFutureClosed, got %v", err)
+		return
+	}
+
+	proceed <- true
+
+	for lcv := 0; lcv < 200 && !future.IsComplete(); lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !future.IsComplete() {
+		t.Error("future should be complete once its function finished")
+		return
+	}
+
+	_, err = future.Get(0)
+	if err != goethe.ErrFutureClosed {
+		t.Errorf("expected ErrFutureClosed after the function finished, got %v", err)
+	}
+}
+
+func startFuturePool(t *testing.T, name string) goethe.Pool {
+	ethe := goethe.GetGoethe()
+
+	pool, err := ethe.NewPool(name, 1, 2, 1*time.Minute, goethe.NewBoundedFunctionQueue(10), nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return nil
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return nil
+	}
+
+	return pool
+}
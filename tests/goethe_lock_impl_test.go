@@ -41,7 +41,10 @@
 package tests
 
 import (
+	"fmt"
 	"github.com/jwells131313/goethe"
+	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -183,6 +186,1697 @@ func TestReaderCanNotBecomeWriter(t *testing.T) {
 	t.Error("there was no error after 20 seconds")
 }
 
+// TestFailedWriteLockKeepsReadCount checks a reader that gets ErrReadLockHeld
+// still holds the read lock exactly as many times as it took it
+func TestFailedWriteLockKeepsReadCount(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	reply := make(chan error)
+	proceed := make(chan bool)
+
+	ethe.Go(func() {
+		lock.ReadLock()
+		lock.ReadLock()
+
+		if err := lock.WriteLock(); err != goethe.ErrReadLockHeld {
+			reply <- fmt.Errorf("expected ErrReadLockHeld, got %v", err)
+			return
+		}
+
+		if err := lock.ReadUnlock(); err != nil {
+			reply <- fmt.Errorf("first ReadUnlock failed %v", err)
+			return
+		}
+
+		reply <- nil
+		<-proceed
+
+		reply <- lock.ReadUnlock()
+	})
+
+	if err := <-reply; err != nil {
+		t.Error(err)
+		return
+	}
+
+	written := make(chan error, 1)
+	ethe.Go(func() {
+		err := lock.WriteLock()
+		if err == nil {
+			lock.WriteUnlock()
+		}
+
+		written <- err
+	})
+
+	// The reader still holds the read lock once
+	select {
+	case err := <-written:
+		t.Errorf("writer got in while the read lock was held once more, %v", err)
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(proceed)
+	if err := <-reply; err != nil {
+		t.Errorf("second ReadUnlock failed %v", err)
+		return
+	}
+
+	// Now the lock is free
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Errorf("could not take the write lock %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("write lock was still blocked by the reader")
+	}
+}
+
+func TestCheckNoLocksHeld(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	holding := make(chan int64)
+	proceed := make(chan bool)
+	released := make(chan bool)
+
+	ethe.Go(func() {
+		lock.WriteLock()
+		lock.ReadLock()
+
+		holding <- ethe.GetThreadID()
+
+		<-proceed
+
+		lock.ReadUnlock()
+		lock.WriteUnlock()
+
+		released <- true
+	})
+
+	holder := <-holding
+
+	err := ethe.CheckNoLocksHeld()
+	if err == nil {
+		t.Error("a lock is held so there should have been an error")
+		return
+	}
+
+	writeHeld := fmt.Sprintf("thread %d holds write lock", holder)
+	readHeld := fmt.Sprintf("thread %d holds read lock", holder)
+	if !strings.Contains(err.Error(), writeHeld) || !strings.Contains(err.Error(), readHeld) {
+		t.Errorf("error did not describe thread %d: %v", holder, err)
+		return
+	}
+
+	proceed <- true
+	<-released
+
+	// Other tests may have leaked locks, but ours must be gone
+	err = ethe.CheckNoLocksHeld()
+	if err != nil && strings.Contains(err.Error(), fmt.Sprintf("thread %d ", holder)) {
+		t.Errorf("lock should have been released: %v", err)
+		return
+	}
+}
+
+func TestTransferWriteLock(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	receiverTid := make(chan int64)
+	transferred := make(chan bool)
+	results := make(chan error, 2)
+
+	ethe.Go(func() {
+		receiverTid <- ethe.GetThreadID()
+
+		<-transferred
+
+		results <- lock.WriteUnlock()
+	})
+
+	ethe.Go(func() {
+		receiver := <-receiverTid
+
+		lock.WriteLock()
+
+		err := lock.TransferWriteLock(receiver)
+		if err != nil {
+			results <- err
+			return
+		}
+
+		// No longer the owner
+		results <- lock.WriteUnlock()
+
+		transferred <- true
+	})
+
+	err := <-results
+	if err != goethe.ErrWriteLockNotHeld {
+		t.Errorf("original owner should no longer hold the lock, got %v", err)
+		return
+	}
+
+	err = <-results
+	if err != nil {
+		t.Errorf("receiver should have been able to unlock %v", err)
+		return
+	}
+
+	errs := make(chan error)
+	ethe.Go(func() {
+		errs <- lock.TransferWriteLock(ethe.GetThreadID())
+	})
+
+	err = <-errs
+	if err != goethe.ErrWriteLockNotHeld {
+		t.Errorf("expected ErrWriteLockNotHeld from non-owner, got %v", err)
+	}
+}
+
+func TestTransferWriteLockToExitedThread(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	exited := make(chan int64)
+	ethe.Go(func() {
+		exited <- ethe.GetThreadID()
+	})
+
+	target := <-exited
+
+	for lcv := 0; lcv < 200; lcv++ {
+		err := goethe.NewBoundedFunctionQueue(1).EnqueueToThread(target, func() {})
+		if err == goethe.ErrNoSuchThread {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	errs := make(chan error)
+	ethe.Go(func() {
+		lock.WriteLock()
+		defer lock.WriteUnlock()
+
+		errs <- lock.TransferWriteLock(target)
+	})
+
+	err := <-errs
+	if err != goethe.ErrNoSuchThread {
+		t.Errorf("expected ErrNoSuchThread, got %v", err)
+	}
+}
+
+func TestWaitUntilUnlocked(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	if err := lock.WaitUntilUnlocked(0); err != nil {
+		t.Errorf("unheld lock should not have to wait %v", err)
+		return
+	}
+
+	holding := make(chan bool)
+	proceed := make(chan bool)
+
+	ethe.Go(func() {
+		lock.WriteLock()
+		defer lock.WriteUnlock()
+
+		holding <- true
+		<-proceed
+	})
+
+	<-holding
+
+	err := lock.WaitUntilUnlocked(100 * time.Millisecond)
+	if err != goethe.ErrLockTimeout {
+		t.Errorf("expected ErrLockTimeout while lock is held, got %v", err)
+		return
+	}
+
+	waited := make(chan error)
+	go func() {
+		waited <- lock.WaitUntilUnlocked(20 * time.Second)
+	}()
+
+	proceed <- true
+
+	err = <-waited
+	if err != nil {
+		t.Errorf("should have seen the lock become free %v", err)
+	}
+}
+
+func TestTryUpgradeTwoReaders(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	reading := make(chan bool)
+	upgrade := make(chan bool)
+	results := make(chan bool, 2)
+	errs := make(chan error, 2)
+
+	for lcv := 0; lcv < 2; lcv++ {
+		ethe.Go(func() {
+			lock.ReadLock()
+			reading <- true
+			<-upgrade
+
+			upgraded, err := lock.TryUpgradeReadToWriteLock(20 * time.Second)
+			if err != nil {
+				errs <- err
+			}
+
+			if upgraded {
+				lock.WriteUnlock()
+			}
+			lock.ReadUnlock()
+
+			results <- upgraded
+		})
+	}
+
+	<-reading
+	<-reading
+	close(upgrade)
+
+	first := <-results
+	second := <-results
+
+	if len(errs) > 0 {
+		t.Errorf("unexpected error from upgrade %v", <-errs)
+		return
+	}
+
+	if first || !second {
+		t.Errorf("expected the first reader to give up and the second to upgrade, got %v and %v",
+			first, second)
+	}
+}
+
+func TestTryUpgradeTimeout(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	reading := make(chan bool)
+	proceed := make(chan bool)
+
+	ethe.Go(func() {
+		lock.ReadLock()
+		defer lock.ReadUnlock()
+
+		reading <- true
+		<-proceed
+	})
+
+	<-reading
+	defer close(proceed)
+
+	results := make(chan error)
+	ethe.Go(func() {
+		lock.ReadLock()
+		defer lock.ReadUnlock()
+
+		upgraded, err := lock.TryUpgradeReadToWriteLock(100 * time.Millisecond)
+		if err != nil {
+			results <- err
+			return
+		}
+		if upgraded {
+			lock.WriteUnlock()
+			results <- fmt.Errorf("should not have upgraded while another reader was in")
+			return
+		}
+
+		// Still holding the read lock
+		results <- lock.WriteLock()
+	})
+
+	err := <-results
+	if err != goethe.ErrReadLockHeld {
+		t.Errorf("expected to still hold the read lock after timeout, got %v", err)
+	}
+}
+
+func TestTryUpgradeWithoutReadLock(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	results := make(chan error)
+	ethe.Go(func() {
+		_, err := lock.TryUpgradeReadToWriteLock(0)
+		results <- err
+	})
+
+	err := <-results
+	if err != goethe.ErrReadLockNotHeld {
+		t.Errorf("expected ErrReadLockNotHeld, got %v", err)
+	}
+}
+
+func TestNonReentrantLock(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLockWithOptions(goethe.LockOptions{
+		NonReentrant: true,
+	})
+
+	results := make(chan error, 3)
+	ethe.Go(func() {
+		results <- lock.WriteLock()
+		results <- lock.WriteLock()
+		results <- lock.WriteUnlock()
+	})
+
+	if err := <-results; err != nil {
+		t.Errorf("first WriteLock should have worked %v", err)
+		return
+	}
+
+	if err := <-results; err != goethe.ErrAlreadyHeld {
+		t.Errorf("expected ErrAlreadyHeld from second WriteLock, got %v", err)
+		return
+	}
+
+	if err := <-results; err != nil {
+		t.Errorf("a single WriteUnlock should release the lock %v", err)
+		return
+	}
+
+	if err := lock.WaitUntilUnlocked(0); err != nil {
+		t.Errorf("lock should be free after one WriteUnlock %v", err)
+	}
+}
+
+func TestReadThenMaybeWrite(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	single := make(chan error)
+	ethe.Go(func() {
+		single <- lock.ReadThenMaybeWrite(func() bool {
+			return true
+		}, func() {
+			// The write lock must be held here
+			single <- lock.WaitUntilUnlocked(0)
+		})
+	})
+
+	if err := <-single; err != goethe.ErrLockTimeout {
+		t.Errorf("write should have been run holding the write lock, got %v", err)
+		return
+	}
+
+	if err := <-single; err != nil {
+		t.Errorf("unexpected error from single thread %v", err)
+		return
+	}
+
+	var initialized int32
+	var writes int32
+
+	reading := make(chan bool)
+	proceed := make(chan bool)
+	results := make(chan error, 5)
+
+	for lcv := 0; lcv < 5; lcv++ {
+		ethe.Go(func() {
+			results <- lock.ReadThenMaybeWrite(func() bool {
+				reading <- true
+				<-proceed
+
+				return atomic.LoadInt32(&initialized) == 0
+			}, func() {
+				atomic.AddInt32(&writes, 1)
+				atomic.StoreInt32(&initialized, 1)
+			})
+		})
+	}
+
+	// All five are reading at once, so at most one can upgrade directly
+	for lcv := 0; lcv < 5; lcv++ {
+		<-reading
+	}
+	close(proceed)
+
+	go func() {
+		// Threads that fall back to the write lock read again
+		for range reading {
+		}
+	}()
+
+	for lcv := 0; lcv < 5; lcv++ {
+		if err := <-results; err != nil {
+			t.Errorf("unexpected error %v", err)
+			return
+		}
+	}
+	close(reading)
+
+	if writes != 1 {
+		t.Errorf("expected exactly one write, got %d", writes)
+	}
+
+	if err := lock.WaitUntilUnlocked(0); err != nil {
+		t.Errorf("lock should be free afterwards %v", err)
+	}
+}
+
+func TestLockerAdapters(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	results := make(chan error, 2)
+	ethe.Go(func() {
+		reader := lock.ReadLocker()
+		reader.Lock()
+
+		// Holding the read lock so the write lock is not available
+		results <- lock.WriteLock()
+
+		reader.Unlock()
+
+		writer := lock.WriteLocker()
+		writer.Lock()
+		results <- lock.WaitUntilUnlocked(0)
+		writer.Unlock()
+	})
+
+	if err := <-results; err != goethe.ErrReadLockHeld {
+		t.Errorf("read locker should hold the read lock, got %v", err)
+		return
+	}
+
+	if err := <-results; err != goethe.ErrLockTimeout {
+		t.Errorf("write locker should hold the write lock, got %v", err)
+		return
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("read locker should panic on a non-goethe thread")
+		}
+	}()
+
+	lock.ReadLocker().Lock()
+}
+
+func TestMaxRecursionDepth(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLockWithOptions(goethe.LockOptions{
+		MaxRecursionDepth: 3,
+	})
+
+	results := make(chan error, 2)
+	ethe.Go(func() {
+		for lcv := 0; lcv < 3; lcv++ {
+			if err := lock.WriteLock(); err != nil {
+				results <- err
+				return
+			}
+			defer lock.WriteUnlock()
+		}
+
+		results <- lock.WriteLock()
+	})
+
+	ethe.Go(func() {
+		for lcv := 0; lcv < 3; lcv++ {
+			if err := lock.ReadLock(); err != nil {
+				results <- err
+				return
+			}
+			defer lock.ReadUnlock()
+		}
+
+		results <- lock.ReadLock()
+	})
+
+	for lcv := 0; lcv < 2; lcv++ {
+		if err := <-results; err != goethe.ErrRecursionLimitExceeded {
+			t.Errorf("expected ErrRecursionLimitExceeded past the depth, got %v", err)
+		}
+	}
+}
+
+func TestGetWriteOwner(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	if _, held := lock.GetWriteOwner(); held {
+		t.Error("new lock should have no owner")
+		return
+	}
+
+	holding := make(chan int64)
+	proceed := make(chan bool)
+
+	ethe.Go(func() {
+		lock.WriteLock()
+		defer lock.WriteUnlock()
+
+		holding <- ethe.GetThreadID()
+		<-proceed
+	})
+
+	tid := <-holding
+
+	owner, held := lock.GetWriteOwner()
+	if !held || owner != tid {
+		t.Errorf("expected owner %d, got %d (%v)", tid, owner, held)
+	}
+
+	close(proceed)
+}
+
+func TestDumpLocks(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLockWithOptions(goethe.LockOptions{Name: "dumped"})
+
+	holding := make(chan int64)
+	proceed := make(chan bool)
+
+	ethe.Go(func() {
+		ethe.SetThreadName("dumpWriter")
+
+		lock.WriteLock()
+		defer lock.WriteUnlock()
+
+		holding <- ethe.GetThreadID()
+		<-proceed
+	})
+
+	tid := <-holding
+
+	readers := make(chan int64)
+	ethe.Go(func() {
+		readers <- ethe.GetThreadID()
+
+		lock.ReadLock()
+		lock.ReadUnlock()
+	})
+
+	reader := <-readers
+
+	writeHeld := fmt.Sprintf("write held by thread %d (dumpWriter) (count 1)", tid)
+	readWaiting := fmt.Sprintf("thread %d waiting for read", reader)
+
+	var dump string
+	for lcv := 0; lcv < 200; lcv++ {
+		dump = ethe.DumpLocks()
+		if strings.Contains(dump, readWaiting) {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	close(proceed)
+
+	if !strings.Contains(dump, writeHeld) {
+		t.Errorf("expected %s in dump:\n%s", writeHeld, dump)
+		return
+	}
+	if !strings.Contains(dump, readWaiting) {
+		t.Errorf("expected %s in dump:\n%s", readWaiting, dump)
+		return
+	}
+	if !strings.Contains(dump, "(dumped):") {
+		t.Errorf("expected the name of the lock in dump:\n%s", dump)
+		return
+	}
+}
+
+func TestGetLockState(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	holding := make(chan int64)
+	proceed := make(chan bool)
+
+	ethe.Go(func() {
+		lock.ReadLock()
+		lock.ReadLock()
+
+		holding <- ethe.GetThreadID()
+		<-proceed
+
+		lock.ReadUnlock()
+		lock.ReadUnlock()
+	})
+
+	reader := <-holding
+
+	waiterIDs := make(chan int64)
+	waitFor := func(count int) goethe.LockState {
+		state := lock.GetLockState()
+		for lcv := 0; lcv < 200 && len(state.Waiters) < count; lcv++ {
+			time.Sleep(10 * time.Millisecond)
+			state = lock.GetLockState()
+		}
+
+		return state
+	}
+
+	done := make(chan bool)
+	ethe.Go(func() {
+		waiterIDs <- ethe.GetThreadID()
+
+		lock.WriteLock()
+		lock.WriteLock()
+
+		holding <- ethe.GetThreadID()
+		<-proceed
+
+		lock.WriteUnlock()
+		lock.WriteUnlock()
+		done <- true
+	})
+
+	writer := <-waiterIDs
+	waitFor(1)
+
+	// Held back by the waiting writer
+	ethe.Go(func() {
+		waiterIDs <- ethe.GetThreadID()
+
+		lock.ReadLock()
+		lock.ReadUnlock()
+		done <- true
+	})
+
+	late := <-waiterIDs
+	state := waitFor(2)
+
+	expected := goethe.LockState{
+		ReadHolders: map[int64]int32{reader: 2},
+		Waiters: []goethe.LockWaiter{
+			{ThreadID: writer, Mode: "write"},
+			{ThreadID: late, Mode: "read"},
+		},
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Errorf("expected state %+v, got %+v", expected, state)
+		return
+	}
+
+	proceed <- true
+	<-holding
+
+	state = lock.GetLockState()
+	if state.WriteOwner != writer || state.WriteCount != 2 || len(state.ReadHolders) != 0 {
+		t.Errorf("expected the writer to hold the lock twice, got %+v", state)
+		return
+	}
+
+	close(proceed)
+	<-done
+	<-done
+
+	state = lock.GetLockState()
+	if state.WriteCount != 0 || len(state.ReadHolders) != 0 || len(state.Waiters) != 0 {
+		t.Errorf("expected nobody to hold or wait for the lock, got %+v", state)
+	}
+}
+
+func TestFairLockPriority(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLockWithOptions(goethe.LockOptions{
+		Fair: true,
+	})
+
+	holding := make(chan bool)
+	proceed := make(chan bool)
+
+	ethe.Go(func() {
+		lock.WriteLock()
+		defer lock.WriteUnlock()
+
+		holding <- true
+		<-proceed
+	})
+
+	<-holding
+
+	order := make(chan string, 3)
+	waitInLine := func(name string, priority int) {
+		tids := make(chan int64)
+
+		ethe.Go(func() {
+			tids <- ethe.GetThreadID()
+
+			lock.WriteLockWithPriority(priority)
+			defer lock.WriteUnlock()
+
+			order <- name
+		})
+
+		waiting := fmt.Sprintf("thread %d waiting for write", <-tids)
+		for lcv := 0; lcv < 200 && !strings.Contains(ethe.DumpLocks(), waiting); lcv++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitInLine("lowFirst", 1)
+	waitInLine("lowSecond", 1)
+	waitInLine("high", 10)
+
+	close(proceed)
+
+	for _, expected := range []string{"high", "lowFirst", "lowSecond"} {
+		if got := <-order; got != expected {
+			t.Errorf("expected %s to get the lock next, got %s", expected, got)
+			return
+		}
+	}
+
+	if reorders := lock.GetStats().PriorityReorders; reorders != 1 {
+		t.Errorf("expected one priority reorder, got %d", reorders)
+		return
+	}
+
+	if reorders := lock.ResetStats().PriorityReorders; reorders != 1 {
+		t.Errorf("expected ResetStats to return one priority reorder, got %d", reorders)
+		return
+	}
+
+	if reorders := lock.GetStats().PriorityReorders; reorders != 0 {
+		t.Errorf("expected no priority reorders after ResetStats, got %d", reorders)
+	}
+}
+
+func TestWithLockPriority(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLockWithOptions(goethe.LockOptions{
+		Fair: true,
+	})
+
+	holding := make(chan bool)
+	proceed := make(chan bool)
+
+	ethe.Go(func() {
+		lock.WriteLock()
+		defer lock.WriteUnlock()
+
+		holding <- true
+		<-proceed
+	})
+
+	<-holding
+
+	order := make(chan string, 3)
+	waitInLine := func(name string, priority int) {
+		tids := make(chan int64)
+
+		ethe.Go(func() {
+			tids <- ethe.GetThreadID()
+
+			ethe.WithLockPriority(priority, func() {
+				// The inner priority is gone once the nested call returns
+				ethe.WithLockPriority(-priority, func() {})
+
+				lock.WriteLock()
+				defer lock.WriteUnlock()
+
+				order <- name
+			})
+		})
+
+		waiting := fmt.Sprintf("thread %d waiting for write", <-tids)
+		for lcv := 0; lcv < 200 && !strings.Contains(ethe.DumpLocks(), waiting); lcv++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitInLine("lowFirst", 1)
+	waitInLine("lowSecond", 1)
+	waitInLine("high", 10)
+
+	close(proceed)
+
+	for _, expected := range []string{"high", "lowFirst", "lowSecond"} {
+		if got := <-order; got != expected {
+			t.Errorf("expected %s to get the lock next, got %s", expected, got)
+			return
+		}
+	}
+
+	if err := ethe.WithLockPriority(1, func() {}); err != goethe.ErrNotGoetheThread {
+		t.Errorf("expected ErrNotGoetheThread off a goethe thread, got %v", err)
+	}
+}
+
+func TestLockDefaultTimeouts(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLockWithTimeouts(100*time.Millisecond, 100*time.Millisecond)
+
+	holding := make(chan bool)
+	proceed := make(chan bool)
+
+	ethe.Go(func() {
+		lock.WriteLock()
+		defer lock.WriteUnlock()
+
+		holding <- true
+		<-proceed
+	})
+
+	<-holding
+
+	errs := make(chan error)
+	ethe.Go(func() {
+		errs <- lock.ReadLock()
+		errs <- lock.WriteLock()
+	})
+
+	for _, kind := range []string{"read", "write"} {
+		if err := <-errs; err != goethe.ErrLockTimeout {
+			t.Errorf("expected %s lock to time out, got %v", kind, err)
+			return
+		}
+	}
+
+	close(proceed)
+
+	// The writer that timed out must not keep holding back readers
+	ethe.Go(func() {
+		err := lock.ReadLock()
+		if err == nil {
+			lock.ReadUnlock()
+		}
+
+		errs <- err
+	})
+
+	if err := <-errs; err != nil {
+		t.Errorf("read lock after the writer left failed %v", err)
+	}
+}
+
+func TestLockSpinCount(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	for _, spinCount := range []int{-1, 0, 1000000} {
+		lock := ethe.NewGoetheLockWithOptions(goethe.LockOptions{
+			SpinCount:    spinCount,
+			WriteTimeout: 100 * time.Millisecond,
+		})
+
+		holding := make(chan bool)
+		proceed := make(chan bool)
+
+		ethe.Go(func() {
+			lock.WriteLock()
+			defer lock.WriteUnlock()
+
+			holding <- true
+			<-proceed
+		})
+
+		<-holding
+
+		errs := make(chan error)
+		ethe.Go(func() {
+			// Spinning does not outlast the timeout
+			errs <- lock.WriteLock()
+
+			err := lock.ReadLock()
+			if err == nil {
+				lock.ReadUnlock()
+			}
+
+			errs <- err
+		})
+
+		if err := <-errs; err != goethe.ErrLockTimeout {
+			t.Errorf("expected write lock with spin count %d to time out, got %v", spinCount, err)
+			return
+		}
+
+		close(proceed)
+
+		if err := <-errs; err != nil {
+			t.Errorf("read lock with spin count %d failed %v", spinCount, err)
+			return
+		}
+	}
+}
+
+func TestWriteThenRead(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	var value int
+	using := make(chan bool)
+	proceed := make(chan bool)
+	errs := make(chan error, 1)
+
+	ethe.Go(func() {
+		errs <- lock.WriteThenRead(func() {
+			value = 13
+		}, func() {
+			using <- true
+			<-proceed
+		})
+	})
+
+	<-using
+
+	readValues := make(chan int)
+	ethe.Go(func() {
+		lock.ReadLock()
+		defer lock.ReadUnlock()
+
+		readValues <- value
+	})
+
+	select {
+	case read := <-readValues:
+		if read != 13 {
+			t.Errorf("reader saw %d instead of the mutated value", read)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("reader was not let in while use was running")
+	}
+
+	writeDone := make(chan bool)
+	ethe.Go(func() {
+		lock.WriteLock()
+		lock.WriteUnlock()
+
+		close(writeDone)
+	})
+
+	select {
+	case <-writeDone:
+		t.Error("writer should wait for use to finish")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(proceed)
+
+	if err := <-errs; err != nil {
+		t.Errorf("unexpected error from WriteThenRead %v", err)
+		return
+	}
+
+	select {
+	case <-writeDone:
+	case <-time.After(10 * time.Second):
+		t.Error("writer did not get the lock after use finished")
+	}
+}
+
+func TestWriterNotStarvedByReaderStream(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	var stop int32
+	readersDone := make(chan bool)
+
+	const numReaders = 5
+	for lcv := 0; lcv < numReaders; lcv++ {
+		ethe.Go(func() {
+			defer func() {
+				readersDone <- true
+			}()
+
+			for atomic.LoadInt32(&stop) == 0 {
+				lock.ReadLock()
+				time.Sleep(time.Millisecond)
+
+				// Holders must be able to take it again while the writer waits
+				lock.ReadLock()
+				time.Sleep(time.Millisecond)
+				lock.ReadUnlock()
+
+				lock.ReadUnlock()
+			}
+		})
+	}
+
+	// Let the readers get going so that the lock is always read held
+	time.Sleep(50 * time.Millisecond)
+
+	acquired := make(chan time.Duration)
+	ethe.Go(func() {
+		start := time.Now()
+
+		lock.WriteLock()
+		defer lock.WriteUnlock()
+
+		acquired <- time.Since(start)
+	})
+
+	select {
+	case waited := <-acquired:
+		t.Logf("writer waited %v behind %d readers", waited, numReaders)
+	case <-time.After(2 * time.Second):
+		t.Error("writer was starved by the stream of readers")
+	}
+
+	atomic.StoreInt32(&stop, 1)
+	for lcv := 0; lcv < numReaders; lcv++ {
+		<-readersDone
+	}
+}
+
+func TestReadLockYieldPoint(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	var value int32
+	yields := make(chan bool, 1)
+	reading := make(chan bool)
+
+	ethe.Go(func() {
+		lock.ReadLock()
+		lock.ReadLock()
+		defer lock.ReadUnlock()
+		defer lock.ReadUnlock()
+
+		// Nobody is waiting yet
+		reading <- lock.ReadLockYieldPoint()
+
+		for lcv := 0; lcv < 1000; lcv++ {
+			if lock.ReadLockYieldPoint() {
+				// The writer went first
+				yields <- atomic.LoadInt32(&value) == 1
+				return
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		yields <- false
+	})
+
+	if <-reading {
+		t.Error("reader should not yield with no writer waiting")
+	}
+
+	ethe.Go(func() {
+		lock.WriteLock()
+		defer lock.WriteUnlock()
+
+		atomic.StoreInt32(&value, 1)
+	})
+
+	select {
+	case sawWrite := <-yields:
+		if !sawWrite {
+			t.Error("reader should have yielded to the writer")
+		}
+	case <-time.After(20 * time.Second):
+		t.Error("reader never yielded")
+	}
+}
+
+func TestLockBothInEitherOrder(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	a := ethe.NewGoetheLock()
+	b := ethe.NewGoetheLock()
+
+	var inside int32
+	errs := make(chan error, 2)
+
+	lockMany := func(first, second goethe.Lock) {
+		for lcv := 0; lcv < 200; lcv++ {
+			unlock, err := ethe.LockBoth(first, second)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			if atomic.AddInt32(&inside, 1) != 1 {
+				errs <- fmt.Errorf("two threads held both locks at once")
+				return
+			}
+			atomic.AddInt32(&inside, -1)
+
+			unlock()
+		}
+
+		errs <- nil
+	}
+
+	ethe.Go(lockMany, a, b)
+	ethe.Go(lockMany, b, a)
+
+	for lcv := 0; lcv < 2; lcv++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("LockBoth failed %v", err)
+				return
+			}
+		case <-time.After(10 * time.Second):
+			t.Error("threads locking in opposite orders deadlocked")
+			return
+		}
+	}
+
+	ethe.Go(func() {
+		unlock, err := ethe.LockBoth(a, a)
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		unlock()
+
+		// Taken once, so nothing is left held
+		errs <- ethe.CheckNoLocksHeld()
+	})
+
+	if err := <-errs; err != nil {
+		t.Errorf("LockBoth of the same lock twice failed %v", err)
+	}
+}
+
+func TestLockAll(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	a := ethe.NewGoetheLock()
+	b := ethe.NewGoetheLock()
+	c := ethe.NewGoetheLock()
+
+	errs := make(chan error, 2)
+
+	lockMany := func(locks ...goethe.Lock) {
+		for lcv := 0; lcv < 200; lcv++ {
+			unlock, err := ethe.LockAll(locks...)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			unlock()
+		}
+
+		errs <- ethe.CheckNoLocksHeld()
+	}
+
+	ethe.Go(func() {
+		lockMany(a, b, c, a)
+	})
+	ethe.Go(func() {
+		lockMany(c, b, a)
+	})
+
+	for lcv := 0; lcv < 2; lcv++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("LockAll failed %v", err)
+				return
+			}
+		case <-time.After(10 * time.Second):
+			t.Error("threads locking in opposite orders deadlocked")
+			return
+		}
+	}
+}
+
+func BenchmarkLockBoth(b *testing.B) {
+	ethe := goethe.GetGoethe()
+	first := ethe.NewGoetheLock()
+	second := ethe.NewGoetheLock()
+
+	benchmarkOnThread(b, func() {
+		unlock, _ := ethe.LockBoth(second, first)
+		unlock()
+	})
+}
+
+func BenchmarkLockAllOfTwo(b *testing.B) {
+	ethe := goethe.GetGoethe()
+	first := ethe.NewGoetheLock()
+	second := ethe.NewGoetheLock()
+
+	benchmarkOnThread(b, func() {
+		unlock, _ := ethe.LockAll(second, first)
+		unlock()
+	})
+}
+
+func BenchmarkContendedLock(b *testing.B) {
+	benchmarkContended(b, goethe.LockOptions{})
+}
+
+func BenchmarkContendedLockWithSpinning(b *testing.B) {
+	benchmarkContended(b, goethe.LockOptions{SpinCount: 4})
+}
+
+// benchmarkContended has several threads take the lock for write
+// over and over, each time only for a moment
+func benchmarkContended(b *testing.B, options goethe.LockOptions) {
+	const threads = 8
+
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLockWithOptions(options)
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+
+	counter := 0
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for thread := 0; thread < threads; thread++ {
+		ethe.Go(func() {
+			defer wg.Done()
+
+			for lcv := thread; lcv < b.N; lcv += threads {
+				lock.WriteLock()
+				counter++
+				lock.WriteUnlock()
+			}
+		})
+	}
+
+	wg.Wait()
+}
+
+func TestWriteVersion(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	errs := make(chan error)
+
+	ethe.Go(func() {
+		before := lock.GetWriteVersion()
+
+		lock.ReadLock()
+		lock.ReadUnlock()
+
+		if version := lock.GetWriteVersion(); version != before {
+			errs <- fmt.Errorf("a read changed the version from %d to %d", before, version)
+			return
+		}
+
+		// Only giving up the outermost write lock counts
+		lock.WriteLock()
+		lock.WriteLock()
+		lock.WriteUnlock()
+
+		if version := lock.GetWriteVersion(); version != before {
+			errs <- fmt.Errorf("a nested unlock changed the version from %d to %d", before, version)
+			return
+		}
+
+		lock.WriteUnlock()
+
+		if version := lock.GetWriteVersion(); version != before+1 {
+			errs <- fmt.Errorf("expected version %d after a write, got %d", before+1, version)
+			return
+		}
+
+		errs <- nil
+	})
+
+	if err := <-errs; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLockHoldTimes(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLockWithOptions(goethe.LockOptions{
+		RecordHoldTimes: true,
+	})
+
+	errs := make(chan error)
+
+	ethe.Go(func() {
+		for lcv := 0; lcv < 3; lcv++ {
+			lock.WriteLock()
+			lock.WriteUnlock()
+		}
+
+		// Nested locks are one hold
+		lock.WriteLock()
+		lock.WriteLock()
+		time.Sleep(50 * time.Millisecond)
+		lock.WriteUnlock()
+		lock.WriteUnlock()
+
+		lock.ReadLock()
+		lock.ReadLock()
+		time.Sleep(20 * time.Millisecond)
+		lock.ReadUnlock()
+		lock.ReadUnlock()
+
+		errs <- nil
+	})
+
+	if err := <-errs; err != nil {
+		t.Error(err)
+		return
+	}
+
+	stats := lock.GetStats()
+
+	writes := stats.WriteHoldTimes
+	if writes.Count != 4 {
+		t.Errorf("expected 4 write holds, got %d", writes.Count)
+		return
+	}
+	if writes.Max < 50*time.Millisecond {
+		t.Errorf("expected the longest write hold to be at least 50ms, got %s", writes.Max)
+		return
+	}
+	if writes.P50 > writes.P99 || writes.P99 > writes.Max {
+		t.Errorf("percentiles out of order %s %s %s", writes.P50, writes.P99, writes.Max)
+		return
+	}
+	if writes.P50 >= 50*time.Millisecond {
+		t.Errorf("expected the median write hold to be one of the short ones, got %s", writes.P50)
+		return
+	}
+
+	reads := stats.ReadHoldTimes
+	if reads.Count != 1 {
+		t.Errorf("expected 1 read hold, got %d", reads.Count)
+		return
+	}
+	if reads.Max < 20*time.Millisecond {
+		t.Errorf("expected the read hold to be at least 20ms, got %s", reads.Max)
+		return
+	}
+
+	if reset := lock.ResetStats(); reset.WriteHoldTimes.Count != 4 {
+		t.Errorf("expected reset to return the 4 write holds, got %d", reset.WriteHoldTimes.Count)
+		return
+	}
+
+	stats = lock.GetStats()
+	if stats.WriteHoldTimes != (goethe.HoldTimeStats{}) || stats.ReadHoldTimes != (goethe.HoldTimeStats{}) {
+		t.Errorf("expected hold times to be cleared by reset, got %v", stats)
+		return
+	}
+
+	// Not recorded unless asked for
+	plain := ethe.NewGoetheLock()
+	ethe.Go(func() {
+		plain.WriteLock()
+		plain.WriteUnlock()
+
+		errs <- nil
+	})
+	<-errs
+
+	if holds := plain.GetStats().WriteHoldTimes; holds.Count != 0 {
+		t.Errorf("expected no hold times without RecordHoldTimes, got %d", holds.Count)
+	}
+}
+
+func TestLockWaitStats(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	clock, restore := installFakeClock()
+	defer restore()
+
+	lock := ethe.NewGoetheLock()
+
+	held := make(chan bool)
+	release := make(chan bool)
+	done := make(chan bool)
+
+	ethe.Go(func() {
+		lock.WriteLock()
+		held <- true
+
+		<-release
+		lock.WriteUnlock()
+	})
+	<-held
+
+	ethe.Go(func() {
+		lock.ReadLock()
+		lock.ReadUnlock()
+
+		done <- true
+	})
+
+	for lcv := 0; lcv < 200 && len(lock.GetLockState().Waiters) == 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	clock.advance(3 * time.Second)
+	close(release)
+	<-done
+
+	stats := lock.GetStats()
+	if stats.Contended != 1 {
+		t.Errorf("expected one contended acquisition, got %d", stats.Contended)
+		return
+	}
+	if stats.TotalWait != 3*time.Second || stats.MaxWait != 3*time.Second {
+		t.Errorf("expected a wait of 3s, got total %s max %s", stats.TotalWait, stats.MaxWait)
+		return
+	}
+
+	// Nobody in the way, so nothing waited
+	ethe.Go(func() {
+		lock.WriteLock()
+		lock.WriteUnlock()
+
+		done <- true
+	})
+	<-done
+
+	if reset := lock.ResetStats(); reset.Contended != 1 {
+		t.Errorf("expected reset to return the one contended acquisition, got %d", reset.Contended)
+		return
+	}
+
+	stats = lock.GetStats()
+	if stats.Contended != 0 || stats.TotalWait != 0 || stats.MaxWait != 0 {
+		t.Errorf("expected wait statistics to be cleared by reset, got %v", stats)
+	}
+}
+
+func TestInterruptLockWaiter(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	for _, fair := range []bool{false, true} {
+		lock := ethe.NewGoetheLockWithOptions(goethe.LockOptions{
+			Fair: fair,
+		})
+
+		holding := make(chan bool)
+		proceed := make(chan bool)
+
+		ethe.Go(func() {
+			lock.WriteLock()
+			defer lock.WriteUnlock()
+
+			holding <- true
+			<-proceed
+		})
+
+		<-holding
+
+		results := make(chan error, 2)
+		waiter := func(acquire func() error) int64 {
+			tids := make(chan int64)
+
+			ethe.Go(func() {
+				tids <- ethe.GetThreadID()
+				results <- acquire()
+			})
+
+			return <-tids
+		}
+
+		for _, acquire := range []func() error{lock.WriteLock, lock.ReadLock} {
+			tid := waiter(acquire)
+
+			interrupted := false
+			for lcv := 0; lcv < 200 && !interrupted; lcv++ {
+				interrupted = ethe.InterruptThread(tid)
+				if !interrupted {
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+
+			if !interrupted {
+				t.Errorf("thread %d never waited for the lock (fair=%v)", tid, fair)
+				return
+			}
+
+			if err := <-results; err != goethe.ErrInterrupted {
+				t.Errorf("expected ErrInterrupted (fair=%v), got %v", fair, err)
+				return
+			}
+
+			if ethe.InterruptThread(tid) {
+				t.Errorf("thread %d is no longer waiting and should not be interrupted (fair=%v)", tid, fair)
+				return
+			}
+		}
+
+		close(proceed)
+
+		ethe.Go(func() {
+			err := lock.WriteLock()
+			if err == nil {
+				lock.WriteUnlock()
+			}
+
+			results <- err
+		})
+
+		if err := <-results; err != nil {
+			t.Errorf("lock should still work after interrupts (fair=%v), got %v", fair, err)
+			return
+		}
+	}
+}
+
+// TestLockOrdersMemoryForRaceDetector changes plain, unsynchronized
+// data under the write lock from many threads.  Run with go test -race,
+// which reports a race if the lock does not order the changes
+func TestLockOrderHandler(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	violations := make(chan goethe.LockOrderViolation, 10)
+	ethe.SetLockOrderHandler(func(violation goethe.LockOrderViolation) {
+		violations <- violation
+	})
+	defer ethe.SetLockOrderHandler(nil)
+
+	a := ethe.NewGoetheLockWithOptions(goethe.LockOptions{Name: "a"})
+	b := ethe.NewGoetheLockWithOptions(goethe.LockOptions{Name: "b"})
+	c := ethe.NewGoetheLockWithOptions(goethe.LockOptions{Name: "c"})
+
+	// Takes the first lock for write and the second for read, as the
+	// order matters whatever kind of lock is taken
+	takeInOrder := func(first, second goethe.Lock) int64 {
+		done := make(chan int64)
+
+		ethe.Go(func() {
+			first.WriteLock()
+			second.ReadLock()
+			second.ReadUnlock()
+			first.WriteUnlock()
+
+			done <- ethe.GetThreadID()
+		})
+
+		return <-done
+	}
+
+	earlier := takeInOrder(a, b)
+	takeInOrder(a, c)
+	takeInOrder(a, b)
+	takeInOrder(c, b)
+
+	select {
+	case violation := <-violations:
+		t.Errorf("locks taken in a consistent order were reported %v", violation)
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	later := takeInOrder(b, a)
+
+	var violation goethe.LockOrderViolation
+	select {
+	case violation = <-violations:
+	case <-time.After(10 * time.Second):
+		t.Error("locks taken in both orders were not reported")
+		return
+	}
+
+	if !strings.Contains(violation.FirstLock, "(b)") || !strings.Contains(violation.SecondLock, "(a)") {
+		t.Errorf("expected b then a, got %s then %s", violation.FirstLock, violation.SecondLock)
+		return
+	}
+
+	if violation.ThreadID != later || violation.EarlierThreadID != earlier {
+		t.Errorf("expected threads %d and %d, got %d and %d", later, earlier,
+			violation.ThreadID, violation.EarlierThreadID)
+		return
+	}
+
+	if !strings.Contains(violation.Stack, "TestLockOrderHandler") ||
+		!strings.Contains(violation.EarlierStack, "TestLockOrderHandler") {
+		t.Errorf("expected both stacks, got %s and %s", violation.Stack, violation.EarlierStack)
+		return
+	}
+
+	// Each pair is only reported once
+	takeInOrder(b, a)
+
+	select {
+	case violation = <-violations:
+		t.Errorf("the same pair was reported twice %v", violation)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLockOrdersMemoryForRaceDetector(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	for _, fair := range []bool{false, true} {
+		lock := ethe.NewGoetheLockWithOptions(goethe.LockOptions{
+			Fair: fair,
+		})
+
+		shared := make(map[int]int)
+		total := 0
+
+		var wg sync.WaitGroup
+		for thread := 0; thread < 8; thread++ {
+			wg.Add(1)
+
+			ethe.Go(func(thread int) {
+				defer wg.Done()
+
+				for lcv := 0; lcv < 50; lcv++ {
+					lock.WriteLock()
+					shared[thread]++
+					total++
+					lock.WriteUnlock()
+
+					lock.ReadLock()
+					_ = shared[thread] + total
+					lock.ReadUnlock()
+				}
+			}, thread)
+		}
+
+		wg.Wait()
+
+		var finalTotal int
+
+		wg.Add(1)
+		ethe.Go(func() {
+			defer wg.Done()
+
+			lock.ReadLock()
+			defer lock.ReadUnlock()
+
+			finalTotal = total
+		})
+
+		wg.Wait()
+
+		if finalTotal != 8*50 {
+			t.Errorf("expected %d changes (fair=%v), got %d", 8*50, fair, finalTotal)
+			return
+		}
+	}
+}
+
 /* ***************************************** Below find utility functions ****************************************** */
 func writerWaitsForNReaders(t *testing.T, numReaders int, recurseDepth int, writeRecurseDepth int) {
 	waiter := newSimpleValue()
@@ -361,3 +2055,22 @@ func (throttle *throttler) wait() {
 
 	throttle.cond.Wait()
 }
+
+// benchmarkOnThread runs the operation b.N times on a goethe thread
+func benchmarkOnThread(b *testing.B, operation func()) {
+	ethe := goethe.GetGoethe()
+	done := make(chan bool)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	ethe.Go(func() {
+		for lcv := 0; lcv < b.N; lcv++ {
+			operation()
+		}
+
+		close(done)
+	})
+
+	<-done
+}
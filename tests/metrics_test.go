@@ -0,0 +1,130 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package tests
+
+import (
+	"github.com/jwells131313/goethe"
+	"github.com/jwells131313/goethe/metrics"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("MetricsPool", 1, 2, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	lock := ethe.NewGoetheLockWithOptions(goethe.LockOptions{
+		RecordHoldTimes: true,
+	})
+
+	done := make(chan bool)
+	ethe.Go(func() {
+		lock.WriteLock()
+		lock.WriteUnlock()
+
+		done <- true
+	})
+	<-done
+
+	registry := metrics.NewRegistry()
+
+	if err = registry.RegisterPool(pool); err != nil {
+		t.Errorf("could not register pool %v", err)
+		return
+	}
+	if err = registry.RegisterPool(pool); err == nil {
+		t.Errorf("registered the same pool twice")
+		return
+	}
+	if err = registry.RegisterLock("metrics \"lock\"", lock); err != nil {
+		t.Errorf("could not register lock %v", err)
+		return
+	}
+
+	// Nothing started, so the function waits on the queue
+	funcQueue.Enqueue(func() {})
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := recorder.Body.String()
+	for _, expected := range []string{
+		"# TYPE goethe_pool_queue_depth gauge\n",
+		"goethe_pool_queue_depth{pool=\"MetricsPool\"} 1\n",
+		"goethe_pool_max_threads{pool=\"MetricsPool\"} 2\n",
+		"# TYPE goethe_pool_task_failures_total counter\n",
+		"goethe_lock_holds_total{lock=\"metrics \\\"lock\\\"\",mode=\"write\"} 1\n",
+		"goethe_lock_holds_total{lock=\"metrics \\\"lock\\\"\",mode=\"read\"} 0\n",
+		"goethe_lock_hold_seconds{lock=\"metrics \\\"lock\\\"\",mode=\"write\",quantile=\"0.99\"} ",
+		"goethe_lock_contended_total{lock=\"metrics \\\"lock\\\"\"} 0\n",
+		"# TYPE goethe_lock_wait_max_seconds gauge\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in metrics\n%s", expected, body)
+			return
+		}
+	}
+
+	// Each metric is described once, before all of its samples
+	if strings.Count(body, "# TYPE goethe_lock_holds_total ") != 1 {
+		t.Errorf("expected one description of the lock holds\n%s", body)
+		return
+	}
+
+	registry.UnregisterPool("MetricsPool")
+
+	for _, metric := range registry.Collect() {
+		if strings.HasPrefix(metric.Name, "goethe_pool_") {
+			t.Errorf("pool metric %s left after unregistering", metric.Name)
+			return
+		}
+	}
+}
@@ -41,7 +41,15 @@
 package tests
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/jwells131313/goethe"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -247,3 +255,3584 @@ func getTID(ret chan int64) {
 
 	ret <- ethe.GetThreadID()
 }
+
+func TestEnqueueToThreadStaysOnThread(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(20)
+
+	pool, err := ethe.NewPool("AffinityPool", 3, 3, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	retVals := make(chan int64)
+
+	funcQueue.Enqueue(getTID, retVals)
+	target := <-retVals
+
+	for lcv := 0; lcv < 10; lcv++ {
+		err = funcQueue.EnqueueToThread(target, getTID, retVals)
+		if err != nil {
+			t.Errorf("could not enqueue to thread %d: %v", target, err)
+			return
+		}
+	}
+
+	for lcv := 0; lcv < 10; lcv++ {
+		ranOn := <-retVals
+		if ranOn != target {
+			t.Errorf("function for thread %d ran on thread %d", target, ranOn)
+			return
+		}
+	}
+}
+
+func TestEnqueueToExitedThread(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	exited, err := ethe.Go(func() {})
+	if err != nil {
+		t.Errorf("could not start thread %v", err)
+		return
+	}
+
+	for lcv := 0; lcv < 200; lcv++ {
+		// Use a throw-away queue until the thread has exited
+		err = goethe.NewBoundedFunctionQueue(1).EnqueueToThread(exited, getTID, nil)
+		if err == goethe.ErrNoSuchThread {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != goethe.ErrNoSuchThread {
+		t.Errorf("expected ErrNoSuchThread, got %v", err)
+		return
+	}
+
+	funcQueue.SetAffinityFallback(true)
+
+	retVals := make(chan int64)
+
+	err = funcQueue.EnqueueToThread(exited, getTID, retVals)
+	if err != nil {
+		t.Errorf("with fallback on enqueue should have worked %v", err)
+		return
+	}
+
+	pool, err := ethe.NewPool("FallbackPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	ranOn := <-retVals
+	if ranOn == exited || ranOn < 0 {
+		t.Errorf("fallback function ran on unexpected thread %d", ranOn)
+		return
+	}
+}
+
+func TestMultiQueuePoolStrictPriority(t *testing.T) {
+	order := runMultiQueuePool(t, "StrictPriorityPool", goethe.NewStrictPrioritySelector())
+	if order == "" {
+		return
+	}
+
+	expected := "HHHLLL"
+	if order != expected {
+		t.Errorf("expected run order %s, got %s", expected, order)
+	}
+}
+
+func TestMultiQueuePoolWeightedRoundRobin(t *testing.T) {
+	selector, err := goethe.NewWeightedRoundRobinSelector(2, 1)
+	if err != nil {
+		t.Errorf("could not create selector %v", err)
+		return
+	}
+
+	order := runMultiQueuePool(t, "RoundRobinPool", selector)
+	if order == "" {
+		return
+	}
+
+	expected := "HHLHLL"
+	if order != expected {
+		t.Errorf("expected run order %s, got %s", expected, order)
+	}
+}
+
+func TestWeightedRoundRobinBadWeight(t *testing.T) {
+	_, err := goethe.NewWeightedRoundRobinSelector(1, 0)
+	if err == nil {
+		t.Error("a weight of zero should not be allowed")
+	}
+}
+
+// runMultiQueuePool returns the order in which the functions on the
+// high and low queues ran on a single threaded pool
+func runMultiQueuePool(t *testing.T, name string, selector goethe.QueueSelector) string {
+	ethe := goethe.GetGoethe()
+
+	high := goethe.NewBoundedFunctionQueue(10)
+	low := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewMultiQueuePool(name, 1, 1, 1*time.Minute, selector,
+		[]goethe.FunctionQueue{high, low}, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return ""
+	}
+	defer pool.Close()
+
+	if len(pool.GetFunctionQueues()) != 2 {
+		t.Errorf("expected two function queues, got %d", len(pool.GetFunctionQueues()))
+		return ""
+	}
+
+	ran := make(chan string, 6)
+	record := func(name string) {
+		ran <- name
+	}
+
+	for lcv := 0; lcv < 3; lcv++ {
+		low.Enqueue(record, "L")
+		high.Enqueue(record, "H")
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return ""
+	}
+
+	retVal := ""
+	for lcv := 0; lcv < 6; lcv++ {
+		select {
+		case name := <-ran:
+			retVal = retVal + name
+		case <-time.After(10 * time.Second):
+			t.Errorf("functions did not run, only got %s", retVal)
+			return ""
+		}
+	}
+
+	return retVal
+}
+
+func TestSaturationEvents(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("SaturatedPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	if pool.GetStats().SaturationEvents != 0 {
+		t.Errorf("new pool should not be saturated %v", pool.GetStats())
+		return
+	}
+
+	proceed := make(chan bool)
+	block := func() {
+		<-proceed
+	}
+
+	funcQueue.Enqueue(block)
+	funcQueue.Enqueue(block)
+	funcQueue.Enqueue(block)
+	defer close(proceed)
+
+	for lcv := 0; lcv < 200; lcv++ {
+		if pool.GetStats().SaturationEvents > 0 {
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Errorf("pool with one thread and a backlog never recorded saturation %v", pool.GetStats())
+}
+
+func TestCloseWaitReturnsErrors(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+	errorQueue := goethe.NewBoundedErrorQueue(10)
+
+	pool, err := ethe.NewPool("CloseWaitPool", 3, 3, 1*time.Minute, funcQueue, errorQueue)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	started := make(chan bool)
+	proceed := make(chan bool)
+	failer := func() error {
+		started <- true
+		<-proceed
+
+		time.Sleep(100 * time.Millisecond)
+		return errors.New("failed during shutdown")
+	}
+
+	for lcv := 0; lcv < 3; lcv++ {
+		funcQueue.Enqueue(failer)
+	}
+	for lcv := 0; lcv < 3; lcv++ {
+		<-started
+	}
+
+	close(proceed)
+
+	infos, err := pool.CloseWait(10 * time.Second)
+	if err != nil {
+		t.Errorf("unexpected error from CloseWait %v", err)
+		return
+	}
+
+	if len(infos) != 3 {
+		t.Errorf("expected the three errors from the running functions, got %d", len(infos))
+		return
+	}
+
+	if pool.GetCurrentThreadCount() != 0 {
+		t.Errorf("all threads should have exited, there are %d", pool.GetCurrentThreadCount())
+	}
+}
+
+func TestCloseWaitTimesOut(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("CloseWaitTimeoutPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	started := make(chan bool)
+	proceed := make(chan bool)
+	defer close(proceed)
+
+	funcQueue.Enqueue(func() {
+		started <- true
+		<-proceed
+	})
+
+	<-started
+
+	clock, restore := installFakeClock()
+	defer restore()
+
+	var infos []goethe.ErrorInformation
+	returned := make(chan error, 1)
+	go func() {
+		var closeErr error
+		infos, closeErr = pool.CloseWait(1 * time.Minute)
+		returned <- closeErr
+	}()
+
+	timedOut := clock.advanceUntil(10*time.Second, func() bool {
+		select {
+		case err = <-returned:
+			return true
+		default:
+			return false
+		}
+	})
+	if !timedOut {
+		t.Error("CloseWait did not return when the clock moved past its timeout")
+		return
+	}
+
+	if err != goethe.ErrCloseTimeout {
+		t.Errorf("expected ErrCloseTimeout, got %v", err)
+		return
+	}
+
+	if len(infos) != 0 {
+		t.Errorf("there should have been no errors, got %d", len(infos))
+	}
+}
+
+func TestCircuitBreakerOpensAndCloses(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	clock, restore := installFakeClock()
+	defer restore()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+	errorQueue := goethe.NewBoundedErrorQueue(10)
+
+	pool, err := ethe.NewPool("BreakerPool", 1, 5, 1*time.Hour, funcQueue, errorQueue)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.SetCircuitBreaker(3, 1*time.Minute)
+	if err != nil {
+		t.Errorf("could not set circuit breaker %v", err)
+		return
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	for lcv := 0; lcv < 3; lcv++ {
+		funcQueue.Enqueue(func() error {
+			return errors.New("poison")
+		})
+	}
+
+	// The failures all happen at the same fake time, so the
+	// breaker opens once the third one has been recorded
+	for lcv := 0; lcv < 200 && pool.GetStats().TaskFailures < 3; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !pool.GetStats().CircuitBreakerOpen {
+		t.Errorf("circuit breaker did not open %v", pool.GetStats())
+		return
+	}
+
+	foundBreakerError := false
+	for info, found := errorQueue.Dequeue(); found; info, found = errorQueue.Dequeue() {
+		if info.GetError() == goethe.ErrCircuitBreakerOpen {
+			foundBreakerError = true
+		}
+	}
+	if !foundBreakerError {
+		t.Error("did not find ErrCircuitBreakerOpen on the error queue")
+		return
+	}
+
+	clock.advance(59 * time.Second)
+	if !pool.GetStats().CircuitBreakerOpen {
+		t.Error("circuit breaker closed before the failures left the window")
+		return
+	}
+
+	clock.advance(2 * time.Second)
+	if pool.GetStats().CircuitBreakerOpen {
+		t.Error("circuit breaker still open after the failures left the window")
+	}
+}
+
+func TestErrorInformationContext(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+	errorQueue := goethe.NewBoundedErrorQueue(10)
+
+	pool, err := ethe.NewPool("ErrorContextPool", 1, 1, 1*time.Minute, funcQueue, errorQueue)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	before := time.Now()
+
+	tids := make(chan int64, 1)
+	funcQueue.Enqueue(func() error {
+		ethe.SetThreadName("worker-one")
+		tids <- ethe.GetThreadID()
+
+		return errors.New("failed with context")
+	})
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	tid := <-tids
+
+	for lcv := 0; lcv < 200 && errorQueue.IsEmpty(); lcv++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	info, found := errorQueue.Dequeue()
+	if !found {
+		t.Error("no error information was put on the error queue")
+		return
+	}
+
+	if info.GetThreadID() != tid {
+		t.Errorf("expected thread id %d, got %d", tid, info.GetThreadID())
+	}
+
+	if info.GetThreadName() != "worker-one" {
+		t.Errorf("expected thread name worker-one, got %s", info.GetThreadName())
+	}
+
+	if info.GetEnqueueTime().Before(before) || info.GetEnqueueTime().After(time.Now()) {
+		t.Errorf("unexpected enqueue time %v", info.GetEnqueueTime())
+	}
+}
+
+func TestSubmitKeyedWaitsForKey(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("KeyedWaitPool", 4, 4, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	started := make(chan bool)
+	release := make(chan bool)
+	order := make(chan string, 2)
+
+	_, err = pool.SubmitKeyed("a", func() {
+		started <- true
+		<-release
+		order <- "first"
+	})
+	if err != nil {
+		t.Errorf("could not submit first function %v", err)
+		return
+	}
+
+	<-started
+
+	second, err := pool.SubmitKeyed("a", func() {
+		order <- "second"
+	})
+	if err != nil {
+		t.Errorf("could not submit second function %v", err)
+		return
+	}
+
+	for lcv := 0; lcv < 200 && !funcQueue.IsEmpty(); lcv++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Give another thread the chance to run the second function if it was going to
+	time.Sleep(200 * time.Millisecond)
+
+	if second.IsComplete() {
+		t.Error("second function ran while the first with the same key was running")
+		return
+	}
+
+	keys := pool.GetRunningKeys()
+	if len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("expected running keys [a], got %v", keys)
+		return
+	}
+
+	close(release)
+
+	_, err = second.Get(20 * time.Second)
+	if err != nil {
+		t.Errorf("second function should have run after the first %v", err)
+		return
+	}
+
+	if first := <-order; first != "first" {
+		t.Errorf("expected first function to finish first, got %s", first)
+		return
+	}
+
+	for lcv := 0; lcv < 200 && len(pool.GetRunningKeys()) != 0; lcv++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if len(pool.GetRunningKeys()) != 0 {
+		t.Errorf("expected no running keys, got %v", pool.GetRunningKeys())
+	}
+}
+
+func TestSubmitKeyedDropsDuplicate(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	pool, err := ethe.NewPool("KeyedDropPool", 4, 4, 1*time.Minute, goethe.NewBoundedFunctionQueue(10), nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	pool.SetDuplicateKeyPolicy(goethe.DropDuplicateKey)
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	started := make(chan bool)
+	release := make(chan bool)
+	defer close(release)
+
+	pool.SubmitKeyed("b", func() {
+		started <- true
+		<-release
+	})
+
+	<-started
+
+	duplicate, err := pool.SubmitKeyed("b", func() {})
+	if err != nil {
+		t.Errorf("could not submit duplicate function %v", err)
+		return
+	}
+
+	_, err = duplicate.Get(20 * time.Second)
+	if err != goethe.ErrDuplicateKey {
+		t.Errorf("expected ErrDuplicateKey, got %v", err)
+		return
+	}
+
+	other, err := pool.SubmitKeyed("c", func() {})
+	if err != nil {
+		t.Errorf("could not submit function with another key %v", err)
+		return
+	}
+
+	_, err = other.Get(20 * time.Second)
+	if err != nil {
+		t.Errorf("function with another key should have run %v", err)
+	}
+}
+
+func TestMinZeroPoolRunsFirstFunctionPromptly(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("MinZeroPool", 0, 2, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	if pool.GetCurrentThreadCount() != 0 {
+		t.Errorf("min zero pool should start with no threads, has %d", pool.GetCurrentThreadCount())
+		return
+	}
+
+	ran := make(chan bool)
+	funcQueue.Enqueue(func() {
+		ran <- true
+	})
+
+	select {
+	case <-ran:
+		break
+	case <-time.After(2 * time.Second):
+		t.Error("first function enqueued on min zero pool did not run promptly")
+		return
+	}
+
+	if pool.GetCurrentThreadCount() != 1 {
+		t.Errorf("expected one thread after first function, got %d", pool.GetCurrentThreadCount())
+	}
+}
+
+func TestCategoryLimit(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("CategoryPool", 4, 4, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.SetCategoryLimit("slow", 1)
+	if err != nil {
+		t.Errorf("could not set category limit %v", err)
+		return
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	release := make(chan bool)
+	slow := func() {
+		<-release
+	}
+
+	first, _ := pool.SubmitWithCategory("slow", slow)
+	second, _ := pool.SubmitWithCategory("slow", slow)
+
+	fast, err := pool.SubmitWithCategory("fast", func() {})
+	if err != nil {
+		t.Errorf("could not submit fast function %v", err)
+		return
+	}
+
+	_, err = fast.Get(20 * time.Second)
+	if err != nil {
+		t.Errorf("fast function should not wait for slow ones %v", err)
+		return
+	}
+
+	for lcv := 0; lcv < 200 && !funcQueue.IsEmpty(); lcv++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	counts := pool.GetCategoryCounts()
+	if counts["slow"] != 1 || len(counts) != 1 {
+		t.Errorf("expected only one slow function running, got %v", counts)
+		return
+	}
+
+	release <- true
+
+	for lcv := 0; lcv < 200 && !first.IsComplete() && !second.IsComplete(); lcv++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if first.IsComplete() == second.IsComplete() {
+		t.Errorf("exactly one slow function should have finished, %v and %v",
+			first.IsComplete(), second.IsComplete())
+		return
+	}
+
+	release <- true
+
+	_, err = first.Get(20 * time.Second)
+	if err != nil {
+		t.Errorf("first slow function did not finish %v", err)
+		return
+	}
+
+	_, err = second.Get(20 * time.Second)
+	if err != nil {
+		t.Errorf("second slow function did not finish %v", err)
+	}
+}
+
+func TestDoneClosesAfterThreadsExit(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("DonePool", 2, 2, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	started := make(chan bool)
+	proceed := make(chan bool)
+	funcQueue.Enqueue(func() {
+		started <- true
+		<-proceed
+	})
+
+	<-started
+
+	select {
+	case <-pool.Done():
+		t.Error("done closed before pool was closed")
+		return
+	default:
+		break
+	}
+
+	pool.Close()
+
+	select {
+	case <-pool.Done():
+		t.Error("done closed while a function was still running")
+		return
+	case <-time.After(200 * time.Millisecond):
+		break
+	}
+
+	close(proceed)
+
+	select {
+	case <-pool.Done():
+		break
+	case <-time.After(20 * time.Second):
+		t.Error("done did not close after the threads exited")
+		return
+	}
+
+	if pool.GetCurrentThreadCount() != 0 {
+		t.Errorf("all threads should have exited, there are %d", pool.GetCurrentThreadCount())
+	}
+}
+
+func TestShutdownAllClosesDependentsFirst(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	front, err := ethe.NewPool("FrontPool", 1, 1, 1*time.Minute, goethe.NewBoundedFunctionQueue(10), nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer front.Close()
+
+	back, err := ethe.NewPool("BackPool", 1, 1, 1*time.Minute, goethe.NewBoundedFunctionQueue(10), nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer back.Close()
+
+	err = front.DependsOn(back)
+	if err != nil {
+		t.Errorf("could not add dependency %v", err)
+		return
+	}
+
+	err = back.DependsOn(front)
+	if err != goethe.ErrDependencyCycle {
+		t.Errorf("expected ErrDependencyCycle, got %v", err)
+		return
+	}
+
+	front.Start()
+	back.Start()
+
+	started := make(chan bool)
+	proceed := make(chan bool)
+	handedOff := make(chan error, 1)
+	ranOnBack := make(chan bool, 1)
+
+	front.Submit(func() {
+		started <- true
+		<-proceed
+
+		_, err := back.Submit(func() {
+			ranOnBack <- true
+		})
+		handedOff <- err
+	})
+
+	<-started
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- ethe.ShutdownAll(20 * time.Second)
+	}()
+
+	for lcv := 0; lcv < 200 && !front.IsClosed(); lcv++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if back.IsClosed() {
+		t.Error("back pool closed while the front pool was still running")
+		return
+	}
+
+	close(proceed)
+
+	if err = <-handedOff; err != nil {
+		t.Errorf("front pool could not submit to back pool during shutdown %v", err)
+		return
+	}
+
+	if err = <-shutdown; err != nil {
+		t.Errorf("unexpected error from ShutdownAll %v", err)
+		return
+	}
+
+	if !back.IsClosed() {
+		t.Error("back pool should have been closed")
+		return
+	}
+
+	select {
+	case <-ranOnBack:
+		break
+	default:
+		t.Error("function handed to the back pool was lost")
+	}
+}
+
+func TestEnqueueWithCallback(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+	errorQueue := goethe.NewBoundedErrorQueue(10)
+
+	pool, err := ethe.NewPool("CallbackPool", 1, 1, 1*time.Minute, funcQueue, errorQueue)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	type callbackResult struct {
+		results []interface{}
+		err     error
+		tid     int64
+	}
+
+	callbacks := make(chan callbackResult, 2)
+	tids := make(chan int64, 2)
+
+	funcQueue.EnqueueWithCallback(func(results []interface{}, err error) {
+		callbacks <- callbackResult{results, err, ethe.GetThreadID()}
+
+		panic("callback panics should not hurt the pool")
+	}, func(a, b int) (int, error) {
+		tids <- ethe.GetThreadID()
+		return a + b, nil
+	}, 1, 2)
+
+	funcQueue.EnqueueWithCallback(func(results []interface{}, err error) {
+		callbacks <- callbackResult{results, err, ethe.GetThreadID()}
+	}, func() error {
+		tids <- ethe.GetThreadID()
+		return errors.New("callback failure")
+	})
+
+	first := <-callbacks
+	if first.err != nil || len(first.results) != 2 || first.results[0] != 3 {
+		t.Errorf("unexpected results in first callback %v %v", first.results, first.err)
+		return
+	}
+
+	if tid := <-tids; tid != first.tid {
+		t.Errorf("callback ran on thread %d instead of %d", first.tid, tid)
+		return
+	}
+
+	second := <-callbacks
+	if second.err == nil || second.err.Error() != "callback failure" {
+		t.Errorf("expected error in second callback, got %v", second.err)
+		return
+	}
+
+	if pool.GetCurrentThreadCount() != 1 {
+		t.Errorf("the pool thread should have survived the panic, thread count %d",
+			pool.GetCurrentThreadCount())
+	}
+}
+
+func TestMaxWeight(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("WeightPool", 4, 4, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.SetMaxWeight(10)
+	if err != nil {
+		t.Errorf("could not set maximum weight %v", err)
+		return
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	started := make(chan bool)
+	release := make(chan bool)
+	heavy := func() {
+		started <- true
+		<-release
+	}
+
+	first, _ := pool.SubmitWeighted(6, heavy)
+	<-started
+
+	second, _ := pool.SubmitWeighted(6, heavy)
+
+	for lcv := 0; lcv < 200 && !funcQueue.IsEmpty(); lcv++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Give the second function the chance to start if it was going to
+	time.Sleep(200 * time.Millisecond)
+
+	if pool.GetInFlightWeight() != 6 {
+		t.Errorf("expected weight of six in flight, got %d", pool.GetInFlightWeight())
+		return
+	}
+
+	release <- true
+	<-started
+
+	if _, err = first.Get(20 * time.Second); err != nil {
+		t.Errorf("first function did not finish %v", err)
+		return
+	}
+
+	if second.IsComplete() {
+		t.Error("second function should still be running")
+		return
+	}
+
+	release <- true
+
+	if _, err = second.Get(20 * time.Second); err != nil {
+		t.Errorf("second function did not finish %v", err)
+		return
+	}
+
+	for lcv := 0; lcv < 200 && pool.GetInFlightWeight() != 0; lcv++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if pool.GetInFlightWeight() != 0 {
+		t.Errorf("expected no weight in flight, got %d", pool.GetInFlightWeight())
+	}
+}
+
+func TestAdmittedWeightedFailsWhenQueueFull(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(2)
+
+	pool, err := ethe.NewPool("FullWeightPool", 2, 2, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.SetMaxWeight(2)
+	if err != nil {
+		t.Errorf("could not set maximum weight %v", err)
+		return
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	started := make(chan bool)
+	releaseHeavy := make(chan bool)
+	releaseOther := make(chan bool)
+
+	pool.SubmitWeighted(2, func() {
+		started <- true
+		<-releaseHeavy
+	})
+	<-started
+
+	light, _ := pool.SubmitWeighted(1, func() {})
+	dropped, _ := pool.SubmitWeighted(1, func() {})
+
+	for lcv := 0; lcv < 200 && !funcQueue.IsEmpty(); lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Run by the thread after the two light functions, so once it
+	// starts they are waiting
+	err = funcQueue.Enqueue(func() {
+		started <- true
+		<-releaseOther
+	})
+	if err != nil {
+		t.Errorf("could not enqueue blocker %v", err)
+		return
+	}
+	<-started
+
+	for lcv := 0; lcv < 2; lcv++ {
+		err = funcQueue.Enqueue(func() {})
+		if err != nil {
+			t.Errorf("could not fill the queue %v", err)
+			return
+		}
+	}
+
+	// The first light function runs on the freed thread, the other
+	// cannot be put back on the full queue
+	close(releaseHeavy)
+
+	if _, err = light.Get(20 * time.Second); err != nil {
+		t.Errorf("first light function did not finish %v", err)
+		return
+	}
+
+	if _, err = dropped.Get(20 * time.Second); err != goethe.ErrAtCapacity {
+		t.Errorf("second light function should have been given ErrAtCapacity, got %v", err)
+		return
+	}
+
+	close(releaseOther)
+
+	for lcv := 0; lcv < 200 && pool.GetInFlightWeight() != 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if pool.GetInFlightWeight() != 0 {
+		t.Errorf("expected no weight in flight, got %d", pool.GetInFlightWeight())
+	}
+}
+
+func TestEnqueueBarrier(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("BarrierPool", 3, 3, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	var mux sync.Mutex
+	order := make([]string, 0)
+	record := func(name string) {
+		mux.Lock()
+		defer mux.Unlock()
+
+		order = append(order, name)
+	}
+
+	started := make(chan bool)
+	release := make(chan bool)
+	for lcv := 0; lcv < 2; lcv++ {
+		funcQueue.Enqueue(func() {
+			started <- true
+			<-release
+
+			// Let the barrier run early if it was going to
+			time.Sleep(100 * time.Millisecond)
+			record("before")
+		})
+	}
+
+	barrierDone := make(chan bool)
+	funcQueue.EnqueueBarrier(func() {
+		record("barrier")
+		time.Sleep(100 * time.Millisecond)
+		record("barrier-end")
+	})
+
+	funcQueue.Enqueue(func() {
+		record("after")
+		barrierDone <- true
+	})
+
+	<-started
+	<-started
+
+	time.Sleep(200 * time.Millisecond)
+
+	mux.Lock()
+	if len(order) != 0 {
+		t.Errorf("nothing should have run while the first functions were running, got %v", order)
+		mux.Unlock()
+		return
+	}
+	mux.Unlock()
+
+	close(release)
+
+	<-barrierDone
+
+	expected := []string{"before", "before", "barrier", "barrier-end", "after"}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+}
+
+func TestEnqueueWithDeadline(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	clock, restore := installFakeClock()
+	defer restore()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+	errorQueue := goethe.NewBoundedErrorQueue(10)
+
+	pool, err := ethe.NewPool("DeadlinePool", 1, 1, 1*time.Minute, funcQueue, errorQueue)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	blocking := make(chan bool)
+	proceed := make(chan bool)
+
+	funcQueue.Enqueue(func() {
+		blocking <- true
+		<-proceed
+	})
+
+	<-blocking
+
+	var stale, fresh int32
+	funcQueue.EnqueueWithDeadline(clock.Now().Add(50*time.Millisecond), func() {
+		atomic.StoreInt32(&stale, 1)
+	})
+	funcQueue.EnqueueWithDeadline(clock.Now().Add(1*time.Minute), func() {
+		atomic.StoreInt32(&fresh, 1)
+	})
+
+	// Keep the only thread busy until the first deadline has passed
+	clock.advance(1 * time.Second)
+	close(proceed)
+
+	for lcv := 0; lcv < 200 && atomic.LoadInt32(&fresh) == 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if atomic.LoadInt32(&fresh) == 0 {
+		t.Error("function with a later deadline was not run")
+		return
+	}
+
+	if atomic.LoadInt32(&stale) != 0 {
+		t.Error("function dequeued after its deadline should not have been run")
+		return
+	}
+
+	info, found := errorQueue.Dequeue()
+	if !found || info.GetError() != goethe.ErrDeadlineExceeded {
+		t.Errorf("expected ErrDeadlineExceeded on the error queue, got %v", info)
+		return
+	}
+}
+
+func TestSetFunctionQueueUnderLoad(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(1000)
+
+	pool, err := ethe.NewPool("SwapQueuePool", 2, 2, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	var runsMux sync.Mutex
+	runs := make(map[int]int)
+
+	record := func(index int) {
+		time.Sleep(time.Millisecond)
+
+		runsMux.Lock()
+		defer runsMux.Unlock()
+
+		runs[index]++
+	}
+
+	const numTasks = 500
+
+	futures := make(chan goethe.Future, numTasks)
+	go func() {
+		for lcv := 0; lcv < numTasks; lcv++ {
+			future, err := pool.Submit(record, lcv)
+			if err != nil {
+				t.Errorf("could not submit task %d %v", lcv, err)
+				close(futures)
+				return
+			}
+
+			futures <- future
+		}
+
+		close(futures)
+	}()
+
+	for lcv := 0; lcv < 200 && funcQueue.GetSize() < 50; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	newQueue := goethe.NewRingBufferFunctionQueue(1000)
+	err = pool.SetFunctionQueue(newQueue)
+	if err != nil {
+		t.Errorf("could not swap function queue %v", err)
+		return
+	}
+
+	if pool.GetFunctionQueue() != newQueue {
+		t.Error("pool did not take the new function queue")
+		return
+	}
+
+	if !funcQueue.IsEmpty() {
+		t.Errorf("old queue should have been drained, it has %d", funcQueue.GetSize())
+		return
+	}
+
+	for future := range futures {
+		if _, err := future.Get(10 * time.Second); err != nil {
+			t.Errorf("task failed %v", err)
+			return
+		}
+	}
+
+	runsMux.Lock()
+	defer runsMux.Unlock()
+
+	for lcv := 0; lcv < numTasks; lcv++ {
+		if runs[lcv] != 1 {
+			t.Errorf("task %d ran %d times", lcv, runs[lcv])
+			return
+		}
+	}
+
+	pool.Close()
+
+	if err := pool.SetFunctionQueue(goethe.NewBoundedFunctionQueue(10)); err != goethe.ErrPoolClosed {
+		t.Errorf("expected ErrPoolClosed swapping the queue of a closed pool, got %v", err)
+	}
+}
+
+func TestSubmitBlocking(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(1)
+
+	pool, err := ethe.NewPool("SubmitBlockingPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	running := make(chan bool)
+	proceed := make(chan bool)
+
+	block := func() {
+		running <- true
+		<-proceed
+	}
+
+	pool.Submit(block)
+	<-running
+
+	// Fills the queue
+	pool.Submit(block)
+
+	type submitResult struct {
+		future goethe.Future
+		err    error
+	}
+
+	results := make(chan submitResult)
+	go func() {
+		future, err := pool.SubmitBlocking(context.Background(), func() int {
+			return 13
+		})
+		results <- submitResult{future, err}
+	}()
+
+	select {
+	case result := <-results:
+		t.Errorf("SubmitBlocking should have waited for room, got %v", result.err)
+		return
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// The first function finishes and the second starts, making room
+	proceed <- true
+	<-running
+
+	var result submitResult
+	select {
+	case result = <-results:
+	case <-time.After(10 * time.Second):
+		t.Error("SubmitBlocking did not return once there was room")
+		return
+	}
+
+	if result.err != nil {
+		t.Errorf("unexpected error from SubmitBlocking %v", result.err)
+		return
+	}
+
+	// Room for one function but the queue is full again
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, err := pool.SubmitBlocking(ctx, block)
+		results <- submitResult{nil, err}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case cancelled := <-results:
+		if cancelled.err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", cancelled.err)
+			return
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("SubmitBlocking did not return when cancelled")
+		return
+	}
+
+	close(proceed)
+
+	values, err := result.future.Get(10 * time.Second)
+	if err != nil || len(values) != 1 || values[0] != 13 {
+		t.Errorf("unexpected result from blocking submit %v %v", values, err)
+	}
+}
+
+func TestIdleAndBusyThreadCounts(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("IdleBusyPool", 3, 3, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	if idle, busy := pool.GetIdleThreadCount(), pool.GetBusyThreadCount(); idle != 3 || busy != 0 {
+		t.Errorf("expected 3 idle and 0 busy threads after start, got %d and %d", idle, busy)
+		return
+	}
+
+	running := make(chan bool)
+	proceed := make(chan bool)
+
+	for lcv := 0; lcv < 2; lcv++ {
+		pool.Submit(func() {
+			running <- true
+			<-proceed
+		})
+	}
+
+	<-running
+	<-running
+
+	if idle, busy := pool.GetIdleThreadCount(), pool.GetBusyThreadCount(); idle != 1 || busy != 2 {
+		t.Errorf("expected 1 idle and 2 busy threads, got %d and %d", idle, busy)
+	}
+
+	if total := pool.GetIdleThreadCount() + pool.GetBusyThreadCount(); total != pool.GetCurrentThreadCount() {
+		t.Errorf("idle and busy threads add up to %d but there are %d", total, pool.GetCurrentThreadCount())
+	}
+
+	close(proceed)
+}
+
+func TestClearCompletesFutures(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("ClearPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	running := make(chan bool)
+	proceed := make(chan bool)
+
+	first, _ := pool.Submit(func() int {
+		running <- true
+		<-proceed
+
+		return 1
+	})
+
+	<-running
+
+	second, _ := pool.Submit(func() int {
+		return 2
+	})
+
+	if cleared := funcQueue.Clear(); cleared != 1 {
+		t.Errorf("expected one function cleared, got %d", cleared)
+		return
+	}
+
+	if _, err := second.Get(10 * time.Second); err != goethe.ErrCleared {
+		t.Errorf("expected ErrCleared from the cleared function, got %v", err)
+		return
+	}
+
+	close(proceed)
+
+	values, err := first.Get(10 * time.Second)
+	if err != nil || values[0] != 1 {
+		t.Errorf("running function should not be affected by Clear, got %v %v", values, err)
+	}
+}
+
+func TestResize(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("ResizePool", 1, 2, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	if err := pool.Resize(3, 2); err == nil {
+		t.Error("a minimum above the maximum should be rejected")
+		return
+	}
+
+	err = pool.Resize(3, 4)
+	if err != nil {
+		t.Errorf("could not grow pool %v", err)
+		return
+	}
+
+	if pool.GetMinThreads() != 3 || pool.GetMaxThreads() != 4 {
+		t.Errorf("expected bounds 3 and 4, got %d and %d", pool.GetMinThreads(), pool.GetMaxThreads())
+		return
+	}
+
+	if count := pool.GetCurrentThreadCount(); count != 3 {
+		t.Errorf("expected the pool to grow to 3 threads right away, has %d", count)
+		return
+	}
+
+	err = pool.Resize(0, 1)
+	if err != nil {
+		t.Errorf("could not shrink pool %v", err)
+		return
+	}
+
+	if err = pool.AwaitThreadCount(1, 10*time.Second); err != nil {
+		t.Errorf("expected the pool to shrink to its new maximum of 1, %v", err)
+		return
+	}
+
+	pool.Close()
+
+	if err := pool.Resize(1, 1); err != goethe.ErrPoolClosed {
+		t.Errorf("expected ErrPoolClosed resizing a closed pool, got %v", err)
+	}
+}
+
+func TestTaskInterceptors(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("InterceptorPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	if err := pool.SetTaskInterceptor(nil); err == nil {
+		t.Error("a nil interceptor should be rejected")
+		return
+	}
+
+	var mux sync.Mutex
+	var calls []string
+	record := func(call string) {
+		mux.Lock()
+		defer mux.Unlock()
+
+		calls = append(calls, call)
+	}
+
+	pool.SetTaskInterceptor(func(next func()) func() {
+		return func() {
+			record("outer before")
+			next()
+			record("outer after")
+		}
+	})
+	pool.SetTaskInterceptor(func(next func()) func() {
+		return func() {
+			defer func() {
+				if r := recover(); r != nil {
+					record("recovered")
+				}
+			}()
+
+			record("inner before")
+			next()
+			record("inner after")
+		}
+	})
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	future, err := pool.Submit(func() int {
+		record("task")
+		return 13
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	results, err := future.Get(10 * time.Second)
+	if err != nil || len(results) != 1 || results[0] != 13 {
+		t.Errorf("expected 13 from the intercepted function, got %v %v", results, err)
+		return
+	}
+
+	mux.Lock()
+	got := calls
+	calls = nil
+	mux.Unlock()
+
+	expected := []string{"outer before", "inner before", "task", "inner after", "outer after"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected calls %v, got %v", expected, got)
+		return
+	}
+
+	future, err = pool.Submit(func() {
+		panic("task panicked")
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	_, err = future.Get(10 * time.Second)
+	if err != goethe.ErrTaskNotRun {
+		t.Errorf("expected ErrTaskNotRun for a recovered panic, got %v", err)
+		return
+	}
+
+	mux.Lock()
+	got = calls
+	mux.Unlock()
+
+	expected = []string{"outer before", "inner before", "recovered", "outer after"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected calls %v, got %v", expected, got)
+	}
+}
+
+func TestResetPoolStats(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("ResetStatsPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	for lcv := 0; lcv < 3; lcv++ {
+		future, err := pool.Submit(func() error {
+			return errors.New("failed")
+		})
+		if err != nil {
+			t.Errorf("could not submit %v", err)
+			return
+		}
+
+		future.Get(10 * time.Second)
+	}
+
+	for lcv := 0; lcv < 200 && pool.GetStats().TaskFailures < 3; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := pool.ResetStats()
+	if stats.TaskFailures != 3 || stats.CurrentThreads != 1 {
+		t.Errorf("expected three failures and one thread before reset, got %v", stats)
+		return
+	}
+
+	stats = pool.GetStats()
+	if stats.TaskFailures != 0 || stats.CurrentThreads != 1 {
+		t.Errorf("expected counters to be zero and one thread after reset, got %v", stats)
+	}
+}
+
+func TestRetireThread(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("RetirePool", 1, 2, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	if err := pool.RetireThread(-5); err != goethe.ErrNoSuchThread {
+		t.Errorf("expected ErrNoSuchThread for an unknown thread, got %v", err)
+		return
+	}
+
+	running := make(chan int64)
+	proceed := make(chan bool)
+
+	future, err := pool.Submit(func() int64 {
+		running <- ethe.GetThreadID()
+		<-proceed
+
+		return ethe.GetThreadID()
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	retired := <-running
+
+	err = pool.RetireThread(retired)
+	if err != nil {
+		t.Errorf("could not retire thread %d: %v", retired, err)
+		return
+	}
+
+	// The minimum is one, so a replacement is started
+	if count := pool.GetCurrentThreadCount(); count != 2 {
+		t.Errorf("expected a replacement thread alongside the retiring one, got %d threads", count)
+		return
+	}
+
+	close(proceed)
+
+	results, err := future.Get(10 * time.Second)
+	if err != nil || results[0] != retired {
+		t.Errorf("retiring thread should finish its function, got %v %v", results, err)
+		return
+	}
+
+	if err = pool.AwaitThreadCount(1, 10*time.Second); err != nil {
+		t.Errorf("expected the retired thread to leave, %v", err)
+		return
+	}
+
+	future, err = pool.Submit(func() int64 {
+		return ethe.GetThreadID()
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	results, err = future.Get(10 * time.Second)
+	if err != nil || results[0] == retired {
+		t.Errorf("expected a function to run on the replacement thread, got %v %v", results, err)
+	}
+}
+
+func TestTaskMetadata(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("MetadataPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	seenByInterceptor := make(chan string, 1)
+	pool.SetTaskInterceptor(func(next func()) func() {
+		return func() {
+			seenByInterceptor <- ethe.GetTaskMetadata()["tenant"]
+			next()
+		}
+	})
+
+	metadata := map[string]string{
+		"tenant": "acme",
+	}
+
+	seenByTask := make(chan map[string]string, 1)
+	err = funcQueue.EnqueueWithMetadata(metadata, func() {
+		seenByTask <- ethe.GetTaskMetadata()
+	})
+	if err != nil {
+		t.Errorf("could not enqueue %v", err)
+		return
+	}
+
+	// The queue keeps its own copy
+	metadata["tenant"] = "changed"
+
+	var queued map[string]string
+	funcQueue.ForEach(func(descriptor goethe.FunctionDescriptor) bool {
+		queued = descriptor.Metadata
+		return false
+	})
+
+	if queued["tenant"] != "acme" {
+		t.Errorf("expected queued metadata to have tenant acme, got %v", queued)
+		return
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	select {
+	case tenant := <-seenByInterceptor:
+		if tenant != "acme" {
+			t.Errorf("expected interceptor to see tenant acme, got %s", tenant)
+			return
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("interceptor never ran")
+		return
+	}
+
+	select {
+	case got := <-seenByTask:
+		if got["tenant"] != "acme" {
+			t.Errorf("expected function to see tenant acme, got %v", got)
+			return
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("function never ran")
+		return
+	}
+
+	future, err := pool.Submit(func() map[string]string {
+		return ethe.GetTaskMetadata()
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	<-seenByInterceptor
+
+	results, err := future.Get(10 * time.Second)
+	if err != nil || results[0].(map[string]string) != nil {
+		t.Errorf("expected no metadata for a function enqueued without it, got %v %v", results, err)
+		return
+	}
+
+	if got := ethe.GetTaskMetadata(); got != nil {
+		t.Errorf("expected no metadata outside of a pool thread, got %v", got)
+	}
+}
+
+func TestSuspendAtCheckpoint(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("CheckpointPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	if err := ethe.Checkpoint(); err != goethe.ErrNotGoetheThread {
+		t.Errorf("expected ErrNotGoetheThread outside of a goethe thread, got %v", err)
+		return
+	}
+
+	var progress int64
+	tids := make(chan int64, 1)
+
+	future, err := pool.Submit(func() error {
+		tids <- ethe.GetThreadID()
+
+		for {
+			if err := ethe.Checkpoint(); err != nil {
+				return err
+			}
+
+			atomic.AddInt64(&progress, 1)
+			time.Sleep(time.Millisecond)
+		}
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	tid := <-tids
+
+	err = ethe.SuspendThread(tid)
+	if err != nil {
+		t.Errorf("could not suspend %v", err)
+		return
+	}
+
+	for lcv := 0; lcv < 200 && pool.GetBusyThreadCount() != 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if busy := pool.GetBusyThreadCount(); busy != 0 {
+		t.Errorf("a parked thread should not be busy, got %d busy", busy)
+		return
+	}
+
+	parkedAt := atomic.LoadInt64(&progress)
+	time.Sleep(50 * time.Millisecond)
+	if now := atomic.LoadInt64(&progress); now != parkedAt {
+		t.Errorf("parked thread kept going from %d to %d", parkedAt, now)
+		return
+	}
+
+	// The parked thread does not count against the maximum of one
+	other, err := pool.Submit(func() int64 {
+		return ethe.GetThreadID()
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	results, err := other.Get(10 * time.Second)
+	if err != nil || results[0] == tid {
+		t.Errorf("expected another thread to run while parked, got %v %v", results, err)
+		return
+	}
+
+	err = ethe.ResumeThread(tid)
+	if err != nil {
+		t.Errorf("could not resume %v", err)
+		return
+	}
+
+	for lcv := 0; lcv < 200 && atomic.LoadInt64(&progress) == parkedAt; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if atomic.LoadInt64(&progress) == parkedAt {
+		t.Error("resumed thread never continued")
+		return
+	}
+
+	// Closing the pool resumes the parked thread and tells it so
+	ethe.SuspendThread(tid)
+
+	for lcv := 0; lcv < 200 && pool.GetBusyThreadCount() != 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	pool.Close()
+
+	_, err = future.Get(10 * time.Second)
+	if err != goethe.ErrPoolClosed {
+		t.Errorf("expected ErrPoolClosed from Checkpoint once the pool closed, got %v", err)
+	}
+}
+
+func TestSubmitOrdered(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(100)
+
+	pool, err := ethe.NewPool("OrderedPool", 3, 3, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	keys := []string{"a", "b", "c"}
+
+	var mux sync.Mutex
+	seen := make(map[string][]int)
+	var running, mostRunning int32
+
+	proceed := make(chan bool)
+	futures := make([]goethe.Future, 0)
+
+	for lcv := 0; lcv < 20; lcv++ {
+		for _, key := range keys {
+			key := key
+			index := lcv
+
+			future, err := pool.SubmitOrdered(key, func() {
+				<-proceed
+
+				now := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+
+				mux.Lock()
+				if now > mostRunning {
+					mostRunning = now
+				}
+				seen[key] = append(seen[key], index)
+				mux.Unlock()
+
+				time.Sleep(time.Millisecond)
+			})
+			if err != nil {
+				t.Errorf("could not submit %v", err)
+				return
+			}
+
+			futures = append(futures, future)
+		}
+	}
+
+	if count := pool.GetActiveKeyCount(); count != len(keys) {
+		t.Errorf("expected %d active keys, got %d", len(keys), count)
+		return
+	}
+
+	close(proceed)
+
+	for _, future := range futures {
+		if _, err := future.Get(10 * time.Second); err != nil {
+			t.Errorf("ordered function failed %v", err)
+			return
+		}
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	for _, key := range keys {
+		for index, got := range seen[key] {
+			if got != index {
+				t.Errorf("functions of key %s ran out of order %v", key, seen[key])
+				return
+			}
+		}
+	}
+
+	if mostRunning < 2 {
+		t.Errorf("functions of different keys should run at the same time, at most %d did", mostRunning)
+		return
+	}
+
+	for lcv := 0; lcv < 200 && pool.GetActiveKeyCount() != 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if count := pool.GetActiveKeyCount(); count != 0 {
+		t.Errorf("expected no active keys once everything ran, got %d", count)
+	}
+}
+
+func TestThreadRecycling(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	clock, restore := installFakeClock()
+	defer restore()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("RecyclingPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	if err := pool.SetThreadRecycling(-1, 0); err == nil {
+		t.Error("a negative number of tasks should be rejected")
+		return
+	}
+
+	err = pool.SetThreadRecycling(3, 0)
+	if err != nil {
+		t.Errorf("could not set thread recycling %v", err)
+		return
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	runOn := func() int64 {
+		future, err := pool.Submit(func() int64 {
+			return ethe.GetThreadID()
+		})
+		if err != nil {
+			return -1
+		}
+
+		results, err := future.Get(10 * time.Second)
+		if err != nil {
+			return -1
+		}
+
+		return results[0].(int64)
+	}
+
+	tids := make([]int64, 6)
+	for index := range tids {
+		tids[index] = runOn()
+	}
+
+	if tids[0] != tids[2] || tids[2] == tids[3] || tids[3] != tids[5] {
+		t.Errorf("expected a new thread after every three functions, got %v", tids)
+		return
+	}
+
+	for lcv := 0; lcv < 200 && pool.GetStats().ThreadsRecycledForTasks < 2; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if recycled := pool.GetStats().ThreadsRecycledForTasks; recycled != 2 {
+		t.Errorf("expected two threads recycled for tasks, got %d", recycled)
+		return
+	}
+
+	err = pool.SetThreadRecycling(0, 1*time.Hour)
+	if err != nil {
+		t.Errorf("could not set thread recycling %v", err)
+		return
+	}
+
+	clock.advanceUntil(1*time.Hour, func() bool {
+		return pool.GetStats().ThreadsRecycledForAge >= 1
+	})
+
+	if recycled := pool.GetStats().ThreadsRecycledForAge; recycled < 1 {
+		t.Errorf("expected a thread recycled for age, got %d", recycled)
+		return
+	}
+
+	if tid := runOn(); tid == tids[5] || tid < 0 {
+		t.Errorf("expected the old thread to have been replaced, ran on %d", tid)
+		return
+	}
+
+	if count := pool.GetCurrentThreadCount(); count != 1 {
+		t.Errorf("recycling should keep the minimum of one thread, got %d", count)
+	}
+}
+
+func TestCloseWaitWithProgress(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("DrainingPool", 2, 2, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("error starting pool %v", err)
+		return
+	}
+
+	started := make(chan bool)
+	proceed := make(chan bool)
+	block := func() {
+		started <- true
+		<-proceed
+	}
+
+	pool.Submit(block)
+	pool.Submit(block)
+	<-started
+	<-started
+
+	type report struct {
+		queued, running int
+	}
+
+	clock, restore := installFakeClock()
+	defer restore()
+
+	reports := make(chan report, 1000)
+	done := make(chan error)
+
+	go func() {
+		_, err := pool.CloseWaitWithProgress(1*time.Hour, 1*time.Second, func(queued int, running int) {
+			reports <- report{queued, running}
+		})
+
+		done <- err
+	}()
+
+	var first report
+	reported := clock.advanceUntil(1*time.Second, func() bool {
+		select {
+		case first = <-reports:
+			return true
+		default:
+			return false
+		}
+	})
+	if !reported {
+		t.Error("no progress was reported when the clock moved past the interval")
+		return
+	}
+
+	if first.running != 2 {
+		t.Errorf("expected two functions running while draining, got %v", first)
+		return
+	}
+
+	close(proceed)
+
+	if err := <-done; err != nil {
+		t.Errorf("pool should have drained, got %v", err)
+		return
+	}
+
+	var last report
+	for len(reports) > 0 {
+		last = <-reports
+	}
+
+	if last.running != 0 {
+		t.Errorf("expected a final report with nothing running, got %v", last)
+	}
+}
+
+func TestWorkBudgetAcrossPools(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	budget := ethe.NewWorkBudget(3)
+
+	queue1 := goethe.NewBoundedFunctionQueue(10)
+	queue1.SetWorkBudget(budget)
+	queue2 := goethe.NewBoundedFunctionQueue(10)
+	queue2.SetWorkBudget(budget)
+
+	pool1, err := ethe.NewPool("BudgetPool1", 1, 1, 1*time.Minute, queue1, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool1.Close()
+
+	pool2, err := ethe.NewPool("BudgetPool2", 1, 1, 1*time.Minute, queue2, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool2.Close()
+
+	pool1.Start()
+	pool2.Start()
+
+	started := make(chan bool, 3)
+	proceed := make(chan bool)
+	block := func() {
+		started <- true
+		<-proceed
+	}
+
+	// One running and one queued in the first pool, one running in the second
+	future1, _ := pool1.Submit(block)
+	future2, _ := pool1.Submit(block)
+	future3, _ := pool2.Submit(block)
+	<-started
+	<-started
+
+	if budget.GetOutstanding() != 3 {
+		t.Errorf("expected 3 outstanding, got %d", budget.GetOutstanding())
+		return
+	}
+
+	_, err = pool2.Submit(block)
+	if err != goethe.ErrWorkBudgetExhausted {
+		t.Errorf("expected ErrWorkBudgetExhausted from the second pool, got %v", err)
+		return
+	}
+
+	err = queue1.Enqueue(block)
+	if err != goethe.ErrWorkBudgetExhausted {
+		t.Errorf("expected ErrWorkBudgetExhausted from the first queue, got %v", err)
+		return
+	}
+
+	close(proceed)
+
+	for _, future := range []goethe.Future{future1, future2, future3} {
+		if _, err = future.Get(10 * time.Second); err != nil {
+			t.Errorf("function did not finish %v", err)
+			return
+		}
+	}
+
+	// The budget is given back once Finished is called after OnDone
+	for lcv := 0; lcv < 200 && budget.GetOutstanding() != 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if budget.GetOutstanding() != 0 {
+		t.Errorf("expected the budget to be given back, %d outstanding", budget.GetOutstanding())
+		return
+	}
+
+	future, err := pool2.Submit(func() {})
+	if err != nil {
+		t.Errorf("expected to submit once the budget was given back, got %v", err)
+		return
+	}
+
+	future.Get(10 * time.Second)
+
+	// Functions cleared from a queue give back their share too
+	queue3 := goethe.NewBoundedFunctionQueue(10)
+	queue3.SetWorkBudget(budget)
+	queue3.Enqueue(block)
+	queue3.Enqueue(block)
+
+	if queue3.Clear() != 2 {
+		t.Errorf("expected to clear two functions")
+		return
+	}
+
+	if budget.GetOutstanding() != 0 {
+		t.Errorf("expected cleared functions to give back the budget, %d outstanding", budget.GetOutstanding())
+	}
+}
+
+func TestSizeChangeListener(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	clock, restore := installFakeClock()
+	defer restore()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("SizeListenerPool", 1, 3, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	type change struct {
+		oldCount, newCount int32
+		reason             string
+	}
+
+	changes := make(chan change, 100)
+	pool.SetSizeChangeListener(func(oldCount, newCount int32, reason string) {
+		// The pool is not locked, so this does not deadlock
+		pool.GetCurrentThreadCount()
+
+		changes <- change{oldCount, newCount, reason}
+	})
+
+	// Idle threads leave as the clock moves past the idle decay
+	expect := func(oldCount, newCount int32, reason string) bool {
+		var got change
+		received := clock.advanceUntil(1*time.Minute, func() bool {
+			select {
+			case got = <-changes:
+				return true
+			default:
+				return false
+			}
+		})
+		if !received {
+			t.Errorf("no change from %d to %d for %s", oldCount, newCount, reason)
+			return false
+		}
+
+		if got.oldCount != oldCount || got.newCount != newCount || got.reason != reason {
+			t.Errorf("expected %d to %d for %s, got %v", oldCount, newCount, reason, got)
+			return false
+		}
+
+		return true
+	}
+
+	pool.Start()
+	if !expect(0, 1, goethe.SizeChangeStart) {
+		return
+	}
+
+	started := make(chan bool)
+	proceed := make(chan bool)
+	block := func() {
+		started <- true
+		<-proceed
+	}
+
+	for lcv := 0; lcv < 3; lcv++ {
+		pool.Submit(block)
+	}
+	for lcv := 0; lcv < 3; lcv++ {
+		<-started
+	}
+
+	if !expect(1, 2, goethe.SizeChangeGrow) || !expect(2, 3, goethe.SizeChangeGrow) {
+		return
+	}
+
+	close(proceed)
+
+	// Down to the minimum once the extra threads are idle
+	if !expect(3, 2, goethe.SizeChangeIdle) || !expect(2, 1, goethe.SizeChangeIdle) {
+		return
+	}
+
+	pool.Close()
+	expect(1, 0, goethe.SizeChangeClose)
+}
+
+func TestDroppedErrors(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+	errorQueue := goethe.NewBoundedErrorQueue(1)
+
+	pool, err := ethe.NewPool("DroppedErrorPool", 1, 1, 1*time.Minute, funcQueue, errorQueue)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	dropped := make(chan goethe.ErrorInformation, 10)
+	pool.SetDroppedErrorHandler(func(info goethe.ErrorInformation) {
+		dropped <- info
+	})
+
+	pool.Start()
+
+	fail := func(message string) error {
+		return errors.New(message)
+	}
+
+	for _, message := range []string{"failure 0", "failure 1", "failure 2"} {
+		funcQueue.Enqueue(fail, message)
+	}
+
+	// The first fills the error queue, the others are dropped
+	for _, expected := range []string{"failure 1", "failure 2"} {
+		select {
+		case info := <-dropped:
+			if info.GetError().Error() != expected {
+				t.Errorf("expected %s to be dropped, got %v", expected, info.GetError())
+				return
+			}
+		case <-time.After(10 * time.Second):
+			t.Errorf("%s was not given to the dropped error handler", expected)
+			return
+		}
+	}
+
+	if dropped := pool.GetStats().ErrorsDropped; dropped != 2 {
+		t.Errorf("expected two dropped errors, got %d", dropped)
+		return
+	}
+
+	info, found := errorQueue.Dequeue()
+	if !found || info.GetError().Error() != "failure 0" {
+		t.Errorf("expected the first failure on the error queue, got %v", info)
+		return
+	}
+
+	if dropped := pool.ResetStats().ErrorsDropped; dropped != 2 {
+		t.Errorf("expected ResetStats to return two dropped errors, got %d", dropped)
+		return
+	}
+
+	if dropped := pool.GetStats().ErrorsDropped; dropped != 0 {
+		t.Errorf("expected no dropped errors after ResetStats, got %d", dropped)
+	}
+}
+
+// silentQueue never tells the pool it has changed, so only
+// the periodic check of the monitor finds its functions
+type silentQueue struct {
+	goethe.FunctionQueue
+}
+
+func (queue *silentQueue) SetStateChangeCallback(func(goethe.FunctionQueue)) {
+}
+
+func TestMonitorInterval(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	clock, restore := installFakeClock()
+	defer restore()
+
+	funcQueue := &silentQueue{goethe.NewBoundedFunctionQueue(10)}
+
+	pool, err := ethe.NewPool("MonitorIntervalPool", 0, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	if err = pool.SetMonitorInterval(0); err == nil {
+		t.Error("expected an error for a monitor interval of zero")
+		return
+	}
+
+	err = pool.SetMonitorInterval(50 * time.Millisecond)
+	if err != nil {
+		t.Errorf("could not set the monitor interval %v", err)
+		return
+	}
+
+	pool.Start()
+
+	// Moves the clock one interval at a time until the function runs.  The
+	// steps add up to far less than the default interval of one minute
+	run := func() bool {
+		ran := make(chan bool)
+		funcQueue.Enqueue(func() {
+			close(ran)
+		})
+
+		return clock.advanceUntil(50*time.Millisecond, func() bool {
+			select {
+			case <-ran:
+				return true
+			default:
+				return false
+			}
+		})
+	}
+
+	// The first may be found by the checks the monitor does when it starts
+	if !run() {
+		t.Error("the monitor did not find the first function")
+		return
+	}
+
+	// Once its thread has decayed only the monitor can start one for the next
+	if !clock.advanceUntil(1*time.Minute, func() bool { return pool.GetCurrentThreadCount() == 0 }) {
+		t.Errorf("the idle thread did not leave the pool, there are %d", pool.GetCurrentThreadCount())
+		return
+	}
+
+	if !run() {
+		t.Error("the monitor did not find the function")
+	}
+}
+
+func blockingWorker(started chan int64, proceed chan bool) {
+	started <- goethe.GetGoethe().GetThreadID()
+	<-proceed
+}
+
+func TestGetRunningTasks(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("RunningTasksPool", 2, 2, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	pool.Start()
+
+	if running := pool.GetRunningTasks(); len(running) != 0 {
+		t.Errorf("expected nothing running, got %v", running)
+		return
+	}
+
+	started := make(chan int64)
+	proceed := make(chan bool)
+
+	before := time.Now()
+	funcQueue.EnqueueWithMetadata(map[string]string{"tenant": "blue"}, blockingWorker, started, proceed)
+	funcQueue.Enqueue(blockingWorker, started, proceed)
+
+	tids := map[int64]bool{<-started: true, <-started: true}
+
+	running := pool.GetRunningTasks()
+	if len(running) != 2 {
+		t.Errorf("expected two running tasks, got %v", running)
+		return
+	}
+
+	if running[0].ThreadID >= running[1].ThreadID {
+		t.Errorf("expected tasks in order of thread id, got %v", running)
+		return
+	}
+
+	tenants := 0
+	for _, task := range running {
+		if !tids[task.ThreadID] {
+			t.Errorf("unexpected thread %d, expected %v", task.ThreadID, tids)
+			return
+		}
+
+		if !strings.HasSuffix(task.FunctionName, "tests.blockingWorker") {
+			t.Errorf("unexpected function name %s", task.FunctionName)
+			return
+		}
+
+		if task.StartTime.Before(before) || task.EnqueueTime.Before(before) {
+			t.Errorf("times are too early, started %v enqueued %v", task.StartTime, task.EnqueueTime)
+			return
+		}
+
+		if task.Metadata["tenant"] == "blue" {
+			tenants++
+		}
+	}
+
+	if tenants != 1 {
+		t.Errorf("expected one task with metadata, got %v", running)
+		return
+	}
+
+	close(proceed)
+
+	for lcv := 0; lcv < 200 && len(pool.GetRunningTasks()) > 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if running := pool.GetRunningTasks(); len(running) != 0 {
+		t.Errorf("expected nothing running once the functions returned, got %v", running)
+	}
+}
+
+func TestAwaitThreadCount(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("AwaitCountPool", 2, 4, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	pool.Start()
+
+	if err = pool.AwaitThreadCount(2, 10*time.Second); err != nil {
+		t.Errorf("pool did not start its minimum threads %v", err)
+		return
+	}
+
+	clock, restore := installFakeClock()
+	defer restore()
+
+	returned := make(chan error, 1)
+	go func() {
+		returned <- pool.AwaitThreadCount(3, 1*time.Minute)
+	}()
+
+	timedOut := clock.advanceUntil(10*time.Second, func() bool {
+		select {
+		case err = <-returned:
+			return true
+		default:
+			return false
+		}
+	})
+	if !timedOut {
+		t.Error("AwaitThreadCount did not return when the clock moved past its timeout")
+		return
+	}
+
+	if !errors.Is(err, goethe.ErrThreadCountTimeout) || !strings.Contains(err.Error(), "has 2 threads") {
+		t.Errorf("expected ErrThreadCountTimeout with the actual count, got %v", err)
+		return
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- pool.AwaitThreadCount(3, 10*time.Second)
+	}()
+
+	pool.Resize(3, 4)
+
+	if err = <-done; err != nil {
+		t.Errorf("did not see the pool grow %v", err)
+	}
+}
+
+func TestEnqueueWithWriteLock(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	lock := ethe.NewGoetheLock()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("HandoffPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	pool.Start()
+
+	owners := make(chan int64, 1)
+	proceed := make(chan bool)
+	errs := make(chan error)
+
+	ethe.Go(func() {
+		if err := funcQueue.EnqueueWithWriteLock(lock, func() {}); err != goethe.ErrWriteLockNotHeld {
+			errs <- fmt.Errorf("expected ErrWriteLockNotHeld without the lock, got %v", err)
+			return
+		}
+
+		lock.WriteLock()
+		lock.WriteLock()
+		if err := funcQueue.EnqueueWithWriteLock(lock, func() {}); err == nil {
+			errs <- errors.New("expected a nested write lock to not be handed over")
+			return
+		}
+		lock.WriteUnlock()
+
+		err := funcQueue.EnqueueWithWriteLock(lock, func() {
+			owner, _ := lock.GetWriteOwner()
+			owners <- owner
+
+			<-proceed
+		})
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		if err := lock.WriteLock(); err != goethe.ErrLockHandedOff {
+			errs <- fmt.Errorf("expected ErrLockHandedOff after handing the lock over, got %v", err)
+			return
+		}
+
+		errs <- nil
+	})
+
+	if err = <-errs; err != nil {
+		t.Error(err)
+		return
+	}
+
+	owner := <-owners
+	if running := pool.GetRunningTasks(); len(running) != 1 || running[0].ThreadID != owner {
+		t.Errorf("expected the pool thread to hold the lock, owner is %d, running %v", owner, running)
+		return
+	}
+
+	// Another thread waits for the function to finish
+	got := make(chan bool)
+	ethe.Go(func() {
+		lock.WriteLock()
+		got <- true
+		lock.WriteUnlock()
+	})
+
+	select {
+	case <-got:
+		t.Errorf("got the write lock while the function held it")
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(proceed)
+
+	select {
+	case <-got:
+	case <-time.After(10 * time.Second):
+		t.Errorf("write lock was not unlocked after the function finished")
+	}
+}
+
+func TestSubmitOrFail(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("HealthPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	pool.Start()
+
+	if err = pool.SetHealthThresholds(goethe.PoolHealthThresholds{MaxErrorRate: 0.5}); err == nil {
+		t.Errorf("expected an error rate without a window to be refused")
+		return
+	}
+
+	err = pool.SetHealthThresholds(goethe.PoolHealthThresholds{
+		MaxErrorRate:    0.5,
+		ErrorRateWindow: 1 * time.Minute,
+		MinTasks:        2,
+		MaxSaturation:   1,
+	})
+	if err != nil {
+		t.Errorf("could not set health thresholds %v", err)
+		return
+	}
+
+	proceed := make(chan bool)
+	busy, err := pool.SubmitOrFail(func() {
+		<-proceed
+	})
+	if err != nil {
+		t.Errorf("healthy pool did not take the function %v", err)
+		return
+	}
+
+	for lcv := 0; lcv < 200 && len(pool.GetRunningTasks()) == 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The only thread is busy
+	_, err = pool.SubmitOrFail(func() {})
+	if !errors.Is(err, goethe.ErrPoolUnhealthy) || !strings.Contains(err.Error(), "saturation") {
+		t.Errorf("expected a saturated pool to be unhealthy, got %v", err)
+		return
+	}
+
+	close(proceed)
+	busy.Get(10 * time.Second)
+
+	for lcv := 0; lcv < 200 && len(pool.GetRunningTasks()) > 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for lcv := 0; lcv < 2; lcv++ {
+		future, err := pool.SubmitOrFail(func() error {
+			return errors.New("failed")
+		})
+		if err != nil {
+			t.Errorf("pool became unhealthy too soon %v", err)
+			return
+		}
+
+		future.Get(10 * time.Second)
+	}
+
+	// The last outcome is recorded just after the future is told
+	for lcv := 0; lcv < 200 && pool.GetHealth().Healthy; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	health := pool.GetHealth()
+	if health.Healthy || health.RecentTasks != 3 || len(health.Reasons) != 1 {
+		t.Errorf("expected the error rate to make the pool unhealthy, got %+v", health)
+		return
+	}
+
+	_, err = pool.SubmitOrFail(func() {})
+	if !errors.Is(err, goethe.ErrPoolUnhealthy) || !strings.Contains(err.Error(), "error rate") {
+		t.Errorf("expected a failing pool to be unhealthy, got %v", err)
+	}
+}
+
+func TestResubmit(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+	errorQueue := goethe.NewBoundedErrorQueue(10)
+
+	pool, err := ethe.NewPool("ResubmitPool", 1, 1, 1*time.Minute, funcQueue, errorQueue)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	pool.Start()
+
+	var calls int32
+	flaky := func(name string) (string, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return "", errors.New("failed the first time")
+		}
+
+		return "hello " + name, nil
+	}
+
+	future, err := pool.Submit(flaky, "world")
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+	future.Get(10 * time.Second)
+
+	for lcv := 0; lcv < 200 && errorQueue.IsEmpty(); lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	info, found := errorQueue.Dequeue()
+	if !found {
+		t.Error("no error information was put on the error queue")
+		return
+	}
+
+	future, err = pool.Resubmit(info)
+	if err != nil {
+		t.Errorf("could not resubmit %v", err)
+		return
+	}
+
+	results, err := future.Get(10 * time.Second)
+	if err != nil || len(results) != 2 || results[0] != "hello world" {
+		t.Errorf("expected the resubmitted function to succeed, got %v %v", results, err)
+		return
+	}
+
+	if _, err = pool.Resubmit(&dummyErrorInformation{err: errors.New("not a function")}); err != goethe.ErrNotResubmittable {
+		t.Errorf("expected ErrNotResubmittable, got %v", err)
+		return
+	}
+
+	pool.Close()
+
+	if _, err = pool.Resubmit(info); err != goethe.ErrPoolClosed {
+		t.Errorf("expected ErrPoolClosed after close, got %v", err)
+	}
+}
+
+func TestPoolPanicHandler(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	globalPanics := make(chan goethe.PanicInformation, 1)
+	ethe.SetPanicHandler(func(info goethe.PanicInformation) {
+		globalPanics <- info
+	})
+	defer ethe.SetPanicHandler(nil)
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("PanicPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	pool.Start()
+
+	poolPanics := make(chan goethe.PanicInformation, 1)
+	pool.SetPanicHandler(func(info goethe.PanicInformation) {
+		poolPanics <- info
+	})
+
+	panicker := func() {
+		panic("task panic")
+	}
+
+	future, err := pool.Submit(panicker)
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	if _, err = future.Get(10 * time.Second); !errors.Is(err, goethe.ErrTaskPanicked) {
+		t.Errorf("expected ErrTaskPanicked, got %v", err)
+		return
+	}
+
+	// The pool handler comes first
+	info := <-poolPanics
+	if info.PoolName != "PanicPool" || info.Value != "task panic" {
+		t.Errorf("unexpected panic information %+v", info)
+		return
+	}
+
+	select {
+	case info = <-globalPanics:
+		t.Errorf("global handler was called even though the pool had one %+v", info)
+		return
+	default:
+	}
+
+	// The thread goes on to the next function
+	future, err = pool.Submit(func() int {
+		return 13
+	})
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	if results, err := future.Get(10 * time.Second); err != nil || results[0] != 13 {
+		t.Errorf("pool thread did not run the next function, got %v %v", results, err)
+		return
+	}
+
+	// Without a pool handler the global handler is told
+	pool.SetPanicHandler(nil)
+
+	future, err = pool.Submit(panicker)
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	if _, err = future.Get(10 * time.Second); !errors.Is(err, goethe.ErrTaskPanicked) {
+		t.Errorf("expected ErrTaskPanicked, got %v", err)
+		return
+	}
+
+	if info = <-globalPanics; info.PoolName != "PanicPool" {
+		t.Errorf("unexpected panic information %+v", info)
+	}
+}
+
+func TestGroupedPanicReleasesKey(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("GroupedPanicPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	pool.Start()
+
+	pool.SetPanicHandler(func(info goethe.PanicInformation) {})
+
+	proceed := make(chan bool)
+
+	submits := map[string]func(string, interface{}, ...interface{}) (goethe.Future, error){
+		"keyed":   pool.SubmitKeyed,
+		"ordered": pool.SubmitOrdered,
+	}
+
+	for name, submit := range submits {
+		// The second function has to wait behind the one that panics
+		panicFuture, err := submit(name, func() {
+			<-proceed
+			panic("grouped panic")
+		})
+		if err != nil {
+			t.Errorf("could not submit %s %v", name, err)
+			return
+		}
+
+		nextFuture, err := submit(name, func() int {
+			return 13
+		})
+		if err != nil {
+			t.Errorf("could not submit %s %v", name, err)
+			return
+		}
+
+		proceed <- true
+
+		if _, err = panicFuture.Get(10 * time.Second); !errors.Is(err, goethe.ErrTaskPanicked) {
+			t.Errorf("expected ErrTaskPanicked from %s, got %v", name, err)
+			return
+		}
+
+		if results, err := nextFuture.Get(10 * time.Second); err != nil || results[0] != 13 {
+			t.Errorf("%s function after the panic did not run, got %v %v", name, results, err)
+			return
+		}
+	}
+
+	for lcv := 0; lcv < 200 && pool.GetActiveKeyCount() != 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if count := pool.GetActiveKeyCount(); count != 0 {
+		t.Errorf("keys still held after the panics %d", count)
+	}
+}
+
+func TestSubmitPreparedCall(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("PreparedPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	pool.Start()
+
+	square, err := goethe.NewPreparedCall(func(value int) (int, error) {
+		if value < 0 {
+			return 0, errors.New("negative")
+		}
+
+		return value * value, nil
+	})
+	if err != nil {
+		t.Errorf("could not prepare call %v", err)
+		return
+	}
+
+	futures := make([]goethe.Future, 0, 5)
+	for lcv := 0; lcv < 5; lcv++ {
+		future, err := pool.Submit(square, lcv)
+		if err != nil {
+			t.Errorf("could not submit %v", err)
+			return
+		}
+
+		futures = append(futures, future)
+	}
+
+	for index, future := range futures {
+		results, err := future.Get(10 * time.Second)
+		if err != nil || results[0] != index*index {
+			t.Errorf("expected %d, got %v %v", index*index, results, err)
+			return
+		}
+	}
+
+	future, err := pool.Submit(square, -1)
+	if err != nil {
+		t.Errorf("could not submit %v", err)
+		return
+	}
+
+	if _, err = future.Get(10 * time.Second); err == nil || err.Error() != "negative" {
+		t.Errorf("expected the error of the prepared call, got %v", err)
+		return
+	}
+
+	if _, err = pool.Submit(square, "not an int"); err == nil {
+		t.Error("expected an argument of the wrong type to be refused")
+	}
+}
+
+func TestNewPoolWithContext(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	for _, policy := range []goethe.ContextCancelPolicy{goethe.CancelQueued, goethe.DrainQueued} {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+		pool, err := ethe.NewPoolWithContext(ctx, policy, "ContextPool", 1, 1, 1*time.Minute, funcQueue, nil)
+		if err != nil {
+			cancel()
+			t.Errorf("could not create pool %v", err)
+			return
+		}
+
+		pool.Start()
+
+		proceed := make(chan bool)
+		running, err := pool.Submit(func() {
+			<-proceed
+		})
+		if err != nil {
+			cancel()
+			t.Errorf("could not submit %v", err)
+			return
+		}
+
+		for lcv := 0; lcv < 200 && len(pool.GetRunningTasks()) == 0; lcv++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		queued, err := pool.Submit(func() int {
+			return 13
+		})
+		if err != nil {
+			cancel()
+			t.Errorf("could not submit %v", err)
+			return
+		}
+
+		cancel()
+
+		if policy == goethe.CancelQueued {
+			if _, err = queued.Get(10 * time.Second); err != goethe.ErrCleared {
+				t.Errorf("expected the queued function to be cleared, got %v", err)
+				return
+			}
+
+			if !pool.IsClosed() {
+				t.Errorf("expected the pool to be closed once its context was cancelled")
+				return
+			}
+		}
+
+		close(proceed)
+
+		if _, err = running.Get(10 * time.Second); err != nil {
+			t.Errorf("running function did not finish %v", err)
+			return
+		}
+
+		if policy == goethe.DrainQueued {
+			if results, err := queued.Get(10 * time.Second); err != nil || results[0] != 13 {
+				t.Errorf("expected the queued function to be run, got %v %v", results, err)
+				return
+			}
+		}
+
+		select {
+		case <-pool.Done():
+		case <-time.After(10 * time.Second):
+			t.Errorf("pool with policy %d did not close after its context was cancelled", policy)
+			return
+		}
+	}
+}
+
+func TestSubmitStream(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("StreamPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("could not start pool %v", err)
+		return
+	}
+
+	streamError := errors.New("stream failed")
+
+	values, errs := pool.SubmitStream(func(emit func(interface{})) error {
+		for lcv := 0; lcv < 5; lcv++ {
+			emit(lcv)
+		}
+
+		return streamError
+	})
+
+	got := make([]interface{}, 0)
+	for value := range values {
+		got = append(got, value)
+	}
+
+	if !reflect.DeepEqual(got, []interface{}{0, 1, 2, 3, 4}) {
+		t.Errorf("unexpected values %v", got)
+		return
+	}
+
+	err, ok := <-errs
+	if !ok || err != streamError {
+		t.Errorf("expected the error of the function, got %v %v", err, ok)
+		return
+	}
+
+	if _, ok = <-errs; ok {
+		t.Error("error channel should be closed after the error")
+		return
+	}
+
+	values, errs = pool.SubmitStream(func(emit func(interface{})) error {
+		emit("only")
+		return nil
+	})
+
+	got = make([]interface{}, 0)
+	for value := range values {
+		got = append(got, value)
+	}
+
+	if !reflect.DeepEqual(got, []interface{}{"only"}) {
+		t.Errorf("unexpected values %v", got)
+		return
+	}
+
+	if err, ok = <-errs; ok {
+		t.Errorf("expected no error from a function that returned nil, got %v", err)
+		return
+	}
+
+	pool.Close()
+
+	values, errs = pool.SubmitStream(func(emit func(interface{})) error {
+		return nil
+	})
+
+	if _, ok = <-values; ok {
+		t.Error("value channel of a closed pool should be closed")
+		return
+	}
+
+	if err = <-errs; err != goethe.ErrPoolClosed {
+		t.Errorf("expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestPoolConfigRoundTrip(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	selector, err := goethe.NewWeightedRoundRobinSelector(3, 1)
+	if err != nil {
+		t.Errorf("could not create selector %v", err)
+		return
+	}
+
+	pool, err := ethe.NewMultiQueuePool("ConfigPool", 1, 4, 30*time.Second, selector,
+		[]goethe.FunctionQueue{goethe.NewRingBufferFunctionQueue(8), goethe.NewBoundedFunctionQueue(16)},
+		goethe.NewBoundedErrorQueue(5))
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	pool.SetMonitorInterval(2 * time.Second)
+	pool.SetCircuitBreaker(5, 1*time.Minute)
+	pool.SetThreadRecycling(100, 1*time.Hour)
+	pool.SetDuplicateKeyPolicy(goethe.DropDuplicateKey)
+	pool.SetCategoryLimit("db", 2)
+	pool.SetMaxWeight(10)
+	pool.SetOrderedRebalancing(true)
+	pool.SetHealthThresholds(goethe.PoolHealthThresholds{
+		MaxErrorRate:    0.5,
+		ErrorRateWindow: 1 * time.Minute,
+		MinTasks:        10,
+	})
+
+	config := pool.GetConfig()
+
+	saved, err := json.Marshal(config)
+	if err != nil {
+		t.Errorf("could not save config %v", err)
+		return
+	}
+
+	var restored goethe.PoolConfig
+	err = json.Unmarshal(saved, &restored)
+	if err != nil {
+		t.Errorf("could not read config %v", err)
+		return
+	}
+
+	pool.Close()
+
+	rebuilt, err := ethe.NewThreadPoolFromConfig(restored)
+	if err != nil {
+		t.Errorf("could not make pool from config %v", err)
+		return
+	}
+	defer rebuilt.Close()
+
+	if again := rebuilt.GetConfig(); !reflect.DeepEqual(again, config) {
+		t.Errorf("config did not survive the round trip\n%+v\n%+v", config, again)
+		return
+	}
+
+	if len(rebuilt.GetFunctionQueues()) != 2 || rebuilt.GetFunctionQueues()[1].GetCapacity() != 16 {
+		t.Errorf("unexpected function queues %v", rebuilt.GetFunctionQueues())
+	}
+}
+
+func TestPoolConfigValidated(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	valid := goethe.PoolConfig{
+		Name:       "ValidatedConfigPool",
+		MinThreads: 1,
+		MaxThreads: 1,
+		IdleDecay:  1 * time.Minute,
+		FunctionQueues: []goethe.QueueConfig{
+			{Type: goethe.QueueTypeBounded, Capacity: 10},
+		},
+	}
+
+	bad := valid
+	bad.MinThreads = 2
+	if _, err := ethe.NewThreadPoolFromConfig(bad); err == nil {
+		t.Error("expected a minimum above the maximum to be refused")
+		return
+	}
+
+	bad = valid
+	bad.FunctionQueues = []goethe.QueueConfig{{Type: goethe.QueueTypeCustom}}
+	if _, err := ethe.NewThreadPoolFromConfig(bad); err == nil {
+		t.Error("expected a custom queue to be refused")
+		return
+	}
+
+	bad = valid
+	bad.HealthThresholds.MaxErrorRate = 2
+	if _, err := ethe.NewThreadPoolFromConfig(bad); err == nil {
+		t.Error("expected an error rate above one to be refused")
+		return
+	}
+
+	// The refused pool is not left behind
+	pool, err := ethe.NewThreadPoolFromConfig(valid)
+	if err != nil {
+		t.Errorf("could not make pool from a valid config %v", err)
+		return
+	}
+	defer pool.Close()
+
+	config := pool.GetConfig()
+	if config.Selector != goethe.SelectorStrictPriority || config.ErrorQueue.Type != "" {
+		t.Errorf("unexpected config %+v", config)
+	}
+}
+
+func TestOrderedRebalancing(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(100)
+
+	pool, err := ethe.NewPool("RebalancingPool", 2, 2, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("could not create pool %v", err)
+		return
+	}
+	defer pool.Close()
+
+	pool.SetOrderedRebalancing(true)
+
+	const hotTasks = 30
+
+	var orderMux sync.Mutex
+	order := make(map[string][]int)
+
+	record := func(key string, sequence int) {
+		orderMux.Lock()
+		defer orderMux.Unlock()
+
+		order[key] = append(order[key], sequence)
+	}
+
+	// Two hot keys, enough to take both threads
+	for _, key := range []string{"hot1", "hot2"} {
+		for lcv := 0; lcv < hotTasks; lcv++ {
+			_, err = pool.SubmitOrdered(key, func(key string, sequence int) {
+				time.Sleep(5 * time.Millisecond)
+				record(key, sequence)
+			}, key, lcv)
+			if err != nil {
+				t.Errorf("could not submit %v", err)
+				return
+			}
+		}
+	}
+
+	idle := make([]goethe.Future, 0)
+	for _, key := range []string{"idle1", "idle2", "idle3", "idle4"} {
+		future, err := pool.SubmitOrdered(key, func(key string) {
+			record(key, 0)
+		}, key)
+		if err != nil {
+			t.Errorf("could not submit %v", err)
+			return
+		}
+
+		idle = append(idle, future)
+	}
+
+	backlogs := pool.GetOrderedBacklogs()
+	if backlogs["hot1"] < hotTasks/2 || backlogs["hot2"] < hotTasks/2 {
+		t.Errorf("expected large backlogs for the hot keys, got %v", backlogs)
+		return
+	}
+
+	pool.Start()
+
+	for _, future := range idle {
+		if _, err = future.Get(10 * time.Second); err != nil {
+			t.Errorf("idle key did not run %v", err)
+			return
+		}
+	}
+
+	// Without rebalancing the idle keys would wait for a hot key to finish
+	backlogs = pool.GetOrderedBacklogs()
+	if backlogs["hot1"] == 0 && backlogs["hot2"] == 0 {
+		t.Errorf("the idle keys waited for the hot keys, backlogs %v", backlogs)
+		return
+	}
+
+	for lcv := 0; lcv < 200 && pool.GetActiveKeyCount() > 0; lcv++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if count := pool.GetActiveKeyCount(); count != 0 {
+		t.Errorf("expected every key to finish, %d still active", count)
+		return
+	}
+
+	orderMux.Lock()
+	defer orderMux.Unlock()
+
+	for _, key := range []string{"hot1", "hot2"} {
+		if len(order[key]) != hotTasks {
+			t.Errorf("expected %d functions of %s to run, got %v", hotTasks, key, order[key])
+			return
+		}
+
+		for index, sequence := range order[key] {
+			if sequence != index {
+				t.Errorf("functions of %s ran out of order %v", key, order[key])
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package tests
+
+import (
+	"github.com/jwells131313/goethe"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rwLocker is satisfied by both *sync.RWMutex and *goethe.RWMutexAdapter
+type rwLocker interface {
+	Lock()
+	Unlock()
+	RLock()
+	RUnlock()
+}
+
+// account is written against sync.RWMutex, except that the type of
+// its mutex is an interface so both can be dropped in
+type account struct {
+	mux      rwLocker
+	checking int
+	savings  int
+}
+
+// transfer moves money with the write lock held, so that
+// readers never see the total change
+func (a *account) transfer(amount int) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.checking -= amount
+	time.Sleep(time.Millisecond)
+	a.savings += amount
+}
+
+func (a *account) total() int {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	return a.checking + a.savings
+}
+
+func TestRWMutexAdapterExcludes(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	// The same code works with either
+	for _, mux := range []rwLocker{&sync.RWMutex{}, ethe.NewRWMutexAdapter()} {
+		a := &account{
+			mux:      mux,
+			checking: 100,
+		}
+
+		var wg sync.WaitGroup
+		totals := make(chan int, 1000)
+
+		wg.Add(6)
+		for lcv := 0; lcv < 3; lcv++ {
+			ethe.Go(func() {
+				defer wg.Done()
+
+				for transfers := 0; transfers < 20; transfers++ {
+					a.transfer(1)
+				}
+			})
+
+			ethe.Go(func() {
+				defer wg.Done()
+
+				for reads := 0; reads < 20; reads++ {
+					totals <- a.total()
+				}
+			})
+		}
+
+		wg.Wait()
+		close(totals)
+
+		for total := range totals {
+			if total != 100 {
+				t.Errorf("reader saw a transfer in progress with %T, total %d", mux, total)
+				return
+			}
+		}
+
+		if a.checking != 40 || a.savings != 60 {
+			t.Errorf("transfers were lost with %T, checking %d savings %d", mux, a.checking, a.savings)
+			return
+		}
+	}
+}
+
+func TestRWMutexAdapterDifferences(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	adapter := ethe.NewRWMutexAdapter()
+
+	reply := make(chan interface{})
+
+	ethe.Go(func() {
+		// Reentrant, where sync.RWMutex would deadlock
+		adapter.Lock()
+		adapter.Lock()
+		adapter.RLock()
+
+		if owner, held := adapter.GetLock().GetWriteOwner(); !held || owner != ethe.GetThreadID() {
+			reply <- "write lock not held by this thread"
+			return
+		}
+
+		adapter.RUnlock()
+		adapter.Unlock()
+		adapter.Unlock()
+
+		defer func() {
+			reply <- recover()
+		}()
+
+		adapter.Unlock()
+	})
+
+	if got := <-reply; got != goethe.ErrWriteLockNotHeld {
+		t.Errorf("expected a panic with ErrWriteLockNotHeld, got %v", got)
+		return
+	}
+
+	ethe.Go(func() {
+		adapter.RLock()
+		defer adapter.RUnlock()
+
+		defer func() {
+			reply <- recover()
+		}()
+
+		adapter.Lock()
+	})
+
+	if got := <-reply; got != goethe.ErrReadLockHeld {
+		t.Errorf("expected a panic with ErrReadLockHeld, got %v", got)
+	}
+}
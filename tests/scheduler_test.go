@@ -137,3 +137,125 @@ func runOnceAndCancel(addToMe *int) {
 		}
 	}
 }
+
+func TestDebouncer(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	clock := newFakeClock()
+	ethe.SetClock(clock)
+	defer func() {
+		ethe.SetClock(nil)
+
+		// Release anything else that started waiting on the fake clock
+		clock.advance(24 * time.Hour)
+	}()
+
+	runs := make(chan int64, 10)
+	debouncer := ethe.NewDebouncer(1*time.Second, func() {
+		runs <- ethe.GetThreadID()
+	})
+
+	for lcv := 0; lcv < 5; lcv++ {
+		debouncer.Trigger()
+		clock.advance(500 * time.Millisecond)
+	}
+
+	select {
+	case <-runs:
+		t.Error("debouncer ran before things were quiet")
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	clock.advance(1 * time.Second)
+
+	select {
+	case tid := <-runs:
+		if tid < 0 {
+			t.Error("debounced function should run on a goethe thread")
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("debounced function did not run")
+		return
+	}
+
+	debouncer.Trigger()
+	debouncer.Stop()
+	clock.advance(2 * time.Second)
+
+	select {
+	case <-runs:
+		t.Error("debounced function ran after Stop")
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if len(runs) != 0 {
+		t.Errorf("debounced function ran %d extra times", len(runs))
+	}
+}
+
+func TestListAndCancelAllScheduled(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	later, err := ethe.ScheduleWithFixedDelay(2*time.Hour, time.Hour, nil, func() {})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	defer later.Cancel()
+
+	sooner, err := ethe.ScheduleAtFixedRate(time.Hour, time.Hour, nil, func() {})
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	defer sooner.Cancel()
+
+	handles := ethe.ListScheduled()
+
+	soonerIndex, laterIndex := -1, -1
+	for index, handle := range handles {
+		switch handle {
+		case sooner:
+			soonerIndex = index
+		case later:
+			laterIndex = index
+		}
+	}
+
+	if soonerIndex < 0 || laterIndex < soonerIndex {
+		t.Errorf("expected both timers listed in the order they run, got %d and %d", soonerIndex, laterIndex)
+		return
+	}
+
+	if !handles[soonerIndex].IsFixedRate() || handles[laterIndex].IsFixedRate() {
+		t.Error("IsFixedRate does not match how the timers were scheduled")
+		return
+	}
+
+	if until := time.Until(handles[soonerIndex].GetNextRunTime()); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("expected the next run in an hour, it is in %v", until)
+		return
+	}
+
+	if cancelled := ethe.CancelAllScheduled(); cancelled < 2 {
+		t.Errorf("expected at least two timers cancelled, got %d", cancelled)
+		return
+	}
+
+	if sooner.IsRunning() || later.IsRunning() {
+		t.Error("timers should be cancelled")
+		return
+	}
+
+	if left := ethe.ListScheduled(); len(left) != 0 {
+		t.Errorf("expected nothing scheduled after CancelAllScheduled, got %d", len(left))
+		return
+	}
+
+	if cancelled := ethe.CancelAllScheduled(); cancelled != 0 {
+		t.Errorf("cancelling again should cancel nothing, got %d", cancelled)
+	}
+}
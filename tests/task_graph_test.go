@@ -0,0 +1,164 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package tests
+
+import (
+	"errors"
+	"github.com/jwells131313/goethe"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTaskGraphOrder(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	graph := ethe.NewTaskGraph()
+
+	var mux sync.Mutex
+	order := make([]string, 0)
+	step := func(id string) func() error {
+		return func() error {
+			time.Sleep(10 * time.Millisecond)
+
+			mux.Lock()
+			defer mux.Unlock()
+
+			order = append(order, id)
+			return nil
+		}
+	}
+
+	// link depends on both compiles, which depend on generate
+	graph.AddTask("link", step("link"), "compileA", "compileB")
+	graph.AddTask("compileA", step("compileA"), "generate")
+	graph.AddTask("compileB", step("compileB"), "generate")
+	graph.AddTask("generate", step("generate"))
+
+	failures, err := graph.Run(10 * time.Second)
+	if err != nil || len(failures) != 0 {
+		t.Errorf("unexpected failures %v %v", failures, err)
+		return
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	if len(order) != 4 || order[0] != "generate" || order[3] != "link" {
+		t.Errorf("tasks ran in the wrong order %v", order)
+	}
+
+	if _, err := graph.Run(0); err == nil {
+		t.Error("a task graph should only run once")
+	}
+}
+
+func TestTaskGraphFailureSkipsDependents(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	graph := ethe.NewTaskGraph()
+
+	failure := errors.New("generate failed")
+
+	var ran sync.Map
+	graph.AddTask("generate", func() error {
+		return failure
+	})
+	graph.AddTask("other", func() error {
+		ran.Store("other", true)
+		return nil
+	})
+	graph.AddTask("compile", func() error {
+		ran.Store("compile", true)
+		return nil
+	}, "generate", "other")
+	graph.AddTask("link", func() error {
+		ran.Store("link", true)
+		return nil
+	}, "compile")
+
+	failures, err := graph.Run(10 * time.Second)
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+		return
+	}
+
+	if failures["generate"] != failure {
+		t.Errorf("expected the failure of generate, got %v", failures["generate"])
+		return
+	}
+
+	for _, skipped := range []string{"compile", "link"} {
+		if !errors.Is(failures[skipped], goethe.ErrDependencyFailed) {
+			t.Errorf("expected %s to be skipped, got %v", skipped, failures[skipped])
+		}
+
+		if _, found := ran.Load(skipped); found {
+			t.Errorf("%s should not have run", skipped)
+		}
+	}
+
+	if _, found := ran.Load("other"); !found || failures["other"] != nil {
+		t.Error("independent task should have run")
+	}
+}
+
+func TestTaskGraphCycle(t *testing.T) {
+	ethe := goethe.GetGoethe()
+	graph := ethe.NewTaskGraph()
+
+	noop := func() error { return nil }
+
+	graph.AddTask("a", noop, "c")
+	graph.AddTask("b", noop, "a")
+
+	if err := graph.AddTask("c", noop, "b"); err != goethe.ErrTaskCycle {
+		t.Errorf("expected ErrTaskCycle, got %v", err)
+		return
+	}
+
+	if err := graph.AddTask("self", noop, "self"); err != goethe.ErrTaskCycle {
+		t.Errorf("expected ErrTaskCycle for a task depending on itself, got %v", err)
+		return
+	}
+
+	if _, err := graph.Run(time.Second); err == nil {
+		t.Error("expected an error running a graph with a task that was never added")
+	}
+}
@@ -41,7 +41,12 @@
 package tests
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"github.com/jwells131313/goethe"
+	"log/slog"
+	"strings"
 	"testing"
 	"time"
 )
@@ -136,3 +141,243 @@ func adder() error {
 
 	return nil
 }
+
+func TestRunWithContext(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	funcQueue := goethe.NewBoundedFunctionQueue(10)
+
+	pool, err := ethe.NewPool("ContextPool", 1, 1, 1*time.Minute, funcQueue, nil)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	defer pool.Close()
+
+	err = pool.Start()
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	contexts := make(chan goethe.ThreadContext)
+	ethe.Go(func() {
+		ethe.SetThreadName("handler")
+
+		tl, _ := ethe.GetThreadLocal("ContextLocal")
+		tl.Set("request-1")
+
+		contexts <- ethe.CaptureContext()
+	})
+
+	ctx := <-contexts
+
+	results := make(chan string, 4)
+	funcQueue.Enqueue(func() {
+		ethe.SetThreadName("worker")
+
+		err := ethe.RunWithContext(ctx, func() {
+			tl, _ := ethe.GetThreadLocal("ContextLocal")
+			value, _ := tl.Get()
+
+			results <- value.(string)
+			results <- ethe.GetThreadName()
+		})
+		if err != nil {
+			results <- err.Error()
+		}
+
+		tl, _ := ethe.GetThreadLocal("ContextLocal")
+		value, _ := tl.Get()
+		if value != nil {
+			results <- "thread local was not cleaned up"
+		} else {
+			results <- "cleaned"
+		}
+		results <- ethe.GetThreadName()
+	})
+
+	expected := []string{"request-1", "handler", "cleaned", "worker"}
+	for _, expect := range expected {
+		got := <-results
+		if got != expect {
+			t.Errorf("expected %s, got %s", expect, got)
+			return
+		}
+	}
+
+	err = ethe.RunWithContext(ctx, func() {})
+	if err != goethe.ErrNotGoetheThread {
+		t.Errorf("expected ErrNotGoetheThread from non-goethe thread, got %v", err)
+	}
+}
+
+func TestOnThreadExit(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	calls := make(chan string, 10)
+
+	err := ethe.EstablishThreadLocal("OnThreadExitLocal", nil, func(tl goethe.ThreadLocal) error {
+		calls <- "destroyer"
+		return nil
+	})
+	if err != nil {
+		t.Errorf("could not establish thread local %v", err)
+		return
+	}
+
+	if err := ethe.OnThreadExit(func() {}); err != goethe.ErrNotGoetheThread {
+		t.Errorf("expected ErrNotGoetheThread from a normal go routine, got %v", err)
+		return
+	}
+
+	ethe.Go(func() {
+		ethe.GetThreadLocal("OnThreadExitLocal")
+
+		ethe.OnThreadExit(func() {
+			calls <- "first"
+		})
+		ethe.OnThreadExit(func() {
+			calls <- "second"
+			panic("a panicking hook should not stop the others")
+		})
+
+		calls <- "body"
+	})
+
+	for _, expected := range []string{"body", "second", "first", "destroyer"} {
+		select {
+		case got := <-calls:
+			if got != expected {
+				t.Errorf("expected %s next, got %s", expected, got)
+				return
+			}
+		case <-time.After(10 * time.Second):
+			t.Errorf("%s was never called", expected)
+			return
+		}
+	}
+}
+
+func TestEstablishThreadLocalWhileThreadsExit(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	done := make(chan bool)
+	for lcv := 0; lcv < 10; lcv++ {
+		name := fmt.Sprintf("ExitingLocal%d", lcv)
+
+		ethe.Go(func() {
+			ethe.GetThreadLocal(name)
+
+			done <- true
+		})
+
+		ethe.Go(func() {
+			ethe.EstablishThreadLocal(name+"Established", nil, nil)
+
+			done <- true
+		})
+	}
+
+	for lcv := 0; lcv < 20; lcv++ {
+		<-done
+	}
+
+	err := ethe.EstablishThreadLocal("ExitingLocal0Established", nil, nil)
+	if err == nil {
+		t.Error("thread local established twice")
+	}
+}
+
+func TestOnThreadExitBeforePanicHandler(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	calls := make(chan string, 10)
+
+	ethe.SetPanicHandler(func(info goethe.PanicInformation) {
+		calls <- "handler"
+	})
+	defer ethe.SetPanicHandler(nil)
+
+	ethe.Go(func() {
+		ethe.OnThreadExit(func() {
+			calls <- "hook"
+		})
+
+		panic("thread panic")
+	})
+
+	for _, expected := range []string{"hook", "handler"} {
+		select {
+		case got := <-calls:
+			if got != expected {
+				t.Errorf("expected %s next, got %s", expected, got)
+				return
+			}
+		case <-time.After(10 * time.Second):
+			t.Errorf("%s was never called", expected)
+			return
+		}
+	}
+}
+
+func TestSlogHandler(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	var buffer bytes.Buffer
+	logger := slog.New(ethe.NewSlogHandler(slog.NewJSONHandler(&buffer, nil))).With("service", "test")
+
+	logger.Info("off thread")
+
+	done := make(chan int64)
+	ethe.Go(func() {
+		logger.Info("unnamed")
+
+		ethe.SetThreadName("slogger")
+		logger.Info("named")
+
+		done <- ethe.GetThreadID()
+	})
+
+	tid := <-done
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 3 {
+		t.Errorf("expected three records, got %v", lines)
+		return
+	}
+
+	records := make([]map[string]interface{}, len(lines))
+	for index, line := range lines {
+		err := json.Unmarshal([]byte(line), &records[index])
+		if err != nil {
+			t.Errorf("could not parse record %s %v", line, err)
+			return
+		}
+
+		if records[index]["service"] != "test" {
+			t.Errorf("record lost the attributes of the logger %s", line)
+			return
+		}
+	}
+
+	if _, found := records[0][goethe.SlogThreadIDKey]; found {
+		t.Errorf("record from a normal go routine should have no thread id %s", lines[0])
+		return
+	}
+
+	// JSON numbers come back as float64
+	if records[1][goethe.SlogThreadIDKey] != float64(tid) {
+		t.Errorf("expected thread id %d in %s", tid, lines[1])
+		return
+	}
+
+	if _, found := records[1][goethe.SlogThreadNameKey]; found {
+		t.Errorf("record from an unnamed thread should have no thread name %s", lines[1])
+		return
+	}
+
+	if records[2][goethe.SlogThreadIDKey] != float64(tid) || records[2][goethe.SlogThreadNameKey] != "slogger" {
+		t.Errorf("expected thread id %d and name slogger in %s", tid, lines[2])
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package tests
+
+import (
+	"github.com/jwells131313/goethe"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	cacheReaders = 5
+	cacheWrites  = 50
+)
+
+// TestVersionedCacheConsistentAcrossWrites has a writer that keeps
+// two values equal under the write lock while readers Get from the cache
+func TestVersionedCacheConsistentAcrossWrites(t *testing.T) {
+	ethe := goethe.GetGoethe()
+
+	lock := ethe.NewGoetheLock()
+
+	var first, second int
+	var computed int32
+
+	cache := ethe.NewVersionedCache(lock, func() interface{} {
+		atomic.AddInt32(&computed, 1)
+		return [2]int{first, second}
+	})
+
+	var failMux sync.Mutex
+	var failure string
+	fail := func(msg string) {
+		failMux.Lock()
+		defer failMux.Unlock()
+
+		if failure == "" {
+			failure = msg
+		}
+	}
+
+	var done int32
+	var wg sync.WaitGroup
+
+	wg.Add(cacheReaders + 1)
+	for lcv := 0; lcv < cacheReaders; lcv++ {
+		ethe.Go(func() {
+			defer wg.Done()
+
+			last := -1
+			for atomic.LoadInt32(&done) == 0 {
+				raw, err := cache.Get()
+				if err != nil {
+					fail(err.Error())
+					return
+				}
+
+				pair := raw.([2]int)
+				if pair[0] != pair[1] {
+					fail("got values from the middle of a write")
+					return
+				}
+				if pair[0] < last {
+					fail("got a value older than one already seen")
+					return
+				}
+				last = pair[0]
+			}
+		})
+	}
+
+	ethe.Go(func() {
+		defer wg.Done()
+		defer atomic.StoreInt32(&done, 1)
+
+		for lcv := 0; lcv < cacheWrites; lcv++ {
+			lock.WriteLock()
+			first++
+			time.Sleep(time.Millisecond)
+			second++
+			lock.WriteUnlock()
+
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	wg.Wait()
+
+	if failure != "" {
+		t.Errorf("%s", failure)
+		return
+	}
+
+	reply := make(chan interface{}, 1)
+	ethe.Go(func() {
+		before := atomic.LoadInt32(&computed)
+		for lcv := 0; lcv < 10; lcv++ {
+			cache.Get()
+		}
+		raw, _ := cache.Get()
+		if atomic.LoadInt32(&computed) > before+1 {
+			reply <- "computed again without a write"
+			return
+		}
+		reply <- raw
+	})
+
+	raw := <-reply
+	pair, ok := raw.([2]int)
+	if !ok {
+		t.Errorf("%v", raw)
+		return
+	}
+	if pair[0] != cacheWrites || pair[1] != cacheWrites {
+		t.Errorf("expected the value after the last write, got %v", pair)
+	}
+}
@@ -0,0 +1,185 @@
+/*
+ * DO NOT ALTER OR REMOVE COPYRIGHT NOTICES OR THIS HEADER.
+ *
+ * Copyright (c) 2018 Oracle and/or its affiliates. All rights reserved.
+ *
+ * The contents of this file are subject to the terms of either the GNU
+ * General Public License Version 2 only ("GPL") or the Common Development
+ * and Distribution License("CDDL") (collectively, the "License").  You
+ * may not use this file except in compliance with the License.  You can
+ * obtain a copy of the License at
+ * https://glassfish.dev.java.net/public/CDDL+GPL_1_1.html
+ * or packager/legal/LICENSE.txt.  See the License for the specific
+ * language governing permissions and limitations under the License.
+ *
+ * When distributing the software, include this License Header Notice in each
+ * file and include the License file at packager/legal/LICENSE.txt.
+ *
+ * GPL Classpath Exception:
+ * Oracle designates this particular file as subject to the "Classpath"
+ * exception as provided by Oracle in the GPL Version 2 section of the License
+ * file that accompanied this code.
+ *
+ * Modifications:
+ * If applicable, add the following below the License Header, with the fields
+ * enclosed by brackets [] replaced by your own identifying information:
+ * "Portions Copyright [year] [name of copyright owner]"
+ *
+ * Contributor(s):
+ * If you wish your version of this file to be governed by only the CDDL or
+ * only the GPL Version 2, indicate your decision by adding "[Contributor]
+ * elects to include this software in this distribution under the [CDDL or GPL
+ * Version 2] license."  If you don't indicate a single choice of license, a
+ * recipient has the option to distribute your version of this file under
+ * either the CDDL, the GPL Version 2 or to extend the choice of license to
+ * its licensees as provided above.  However, if you add GPL Version 2 code
+ * and therefore, elected the GPL Version 2 license, then the option applies
+ * only if the new code is made subject to such option by the copyright
+ * holder.
+ */
+
+package goethe
+
+type threadContext struct {
+	tid    int64
+	name   string
+	values map[string]interface{}
+}
+
+// savedLocal is what a thread local looked like before
+// RunWithContext replaced it
+type savedLocal struct {
+	existed bool
+	value   interface{}
+}
+
+func (ctx *threadContext) GetThreadID() int64 {
+	return ctx.tid
+}
+
+func (ctx *threadContext) GetThreadName() string {
+	return ctx.name
+}
+
+func (ctx *threadContext) GetLocal(name string) (interface{}, bool) {
+	value, found := ctx.values[name]
+	return value, found
+}
+
+// CaptureContext takes a snapshot of the thread locals and name of the
+// current goethe thread so they can be restored with RunWithContext
+func (goth *StandardThreadUtilities) CaptureContext() ThreadContext {
+	tid := goth.GetThreadID()
+
+	retVal := &threadContext{
+		tid:    tid,
+		values: make(map[string]interface{}),
+	}
+
+	if tid < 0 {
+		return retVal
+	}
+
+	retVal.name = goth.getThreadName(tid)
+
+	for name, operators := range goth.getAllOperators() {
+		if name == TimerThreadLocal {
+			// The timer belongs to the job running on the timer thread
+			continue
+		}
+
+		operators.lock.ReadLock()
+		actual, found := operators.actuals[tid]
+		operators.lock.ReadUnlock()
+
+		if found {
+			retVal.values[name] = actual.(*threadLocal).data
+		}
+	}
+
+	return retVal
+}
+
+// RunWithContext runs f on the current goethe thread with the thread
+// locals and name from the context, and then puts back what was there before
+func (goth *StandardThreadUtilities) RunWithContext(ctx ThreadContext, f func()) error {
+	tid := goth.GetThreadID()
+	if tid < 0 {
+		return ErrNotGoetheThread
+	}
+
+	captured, ok := ctx.(*threadContext)
+	if !ok || captured == nil {
+		f()
+		return nil
+	}
+
+	oldName := goth.getThreadName(tid)
+	saved := make(map[string]savedLocal)
+
+	for name, value := range captured.values {
+		saved[name] = goth.replaceLocal(tid, name, value)
+	}
+	goth.SetThreadName(captured.name)
+
+	defer func() {
+		goth.SetThreadName(oldName)
+
+		for name, old := range saved {
+			goth.restoreLocal(tid, name, old)
+		}
+	}()
+
+	f()
+
+	return nil
+}
+
+// replaceLocal sets the thread local of the given thread without
+// running any initializer and returns what was there before
+func (goth *StandardThreadUtilities) replaceLocal(tid int64, name string, value interface{}) savedLocal {
+	operators := goth.getOrCreateOperators(name)
+
+	operators.lock.WriteLock()
+	defer operators.lock.WriteUnlock()
+
+	actual, found := operators.actuals[tid]
+	if !found {
+		actual = newThreadLocal(name, goth, tid)
+		operators.actuals[tid] = actual
+
+		actual.(*threadLocal).data = value
+
+		return savedLocal{}
+	}
+
+	local := actual.(*threadLocal)
+	retVal := savedLocal{
+		existed: true,
+		value:   local.data,
+	}
+
+	local.data = value
+
+	return retVal
+}
+
+// restoreLocal puts back a thread local replaced by replaceLocal.  A thread
+// local that did not exist before is removed without running its destroyer,
+// since the value belongs to the thread the context was captured from
+func (goth *StandardThreadUtilities) restoreLocal(tid int64, name string, old savedLocal) {
+	operators := goth.getOrCreateOperators(name)
+
+	operators.lock.WriteLock()
+	defer operators.lock.WriteUnlock()
+
+	if !old.existed {
+		delete(operators.actuals, tid)
+		return
+	}
+
+	actual, found := operators.actuals[tid]
+	if found {
+		actual.(*threadLocal).data = old.value
+	}
+}
@@ -80,6 +80,7 @@ type timerJob struct {
 	mux         sync.Mutex
 	initialTime *time.Time
 	cancelled   bool
+	nextRun     time.Time
 	delay       time.Duration
 	fixed       bool
 	method      interface{}
@@ -91,10 +92,8 @@ type timerJob struct {
 
 // NewTimer creates a timer for use with the goethe scheduler
 func newTimer() timerImpl {
-	goethe := GetGoethe()
-
 	retVal := &timerData{
-		mux:    goethe.NewGoetheLock(),
+		mux:    globalGoethe.newInternalLock(),
 		heap:   newHeap(),
 		sleepy: newSleeper(),
 	}
@@ -122,13 +121,12 @@ func (timer *timerData) runOne() bool {
 		return true
 	}
 
-	now := time.Now()
-	now = now.Add(fudgeFactor)
+	currentTime := now().Add(fudgeFactor)
 
 	pNode := peekNode.(*timerJob)
 
 	// Is it inside the range
-	until := (*peek).Sub(now)
+	until := (*peek).Sub(currentTime)
 	if until >= 0 {
 		timer.sleepy.sleep(until, timer.cond, pNode.next.jobNumber)
 
@@ -155,7 +153,7 @@ func (timer *timerData) runOne() bool {
 
 	if job.fixed {
 		// calculate the next time
-		sinceInitial := now.Sub(*job.initialTime)
+		sinceInitial := currentTime.Sub(*job.initialTime)
 
 		numRuns := sinceInitial / job.delay
 
@@ -192,7 +190,7 @@ func (timer *timerData) scheduleNextWakeUp() {
 		return
 	}
 
-	until := time.Until(*peek)
+	until := (*peek).Sub(now())
 	timer.sleepy.sleep(until, timer.cond, pNode.next.jobNumber)
 }
 
@@ -216,7 +214,7 @@ func (timer *timerData) invoke(ethe *StandardThreadUtilities, job *timerJob) {
 		return
 	}
 
-	nextRun := time.Now().Add(job.delay)
+	nextRun := now().Add(job.delay)
 
 	timer.scheduleNext(job, &nextRun)
 }
@@ -230,11 +228,11 @@ func (timer *timerData) addJob(
 	fixed bool) (Timer, error) {
 	ethe := GetGoethe()
 
-	now := time.Now()
-	added := now.Add(initialDelay)
+	added := now().Add(initialDelay)
 
 	retVal := &timerJob{
 		initialTime: &added,
+		nextRun:     added,
 		delay:       period,
 		fixed:       fixed,
 		method:      method,
@@ -263,6 +261,10 @@ func (timer *timerData) scheduleNext(job *timerJob, nextRingTime *time.Time) err
 
 	job.next = nextRing
 
+	job.mux.Lock()
+	job.nextRun = *nextRingTime
+	job.mux.Unlock()
+
 	err := timer.heap.Add(nextRingTime, job)
 	if err != nil {
 		return err
@@ -293,3 +295,17 @@ func (job *timerJob) IsRunning() bool {
 func (job *timerJob) GetErrorQueue() ErrorQueue {
 	return job.errors
 }
+
+// GetNextRunTime returns when the method is next run
+func (job *timerJob) GetNextRunTime() time.Time {
+	job.mux.Lock()
+	defer job.mux.Unlock()
+
+	return job.nextRun
+}
+
+// IsFixedRate returns true if the method is run at a fixed rate
+// rather than with a fixed delay between runs
+func (job *timerJob) IsFixedRate() bool {
+	return job.fixed
+}